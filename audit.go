@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"strings"
+)
+
+// auditMinSamples is the number of mined blocks needed before the
+// statistical checks of a nonce audit are meaningful.
+const auditMinSamples = 32
+
+// NonceAudit summarizes the nonces and hash prefixes of mined blocks.
+// An honest miner searching the nonce space produces nonces with unbiased
+// low bits, a roughly geometric nonce distribution and uniformly
+// distributed hash digits after the required zero prefix; large deviations
+// point to a biased or broken mining implementation.
+type NonceAudit struct {
+	Blocks     int
+	Difficulty int
+
+	// Histogram[k] counts nonces n with bits.Len(n) == k, so bucket k
+	// covers [2^(k-1), 2^k) and bucket 0 holds nonce 0.
+	Histogram []int
+
+	// LowBitSet[b] counts nonces with bit b set.
+	LowBitSet [8]int
+
+	// PrefixNibbles counts the hash digit that follows the required zeros.
+	PrefixNibbles [16]int
+
+	MeanAttempts     float64
+	ExpectedAttempts float64
+
+	Findings []string
+}
+
+// Suspicious reports whether the audit found anything worth a closer look.
+func (a *NonceAudit) Suspicious() bool {
+	return len(a.Findings) > 0
+}
+
+// auditNonces examines the mined blocks of a chain (the genesis block is
+// not mined and is skipped) that were produced at the given difficulty.
+func auditNonces(chain []*Block, difficulty int) *NonceAudit {
+	a := &NonceAudit{
+		Difficulty:       difficulty,
+		ExpectedAttempts: math.Pow(16, float64(difficulty)),
+	}
+
+	var totalAttempts float64
+	for _, block := range chain {
+		if block.Index == 0 {
+			continue
+		}
+		a.Blocks++
+
+		nonce := uint64(block.Nonce)
+		bucket := bits.Len64(nonce)
+		for len(a.Histogram) <= bucket {
+			a.Histogram = append(a.Histogram, 0)
+		}
+		a.Histogram[bucket]++
+
+		for b := range a.LowBitSet {
+			if nonce&(1<<b) != 0 {
+				a.LowBitSet[b]++
+			}
+		}
+
+		if difficulty < 64 && len(block.Hash) > difficulty/2 {
+			a.PrefixNibbles[hashNibble(block.Hash, difficulty)]++
+		}
+
+		// A sequential search starting at 0 makes nonce+1 attempts
+		totalAttempts += float64(nonce) + 1
+	}
+
+	if a.Blocks == 0 {
+		return a
+	}
+	a.MeanAttempts = totalAttempts / float64(a.Blocks)
+
+	if a.Blocks < auditMinSamples {
+		a.Findings = append(a.Findings, fmt.Sprintf(
+			"only %d mined blocks; at least %d are needed for statistical checks", a.Blocks, auditMinSamples))
+		return a
+	}
+	a.checkLowBits()
+	a.checkPrefixNibbles()
+	a.checkMeanAttempts()
+	return a
+}
+
+// hashNibble returns the i-th hex digit of a hash.
+func hashNibble(hash []byte, i int) byte {
+	b := hash[i/2]
+	if i%2 == 0 {
+		return b >> 4
+	}
+	return b & 0x0f
+}
+
+// checkLowBits flags nonce bits that are set far more or less often than
+// an honest search predicts, such as a miner that only tries even nonces.
+// For a search succeeding with probability p per attempt, nonces are
+// geometric and bit b is set with probability r/(1+r), r = (1-p)^(2^b).
+func (a *NonceAudit) checkLowBits() {
+	n := float64(a.Blocks)
+	p := 1 / a.ExpectedAttempts
+	for b, set := range a.LowBitSet {
+		r := math.Pow(1-p, float64(uint64(1)<<b))
+		prob := r / (1 + r)
+
+		// Skip bits too rarely (or too often) set for the normal approximation
+		variance := n * prob * (1 - prob)
+		if variance < 10 {
+			continue
+		}
+		if z := (float64(set) - n*prob) / math.Sqrt(variance); math.Abs(z) > 4 {
+			a.Findings = append(a.Findings, fmt.Sprintf(
+				"nonce bit %d is set in %d of %d blocks (expected about %.0f)", b, set, a.Blocks, n*prob))
+		}
+	}
+}
+
+// checkPrefixNibbles runs a chi-square test on the digit after the
+// required zero prefix, which should be uniform over 0-f.
+func (a *NonceAudit) checkPrefixNibbles() {
+	if a.Difficulty >= 64 {
+		return
+	}
+	expected := float64(a.Blocks) / 16
+	var chi2 float64
+	for _, count := range a.PrefixNibbles {
+		d := float64(count) - expected
+		chi2 += d * d / expected
+	}
+	// Critical value for 15 degrees of freedom at p = 0.001
+	if chi2 > 37.70 {
+		a.Findings = append(a.Findings, fmt.Sprintf(
+			"hash digit %d is not uniformly distributed (chi-square %.1f)", a.Difficulty+1, chi2))
+	}
+}
+
+// checkMeanAttempts compares the mean number of attempts per block with
+// the 16^difficulty an honest search needs on average. Far fewer attempts
+// suggest precomputed or non-random blocks; far more suggest the miner is
+// skipping parts of the nonce space.
+func (a *NonceAudit) checkMeanAttempts() {
+	if a.Difficulty == 0 {
+		return
+	}
+	// Attempts are geometric, so their standard deviation is about the mean
+	stderr := a.ExpectedAttempts / math.Sqrt(float64(a.Blocks))
+	if z := (a.MeanAttempts - a.ExpectedAttempts) / stderr; math.Abs(z) > 4 {
+		a.Findings = append(a.Findings, fmt.Sprintf(
+			"mean attempts per block %.0f is far from the expected %.0f", a.MeanAttempts, a.ExpectedAttempts))
+	}
+}
+
+// Print writes the histogram and findings in a human-readable form.
+func (a *NonceAudit) Print(w io.Writer) {
+	fmt.Fprintf(w, "\nNonce Audit (%d mined blocks, difficulty %d):\n", a.Blocks, a.Difficulty)
+	fmt.Fprintf(w, "- Mean attempts per block: %.0f (expected %.0f)\n", a.MeanAttempts, a.ExpectedAttempts)
+
+	peak := 0
+	for _, count := range a.Histogram {
+		peak = max(peak, count)
+	}
+	for k, count := range a.Histogram {
+		lo, hi := uint64(0), uint64(1)
+		if k > 0 {
+			lo, hi = 1<<(k-1), 1<<k
+		}
+		bar := strings.Repeat("#", count*40/max(peak, 1))
+		fmt.Fprintf(w, "  %-24s %6d %s\n", fmt.Sprintf("[%d, %d)", lo, hi), count, bar)
+	}
+
+	if !a.Suspicious() {
+		fmt.Fprintf(w, "- No signs of a biased miner\n")
+		return
+	}
+	for _, finding := range a.Findings {
+		fmt.Fprintf(w, "- %s\n", finding)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// mineDeterministic mines n blocks with fixed timestamps, trying nonces
+// start, start+step, ... so the result is reproducible.
+func mineDeterministic(n, difficulty int, start, step uint64) []*Block {
+	chain := []*Block{{Index: 0, Data: []byte("Genesis"), PrevHash: []byte{}}}
+	chain[0].Hash = calculateHash(chain[0])
+
+	for i := 1; i <= n; i++ {
+		block := &Block{Index: i, Timestamp: int64(i), Data: []byte("audit"), PrevHash: chain[i-1].Hash}
+		for block.Nonce = start; ; block.Nonce += step {
+			block.Hash = calculateHash(block)
+			if validateDifficulty(block.Hash, difficulty) {
+				break
+			}
+		}
+		chain = append(chain, block)
+	}
+	return chain
+}
+
+// TestAuditNonces_HonestMiner checks that a sequential search raises no findings.
+func TestAuditNonces_HonestMiner(t *testing.T) {
+	audit := auditNonces(mineDeterministic(200, 1, 0, 1), 1)
+	if audit.Blocks != 200 {
+		t.Fatalf("expected 200 audited blocks, got %d", audit.Blocks)
+	}
+	if audit.Suspicious() {
+		t.Errorf("honest miner flagged: %v", audit.Findings)
+	}
+}
+
+// TestAuditNonces_EvenNonces verifies that a miner skipping odd nonces is detected.
+func TestAuditNonces_EvenNonces(t *testing.T) {
+	audit := auditNonces(mineDeterministic(200, 1, 0, 2), 1)
+	if !audit.Suspicious() {
+		t.Fatal("expected even-only nonces to be flagged")
+	}
+	if !strings.Contains(strings.Join(audit.Findings, "\n"), "nonce bit 0") {
+		t.Errorf("expected a finding about nonce bit 0, got %v", audit.Findings)
+	}
+}
+
+// TestAuditNonces_TooFewBlocks ensures small samples are reported rather than judged.
+func TestAuditNonces_TooFewBlocks(t *testing.T) {
+	audit := auditNonces(mineDeterministic(5, 1, 0, 1), 1)
+	if len(audit.Findings) != 1 || !strings.Contains(audit.Findings[0], "statistical checks") {
+		t.Errorf("expected a single too-few-blocks finding, got %v", audit.Findings)
+	}
+}
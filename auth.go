@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+// On a node with tenants every request carries a credential, and the
+// tenant it names has a role. Read-only tenants may only query the chain
+// and subscribe to events; the default role may also submit blocks; only
+// admins may control the node: its miner, its peers and its shutdown.
+// Without tenants the node trusts whoever reaches its address, which by
+// default is only this machine.
+
+// Tenant roles, as given in the tenants file.
+const (
+	roleReadOnly = "read-only"
+	roleUser     = "user"
+	roleAdmin    = "admin"
+)
+
+// Actions that change the chain or control the node, by role allowed to
+// take them. REST actions are named by their method and path.
+var (
+	writeActions = []string{"submitblock"}
+	adminActions = []string{"setgenerate", "setban", "stop", "POST /peers"}
+)
+
+// validRole reports whether role names a role; an empty role is roleUser.
+func validRole(role string) bool {
+	return role == "" || role == roleReadOnly || role == roleUser || role == roleAdmin
+}
+
+// authorize fails with rpcUnauthorized when the tenant of ctx may not take
+// action. Every action is allowed on a node without tenants.
+func authorize(ctx context.Context, action string) *rpcError {
+	t := tenantFrom(ctx)
+	switch {
+	case t == nil || t.Admin:
+		return nil
+	case slices.Contains(adminActions, action):
+		return &rpcError{Code: rpcUnauthorized, Message: fmt.Sprintf("%s needs the %s role", action, roleAdmin)}
+	case t.Role == roleReadOnly && slices.Contains(writeActions, action):
+		return &rpcError{Code: rpcUnauthorized, Message: fmt.Sprintf("tenant %q is %s", t.Name, roleReadOnly)}
+	}
+	return nil
+}
+
+// callStop answers the stop RPC, shutting the node down as SIGTERM would.
+func (s *rpcServer) callStop(ctx context.Context, params []json.RawMessage) (any, error) {
+	if err := rpcArgs(params); err != nil {
+		return nil, err
+	}
+	if s.shutdown == nil {
+		return nil, &rpcError{Code: rpcInvalidRequest, Message: "this node cannot be stopped over RPC"}
+	}
+	s.logger.LogAttrs(ctx, slog.LevelInfo, "stop_requested", slog.String("request_id", requestID(ctx)), slog.String("peer", peerFrom(ctx)))
+	s.shutdown()
+	return "blockchain daemon stopping", nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTenantRoles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	os.WriteFile(path, []byte(`[
+		{"name": "viewer", "key": "v", "role": "read-only"},
+		{"name": "ops", "key": "o", "role": "admin"},
+		{"name": "legacy", "key": "l", "admin": true}
+	]`), 0o644)
+	tenants, err := loadTenants(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tenants[0].Admin || !tenants[1].Admin || !tenants[2].Admin {
+		t.Errorf("admin flags %+v", tenants)
+	}
+	for _, bad := range []string{
+		`[{"name": "a", "key": "k", "role": "root"}]`,
+		`[{"name": "a", "key": "k", "role": "read-only", "admin": true}]`,
+	} {
+		os.WriteFile(path, []byte(bad), 0o644)
+		if _, err := loadTenants(path); err == nil {
+			t.Errorf("accepted %s", bad)
+		}
+	}
+}
+
+// TestRPCRoles checks which roles may submit blocks and control the node,
+// with bearer tokens and basic auth.
+func TestRPCRoles(t *testing.T) {
+	s := newRPCServer(makeBlockchain(2, 1), 1)
+	s.peers, _ = newPeerManager("")
+	stopped := false
+	s.shutdown = func() { stopped = true }
+	s.quotas = newQuotaTracker([]Tenant{
+		{Name: "viewer", Key: "viewer-key", Role: roleReadOnly},
+		{Name: "miner", Key: "miner-key"},
+		{Name: "ops", Key: "ops-key", Role: roleAdmin, Admin: true},
+	}, nil)
+	call := func(auth func(*http.Request), method, params string) *rpcError {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"jsonrpc":"2.0","method":"`+method+`","params":`+params+`,"id":1}`))
+		// An invalid block from a loopback client gets no ban
+		req.RemoteAddr = "127.0.0.1:5000"
+		auth(req)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		var resp rpcResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode response %q: %v", rec.Body.String(), err)
+		}
+		return resp.Error
+	}
+	bearer := func(key string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+key) }
+	}
+	basic := func(user, key string) func(*http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(user, key) }
+	}
+
+	if err := call(bearer("viewer-key"), "getblockcount", "[]"); err != nil {
+		t.Errorf("read-only getblockcount: %v", err)
+	}
+	if err := call(basic("viewer", "viewer-key"), "submitblock", `[{}]`); err == nil || err.Code != rpcUnauthorized {
+		t.Errorf("read-only submitblock: %v", err)
+	}
+	if err := call(basic("miner", "viewer-key"), "getblockcount", "[]"); err == nil || err.Code != rpcUnauthorized {
+		t.Errorf("basic auth with another tenant's key: %v", err)
+	}
+	for _, method := range []string{"setgenerate", "setban", "stop"} {
+		if err := call(bearer("miner-key"), method, "[]"); err == nil || err.Code != rpcUnauthorized {
+			t.Errorf("%s by a user: %v", method, err)
+		}
+	}
+	if err := call(bearer("miner-key"), "submitblock", `[{}]`); err != nil && err.Code == rpcUnauthorized {
+		t.Errorf("submitblock by a user: %v", err)
+	}
+	if err := call(basic("ops", "ops-key"), "stop", "[]"); err != nil || !stopped {
+		t.Errorf("admin stop: %v, stopped %t", err, stopped)
+	}
+
+	addPeer := func(key string) int {
+		req := httptest.NewRequest(http.MethodPost, "/peers", strings.NewReader(`{"url":"http://10.0.0.2:7544/"}`))
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := addPeer("miner-key"); code != http.StatusForbidden {
+		t.Errorf("POST /peers by a user: status %d", code)
+	}
+	if code := addPeer("ops-key"); code != http.StatusCreated {
+		t.Errorf("POST /peers by an admin: status %d", code)
+	}
+
+	// A ban refuses users from the address, but not an admin, who can lift it
+	s.peers.Ban("192.0.2.1", time.Hour, "test")
+	banned := func(key, method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"jsonrpc":"2.0","method":"`+method+`","params":["192.0.2.1","remove"],"id":1}`))
+		req.RemoteAddr = "192.0.2.1:5000"
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := banned("miner-key", "getblockcount"); rec.Code != http.StatusForbidden {
+		t.Errorf("user from a banned address: status %d", rec.Code)
+	}
+	if rec := banned("ops-key", "setban"); rec.Code != http.StatusOK || s.peers.Banned("192.0.2.1") {
+		t.Errorf("admin lifting the ban of its address: status %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRPCStopWithoutShutdown(t *testing.T) {
+	var resp rpcResponse
+	rpcPost(t, newRPCServer(makeBlockchain(2, 1), 1), `{"jsonrpc":"2.0","method":"stop","id":1}`, &resp)
+	if resp.Error == nil || resp.Error.Code != rpcInvalidRequest {
+		t.Errorf("stop without a daemon: %+v", resp.Error)
+	}
+}
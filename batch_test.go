@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// TestAppendBatch appends a run of blocks at once, and checks that a run
+// with an invalid block leaves the chain as it was.
+func TestAppendBatch(t *testing.T) {
+	chain := makeBlockchain(2, 1)
+	s := newRPCServer(chain, 1)
+	sub := s.bus.Subscribe(KindBlockAccepted, KindValidationFailed)
+	defer sub.Close()
+	_, tipChanged := s.work()
+
+	batch := extendChain(t, chain, 4, "batch")[2:]
+	bad := *batch[1]
+	bad.Nonce++
+	err := s.AppendBatch(context.Background(), []*Block{batch[0], &bad, batch[2]})
+	var verr *BlockValidationError
+	if !errors.As(err, &verr) || verr.Index != 3 {
+		t.Fatalf("batch with a bad block: %v", err)
+	}
+	if got := s.snapshot(); len(got) != 2 {
+		t.Fatalf("failed batch left %d blocks", len(got))
+	}
+	if ev, ok := (<-sub.C).(ValidationFailed); !ok || ev.Height != 3 || ev.Reason != "bad-hash" {
+		t.Errorf("failure event %+v", ev)
+	}
+
+	// A batch is held to the height and timestamp checks of a submission
+	skipping := *batch[0]
+	skipping.Index = 9
+	early := *batch[0]
+	early.Timestamp = chain[1].Timestamp - 1
+	for _, c := range []struct {
+		block  *Block
+		want   error
+		reason string
+	}{{&skipping, ErrBadHeight, "bad-height"}, {&early, ErrTimeTooOld, "time-too-old"}} {
+		if err := s.AppendBatch(context.Background(), []*Block{c.block, batch[1]}); !errors.Is(err, c.want) {
+			t.Errorf("batch with a %s block: %v", c.reason, err)
+		}
+		if ev, ok := (<-sub.C).(ValidationFailed); !ok || ev.Reason != c.reason {
+			t.Errorf("failure event %+v, want %s", ev, c.reason)
+		}
+		raw, _ := json.Marshal(c.block)
+		if reason := s.submitBlock(context.Background(), raw); reason != c.reason {
+			t.Errorf("submitting the %s block: %v", c.reason, reason)
+		}
+		<-sub.C
+	}
+	if got := s.snapshot(); len(got) != 2 {
+		t.Fatalf("failed batches left %d blocks", len(got))
+	}
+
+	if err := s.AppendBatch(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.snapshot(); len(got) != 6 || validateChain(got, 1) != nil {
+		t.Fatalf("chain of %d blocks after the batch", len(got))
+	}
+	if got, want := s.TotalWork(), chainWork(s.snapshot(), 1); got.Cmp(want) != 0 {
+		t.Errorf("total work %s, want %s", got, want)
+	}
+	select {
+	case <-tipChanged:
+	default:
+		t.Error("tip change not signalled")
+	}
+	for _, want := range batch {
+		if ev, ok := (<-sub.C).(BlockAccepted); !ok || ev.Block != want {
+			t.Errorf("accepted %+v, want block %d", ev, want.Index)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.AppendBatch(ctx, extendChain(t, s.snapshot(), 1, "late")[6:]); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled batch: %v", err)
+	}
+}
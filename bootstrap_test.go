@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestSnapshotRestore round-trips a chain through a bootstrap snapshot
+// that keeps the data of its recent blocks only.
+func TestSnapshotRestore(t *testing.T) {
+	chain := makeBlockchain(12, 1)
+	var buf bytes.Buffer
+	if err := Snapshot(&buf, chain, 3); err != nil {
+		t.Fatal(err)
+	}
+	tip := chain[11].Hash
+	restored, err := RestoreSnapshot(bytes.NewReader(buf.Bytes()), 1, tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 12 || !bytes.Equal(restored[11].Hash, tip) {
+		t.Fatalf("restored %d blocks", len(restored))
+	}
+	if len(chain[5].Data) == 0 || len(restored[5].Data) != 0 || dataLength(restored[5]) != len(chain[5].Data) {
+		t.Error("old block data not pruned, or its length lost")
+	}
+	if !bytes.Equal(restored[0].Data, chain[0].Data) || !bytes.Equal(restored[9].Data, chain[9].Data) {
+		t.Error("genesis or recent block data lost")
+	}
+	if got := pruneHeightOf(restored); got != 9 {
+		t.Errorf("prune height %d, want 9", got)
+	}
+
+	corrupt := bytes.Clone(buf.Bytes())
+	corrupt[len(corrupt)/2] ^= 1
+	if _, err := RestoreSnapshot(bytes.NewReader(corrupt), 1, nil); !errors.Is(err, ErrSnapshotIntegrity) {
+		t.Errorf("expected ErrSnapshotIntegrity, got %v", err)
+	}
+	if _, err := RestoreSnapshot(bytes.NewReader(buf.Bytes()), 1, chain[10].Hash); err == nil {
+		t.Error("restored a snapshot ending at another tip")
+	}
+	if _, err := RestoreSnapshot(bytes.NewReader(buf.Bytes()), 8, nil); err == nil {
+		t.Error("restored a chain below the difficulty")
+	}
+}
+
+// TestSnapshotCommands writes a snapshot of a data directory and boots
+// another one from it.
+func TestSnapshotCommands(t *testing.T) {
+	source, target := t.TempDir(), filepath.Join(t.TempDir(), "node")
+	chain := makeBlockchain(6, 1)
+	if err := saveStore(source, chain); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "chain.snap")
+	if code := runSnapshot([]string{"-datadir", source, "-output", path, "-recent", "2"}); code != exitOK {
+		t.Fatalf("snapshot: exit code %d", code)
+	}
+	tip := hex.EncodeToString(chain[5].Hash)
+	if code := runRestore([]string{"-file", path, "-datadir", target, "-difficulty", "1", "-tip", tip}); code != exitOK {
+		t.Fatalf("restore: exit code %d", code)
+	}
+	restored, report, err := loadStoredChain(target, &PoWEngine{Difficulty: 1}, true)
+	if err != nil || !report.Valid() || len(restored) != 6 || pruneHeightOf(restored) != 4 {
+		t.Fatalf("restored store: %d blocks, %v", len(restored), err)
+	}
+	if code := runRestore([]string{"-file", path, "-datadir", target, "-difficulty", "1"}); code != exitConfig {
+		t.Errorf("restore over a chain: expected exit code %d, got %d", exitConfig, code)
+	}
+
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, data[:len(data)-1], 0o644)
+	if code := runRestore([]string{"-file", path, "-datadir", t.TempDir(), "-difficulty", "1"}); code != exitStorage {
+		t.Errorf("truncated snapshot: expected exit code %d, got %d", exitStorage, code)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"sync"
+)
+
+// The event bus carries the lifecycle of the chain to the subsystems that
+// react to it, so that the chain code publishes what happened without
+// knowing who listens. WebSocket streams are one subscriber; anything else
+// wanting to follow blocks, rejections or peers subscribes the same way.
+
+// EventKind names a type of Event.
+type EventKind string
+
+// Event kinds.
+const (
+	KindBlockMined       EventKind = "block_mined"
+	KindBlockAccepted    EventKind = "block_accepted"
+	KindChainReorged     EventKind = "chain_reorged"
+	KindValidationFailed EventKind = "validation_failed"
+	KindPeerConnected    EventKind = "peer_connected"
+	KindReorgRefused     EventKind = "reorg_refused"
+)
+
+// busBuffer is how many events a subscriber may fall behind by before the
+// bus drops it.
+const busBuffer = 64
+
+// Event is something that happened to the chain or the node.
+type Event interface {
+	Kind() EventKind
+}
+
+// BlockMined is published when a block found by this node's miner, or by
+// a miner working on its stratum jobs, is offered to the chain. It is
+// followed by BlockAccepted or ValidationFailed.
+type BlockMined struct {
+	Block *Block
+}
+
+// BlockAccepted is published when a block becomes the tip of the chain,
+// by extending it or by a reorg onto its branch.
+type BlockAccepted struct {
+	Block *Block
+	// RequestID identifies the API request that submitted the block
+	RequestID string
+}
+
+// ChainReorged is published when a branch with more work replaces the
+// best chain above Fork, the height of the last block they share: by the
+// node when a submitted side branch overtakes its chain, and by a light
+// client given the bus.
+type ChainReorged struct {
+	Fork    int
+	OldTip  []byte
+	NewTip  []byte
+	Removed int // blocks of the old branch
+	Added   int // blocks of the new branch
+}
+
+// ValidationFailed is published when a block offered at Height is
+// rejected, with its BIP 22 reason.
+type ValidationFailed struct {
+	Height    int
+	Reason    string
+	RequestID string
+}
+
+// PeerConnected is published when a known peer proves its identity in a
+// handshake, with its URL and node ID, and when a client opens an event
+// stream, with its address and request ID.
+type PeerConnected struct {
+	Addr      string
+	NodeID    string
+	RequestID string
+}
+
+// ReorgRefused is a critical alert, published when a branch with more
+// work would replace more blocks than the node's maximum reorg depth and
+// the node stays on its chain instead. Either the node was cut off from
+// the network for that long or someone rewrote history; an operator must
+// find out which.
+type ReorgRefused struct {
+	Fork      int
+	Tip       []byte
+	BranchTip []byte
+	Depth     int // blocks the reorg would have removed
+	MaxDepth  int
+}
+
+func (BlockMined) Kind() EventKind       { return KindBlockMined }
+func (BlockAccepted) Kind() EventKind    { return KindBlockAccepted }
+func (ChainReorged) Kind() EventKind     { return KindChainReorged }
+func (ValidationFailed) Kind() EventKind { return KindValidationFailed }
+func (PeerConnected) Kind() EventKind    { return KindPeerConnected }
+func (ReorgRefused) Kind() EventKind     { return KindReorgRefused }
+
+// EventBus fans events out to subscribers. Publishing never blocks: a
+// subscriber that falls behind by more than busBuffer events is dropped,
+// and its channel closed, so a slow consumer cannot stall the chain. A
+// nil bus discards events.
+type EventBus struct {
+	mu   sync.Mutex
+	subs map[*Subscription]map[EventKind]bool
+}
+
+// Subscription receives the events of the kinds it was made for on C,
+// in the order they were published.
+type Subscription struct {
+	C   <-chan Event
+	c   chan Event
+	bus *EventBus
+}
+
+// NewEventBus returns a bus without subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[*Subscription]map[EventKind]bool)}
+}
+
+// Subscribe returns a subscription to events of kinds, or of every kind
+// when none are given.
+func (b *EventBus) Subscribe(kinds ...EventKind) *Subscription {
+	c := make(chan Event, busBuffer)
+	sub := &Subscription{C: c, c: c, bus: b}
+	var set map[EventKind]bool
+	if len(kinds) > 0 {
+		set = make(map[EventKind]bool, len(kinds))
+		for _, k := range kinds {
+			set[k] = true
+		}
+	}
+	b.mu.Lock()
+	b.subs[sub] = set
+	b.mu.Unlock()
+	return sub
+}
+
+// Close ends the subscription and closes C. It may be called more than
+// once, and after the bus dropped the subscription.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.drop(s)
+}
+
+// drop removes a subscription. The caller must hold b.mu.
+func (b *EventBus) drop(s *Subscription) {
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.c)
+	}
+}
+
+// Publish delivers ev to the subscribers of its kind.
+func (b *EventBus) Publish(ev Event) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub, kinds := range b.subs {
+		if kinds != nil && !kinds[ev.Kind()] {
+			continue
+		}
+		select {
+		case sub.c <- ev:
+		default:
+			b.drop(sub)
+		}
+	}
+}
+
+// Subscribers returns how many subscriptions are open.
+func (b *EventBus) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
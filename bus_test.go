@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestEventBus(t *testing.T) {
+	bus := NewEventBus()
+	blocks := bus.Subscribe(KindBlockAccepted)
+	all := bus.Subscribe()
+	defer all.Close()
+
+	bus.Publish(ValidationFailed{Height: 3, Reason: "high-hash"})
+	bus.Publish(BlockAccepted{RequestID: "r1"})
+	if ev := <-blocks.C; ev.(BlockAccepted).RequestID != "r1" {
+		t.Errorf("filtered subscriber got %+v", ev)
+	}
+	if ev := <-all.C; ev.Kind() != KindValidationFailed {
+		t.Errorf("first event of every kind: %+v", ev)
+	}
+	if ev := <-all.C; ev.Kind() != KindBlockAccepted {
+		t.Errorf("second event of every kind: %+v", ev)
+	}
+
+	// A subscriber that stops reading is dropped instead of blocking; one
+	// that keeps up stays
+	for range busBuffer + 1 {
+		bus.Publish(BlockAccepted{})
+		<-all.C
+	}
+	for range blocks.C {
+	}
+	if n := bus.Subscribers(); n != 1 {
+		t.Errorf("%d subscribers after the slow one was dropped", n)
+	}
+	blocks.Close()
+
+	var none *EventBus
+	none.Publish(BlockMined{})
+}
+
+func TestServerEvents(t *testing.T) {
+	chain := makeBlockchain(2, 1)
+	s := newRPCServer(chain, 1)
+	sub := s.bus.Subscribe()
+	defer sub.Close()
+
+	next, err := generateBlock(context.Background(), chain[1], "mined", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason := s.addBlock(context.Background(), next); reason != "" {
+		t.Fatal(reason)
+	}
+	if ev := <-sub.C; ev.(BlockMined).Block != next {
+		t.Errorf("first event %+v", ev)
+	}
+	if ev := <-sub.C; ev.(BlockAccepted).Block != next {
+		t.Errorf("second event %+v", ev)
+	}
+
+	// A duplicate publishes nothing; a block skipping a height fails
+	raw, _ := json.Marshal(next)
+	s.submitBlock(context.Background(), raw)
+	skipping := *next
+	skipping.Index = 5
+	raw, _ = json.Marshal(&skipping)
+	s.submitBlock(withRequestID(context.Background(), "req-1"), raw)
+	if ev := <-sub.C; ev.Kind() != KindValidationFailed {
+		t.Errorf("rejected submission published %+v", ev)
+	} else if f := ev.(ValidationFailed); f.Height != 3 || f.Reason != "bad-height" || f.RequestID != "req-1" {
+		t.Errorf("validation failure %+v", f)
+	}
+}
+
+// TestServerReorg submits a side branch block by block: it is kept aside
+// while it has less work, and the chain switches to it once it has more.
+func TestServerReorg(t *testing.T) {
+	base := makeBlockchain(2, 1)
+	best := extendChain(t, base, 2, "best")
+	side := extendChain(t, base, 3, "side")
+	s := newRPCServer(best, 1)
+	sub := s.bus.Subscribe(KindChainReorged, KindBlockAccepted)
+	defer sub.Close()
+
+	submit := func(b *Block) any {
+		raw, _ := json.Marshal(b)
+		return s.submitBlock(context.Background(), raw)
+	}
+	for _, b := range side[2:4] {
+		if reason := submit(b); reason != "inconclusive" {
+			t.Fatalf("block %d of the lighter branch: %v", b.Index, reason)
+		}
+	}
+	if tip := s.tip(); tip != best[3] {
+		t.Fatalf("tip moved to %d before the branch had more work", tip.Index)
+	}
+	if reason := submit(side[4]); reason != nil {
+		t.Fatalf("block overtaking the chain: %v", reason)
+	}
+	if tip := s.tip(); tip.Index != 4 || !bytes.Equal(tip.Hash, side[4].Hash) {
+		t.Fatalf("tip is %d %x, want the side branch", tip.Index, tip.Hash)
+	}
+	if err := validateChain(s.snapshot(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.TotalWork(), chainWork(side, 1); got.Cmp(want) != 0 {
+		t.Errorf("total work %s after the reorg, want %s", got, want)
+	}
+	var info struct{ Result struct{ ChainWork string } }
+	rpcPost(t, s, `{"jsonrpc":"2.0","method":"getblockchaininfo","id":1}`, &info)
+	if want := fmt.Sprintf("%064x", chainWork(side, 1)); info.Result.ChainWork != want {
+		t.Errorf("getblockchaininfo chainwork %q, want %q", info.Result.ChainWork, want)
+	}
+	var block struct{ Result *rpcBlock }
+	rpcPost(t, s, fmt.Sprintf(`{"jsonrpc":"2.0","method":"getblock","params":["%x"],"id":1}`, side[3].Hash), &block)
+	if want := fmt.Sprintf("%064x", chainWork(side[:4], 1)); block.Result.ChainWork != want {
+		t.Errorf("getblock chainwork %q, want %q", block.Result.ChainWork, want)
+	}
+	if n := s.reorgs.Load(); n != 1 {
+		t.Errorf("%d reorgs counted", n)
+	}
+	r, ok := (<-sub.C).(ChainReorged)
+	if !ok || r.Fork != 1 || r.Removed != 2 || r.Added != 3 || !bytes.Equal(r.OldTip, best[3].Hash) {
+		t.Errorf("reorg %+v", r)
+	}
+	if ev, ok := (<-sub.C).(BlockAccepted); !ok || ev.Block != s.tip() {
+		t.Errorf("accepted %+v", ev)
+	}
+
+	// The old branch is now the side branch, and a block for it is kept
+	if reason := submit(best[2]); reason != "duplicate" {
+		t.Errorf("resubmitted block of the old branch: %v", reason)
+	}
+	orphan := *side[4]
+	orphan.Index, orphan.PrevHash = 5, make([]byte, 32)
+	orphan.Hash = calculateHash(&orphan)
+	if reason := submit(&orphan); reason != "bad-prevblk" {
+		t.Errorf("block of an unknown branch: %v", reason)
+	}
+}
+
+// TestServerReorgWork checks that branches are weighed by the targets
+// their blocks meet rather than by their length: a longer branch mined
+// at the server's difficulty loses to a chain mined at a harder one.
+func TestServerReorgWork(t *testing.T) {
+	base := makeBlockchain(2, 1)
+	heavy := append([]*Block(nil), base...)
+	for i := range 2 {
+		block, _, err := mineBlock(context.Background(), heavy[len(heavy)-1], fmt.Sprintf("heavy %d", i), 2, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		heavy = append(heavy, block)
+	}
+	light := extendChain(t, base, 4, "light")
+	s := newRPCServer(heavy, 1)
+	if got, want := s.TotalWork(), chainWork(heavy, 1); got.Cmp(want) != 0 {
+		t.Fatalf("total work %s, want %s", got, want)
+	}
+
+	for _, b := range light[2:] {
+		raw, _ := json.Marshal(b)
+		if reason := s.submitBlock(context.Background(), raw); reason != "inconclusive" {
+			t.Fatalf("block %d of the longer, lighter branch: %v", b.Index, reason)
+		}
+	}
+	if tip := s.tip(); tip != heavy[3] {
+		t.Errorf("tip moved to %d %x", tip.Index, tip.Hash)
+	}
+	if n := s.reorgs.Load(); n != 0 {
+		t.Errorf("%d reorgs counted", n)
+	}
+}
+
+// TestServerMaxReorgDepth checks that a reorg deeper than the limit is
+// refused with an alert, and one within it goes ahead.
+func TestServerMaxReorgDepth(t *testing.T) {
+	base := makeBlockchain(2, 1)
+	best := extendChain(t, base, 2, "best")
+	side := extendChain(t, base, 3, "side")
+	s := newRPCServer(best, 1)
+	s.maxReorgDepth = 1
+	sub := s.bus.Subscribe(KindReorgRefused, KindChainReorged)
+	defer sub.Close()
+
+	submit := func(b *Block) any {
+		raw, _ := json.Marshal(b)
+		return s.submitBlock(context.Background(), raw)
+	}
+	for _, b := range side[2:4] {
+		submit(b)
+	}
+	if reason := submit(side[4]); reason != "reorg-too-deep" {
+		t.Fatalf("block overtaking two blocks deep: %v", reason)
+	}
+	if tip := s.tip(); tip != best[3] {
+		t.Fatalf("tip moved to %d %x", tip.Index, tip.Hash)
+	}
+	alert, ok := (<-sub.C).(ReorgRefused)
+	if !ok || alert.Fork != 1 || alert.Depth != 2 || alert.MaxDepth != 1 || !bytes.Equal(alert.BranchTip, side[4].Hash) {
+		t.Fatalf("alert %+v", alert)
+	}
+	if ev, ok := s.wsEvent(alert); !ok || ev.Topic != topicAlerts || ev.Depth != 2 {
+		t.Errorf("WebSocket event %+v", ev)
+	}
+
+	// The branch was kept, so raising the limit lets its next block through
+	s.mu.Lock()
+	s.maxReorgDepth = 2
+	s.mu.Unlock()
+	next := extendChain(t, side, 1, "next")[5]
+	if reason := submit(next); reason != nil {
+		t.Fatalf("block within the limit: %v", reason)
+	}
+	if ev, ok := (<-sub.C).(ChainReorged); !ok || ev.Removed != 2 {
+		t.Errorf("reorg %+v", ev)
+	}
+}
+
+func TestLightClientReorgEvent(t *testing.T) {
+	base := makeBlockchain(2, 1)
+	short := extendChain(t, base, 2, "short")
+	long := extendChain(t, base, 3, "long")
+	client := NewLightClient(base[0].Header(), 1)
+	bus := NewEventBus()
+	client.UseBus(bus)
+	sub := bus.Subscribe(KindChainReorged)
+	defer sub.Close()
+
+	var headers []*BlockHeader
+	for _, block := range short[1:] {
+		headers = append(headers, block.Header())
+	}
+	client.AddHeaders(headers)
+	headers = nil
+	for _, block := range long[2:] {
+		headers = append(headers, block.Header())
+	}
+	if changed, err := client.AddHeaders(headers); err != nil || !changed {
+		t.Fatalf("AddHeaders(long) = %v, %v", changed, err)
+	}
+	select {
+	case ev := <-sub.C:
+		r := ev.(ChainReorged)
+		if r.Fork != 1 || r.Removed != 2 || r.Added != 3 || !bytes.Equal(r.OldTip, short[3].Hash) || !bytes.Equal(r.NewTip, long[4].Hash) {
+			t.Errorf("reorg %+v", r)
+		}
+	default:
+		t.Fatal("no reorg published")
+	}
+	if len(sub.C) != 0 {
+		t.Error("extending the chain published a reorg")
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"unicode/utf8"
+)
+
+// CBOR encoding of blocks and chains (RFC 8949). Byte fields stay bytes,
+// where JSON has to base64 them, and the encoding is deterministic as in
+// section 4.2.1 of the RFC: integers and lengths take their shortest
+// form, lengths are always definite and map keys are sorted. A block's
+// map uses the JSON field names as keys and, like the JSON, leaves out
+// the omitempty fields when they are empty. A Merkle root or epoch summary
+// is written whenever it is set, since its presence changes the hash.
+//
+// Every block has exactly one encoding. The decoder accepts that one and
+// nothing else, so equal blocks always have equal bytes and a decoded
+// block re-encodes to its input.
+
+// CBOR major types.
+const (
+	cborUint  = 0
+	cborNeg   = 1
+	cborBytes = 2
+	cborText  = 3
+	cborArray = 4
+	cborMap   = 5
+)
+
+var errCBORTruncated = errors.New("cbor: truncated item")
+
+// MarshalCBOR encodes the block as a canonical CBOR map. It never fails;
+// the error is there to match the usual CBOR marshaler interface.
+func (b *Block) MarshalCBOR() ([]byte, error) {
+	return b.appendCBOR(nil), nil
+}
+
+func (b *Block) appendCBOR(out []byte) []byte {
+	m := cborFields{
+		{"index", appendCBORInt(nil, int64(b.Index))},
+		{"timestamp", appendCBORInt(nil, b.Timestamp)},
+		{"data", appendCBORHead(nil, cborBytes, uint64(len(b.Data)), b.Data...)},
+		{"prev_hash", appendCBORHead(nil, cborBytes, uint64(len(b.PrevHash)), b.PrevHash...)},
+		{"hash", appendCBORHead(nil, cborBytes, uint64(len(b.Hash)), b.Hash...)},
+		{"nonce", appendCBORHead(nil, cborUint, b.Nonce)},
+	}
+	if b.Bits != 0 {
+		m = append(m, cborField{"bits", appendCBORHead(nil, cborUint, uint64(b.Bits))})
+	}
+	if b.HashAlgo != 0 {
+		m = append(m, cborField{"hash_algo", appendCBORHead(nil, cborUint, uint64(b.HashAlgo))})
+	}
+	if b.ExtraNonce != 0 {
+		m = append(m, cborField{"extra_nonce", appendCBORHead(nil, cborUint, b.ExtraNonce)})
+	}
+	if b.MerkleRoot != nil {
+		m = append(m, cborField{"merkle_root", appendCBORHead(nil, cborBytes, uint64(len(b.MerkleRoot)), b.MerkleRoot...)})
+	}
+	if e := b.Epoch; e != nil {
+		fields := cborFields{
+			{"epoch", appendCBORInt(nil, int64(e.Epoch))},
+			{"hash", appendCBORHead(nil, cborBytes, uint64(len(e.Hash)), e.Hash...)},
+			{"start_time", appendCBORInt(nil, e.StartTime)},
+			{"end_time", appendCBORInt(nil, e.EndTime)},
+			{"data_bytes", appendCBORInt(nil, e.DataBytes)},
+		}
+		if len(e.ValidatorsHash) != 0 {
+			fields = append(fields, cborField{"validators_hash", appendCBORHead(nil, cborBytes, uint64(len(e.ValidatorsHash)), e.ValidatorsHash...)})
+		}
+		m = append(m, cborField{"epoch", fields.appendCBOR(nil)})
+	}
+	if r := b.Redaction; r != nil {
+		m = append(m, cborField{"redaction", cborFields{
+			{"reason", appendCBORHead(nil, cborText, uint64(len(r.Reason)), []byte(r.Reason)...)},
+			{"redacted_at", appendCBORInt(nil, r.RedactedAt)},
+			{"data_length", appendCBORInt(nil, int64(r.DataLength))},
+		}.appendCBOR(nil)})
+	}
+	if len(b.Signature) != 0 {
+		m = append(m, cborField{"signature", appendCBORHead(nil, cborBytes, uint64(len(b.Signature)), b.Signature...)})
+	}
+	return m.appendCBOR(out)
+}
+
+// UnmarshalCBOR decodes a block written by MarshalCBOR into b. Encodings
+// MarshalCBOR would not have written, including ones with unknown keys,
+// are refused, as are blocks beyond blockLimits.
+func (b *Block) UnmarshalCBOR(data []byte) error {
+	if n := int64(len(data)); n > blockLimits.MaxBlockBytes {
+		return fmt.Errorf("cbor: %w: block is %d bytes, limit %d", ErrBlockTooLarge, n, blockLimits.MaxBlockBytes)
+	}
+	d := &cborDecoder{raw: data}
+	block, err := d.block()
+	if err != nil {
+		return err
+	}
+	if d.off != len(data) {
+		return fmt.Errorf("cbor: %d trailing bytes", len(data)-d.off)
+	}
+	*b = *block
+	return nil
+}
+
+// marshalChainCBOR encodes a chain as a CBOR array of blocks.
+func marshalChainCBOR(chain []*Block) []byte {
+	out := appendCBORHead(nil, cborArray, uint64(len(chain)))
+	for _, block := range chain {
+		out = block.appendCBOR(out)
+	}
+	return out
+}
+
+// unmarshalChainCBOR decodes a chain written by marshalChainCBOR, calling
+// check with the position of each block, the block and the size of its
+// encoding as it is decoded.
+func unmarshalChainCBOR(data []byte, check func(i int, block *Block, size int) error) ([]*Block, error) {
+	d := &cborDecoder{raw: data}
+	n, err := d.head(cborArray, "chain")
+	if err != nil {
+		return nil, err
+	}
+	// Every block takes at least a byte, so n cannot exceed what is left
+	if n > uint64(len(data)-d.off) {
+		return nil, errCBORTruncated
+	}
+	chain := make([]*Block, 0, n)
+	for i := range int(n) {
+		start := d.off
+		block, err := d.block()
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", i, err)
+		}
+		if err := check(i, block, d.off-start); err != nil {
+			return nil, err
+		}
+		chain = append(chain, block)
+	}
+	if d.off != len(data) {
+		return nil, fmt.Errorf("cbor: %d trailing bytes", len(data)-d.off)
+	}
+	return chain, nil
+}
+
+// cborField is a map entry whose value is already encoded.
+type cborField struct {
+	key   string
+	value []byte
+}
+
+type cborFields []cborField
+
+// appendCBOR writes the fields as a map with its keys in canonical order.
+// All keys are text strings, so that order is by length and then bytewise.
+func (m cborFields) appendCBOR(out []byte) []byte {
+	sort.Slice(m, func(i, j int) bool {
+		if len(m[i].key) != len(m[j].key) {
+			return len(m[i].key) < len(m[j].key)
+		}
+		return m[i].key < m[j].key
+	})
+	out = appendCBORHead(out, cborMap, uint64(len(m)))
+	for _, f := range m {
+		out = appendCBORHead(out, cborText, uint64(len(f.key)), []byte(f.key)...)
+		out = append(out, f.value...)
+	}
+	return out
+}
+
+// appendCBORHead writes the head of an item in its shortest form, followed
+// by contents, if any.
+func appendCBORHead(out []byte, major byte, arg uint64, contents ...byte) []byte {
+	major <<= 5
+	switch {
+	case arg < 24:
+		out = append(out, major|byte(arg))
+	case arg <= math.MaxUint8:
+		out = append(out, major|24, byte(arg))
+	case arg <= math.MaxUint16:
+		out = binary.BigEndian.AppendUint16(append(out, major|25), uint16(arg))
+	case arg <= math.MaxUint32:
+		out = binary.BigEndian.AppendUint32(append(out, major|26), uint32(arg))
+	default:
+		out = binary.BigEndian.AppendUint64(append(out, major|27), arg)
+	}
+	return append(out, contents...)
+}
+
+func appendCBORInt(out []byte, v int64) []byte {
+	if v < 0 {
+		return appendCBORHead(out, cborNeg, uint64(-1-v))
+	}
+	return appendCBORHead(out, cborUint, uint64(v))
+}
+
+// cborDecoder reads the items MarshalCBOR writes. It only handles the
+// types a block uses; indefinite lengths, tags and floats are refused.
+type cborDecoder struct {
+	raw []byte
+	off int
+}
+
+// head reads the head of an item of the given major type and returns its
+// argument. Arguments not in their shortest form are refused.
+func (d *cborDecoder) head(major byte, what string) (uint64, error) {
+	if d.off >= len(d.raw) {
+		return 0, errCBORTruncated
+	}
+	initial := d.raw[d.off]
+	if initial>>5 != major {
+		return 0, fmt.Errorf("cbor: %s has major type %d, want %d", what, initial>>5, major)
+	}
+	d.off++
+	info := initial & 0x1f
+	if info < 24 {
+		return uint64(info), nil
+	}
+	if info > 27 {
+		return 0, fmt.Errorf("cbor: %s has unsupported additional information %d", what, info)
+	}
+	size := 1 << (info - 24)
+	if len(d.raw)-d.off < size {
+		return 0, errCBORTruncated
+	}
+	var arg uint64
+	for _, c := range d.raw[d.off : d.off+size] {
+		arg = arg<<8 | uint64(c)
+	}
+	d.off += size
+	if size > 1 && arg>>(4*size) == 0 || size == 1 && arg < 24 {
+		return 0, fmt.Errorf("cbor: %s length or value is not in its shortest form", what)
+	}
+	return arg, nil
+}
+
+func (d *cborDecoder) uint(what string, max uint64) (uint64, error) {
+	v, err := d.head(cborUint, what)
+	if err == nil && v > max {
+		err = fmt.Errorf("cbor: %s %d out of range", what, v)
+	}
+	return v, err
+}
+
+func (d *cborDecoder) int(what string) (int64, error) {
+	if d.off < len(d.raw) && d.raw[d.off]>>5 == cborNeg {
+		v, err := d.head(cborNeg, what)
+		if err == nil && v > math.MaxInt64 {
+			err = fmt.Errorf("cbor: %s -1-%d out of range", what, v)
+		}
+		return -1 - int64(v), err
+	}
+	v, err := d.uint(what, math.MaxInt64)
+	return int64(v), err
+}
+
+// bytes reads a byte or text string and returns a copy of its contents.
+func (d *cborDecoder) bytes(major byte, what string) ([]byte, error) {
+	n, err := d.head(major, what)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(d.raw)-d.off) {
+		return nil, errCBORTruncated
+	}
+	b := append([]byte{}, d.raw[d.off:d.off+int(n)]...)
+	d.off += int(n)
+	if major == cborText && !utf8.Valid(b) {
+		return nil, fmt.Errorf("cbor: %s is not valid UTF-8", what)
+	}
+	return b, nil
+}
+
+// fields reads a map with text keys, calling fn to decode each value.
+// Keys must be in canonical order, which also rules out duplicates.
+func (d *cborDecoder) fields(what string, fn func(key string) error) error {
+	n, err := d.head(cborMap, what)
+	if err != nil {
+		return err
+	}
+	var prev string
+	for i := range n {
+		key, err := d.bytes(cborText, what+" key")
+		if err != nil {
+			return err
+		}
+		k := string(key)
+		if i > 0 && (len(k) < len(prev) || len(k) == len(prev) && k <= prev) {
+			return fmt.Errorf("cbor: %s key %q out of order", what, k)
+		}
+		prev = k
+		if err := fn(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// block decodes one block and checks that it was canonically encoded.
+func (d *cborDecoder) block() (*Block, error) {
+	start := d.off
+	b := &Block{Data: []byte{}, PrevHash: []byte{}}
+	err := d.fields("block", func(key string) error {
+		var err error
+		var v int64
+		var u uint64
+		switch key {
+		case "index":
+			v, err = d.int(key)
+			if err == nil && int64(int(v)) != v {
+				err = fmt.Errorf("cbor: index %d out of range", v)
+			}
+			b.Index = int(v)
+		case "timestamp":
+			b.Timestamp, err = d.int(key)
+		case "data":
+			b.Data, err = d.bytes(cborBytes, key)
+			if n := int64(len(b.Data)); err == nil && n > blockLimits.MaxDataBytes {
+				err = fmt.Errorf("cbor: %w: data is %d bytes, limit %d", ErrBlockTooLarge, n, blockLimits.MaxDataBytes)
+			}
+		case "prev_hash":
+			b.PrevHash, err = d.bytes(cborBytes, key)
+		case "hash":
+			b.Hash, err = d.bytes(cborBytes, key)
+		case "nonce":
+			b.Nonce, err = d.uint(key, math.MaxUint64)
+		case "bits":
+			u, err = d.uint(key, math.MaxUint32)
+			b.Bits = uint32(u)
+		case "hash_algo":
+			u, err = d.uint(key, math.MaxUint8)
+			b.HashAlgo = byte(u)
+		case "extra_nonce":
+			b.ExtraNonce, err = d.uint(key, math.MaxUint64)
+		case "merkle_root":
+			b.MerkleRoot, err = d.bytes(cborBytes, key)
+		case "epoch":
+			b.Epoch, err = d.epoch()
+		case "redaction":
+			b.Redaction, err = d.redaction()
+		case "signature":
+			b.Signature, err = d.bytes(cborBytes, key)
+		default:
+			err = fmt.Errorf("cbor: unknown block field %q", key)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(b.appendCBOR(nil), d.raw[start:d.off]) {
+		return nil, errors.New("cbor: block is not canonically encoded")
+	}
+	return b, nil
+}
+
+func (d *cborDecoder) epoch() (*EpochSummary, error) {
+	e := new(EpochSummary)
+	err := d.fields("epoch", func(key string) error {
+		var err error
+		var v int64
+		switch key {
+		case "epoch":
+			v, err = d.int(key)
+			if err == nil && int64(int(v)) != v {
+				err = fmt.Errorf("cbor: epoch %d out of range", v)
+			}
+			e.Epoch = int(v)
+		case "hash":
+			e.Hash, err = d.bytes(cborBytes, key)
+		case "start_time":
+			e.StartTime, err = d.int(key)
+		case "end_time":
+			e.EndTime, err = d.int(key)
+		case "data_bytes":
+			e.DataBytes, err = d.int(key)
+		case "validators_hash":
+			e.ValidatorsHash, err = d.bytes(cborBytes, key)
+		default:
+			err = fmt.Errorf("cbor: unknown epoch field %q", key)
+		}
+		return err
+	})
+	return e, err
+}
+
+func (d *cborDecoder) redaction() (*Redaction, error) {
+	r := new(Redaction)
+	err := d.fields("redaction", func(key string) error {
+		var err error
+		var v int64
+		switch key {
+		case "reason":
+			var reason []byte
+			reason, err = d.bytes(cborText, key)
+			r.Reason = string(reason)
+		case "redacted_at":
+			r.RedactedAt, err = d.int(key)
+		case "data_length":
+			v, err = d.int(key)
+			if err == nil && int64(int(v)) != v {
+				err = fmt.Errorf("cbor: data length %d out of range", v)
+			}
+			r.DataLength = int(v)
+		default:
+			err = fmt.Errorf("cbor: unknown redaction field %q", key)
+		}
+		return err
+	})
+	return r, err
+}
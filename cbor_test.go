@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestBlockCBOR_KnownEncoding pins the encoding: keys sorted by length
+// and then bytewise, integers in their shortest form, and the omitempty
+// fields left out.
+func TestBlockCBOR_KnownEncoding(t *testing.T) {
+	block := &Block{Index: 1, Timestamp: -2, Data: []byte("hi"), PrevHash: []byte{}, Hash: []byte{}, Nonce: 300, HashAlgo: HashBLAKE3}
+	want := "a7" +
+		"6464617461" + "426869" +
+		"6468617368" + "40" +
+		"65696e646578" + "01" +
+		"656e6f6e6365" + "19012c" +
+		"69686173685f616c676f" + "02" +
+		"69707265765f68617368" + "40" +
+		"6974696d657374616d70" + "21"
+	raw, _ := block.MarshalCBOR()
+	if got := hex.EncodeToString(raw); got != want {
+		t.Fatalf("MarshalCBOR = %s, want %s", got, want)
+	}
+
+	var decoded Block
+	if err := decoded.UnmarshalCBOR(raw); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, block) {
+		t.Errorf("round trip changed block: %+v != %+v", decoded, *block)
+	}
+}
+
+// TestChainCBOR_RoundTrip exports a chain with every optional field as
+// CBOR and imports it again.
+func TestChainCBOR_RoundTrip(t *testing.T) {
+	chain := makeBlockchain(4, 1)
+	chain[1].ExtraNonce = 1 << 40
+	chain[2].Epoch = &EpochSummary{Epoch: 1, Hash: make([]byte, 32), StartTime: -1, DataBytes: 300}
+	chain[3].Signature = []byte("sig")
+	for _, block := range chain {
+		block.Hash = calculateHash(block)
+	}
+	redacted := *chain[3]
+	redacted.Data = []byte{}
+	redacted.Redaction = &Redaction{Reason: "gdpr", RedactedAt: 1700000000, DataLength: 7}
+
+	path := filepath.Join(t.TempDir(), "chain.cbor")
+	want := append(chain[:3:3], &redacted)
+	if err := writeChainFile(want, path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := unmarshalChainCBOR(data, func(int, *Block, int) error { return nil })
+	if err != nil {
+		t.Fatalf("unmarshalChainCBOR: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Fatal("decoded chain differs from the original")
+	}
+	if _, err := readChainFile(path, DecodePolicy{Strict: true, MaxBlockBytes: 100}); !errors.Is(err, ErrImportLimit) {
+		t.Errorf("expected the block size limit, got %v", err)
+	}
+}
+
+// TestBlockCBOR_Malformed covers inputs the decoder must reject, among
+// them every encoding of a block but the canonical one.
+func TestBlockCBOR_Malformed(t *testing.T) {
+	full, _ := (&Block{Index: 1, Data: []byte("data")}).MarshalCBOR()
+	cases := map[string]string{
+		"truncated":            hex.EncodeToString(full[:len(full)-1]),
+		"not a map":            "80",
+		"indefinite map":       "bf",
+		"integer key":          "a10101",
+		"unknown key":          "a1637a7a7a01",
+		"long-form index":      "a165696e6465781801",
+		"keys out of order":    "a2656e6f6e6365" + "01" + "6464617461" + "40",
+		"duplicate key":        "a2656e6f6e636501656e6f6e636501",
+		"missing fields":       "a165696e64657801",
+		"zero bits written":    "a7" + "6462697473" + "00" + hex.EncodeToString(full[1:]),
+		"hash algo too large":  "a169686173685f616c676f190100",
+		"negative nonce":       "a1656e6f6e636520",
+		"text for bytes":       "a16464617461" + "6161",
+		"invalid UTF-8 reason": "a169726564616374696f6ea166726561736f6e61ff",
+	}
+	for name, h := range cases {
+		raw, _ := hex.DecodeString(h)
+		var b Block
+		if err := b.UnmarshalCBOR(raw); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	var b Block
+	if err := b.UnmarshalCBOR(append(full, 0)); err == nil {
+		t.Error("trailing byte: expected an error")
+	}
+	if err := b.UnmarshalCBOR(full[:len(full)-1]); !errors.Is(err, errCBORTruncated) {
+		t.Errorf("expected errCBORTruncated, got %v", err)
+	}
+	if _, err := unmarshalChainCBOR([]byte{0x9a, 0xff, 0xff, 0xff, 0xff}, nil); !errors.Is(err, errCBORTruncated) {
+		t.Errorf("huge block count: %v", err)
+	}
+
+	t.Cleanup(func() { blockLimits = defaultBlockLimits })
+	blockLimits = BlockLimits{MaxBlockBytes: 1 << 10, MaxDataBytes: 3}
+	if err := b.UnmarshalCBOR(full); !errors.Is(err, ErrBlockTooLarge) {
+		t.Errorf("expected ErrBlockTooLarge for the data, got %v", err)
+	}
+}
+
+// TestCommandsCBOR exports an imported chain with -format cbor and
+// imports the result, whose extension says nothing of its format.
+func TestCommandsCBOR(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "chain.json")
+	if err := writeChainFile(makeBlockchain(3, 1), src); err != nil {
+		t.Fatal(err)
+	}
+	dataDir := filepath.Join(dir, "data")
+	if code := runImport([]string{"-file", src, "-datadir", dataDir, "-difficulty", "1"}); code != exitOK {
+		t.Fatalf("import: exit %d", code)
+	}
+	out := filepath.Join(dir, "chain.bin")
+	if code := runExport([]string{"-datadir", dataDir, "-output", out, "-format", "xml"}); code != exitConfig {
+		t.Errorf("export -format xml: exit %d", code)
+	}
+	if code := runExport([]string{"-datadir", dataDir, "-output", out, "-format", "cbor"}); code != exitOK {
+		t.Fatalf("export -format cbor: exit %d", code)
+	}
+	if raw, _ := os.ReadFile(out); len(raw) == 0 || raw[0] != 0x83 {
+		t.Fatalf("export wrote % x..., want a CBOR array of 3 blocks", raw[:min(len(raw), 4)])
+	}
+
+	again := filepath.Join(dir, "again")
+	if code := runImport([]string{"-file", out, "-datadir", again, "-difficulty", "1"}); code == exitOK {
+		t.Error("imported CBOR as JSON")
+	}
+	if code := runImport([]string{"-file", out, "-datadir", again, "-difficulty", "1", "-format", "cbor"}); code != exitOK {
+		t.Fatalf("import -format cbor: exit %d", code)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Chain files come in four formats, selected by extension:
+//
+//	.json   indented JSON array (the default for any other extension)
+//	.jsonl  JSON Lines, one block per line
+//	.pb     protobuf Chain message
+//	.cbor   canonical CBOR array of blocks
+//
+// A trailing .gz, as in chain.jsonl.gz, gzip-compresses any of them. The
+// JSON formats are written and read one block at a time, so exporting or
+// importing a large chain never holds its whole encoding in memory.
+
+type chainFormat int
+
+const (
+	formatJSON chainFormat = iota
+	formatJSONL
+	formatProto
+	formatCBOR
+)
+
+// chainFormatNames are the format names the -format flag accepts.
+var chainFormatNames = map[string]chainFormat{
+	"json":  formatJSON,
+	"jsonl": formatJSONL,
+	"pb":    formatProto,
+	"cbor":  formatCBOR,
+}
+
+// chainFileFormat returns the format and compression a path selects.
+func chainFileFormat(path string) (chainFormat, bool) {
+	name := strings.ToLower(path)
+	gzipped := strings.HasSuffix(name, ".gz")
+	name = strings.TrimSuffix(name, ".gz")
+	switch {
+	case strings.HasSuffix(name, ".jsonl"):
+		return formatJSONL, gzipped
+	case strings.HasSuffix(name, ".pb"):
+		return formatProto, gzipped
+	case strings.HasSuffix(name, ".cbor"):
+		return formatCBOR, gzipped
+	}
+	return formatJSON, gzipped
+}
+
+// chainFormatFlag returns the format a -format flag names, or the one
+// path selects if the flag is empty.
+func chainFormatFlag(name, path string) (chainFormat, error) {
+	if name == "" {
+		format, _ := chainFileFormat(path)
+		return format, nil
+	}
+	format, ok := chainFormatNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown format %q; use json, jsonl, pb or cbor", name)
+	}
+	return format, nil
+}
+
+// writeChainFile writes the chain to path in the format its extension
+// selects. The file is overwritten if it already exists.
+func writeChainFile(chain []*Block, path string) error {
+	format, _ := chainFileFormat(path)
+	return writeChainFileAs(chain, path, format)
+}
+
+// writeChainFileAs writes the chain to path in the given format, gzipped
+// if the path ends in .gz.
+func writeChainFileAs(chain []*Block, path string, format chainFormat) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	_, gzipped := chainFileFormat(path)
+	w := injectedFaults.Writer(f)
+	if gzipped {
+		zw := gzip.NewWriter(w)
+		defer func() {
+			if cerr := zw.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		w = zw
+	}
+
+	switch format {
+	case formatJSONL:
+		return encodeChainJSONL(w, chain)
+	case formatProto:
+		_, err := w.Write(marshalChainProto(chain))
+		return err
+	case formatCBOR:
+		_, err := w.Write(marshalChainCBOR(chain))
+		return err
+	}
+	return encodeChainJSON(w, chain)
+}
+
+// readChainFile loads a chain written by writeChainFile, decoding it under
+// the given policy, and checks that every block is consistent with the
+// canonical serializer before returning.
+func readChainFile(path string, policy DecodePolicy) ([]*Block, error) {
+	format, _ := chainFileFormat(path)
+	return readChainFileAs(path, format, policy)
+}
+
+// readChainFileAs is readChainFile for a file in the given format.
+func readChainFileAs(path string, format chainFormat, policy DecodePolicy) ([]*Block, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	_, gzipped := chainFileFormat(path)
+	var r io.Reader = f
+	if gzipped {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, &ChainDecodeError{Path: path, Err: err}
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	var chain []*Block
+	switch format {
+	case formatJSONL:
+		err = decodeChainJSONL(r, policy, func(block *Block) error {
+			chain = append(chain, block)
+			return nil
+		})
+	case formatProto:
+		chain, err = decodeChainProto(r, policy)
+	case formatCBOR:
+		chain, err = decodeChainCBOR(r, policy)
+	default:
+		chain, err = decodeChainJSON(r, policy)
+	}
+	if err != nil {
+		return nil, &ChainDecodeError{Path: path, Err: err}
+	}
+
+	if err := verifyCanonicalHashes(chain, policy.Trusted); err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// encodeChainJSON writes the chain as an indented JSON array, one block at
+// a time. The output matches json.MarshalIndent(chain, "", "  ").
+func encodeChainJSON(w io.Writer, chain []*Block) error {
+	bw := bufio.NewWriter(w)
+	if len(chain) == 0 {
+		bw.WriteString("[]\n")
+		return bw.Flush()
+	}
+
+	bw.WriteString("[\n")
+	for i, block := range chain {
+		data, err := json.MarshalIndent(block, "  ", "  ")
+		if err != nil {
+			return fmt.Errorf("block %d: %w", i, err)
+		}
+		bw.WriteString("  ")
+		bw.Write(data)
+		if i < len(chain)-1 {
+			bw.WriteByte(',')
+		}
+		bw.WriteByte('\n')
+	}
+	bw.WriteString("]\n")
+	return bw.Flush()
+}
+
+// encodeChainJSONL writes the chain as JSON Lines.
+func encodeChainJSONL(w io.Writer, chain []*Block) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for i, block := range chain {
+		if err := enc.Encode(block); err != nil {
+			return fmt.Errorf("block %d: %w", i, err)
+		}
+	}
+	return bw.Flush()
+}
+
+// decodeChainJSONL decodes JSON Lines one block at a time, passing each
+// block to fn as soon as it is decoded. Limits and field checks follow
+// the policy, as for decodeChainJSON.
+func decodeChainJSONL(r io.Reader, policy DecodePolicy, fn func(*Block) error) error {
+	r, elems := policy.limitReader(r)
+	dec := json.NewDecoder(r)
+
+	for i := 0; ; i++ {
+		elems.next(dec.InputOffset())
+		var msg json.RawMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("block %d: %w", i, err)
+		}
+		if policy.MaxBlocks > 0 && i >= policy.MaxBlocks {
+			return fmt.Errorf("%w: more than %d blocks", ErrImportLimit, policy.MaxBlocks)
+		}
+
+		block, err := decodeBlockJSON(msg, i, policy)
+		if err != nil {
+			return err
+		}
+		if err := fn(block); err != nil {
+			return err
+		}
+	}
+}
+
+// decodeChainProto reads a protobuf Chain message and applies the
+// policy's limits and field checks to it.
+func decodeChainProto(r io.Reader, policy DecodePolicy) ([]*Block, error) {
+	if policy.MaxTotalBytes > 0 {
+		r = &limitedReader{r: r, remaining: policy.MaxTotalBytes, limit: policy.MaxTotalBytes}
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := unmarshalChainProto(data)
+	if err != nil {
+		return nil, err
+	}
+	if policy.MaxBlocks > 0 && len(chain) > policy.MaxBlocks {
+		return nil, fmt.Errorf("%w: more than %d blocks", ErrImportLimit, policy.MaxBlocks)
+	}
+	for i, block := range chain {
+		if size := block.protoSize(); policy.MaxBlockBytes > 0 && size > policy.MaxBlockBytes {
+			return nil, fmt.Errorf("%w: block %d is %d bytes, limit %d", ErrImportLimit, i, size, policy.MaxBlockBytes)
+		}
+		if err := checkBlockFields(block, i, policy); err != nil {
+			return nil, err
+		}
+	}
+	return chain, nil
+}
+
+// decodeChainCBOR reads a CBOR chain and applies the policy's limits and
+// field checks to it.
+func decodeChainCBOR(r io.Reader, policy DecodePolicy) ([]*Block, error) {
+	if policy.MaxTotalBytes > 0 {
+		r = &limitedReader{r: r, remaining: policy.MaxTotalBytes, limit: policy.MaxTotalBytes}
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalChainCBOR(data, func(i int, block *Block, size int) error {
+		if policy.MaxBlocks > 0 && i >= policy.MaxBlocks {
+			return fmt.Errorf("%w: more than %d blocks", ErrImportLimit, policy.MaxBlocks)
+		}
+		if policy.MaxBlockBytes > 0 && int64(size) > policy.MaxBlockBytes {
+			return fmt.Errorf("%w: block %d is %d bytes, limit %d", ErrImportLimit, i, size, policy.MaxBlockBytes)
+		}
+		return checkBlockFields(block, i, policy)
+	})
+}
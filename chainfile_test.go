@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestEncodeChainJSON_MatchesEncoder checks that the streaming writer
+// produces exactly what encoding the whole chain at once used to.
+func TestEncodeChainJSON_MatchesEncoder(t *testing.T) {
+	for _, chain := range [][]*Block{makeBlockchain(3, 1), {}} {
+		var want bytes.Buffer
+		enc := json.NewEncoder(&want)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(chain); err != nil {
+			t.Fatal(err)
+		}
+
+		var got bytes.Buffer
+		if err := encodeChainJSON(&got, chain); err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("streaming output differs:\n%s\nwant:\n%s", got.String(), want.String())
+		}
+	}
+}
+
+// TestChainFile_RoundTrip writes and reads a chain in every format, with
+// and without compression.
+func TestChainFile_RoundTrip(t *testing.T) {
+	chain := makeBlockchain(4, 1)
+	dir := t.TempDir()
+	for _, name := range []string{"chain.json", "chain.jsonl", "chain.pb", "chain.json.gz", "chain.jsonl.gz", "chain.pb.gz"} {
+		path := filepath.Join(dir, name)
+		if err := writeChainFile(chain, path); err != nil {
+			t.Fatalf("%s: write: %v", name, err)
+		}
+		if strings.HasSuffix(name, ".gz") {
+			data, _ := os.ReadFile(path)
+			if !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+				t.Errorf("%s: file is not gzip-compressed", name)
+			}
+		}
+
+		imported, err := readChainFile(path, DecodePolicy{Strict: true})
+		if err != nil {
+			t.Fatalf("%s: read: %v", name, err)
+		}
+		if !reflect.DeepEqual(imported, chain) {
+			t.Errorf("%s: imported chain differs from the original", name)
+		}
+	}
+}
+
+// TestDecodeChainJSONL_Streaming checks that blocks are delivered one at a
+// time and that limits and callback errors stop decoding.
+func TestDecodeChainJSONL_Streaming(t *testing.T) {
+	var buf bytes.Buffer
+	if err := encodeChainJSONL(&buf, makeBlockchain(5, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 5 {
+		t.Fatalf("expected 5 lines, got %d", lines)
+	}
+
+	var indexes []int
+	err := decodeChainJSONL(bytes.NewReader(buf.Bytes()), DecodePolicy{}, func(b *Block) error {
+		indexes = append(indexes, b.Index)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(indexes, []int{0, 1, 2, 3, 4}) {
+		t.Fatalf("got indexes %v, err %v", indexes, err)
+	}
+
+	err = decodeChainJSONL(bytes.NewReader(buf.Bytes()), DecodePolicy{MaxBlocks: 3}, func(*Block) error { return nil })
+	if !errors.Is(err, ErrImportLimit) {
+		t.Errorf("expected ErrImportLimit, got %v", err)
+	}
+
+	stop := errors.New("stop")
+	err = decodeChainJSONL(bytes.NewReader(buf.Bytes()), DecodePolicy{}, func(*Block) error { return stop })
+	if !errors.Is(err, stop) {
+		t.Errorf("expected callback error, got %v", err)
+	}
+}
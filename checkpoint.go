@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+)
+
+// checkpointName is the file in a data directory holding the checkpoint of
+// the stored chain.
+const checkpointName = "checkpoint.json"
+
+// Checkpoint records that the stored chain was fully valid up to a block,
+// so later runs only need to check the blocks appended after it.
+type Checkpoint struct {
+	Height     int      `json:"height"`
+	Hash       []byte   `json:"hash"`
+	Work       *big.Int `json:"work"` // cumulative expected hashes up to Height
+	Difficulty int      `json:"difficulty"`
+}
+
+// newCheckpoint returns a checkpoint at the tip of a valid chain. The work
+// of blocks up to prev's height is taken from prev when it is given.
+func newCheckpoint(chain []*Block, difficulty int, prev *Checkpoint) *Checkpoint {
+	tip := chain[len(chain)-1]
+	cp := &Checkpoint{Height: tip.Index, Hash: tip.Hash, Work: new(big.Int), Difficulty: difficulty}
+	start := 1
+	if prev != nil {
+		cp.Work.Set(prev.Work)
+		start = prev.Height + 1
+	}
+	for _, block := range chain[start:] {
+		cp.Work.Add(cp.Work, blockWork(block, difficulty))
+	}
+	return cp
+}
+
+// matches reports whether the checkpoint applies to chain at difficulty.
+func (cp *Checkpoint) matches(chain []*Block, difficulty int) bool {
+	return cp.Difficulty == difficulty && cp.Work != nil && cp.Height >= 0 && cp.Height < len(chain) &&
+		bytes.Equal(chain[cp.Height].Hash, cp.Hash)
+}
+
+func checkpointPath(dataDir string) string {
+	return filepath.Join(dataDir, checkpointName)
+}
+
+// readCheckpoint loads the checkpoint in dataDir, or returns nil if there
+// is none.
+func readCheckpoint(dataDir string) (*Checkpoint, error) {
+	data, err := os.ReadFile(checkpointPath(dataDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cp := new(Checkpoint)
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, &ChainDecodeError{Path: checkpointPath(dataDir), Err: err}
+	}
+	return cp, nil
+}
+
+// writeCheckpoint stores cp in dataDir.
+func writeCheckpoint(dataDir string, cp *Checkpoint) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dataDir, "tmp-"+checkpointName)
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, checkpointPath(dataDir))
+}
+
+// loadStoredChain reads the chain in dataDir and validates the blocks
+// appended since its checkpoint with engine, or every block if full is set
+// or the checkpoint does not match. A valid chain gets a new checkpoint at
+// its tip. Blocks covered by the checkpoint are trusted, so only a full
+// validation detects tampering with them.
+func loadStoredChain(dataDir string, engine Engine, full bool) ([]*Block, *ValidationReport, error) {
+	difficulty := engine.MinDifficulty()
+	var cp *Checkpoint
+	if !full {
+		var err error
+		if cp, err = readCheckpoint(dataDir); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	policy := DecodePolicy{Strict: true, TrustRedactions: true}
+	if cp != nil {
+		policy.Trusted = cp.Height + 1
+	}
+	path := chainStorePath(dataDir)
+	chain, err := readChainFile(path, policy)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(chain) == 0 {
+		return nil, nil, fmt.Errorf("%s contains no blocks", path)
+	}
+	if cp != nil && !cp.matches(chain, difficulty) {
+		// The chain was replaced since the checkpoint, so check everything
+		if err := verifyCanonicalHashes(chain, 0); err != nil {
+			return nil, nil, err
+		}
+		cp = nil
+	}
+
+	start := 1
+	if cp != nil {
+		start = cp.Height + 1
+	}
+	report := validateChainReportFrom(chain, engine, start)
+	if !report.Valid() {
+		return chain, report, nil
+	}
+	if cp == nil || cp.Height < len(chain)-1 {
+		if err := writeCheckpoint(dataDir, newCheckpoint(chain, difficulty, cp)); err != nil {
+			return nil, nil, err
+		}
+	}
+	return chain, report, nil
+}
+
+// Chain parameters can also pin the hashes of known-good blocks by
+// height. These checkpoints are part of consensus, unlike the one above,
+// which only saves a node from checking its own chain again: a block at a
+// pinned height must have the pinned hash, and once the chain is past a
+// checkpoint no branch may fork below it. The pinned hash commits to every
+// block under it, so a chain reaching a checkpoint skips the proof-of-work
+// checks of the blocks it buries.
+
+// Errors reported for blocks that conflict with pinned checkpoints.
+var (
+	ErrCheckpointMismatch = errors.New("block does not match checkpoint")
+	ErrCheckpointFork     = errors.New("branch forks below a checkpoint")
+)
+
+// chainCheckpoints are the pinned checkpoints of the chain this process
+// works on, set from its chain parameters like formatActivations.
+var chainCheckpoints map[int][]byte
+
+// parseCheckpoints decodes the checkpoints of chain parameters, hex block
+// hashes by height. Genesis is pinned by the parameters already.
+func parseCheckpoints(checkpoints map[int]string) (map[int][]byte, error) {
+	if len(checkpoints) == 0 {
+		return nil, nil
+	}
+	pinned := make(map[int][]byte, len(checkpoints))
+	for height, h := range checkpoints {
+		hash, err := hex.DecodeString(h)
+		if err != nil || len(hash) != hashSize {
+			return nil, fmt.Errorf("checkpoints: hash at height %d must be %d hex bytes", height, hashSize)
+		}
+		if height < 1 {
+			return nil, fmt.Errorf("checkpoints: height %d, want at least 1", height)
+		}
+		pinned[height] = hash
+	}
+	return pinned, nil
+}
+
+// lastCheckpoint returns the highest pinned height at or below height, or
+// 0 if there is none.
+func lastCheckpoint(height int) int {
+	last := 0
+	for h := range chainCheckpoints {
+		if h <= height && h > last {
+			last = h
+		}
+	}
+	return last
+}
+
+// checkCheckpoint reports an ErrCheckpointMismatch if height is pinned
+// and hash, of the block at that height, is not the pinned hash.
+func checkCheckpoint(height int, hash []byte) error {
+	if pinned, ok := chainCheckpoints[height]; ok && !bytes.Equal(hash, pinned) {
+		return &BlockValidationError{Index: height, Err: fmt.Errorf("%w: hash %x, pinned %x", ErrCheckpointMismatch, hash, pinned)}
+	}
+	return nil
+}
+
+// checkCheckpointFork reports an ErrCheckpointFork if a branch whose first
+// block differing from the chain is at height would replace a checkpoint
+// of a chain whose tip is at tip.
+func checkCheckpointFork(height, tip int) error {
+	if last := lastCheckpoint(tip); height <= last {
+		return &BlockValidationError{Index: height, Err: fmt.Errorf("%w at height %d", ErrCheckpointFork, last)}
+	}
+	return nil
+}
+
+// validateBuriedPair is validateBlockPairWith for the block at height of
+// a chain that reaches the checkpoint at buried: blocks at or below it
+// skip the engine's seal check, such as their proof-of-work or signature.
+// Whether a block is buried goes by its position, so a block must carry
+// the index of its position to be checked at all. The caller must reject
+// the whole chain if the block at buried turns out not to match, since
+// only then are the blocks below it vouched for.
+func validateBuriedPair(prev, block *Block, height int, engine Engine, buried int, hashCache *HashCache) error {
+	if block.Index != height {
+		return &BlockValidationError{Index: height, Err: fmt.Errorf("%w: block at position %d has index %d", ErrBadHeight, height, block.Index)}
+	}
+	if height > buried {
+		return validateBlockPairWith(prev, block, engine, hashCache)
+	}
+	if err := checkBlockSize(block); err != nil {
+		return err
+	}
+	if _, err := checkHeaderLink(prev, block, hashCache); err != nil {
+		return err
+	}
+	return checkBody(block)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLoadStoredChainCheckpoint checks that only blocks appended since the
+// checkpoint are validated and that the checkpoint follows the tip.
+func TestLoadStoredChainCheckpoint(t *testing.T) {
+	dataDir := t.TempDir()
+	chain := makeBlockchain(5, 1)
+	if err := writeChainFile(chain, chainStorePath(dataDir)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, report, err := loadStoredChain(dataDir, &PoWEngine{Difficulty: 1}, false)
+	if err != nil || !report.Valid() || report.Skipped != 0 {
+		t.Fatalf("first load: report %+v, err %v", report, err)
+	}
+
+	for i := 5; i < 7; i++ {
+		block, err := generateBlock(context.Background(), chain[i-1], fmt.Sprintf("Block %d", i), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chain = append(chain, block)
+	}
+	if err := writeChainFile(chain, chainStorePath(dataDir)); err != nil {
+		t.Fatal(err)
+	}
+	_, report, err = loadStoredChain(dataDir, &PoWEngine{Difficulty: 1}, false)
+	if err != nil || !report.Valid() || report.Skipped != 4 {
+		t.Fatalf("incremental load: report %+v, err %v", report, err)
+	}
+	cp, err := readCheckpoint(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := newCheckpoint(chain, 1, nil); cp.Height != 6 || cp.Work.Cmp(want.Work) != 0 {
+		t.Errorf("checkpoint %+v, want height 6 and work %s", cp, want.Work)
+	}
+
+	// Blocks behind the checkpoint are trusted until a full validation
+	chain[2].Data = []byte("tampered")
+	if err := writeChainFile(chain, chainStorePath(dataDir)); err != nil {
+		t.Fatal(err)
+	}
+	if _, report, err := loadStoredChain(dataDir, &PoWEngine{Difficulty: 1}, false); err != nil || !report.Valid() {
+		t.Errorf("incremental load rechecked trusted blocks: report %+v, err %v", report, err)
+	}
+	if _, _, err := loadStoredChain(dataDir, &PoWEngine{Difficulty: 1}, true); err == nil {
+		t.Error("full load accepted a tampered block")
+	}
+	if code := runValidate([]string{"-datadir", dataDir, "-difficulty", "1", "-full"}); code != exitValidation {
+		t.Errorf("validate -full: expected exit code %d, got %d", exitValidation, code)
+	}
+}
+
+// TestLoadStoredChainReplaced ignores a checkpoint that does not match the
+// stored chain or the difficulty.
+func TestLoadStoredChainReplaced(t *testing.T) {
+	dataDir := t.TempDir()
+	if err := writeChainFile(makeBlockchain(4, 1), chainStorePath(dataDir)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadStoredChain(dataDir, &PoWEngine{Difficulty: 1}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	replaced := makeBlockchain(4, 2)
+	replaced[3].Data = []byte("tampered")
+	if err := writeChainFile(replaced, chainStorePath(dataDir)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadStoredChain(dataDir, &PoWEngine{Difficulty: 1}, false); err == nil {
+		t.Error("stale checkpoint hid a tampered block")
+	}
+
+	if err := writeChainFile(makeBlockchain(4, 1), chainStorePath(dataDir)); err != nil {
+		t.Fatal(err)
+	}
+	if _, report, err := loadStoredChain(dataDir, &PoWEngine{Difficulty: 2}, false); err != nil || report.Valid() {
+		t.Errorf("checkpoint at difficulty 1 was used at difficulty 2: report %+v, err %v", report, err)
+	}
+}
+
+// TestPinnedCheckpoints validates chains against a pinned hash: a block
+// that differs is refused, and blocks under a checkpoint the chain
+// reaches skip their proof-of-work check.
+func TestPinnedCheckpoints(t *testing.T) {
+	t.Cleanup(func() { chainCheckpoints = nil })
+	chain := makeBlockchain(6, 0)
+
+	chainCheckpoints = map[int][]byte{3: chain[3].Hash}
+	if err := validateChain(chain, 0); err != nil {
+		t.Fatal(err)
+	}
+	other := makeBlockchain(6, 1)
+	chainCheckpoints = map[int][]byte{3: other[3].Hash}
+	var verr *BlockValidationError
+	if err := validateChain(chain, 0); !errors.As(err, &verr) || verr.Index != 3 || !errors.Is(err, ErrCheckpointMismatch) {
+		t.Errorf("block differing from the checkpoint: %v", err)
+	}
+
+	// Mined at difficulty 0, every block fails difficulty 8, but those
+	// under the checkpoint at 4 are not checked
+	chainCheckpoints = map[int][]byte{4: chain[4].Hash}
+	for name, err := range map[string]error{
+		"sequential": validateChain(chain, 8),
+		"concurrent": validateChainConcurrent(context.Background(), chain, 8, ValidateOptions{Threshold: 1}),
+		"report":     validateChainReport(chain, 8).Problems[0],
+	} {
+		if !errors.As(err, &verr) || verr.Index != 5 || !errors.Is(err, ErrInsufficientWork) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	// A chain short of the checkpoint is checked in full
+	if err := validateChain(chain[:4], 8); !errors.As(err, &verr) || verr.Index != 1 {
+		t.Errorf("chain below the checkpoint: %v", err)
+	}
+}
+
+// TestPinnedCheckpointPositions checks that checkpoints go by a block's
+// position in the chain, not the index it claims: blocks above the
+// checkpoint claiming buried heights do not skip their proof-of-work, and
+// a block at a pinned height claiming another does not skip the pin.
+func TestPinnedCheckpointPositions(t *testing.T) {
+	t.Cleanup(func() { chainCheckpoints = nil })
+	chain := makeBlockchain(4, 1)
+	chainCheckpoints = map[int][]byte{3: chain[3].Hash}
+	// Linked and hashed, but not mined
+	fake := func(prev *Block, index int) *Block {
+		b := &Block{Index: index, Timestamp: prev.Timestamp, Data: []byte("unmined"), PrevHash: prev.Hash}
+		b.Hash = calculateHash(b)
+		return b
+	}
+
+	long := append([]*Block(nil), chain...)
+	for _, index := range []int{1, 2} {
+		long = append(long, fake(long[len(long)-1], index))
+	}
+	dodging := append([]*Block(nil), chain[:3]...)
+	dodging = append(dodging, fake(dodging[2], 7))
+	dodging = append(dodging, fake(dodging[3], 8))
+
+	for name, c := range map[string]struct {
+		chain []*Block
+		at    int
+	}{"buried indices above the checkpoint": {long, 4}, "unpinned index at the checkpoint": {dodging, 3}} {
+		var verr *BlockValidationError
+		for check, err := range map[string]error{
+			"sequential": validateChain(c.chain, 1),
+			"concurrent": validateChainConcurrent(context.Background(), c.chain, 1, ValidateOptions{Threshold: 1}),
+			"report":     validateChainReport(c.chain, 1).Err(),
+		} {
+			if !errors.As(err, &verr) || verr.Index != c.at || !errors.Is(err, ErrBadHeight) {
+				t.Errorf("%s, %s: %v", name, check, err)
+			}
+		}
+	}
+}
+
+// TestPinnedCheckpointFork checks that the node and the light client
+// refuse a branch forking below a checkpoint, and take one forking above.
+func TestPinnedCheckpointFork(t *testing.T) {
+	t.Cleanup(func() { chainCheckpoints = nil })
+	chain := makeBlockchain(6, 1)
+	chainCheckpoints = map[int][]byte{3: chain[3].Hash}
+	s := newRPCServer(chain, 1)
+	client := NewLightClient(chain[0].Header(), 1)
+	var headers []*BlockHeader
+	for _, block := range chain[1:] {
+		headers = append(headers, block.Header())
+	}
+	if _, err := client.AddHeaders(headers); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		fork   int
+		reason any
+	}{{2, "bad-fork-prior-to-checkpoint"}, {3, "inconclusive"}} {
+		branch := extendChain(t, chain[:tt.fork+1:tt.fork+1], 1, "branch")
+		raw, _ := json.Marshal(branch[tt.fork+1])
+		if reason := s.submitBlock(context.Background(), raw); reason != tt.reason {
+			t.Errorf("block forking at %d: %v, want %v", tt.fork, reason, tt.reason)
+		}
+	}
+
+	// A branch from below the checkpoint that does not reach it, resent
+	// with a block the client already has
+	branch := extendChain(t, chain[:2:2], 1, "branch")
+	var run []*BlockHeader
+	for _, block := range branch[1:] {
+		run = append(run, block.Header())
+	}
+	if _, err := client.AddHeaders(run); !errors.Is(err, ErrCheckpointFork) {
+		t.Errorf("light client took a branch forking below the checkpoint: %v", err)
+	}
+}
+
+func TestPinnedCheckpointParams(t *testing.T) {
+	t.Cleanup(func() { chainCheckpoints = nil })
+	hash := fmt.Sprintf("%064x", 7)
+	path := filepath.Join(t.TempDir(), "params.json")
+	os.WriteFile(path, []byte(`{"chain_id": "pinned", "checkpoints": {"1000": "`+hash+`"}}`), 0o644)
+	params, err := loadChainParams(path, networks["regtest"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Checkpoints[1000] != hash {
+		t.Errorf("checkpoints %v", params.Checkpoints)
+	}
+
+	for name, checkpoints := range map[string]map[int]string{
+		"genesis":    {0: hash},
+		"short hash": {5: "abcd"},
+		"not hex":    {5: "zz"},
+	} {
+		if _, err := parseCheckpoints(checkpoints); err == nil {
+			t.Errorf("%s: accepted %v", name, checkpoints)
+		}
+	}
+}
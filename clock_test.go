@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSteppingClock(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	c := NewSteppingClock(start, time.Minute)
+	for i := range 3 {
+		if got := c.Now(); !got.Equal(start.Add(time.Duration(i) * time.Minute)) {
+			t.Errorf("reading %d: %v", i, got)
+		}
+	}
+	c.Set(start)
+	if got := c.Now(); !got.Equal(start) {
+		t.Errorf("after Set: %v", got)
+	}
+}
+
+// TestClockReproducibleBlocks checks that blocks stamped by the same
+// stepping clock come out the same.
+func TestClockReproducibleBlocks(t *testing.T) {
+	genesis := newGenesisBlock(sha256Hasher{})
+	build := func() []*Block {
+		clock := NewSteppingClock(time.Unix(genesis.Timestamp+60, 0), time.Minute)
+		chain := []*Block{genesis}
+		for i := 1; i <= 3; i++ {
+			block, err := generateBlockWith(context.Background(), clock, chain[i-1], "x", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			chain = append(chain, block)
+		}
+		return chain
+	}
+	a, b := build(), build()
+	for i := range a {
+		if !bytes.Equal(a[i].Hash, b[i].Hash) {
+			t.Fatalf("block %d differs: %x and %x", i, a[i].Hash, b[i].Hash)
+		}
+	}
+	if a[2].Timestamp-a[1].Timestamp != 60 {
+		t.Errorf("blocks stamped %d seconds apart, want 60", a[2].Timestamp-a[1].Timestamp)
+	}
+
+	engine := &PoWEngine{Difficulty: 1, Clock: NewSteppingClock(time.Unix(1800000000, 0), 0)}
+	block, err := sealNext(context.Background(), engine, a, "y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Timestamp != 1800000000 {
+		t.Errorf("engine stamped %d, want its clock's time", block.Timestamp)
+	}
+}
+
+func TestMineStepClock(t *testing.T) {
+	dir := t.TempDir()
+	mine := func(name string) []byte {
+		path := filepath.Join(dir, name)
+		if code := runMine([]string{"-network", "regtest", "-step-clock", "-workers", "1", "-blocks", "3", "-output", path}); code != exitOK {
+			t.Fatalf("mine: exit code %d", code)
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	if a, b := mine("a.json"), mine("b.json"); !bytes.Equal(a, b) {
+		t.Error("two regtest runs with -step-clock mined different chains")
+	}
+
+	params, _, _ := newTestStake(t)
+	if code := runMine([]string{"-params", writeStakeParams(t, params), "-step-clock", "-validator-key", "missing.json"}); code != exitConfig {
+		t.Errorf("-step-clock on a proof-of-stake chain: expected exit code %d, got %d", exitConfig, code)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+	"time"
+)
+
+// chainStoreName is the file in a data directory holding the imported chain.
+const chainStoreName = "chain.jsonl.gz"
+
+func chainStorePath(dataDir string) string {
+	return filepath.Join(dataDir, chainStoreName)
+}
+
+// runImport implements the import subcommand: it fully validates a chain
+// file and stores it in a data directory.
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	file := fs.String("file", "", "chain file to import: .json, .jsonl, .pb or .cbor, optionally .gz (required)")
+	format := fs.String("format", "", "format of the file: json, jsonl, pb or cbor (default: from its extension)")
+	dataDir := fs.String("datadir", "", "data directory to store the chain in (required)")
+	fs.Int("difficulty", 4, "proof-of-work difficulty the chain was mined at")
+	network, paramsPath := addChainFlags(fs)
+	strict := fs.Bool("strict", false, "fail on unknown fields and other tolerated problems")
+	trustRedactions := fs.Bool("trust-redactions", false, "believe the redaction markers of a file this node wrote, such as one of its retention snapshots")
+	policy := addDecodeLimitFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return flagError(err)
+	}
+	if *file == "" || *dataDir == "" {
+		return usage("Usage: blockchain import -file chain.json -datadir dir [-format name] [-difficulty n] [-network name] [-params file] [-strict]")
+	}
+	if err := policy.checkLimits(); err != nil {
+		return failCode(exitConfig, err)
+	}
+	fileFormat, err := chainFormatFlag(*format, *file)
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+	_, engine, err := chainEngineFlags(fs, *network, *paramsPath)
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+	policy.Strict, policy.TrustRedactions = *strict, *trustRedactions
+	policy.Warn = func(w DecodeWarning) {
+		fmt.Printf("Warning: %v\n", w)
+	}
+
+	chain, err := importChainAs(*file, fileFormat, engine, *policy)
+	if err != nil {
+		return fail(err)
+	}
+	if err := os.MkdirAll(*dataDir, 0o755); err != nil {
+		return fail(err)
+	}
+	if err := saveStore(*dataDir, chain); err != nil {
+		return fail(fmt.Errorf("writing chain: %w", err))
+	}
+	if err := writeCheckpoint(*dataDir, newCheckpoint(chain, engine.MinDifficulty(), nil)); err != nil {
+		return fail(fmt.Errorf("writing checkpoint: %w", err))
+	}
+	fmt.Printf("Imported %d blocks into %s\n", len(chain), chainStorePath(*dataDir))
+	return 0
+}
+
+// runExport implements the export subcommand: it writes the chain stored
+// in a data directory to a file in the format its extension selects.
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	dataDir := fs.String("datadir", "", "data directory holding an imported chain (required)")
+	output := fs.String("output", "", "file to write: .json, .jsonl, .pb or .cbor, optionally .gz")
+	format := fs.String("format", "", "format to write: json, jsonl, pb or cbor (default: from the -output extension)")
+	segments := fs.String("segments", "", "segment store directory to bring up to date with the chain (instead of -output)")
+	segmentSize := fs.Int64("segment-size", defaultSegmentBytes, "with -segments, bytes per segment file")
+	if err := fs.Parse(args); err != nil {
+		return flagError(err)
+	}
+	if *dataDir == "" || (*output == "") == (*segments == "") {
+		return usage("Usage: blockchain export -datadir dir (-output chain.json [-format name] | -segments dir [-segment-size n])")
+	}
+	if *segmentSize <= 0 {
+		return failf(exitConfig, "segment-size must be positive")
+	}
+	fileFormat, err := chainFormatFlag(*format, *output)
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+
+	chain, err := readChainFile(chainStorePath(*dataDir), DecodePolicy{Strict: true, TrustRedactions: true})
+	if errors.Is(err, os.ErrNotExist) {
+		return failf(exitStorage, "no chain in %s; run 'blockchain import' first", *dataDir)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if *segments != "" {
+		store, err := openSegmentStore(*segments, *segmentSize)
+		if err != nil {
+			return failCode(exitStorage, err)
+		}
+		defer store.Close()
+		n, err := appendSegments(store, chain)
+		if err != nil {
+			return failCode(exitStorage, err)
+		}
+		fmt.Printf("Appended %d blocks to %s\n", n, *segments)
+		return 0
+	}
+	if err := writeChainFileAs(chain, *output, fileFormat); err != nil {
+		return fail(fmt.Errorf("writing chain: %w", err))
+	}
+	fmt.Printf("Exported %d blocks to %s\n", len(chain), *output)
+	return 0
+}
+
+// runInspect implements the inspect subcommand, which prints a summary of
+// a chain or the full contents of one block.
+func runInspect(args []string) int {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	file := fs.String("file", "", "chain file to inspect")
+	dataDir := fs.String("datadir", "", "data directory holding an imported chain (instead of -file)")
+	index := fs.Int("index", -1, "index of a block to show in full")
+	tmplText := fs.String("template", "", "Go template applied to the -index block, or to every block; see the README for fields and helpers")
+	segments := fs.String("segments", "", "segment store directory to read the -index block from (instead of -file)")
+	if err := fs.Parse(args); err != nil {
+		return flagError(err)
+	}
+	path := *file
+	if path == "" && *dataDir != "" {
+		path = chainStorePath(*dataDir)
+	}
+	if (path == "") == (*segments == "") || (*segments != "" && *index < 0) {
+		return usage("Usage: blockchain inspect (-file chain.json | -datadir dir | -segments dir -index n) [-index n] [-template text]")
+	}
+	var tmpl *template.Template
+	if *tmplText != "" {
+		var err error
+		if tmpl, err = parseOutputTemplate(*tmplText); err != nil {
+			return failf(exitConfig, "invalid template: %w", err)
+		}
+	}
+
+	if *segments != "" {
+		// Only the block asked for is read
+		if _, err := os.Stat(filepath.Join(*segments, segmentIndexName)); err != nil {
+			return failf(exitStorage, "no segment store in %s: %v", *segments, err)
+		}
+		store, err := openSegmentStore(*segments, 0)
+		if err != nil {
+			return failCode(exitStorage, err)
+		}
+		defer store.Close()
+		block, err := store.Block(*index)
+		switch {
+		case errors.Is(err, ErrBlockNotFound):
+			return failCode(exitConfig, err)
+		case err != nil:
+			return failCode(exitStorage, err)
+		case tmpl != nil:
+			if err := renderTemplate(os.Stdout, tmpl, block); err != nil {
+				return fail(err)
+			}
+		default:
+			printBlock(block)
+		}
+		return 0
+	}
+
+	chain, err := readChainFile(path, DecodePolicy{Warn: func(w DecodeWarning) {
+		fmt.Printf("Warning: %v\n", w)
+	}})
+	if err != nil {
+		return fail(err)
+	}
+	if len(chain) == 0 {
+		return failf(exitStorage, "%s contains no blocks", path)
+	}
+
+	if *index >= 0 {
+		if *index >= len(chain) {
+			return failf(exitConfig, "block %d not found; the chain has %d blocks", *index, len(chain))
+		}
+		if tmpl != nil {
+			chain = chain[*index : *index+1]
+		} else {
+			printBlock(chain[*index])
+			return 0
+		}
+	}
+	if tmpl != nil {
+		for _, block := range chain {
+			if err := renderTemplate(os.Stdout, tmpl, block); err != nil {
+				return fail(err)
+			}
+		}
+		return 0
+	}
+
+	genesis, tip := chain[0], chain[len(chain)-1]
+	var dataBytes int
+	for _, block := range chain {
+		dataBytes += len(block.Data)
+	}
+	algo := fmt.Sprintf("unknown (%d)", genesis.HashAlgo)
+	if h, err := hasherByID(genesis.HashAlgo); err == nil {
+		algo = h.Name()
+	}
+	fmt.Printf("Chain: %s\n", path)
+	fmt.Printf("- Blocks: %d (tip index %d)\n", len(chain), tip.Index)
+	fmt.Printf("- Hash algorithm: %s\n", algo)
+	fmt.Printf("- Genesis: %x (%s)\n", genesis.Hash, time.Unix(genesis.Timestamp, 0).UTC().Format(time.RFC3339))
+	fmt.Printf("- Tip: %x (%s)\n", tip.Hash, time.Unix(tip.Timestamp, 0).UTC().Format(time.RFC3339))
+	if tip.Bits != 0 {
+		fmt.Printf("- Tip target: bits %08x (difficulty %.2f)\n", tip.Bits, targetToDifficulty(compactToTarget(tip.Bits)))
+	}
+	fmt.Printf("- Block data: %d bytes\n", dataBytes)
+	return 0
+}
+
+func printBlock(block *Block) {
+	fmt.Printf("Index: %d\n", block.Index)
+	fmt.Printf("Timestamp: %d (%s)\n", block.Timestamp, time.Unix(block.Timestamp, 0).UTC().Format(time.RFC3339))
+	fmt.Printf("Hash: %s\n", hex.EncodeToString(block.Hash))
+	fmt.Printf("PrevHash: %s\n", hex.EncodeToString(block.PrevHash))
+	fmt.Printf("Nonce: %d\n", block.Nonce)
+	if block.ExtraNonce != 0 {
+		fmt.Printf("ExtraNonce: %d\n", block.ExtraNonce)
+	}
+	fmt.Printf("Bits: %08x\n", block.Bits)
+	fmt.Printf("HashAlgo: %d\n", block.HashAlgo)
+	if r := block.Redaction; r != nil {
+		fmt.Printf("Data: redacted (%d bytes removed at %s): %s\n", r.DataLength, time.Unix(r.RedactedAt, 0).UTC().Format(time.RFC3339), r.Reason)
+		return
+	}
+	fmt.Printf("Data (%d bytes): %q\n", len(block.Data), block.Data)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestImportExportCommands round-trips a chain through a data directory
+// and checks the exit codes of the chain file commands.
+func TestImportExportCommands(t *testing.T) {
+	dir := t.TempDir()
+	chain := makeBlockchain(3, 1)
+	src := filepath.Join(dir, "chain.json")
+	if err := writeChainFile(chain, src); err != nil {
+		t.Fatal(err)
+	}
+	dataDir := filepath.Join(dir, "data")
+	out := filepath.Join(dir, "export.pb")
+
+	if code := runExport([]string{"-datadir", dataDir, "-output", out}); code != exitStorage {
+		t.Errorf("export before import: expected exit code %d, got %d", exitStorage, code)
+	}
+	if code := runImport([]string{"-file", src, "-datadir", dataDir, "-difficulty", "8"}); code != exitValidation {
+		t.Errorf("import of underworked chain: expected exit code %d, got %d", exitValidation, code)
+	}
+	if code := runImport([]string{"-file", src, "-datadir", dataDir, "-difficulty", "1", "-max-blocks", "2"}); code != exitStorage {
+		t.Errorf("import over -max-blocks: expected exit code %d, got %d", exitStorage, code)
+	}
+	if code := runImport([]string{"-file", src, "-datadir", dataDir, "-max-block-bytes", "-1"}); code != exitConfig {
+		t.Errorf("import with a negative limit: expected exit code %d, got %d", exitConfig, code)
+	}
+	if code := runImport([]string{"-file", src, "-datadir", dataDir, "-difficulty", "1"}); code != 0 {
+		t.Fatalf("import: expected exit code 0, got %d", code)
+	}
+	if code := runExport([]string{"-datadir", dataDir, "-output", out}); code != 0 {
+		t.Fatalf("export: expected exit code 0, got %d", code)
+	}
+
+	exported, err := readChainFile(out, DecodePolicy{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(exported, chain) {
+		t.Error("exported chain differs from the imported one")
+	}
+
+	if code := runInspect([]string{"-datadir", dataDir, "-index", "2"}); code != 0 {
+		t.Errorf("inspect: expected exit code 0, got %d", code)
+	}
+	if code := runInspect([]string{"-file", out, "-index", "3"}); code != exitConfig {
+		t.Errorf("inspect of missing block: expected exit code %d, got %d", exitConfig, code)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"math/big"
+)
+
+// ChainComparison describes how two chains relate: where they diverge and
+// how much work each holds.
+type ChainComparison struct {
+	// Fork is the height of the last block the chains share, or -1 when
+	// even their genesis blocks differ.
+	Fork         int
+	LenA, LenB   int
+	WorkA, WorkB *big.Int
+}
+
+// Identical reports whether the chains hold the same blocks.
+func (c *ChainComparison) Identical() bool {
+	return c.LenA == c.LenB && c.Fork == c.LenA-1
+}
+
+// Cmp returns +1 if chain a has more work than b, -1 if less, and 0 on a
+// tie.
+func (c *ChainComparison) Cmp() int {
+	return c.WorkA.Cmp(c.WorkB)
+}
+
+// chainWork returns the total work of chain. Blocks without a recorded
+// target count as mined at legacyDifficulty.
+func chainWork(chain []*Block, legacyDifficulty int) *big.Int {
+	total := new(big.Int)
+	for _, block := range chain[min(1, len(chain)):] {
+		total.Add(total, blockWork(block, legacyDifficulty))
+	}
+	return total
+}
+
+// cumulativeWork returns the work of chain[:i+1] for every height i, as
+// chainWork counts it.
+func cumulativeWork(chain []*Block, legacyDifficulty int) []*big.Int {
+	work := make([]*big.Int, len(chain))
+	total := new(big.Int)
+	for i, block := range chain {
+		if i > 0 {
+			total = new(big.Int).Add(total, blockWork(block, legacyDifficulty))
+		}
+		work[i] = total
+	}
+	return work
+}
+
+// Compare finds where chains a and b diverge and weighs each by its
+// cumulative work. The genesis block, which is not mined, counts for
+// nothing.
+func Compare(a, b []*Block, legacyDifficulty int) *ChainComparison {
+	c := &ChainComparison{Fork: -1, LenA: len(a), LenB: len(b)}
+	for h := 0; h < len(a) && h < len(b) && bytes.Equal(a[h].Hash, b[h].Hash); h++ {
+		c.Fork = h
+	}
+	c.WorkA, c.WorkB = chainWork(a, legacyDifficulty), chainWork(b, legacyDifficulty)
+	return c
+}
+
+// ResolveConflict validates chains a and b with engine and returns the
+// canonical one: the one with the most cumulative work, not merely the
+// longest. On a tie a, the chain already held, is kept. Chains from
+// different genesis blocks are not competing branches and are refused.
+func ResolveConflict(a, b []*Block, engine Engine) ([]*Block, error) {
+	if len(a) == 0 || len(b) == 0 {
+		return nil, fmt.Errorf("cannot resolve a conflict with an empty chain")
+	}
+	c := Compare(a, b, engine.MinDifficulty())
+	if c.Fork < 0 {
+		return nil, fmt.Errorf("the chains start from different genesis blocks")
+	}
+	if err := validateChainWith(a, engine); err != nil {
+		return nil, fmt.Errorf("first chain: %w", err)
+	}
+	if err := validateChainWith(b, engine); err != nil {
+		return nil, fmt.Errorf("second chain: %w", err)
+	}
+	if c.Cmp() < 0 {
+		return b, nil
+	}
+	return a, nil
+}
+
+// runDiff implements the diff subcommand, which reports where two chain
+// files diverge and which of them is canonical. Like diff(1), it exits
+// with 1 when the chains differ.
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.Int("difficulty", 4, "proof-of-work difficulty the chains were mined at")
+	network, paramsPath := addChainFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return flagError(err)
+	}
+	if fs.NArg() != 2 {
+		return usage("Usage: blockchain diff [-difficulty n] [-network name] [-params file] a.json b.json")
+	}
+	_, engine, err := chainEngineFlags(fs, *network, *paramsPath)
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+	pathA, pathB := fs.Arg(0), fs.Arg(1)
+	a, err := readChainFile(pathA, DecodePolicy{})
+	if err != nil {
+		return fail(err)
+	}
+	b, err := readChainFile(pathB, DecodePolicy{})
+	if err != nil {
+		return fail(err)
+	}
+
+	c := Compare(a, b, engine.MinDifficulty())
+	if c.Identical() {
+		fmt.Printf("Chains are identical (%d blocks)\n", c.LenA)
+		return exitOK
+	}
+	if c.Fork < 0 {
+		return failf(exitValidation, "%s and %s start from different genesis blocks", pathA, pathB)
+	}
+	fmt.Printf("Common blocks: 0-%d (tip %x)\n", c.Fork, a[c.Fork].Hash)
+	for _, side := range []struct {
+		path  string
+		chain []*Block
+		work  *big.Int
+	}{{pathA, a, c.WorkA}, {pathB, b, c.WorkB}} {
+		if h := c.Fork + 1; h < len(side.chain) {
+			fmt.Printf("%s: %d blocks after the fork, from %x, work %s\n", side.path, len(side.chain)-h, side.chain[h].Hash, side.work)
+		} else {
+			fmt.Printf("%s: no blocks after the fork, work %s\n", side.path, side.work)
+		}
+	}
+
+	if _, err := ResolveConflict(a, b, engine); err != nil {
+		return fail(err)
+	}
+	// ResolveConflict picks b only when it has more work
+	winner := pathA
+	if c.Cmp() < 0 {
+		winner = pathB
+	}
+	fmt.Printf("Canonical: %s\n", winner)
+	return exitFailure
+}
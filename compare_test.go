@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// branch extends chain[:fork+1] with n blocks mined at difficulty.
+func branch(t *testing.T, chain []*Block, fork, n, difficulty int) []*Block {
+	t.Helper()
+	b := append([]*Block(nil), chain[:fork+1]...)
+	for i := 0; i < n; i++ {
+		block, err := generateBlock(context.Background(), b[len(b)-1], "branch", difficulty)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b = append(b, block)
+	}
+	return b
+}
+
+func TestResolveConflict(t *testing.T) {
+	a := makeBlockchain(5, 1)
+	longer := branch(t, a, 2, 3, 1)
+	heavier := branch(t, a, 2, 1, 3)
+
+	c := Compare(a, heavier, 1)
+	if c.Fork != 2 || c.Identical() || c.Cmp() >= 0 {
+		t.Errorf("compare: %+v", c)
+	}
+	if !Compare(a, a, 1).Identical() {
+		t.Error("a chain differs from itself")
+	}
+
+	for _, tt := range []struct {
+		name string
+		a, b []*Block
+		want []*Block
+	}{
+		{"longer with more work", a, longer, longer},
+		{"shorter with more work", a, heavier, heavier},
+		{"held chain has more work", heavier, longer, heavier},
+		{"tie keeps the held chain", a, branch(t, a, 2, 2, 1), a},
+	} {
+		got, err := ResolveConflict(tt.a, tt.b, &PoWEngine{Difficulty: 1})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if &got[len(got)-1] != &tt.want[len(tt.want)-1] {
+			t.Errorf("%s: picked the other chain", tt.name)
+		}
+	}
+
+	invalid := append([]*Block(nil), longer...)
+	bad := *invalid[4]
+	bad.Nonce++
+	invalid[4] = &bad
+	if _, err := ResolveConflict(a, invalid, &PoWEngine{Difficulty: 1}); err == nil {
+		t.Error("resolved to an invalid chain")
+	}
+	other := makeBlockchain(3, 1)
+	other[0] = &Block{Data: []byte("other"), PrevHash: []byte{}}
+	other[0].Hash = calculateHash(other[0])
+	if _, err := ResolveConflict(a, other, &PoWEngine{Difficulty: 1}); err == nil {
+		t.Error("resolved chains from different genesis blocks")
+	}
+}
+
+func TestRunDiff(t *testing.T) {
+	dir := t.TempDir()
+	a := makeBlockchain(4, 1)
+	write := func(name string, chain []*Block) string {
+		path := filepath.Join(dir, name)
+		if err := writeChainFile(chain, path); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	pathA := write("a.json", a)
+	pathB := write("b.json", branch(t, a, 1, 1, 3))
+
+	if code := runDiff([]string{"-difficulty", "1", pathA, pathA}); code != exitOK {
+		t.Errorf("identical chains: exit code %d", code)
+	}
+	if code := runDiff([]string{"-difficulty", "1", pathA, pathB}); code != exitFailure {
+		t.Errorf("diverging chains: exit code %d", code)
+	}
+	if code := runDiff([]string{"-difficulty", "4", pathA, pathB}); code != exitValidation {
+		t.Errorf("chains below the difficulty: expected exit code %d, got %d", exitValidation, code)
+	}
+	if code := runDiff([]string{pathA}); code != exitConfig {
+		t.Errorf("one file: expected exit code %d, got %d", exitConfig, code)
+	}
+}
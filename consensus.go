@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// Engine is a consensus algorithm: which header fields a block needs, how
+// it is sealed and how its seal is checked. Chain code builds, seals and
+// validates blocks only through an Engine, so engines are interchangeable.
+// The height, link, hash and body checks are the same for every engine and
+// are done by the validator; an engine checks only what it adds to the
+// header, and what it requires of a block's parent.
+//
+// The built-in engines are proof-of-work, the default, and proof-of-stake,
+// selected by a chain's consensus parameter.
+type Engine interface {
+	Name() string
+	// Prepare sets the consensus fields of block, the candidate following
+	// chain, before it is sealed.
+	Prepare(chain []*Block, block *Block) error
+	// Seal completes a prepared block, setting its hash. It may block
+	// until the engine allows the block.
+	Seal(ctx context.Context, block *Block) (*Block, error)
+	// VerifySeal checks the consensus fields of block, the successor of
+	// prev, whose hash is already known to be correct. Blocks buried by a
+	// checkpoint are not passed to it.
+	VerifySeal(prev, block *Block) error
+	// MinDifficulty is the proof-of-work difficulty every block must
+	// meet, which checkpoints record and legacy blocks without a target
+	// are weighed at, or 0 for an engine without proof-of-work.
+	MinDifficulty() int
+}
+
+// Consensus engine names, as given in chain parameters.
+const (
+	ConsensusPoW = "pow"
+	ConsensusPoS = "pos"
+)
+
+// PoWEngine seals blocks by mining them at a fixed difficulty.
+type PoWEngine struct {
+	Difficulty int
+	Miner      MinerOptions
+	// Clock stamps the blocks the engine prepares; nil is SystemClock
+	Clock Clock
+
+	hashes atomic.Uint64
+}
+
+func (e *PoWEngine) Name() string { return ConsensusPoW }
+
+func (e *PoWEngine) Prepare(chain []*Block, block *Block) error {
+	block.Timestamp = orSystem(e.Clock).Now().Unix()
+	block.Bits = 0
+	if formatActive(blockFormatV2, block.Index) {
+		block.Bits = difficultyToCompact(e.Difficulty)
+	}
+	return nil
+}
+
+func (e *PoWEngine) Seal(ctx context.Context, block *Block) (*Block, error) {
+	block, attempts, err := mineCandidate(ctx, block, e.Difficulty, e.Miner)
+	e.hashes.Add(attempts)
+	return block, err
+}
+
+func (e *PoWEngine) VerifySeal(prev, block *Block) error {
+	return checkProofOfWork(block, block.Hash, e.Difficulty)
+}
+
+func (e *PoWEngine) MinDifficulty() int { return e.Difficulty }
+
+// Hashes returns the number of hashes the engine has tried while sealing,
+// including on failure.
+func (e *PoWEngine) Hashes() uint64 {
+	return e.hashes.Load()
+}
+
+// sealNext builds, prepares and seals the block following chain.
+func sealNext(ctx context.Context, engine Engine, chain []*Block, data string) (*Block, error) {
+	// The clock and difficulty only set the timestamp and Bits, which
+	// Prepare replaces
+	candidate := nextCandidate(SystemClock, chain, data, 0)
+	if err := engine.Prepare(chain, candidate); err != nil {
+		return nil, err
+	}
+	return engine.Seal(ctx, candidate)
+}
+
+// validateChainWith is validateChain for a chain sealed by engine.
+func validateChainWith(chain []*Block, engine Engine) error {
+	hashCache := NewHashCache(len(chain))
+	buried := lastCheckpoint(len(chain) - 1)
+	for i := 1; i < len(chain); i++ {
+		if err := validateBuriedPair(chain[i-1], chain[i], i, engine, buried, hashCache); err != nil {
+			return err
+		}
+		if err := checkEpochSummary(chain[:i], chain[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateBlockPairWith is validateBlockPair for a block sealed by engine.
+func validateBlockPairWith(prev, block *Block, engine Engine, hashCache *HashCache) error {
+	// Size first, so an oversized block is not hashed
+	if err := checkBlockSize(block); err != nil {
+		return err
+	}
+	if _, err := checkHeaderLink(prev, block, hashCache); err != nil {
+		return err
+	}
+	if err := engine.VerifySeal(prev, block); err != nil {
+		return err
+	}
+	return checkBody(block)
+}
+
+// engine returns the consensus engine of the chain. key is the wallet that
+// signs the blocks this node proposes on a proof-of-stake chain, or nil for
+// a node that only validates.
+func (p *ChainParams) engine(genesis *Block, key *Wallet) (Engine, error) {
+	switch p.Consensus {
+	case "", ConsensusPoW:
+		return &PoWEngine{Difficulty: p.Difficulty}, nil
+	case ConsensusPoS:
+		return newStakeEngine(p.Validators, genesis, p.GenesisTimestamp, p.BlockInterval, key)
+	default:
+		return nil, fmt.Errorf("unknown consensus %q (want %s or %s)", p.Consensus, ConsensusPoW, ConsensusPoS)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestEngines builds and validates a chain through each engine alone.
+func TestEngines(t *testing.T) {
+	params, genesis, wallets := newTestStake(t)
+	params.Validators = params.Validators[:1]
+	stake, err := params.engine(genesis, wallets[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each stake block waits for its slot; start the clock one slot ahead
+	clock := NewSteppingClock(time.Unix(genesis.Timestamp+params.BlockInterval, 0), 0)
+	stake.(*StakeEngine).clock = clock
+
+	for _, engine := range []Engine{&PoWEngine{Difficulty: 1, Miner: MinerOptions{Workers: 1}}, stake} {
+		t.Run(engine.Name(), func(t *testing.T) {
+			chain := []*Block{genesis}
+			for i := 1; i <= 3; i++ {
+				block, err := sealNext(context.Background(), engine, chain, fmt.Sprintf("Block %d", i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				chain = append(chain, block)
+				clock.Set(time.Unix(block.Timestamp+params.BlockInterval, 0))
+			}
+			if err := validateChainWith(chain, engine); err != nil {
+				t.Fatalf("sealed chain: %v", err)
+			}
+			// Forge data until the hash misses the target, as it mostly would
+			b := *chain[2]
+			for n := 0; n == 0 || validateDifficulty(b.Hash, 1); n++ {
+				b.Data = []byte(fmt.Sprintf("forged %d", n))
+				b.MerkleRoot = dataMerkleRoot(b.HashAlgo, b.Data)
+				b.Hash = calculateHash(&b)
+			}
+			if err := engine.VerifySeal(chain[1], &b); err == nil {
+				t.Error("a changed block kept its seal")
+			}
+		})
+	}
+
+	pow := &PoWEngine{Difficulty: 1}
+	chain := []*Block{genesis}
+	block, err := sealNext(context.Background(), pow, chain, "x")
+	if err != nil || pow.Hashes() == 0 || block.Bits != difficultyToCompact(1) {
+		t.Fatalf("pow seal: %v, %d hashes", err, pow.Hashes())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := sealNext(ctx, &PoWEngine{Difficulty: 8}, chain, "x"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancelled seal, got %v", err)
+	}
+}
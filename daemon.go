@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout bounds how long the daemon waits for in-flight HTTP
+// requests when it is asked to stop.
+const shutdownTimeout = 5 * time.Second
+
+// runDaemon implements the daemon subcommand: a long-running node that
+// mines continuously, serves JSON-RPC and WebSocket clients, and keeps its
+// chain in a data directory.
+func runDaemon(args []string) int {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	dataDir := fs.String("datadir", "", "data directory holding the node's chain (required)")
+	addr := fs.String("addr", "127.0.0.1:7544", "address to listen on")
+	difficulty := fs.Int("difficulty", 4, "proof-of-work difficulty")
+	workers := fs.Int("workers", runtime.NumCPU(), "number of parallel mining workers")
+	fs.String("hash", "sha256", "block hash algorithm for a new chain: sha256, sha3-256 or blake3")
+	network, paramsPath := addChainFlags(fs)
+	saveInterval := fs.Duration("save-interval", time.Minute, "how often to write the chain to the data directory")
+	metricsURL := fs.String("metrics-url", "", "push metrics to an InfluxDB write URL (http://...) or Graphite listener (graphite://host:port)")
+	metricsPrefix := fs.String("metrics-prefix", "blockchain", "InfluxDB measurement or Graphite path prefix for pushed metrics")
+	metricsInterval := fs.Duration("metrics-interval", 10*time.Second, "how often to push metrics")
+	feedURL := fs.String("feed-url", "", "publish each block to a NATS subject (nats://host:port/subject) or Kafka topic via REST Proxy (kafka://host:port/topic)")
+	feedFormat := fs.String("feed-format", feedJSON, "feed message encoding: json or protobuf")
+	retentionPath := fs.String("retention", "", "retention policy to enforce: .json, .yaml or .yml")
+	retentionInterval := fs.Duration("retention-interval", time.Hour, "how often to run the retention policy")
+	retentionDryRun := fs.Bool("retention-dry-run", false, "log the retention policy's actions without taking them")
+	stratumAddr := fs.String("stratum-addr", "", "address to serve block templates to external miners on, over stratum-style TCP")
+	grpcAddr := fs.String("grpc-addr", "", "address to serve the gRPC BlockchainService on, over HTTP/2")
+	tenantsPath := fs.String("tenants", "", "JSON file of API keys and their quotas; every request then needs a key")
+	samples := fs.Int("self-check-samples", selfCheckSamples, "random stored blocks to re-verify at startup")
+	maxReorgDepth := fs.Int("max-reorg-depth", 0, "refuse reorgs removing more blocks than this, with a critical alert (0 allows any)")
+	wsOrigins := fs.String("ws-origin", "", "comma-separated origins of web pages, besides the node's own, allowed to subscribe over WebSocket")
+	pruneDepth := fs.Int("prune", 0, fmt.Sprintf("discard the bodies of blocks this far below the tip, keeping their headers (0 keeps everything; at least %d)", minPruneDepth))
+	staticPeers := fs.String("peers", "", "comma-separated JSON-RPC URLs of peers known from the start")
+	mdns := fs.Bool("mdns", false, "advertise the node and discover peers on the local network over mDNS")
+	rateLimit := fs.Float64("rate-limit", 0, "requests per second allowed from each client IP (0 for no limit)")
+	rateBurst := fs.Int("rate-burst", 20, "requests a client IP may send at once under -rate-limit")
+	useTLS := fs.Bool("tls", false, "serve over TLS with a certificate for the node's identity key")
+	applyChaos := addChaosFlag(fs)
+	logOpts := addLogFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return flagError(err)
+	}
+	if *dataDir == "" {
+		return usage("Usage: blockchain daemon -datadir dir [-addr host:port] [-difficulty n] [-workers n] [-hash name] [-network name] [-params file] [-features names] [-save-interval d] [-metrics-url url] [-feed-url url] [-retention file] [-tenants file] [-stratum-addr host:port] [-grpc-addr host:port] [-prune n] [-max-reorg-depth n] [-ws-origin origins] [-peers urls] [-mdns] [-tls] [-rate-limit n] [-rate-burst n]")
+	}
+	if *workers < 1 {
+		return failf(exitConfig, "workers must be at least 1")
+	}
+	if *saveInterval <= 0 {
+		return failf(exitConfig, "save-interval must be positive")
+	}
+	if *samples < 0 {
+		return failf(exitConfig, "self-check-samples must not be negative")
+	}
+	if *rateLimit < 0 || (*rateLimit > 0 && *rateBurst < 1) {
+		return failf(exitConfig, "rate-limit must not be negative, and rate-burst must be at least 1")
+	}
+	if *pruneDepth != 0 && *pruneDepth < minPruneDepth {
+		return failf(exitConfig, "prune must be 0 or at least %d", minPruneDepth)
+	}
+	if *maxReorgDepth < 0 {
+		return failf(exitConfig, "max-reorg-depth must not be negative")
+	}
+	origins, err := parseOrigins(*wsOrigins)
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+	if err := applyChaos(); err != nil {
+		return failCode(exitConfig, err)
+	}
+	var peerURLs []string
+	for _, u := range strings.Split(*staticPeers, ",") {
+		if u = strings.TrimSpace(u); u == "" {
+			continue
+		}
+		if _, err := parsePeerURL(u); err != nil {
+			return failCode(exitConfig, err)
+		}
+		peerURLs = append(peerURLs, u)
+	}
+	params, err := chainParamsFlags(fs, *network, *paramsPath)
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+	if *stratumAddr != "" {
+		// featureRegistry keeps stratum off on proof-of-stake chains,
+		// which have no jobs to hand out
+		if err := needFeature(FeatureStratum, "-stratum-addr"); err != nil {
+			return failCode(exitConfig, err)
+		}
+	}
+	if *grpcAddr != "" {
+		if err := needFeature(FeatureGRPC, "-grpc-addr"); err != nil {
+			return failCode(exitConfig, err)
+		}
+	}
+	genesis, err := params.genesisBlock()
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+	// The daemon validates with the chain's engine but mines only on a
+	// proof-of-work chain; proof-of-stake blocks are sealed by 'blockchain
+	// mine' and submitted
+	engine, err := params.engine(genesis, nil)
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+	*difficulty = engine.MinDifficulty()
+	var exporter *metricsExporter
+	if *metricsURL != "" {
+		if *metricsInterval <= 0 {
+			return failf(exitConfig, "metrics-interval must be positive")
+		}
+		if exporter, err = newMetricsExporter(*metricsURL, *metricsPrefix); err != nil {
+			return failCode(exitConfig, err)
+		}
+	}
+	var sink feedSink
+	if *feedURL != "" {
+		if *feedFormat != feedJSON && *feedFormat != feedProtobuf {
+			return failf(exitConfig, "feed-format must be %s or %s", feedJSON, feedProtobuf)
+		}
+		if sink, err = newFeedSink(*feedURL); err != nil {
+			return failCode(exitConfig, err)
+		}
+	}
+	var retention *retentionEngine
+	if *retentionPath != "" {
+		if *retentionInterval <= 0 {
+			return failf(exitConfig, "retention-interval must be positive")
+		}
+		policy, err := loadRetentionPolicy(*retentionPath)
+		if err != nil {
+			return failCode(exitConfig, err)
+		}
+		if retention, err = newRetentionEngine(policy, *dataDir); err != nil {
+			return failCode(exitConfig, err)
+		}
+	}
+	var tenants []Tenant
+	if *tenantsPath != "" {
+		if tenants, err = loadTenants(*tenantsPath); err != nil {
+			return failCode(exitConfig, err)
+		}
+	}
+	logger, err := logOpts.newLogger(os.Stderr)
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+	if err := os.MkdirAll(*dataDir, 0o755); err != nil {
+		logger.Error("datadir_create_failed", slog.String("datadir", *dataDir), slog.Any("error", err))
+		return failReported(err)
+	}
+	// From here on the session is recorded however the daemon stops
+	session := &SessionSummary{Started: time.Now()}
+	stopHeapTracking := trackPeakHeap(time.Second)
+	var server *rpcServer
+	defer func() {
+		if server != nil {
+			session.BlocksMined = int(server.miner.blocks.Load())
+			session.HashesAttempted = server.miner.hashes.Load()
+			session.Reorgs = int(server.reorgs.Load())
+		}
+		session.finish(time.Since(session.Started), stopHeapTracking())
+		session.record(logger, *dataDir)
+	}()
+	failed := func(err error) int {
+		session.Error = err.Error()
+		return failReported(err)
+	}
+	identity, err := loadNodeKey(*dataDir)
+	if err != nil {
+		logger.Error("node_key_load_failed", slog.String("path", filepath.Join(*dataDir, nodeKeyName)), slog.Any("error", err))
+		return failed(err)
+	}
+	// Every line carries the node ID, which outlives addresses and restarts
+	logger = logger.With(slog.String("node_id", nodeID(identity.PublicKey)))
+	if len(enabledFeatures) > 0 {
+		logger.Info("experimental_features_enabled", slog.Any("features", enabledFeatures))
+	}
+
+	path := chainStorePath(*dataDir)
+	chain, report, err := loadStoredChain(*dataDir, engine, false)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		chain = []*Block{genesis}
+	case err != nil:
+		logger.Error("chain_load_failed", slog.String("path", path), slog.Any("error", err))
+		return failed(err)
+	case !report.Valid():
+		logger.Error("chain_load_failed", slog.String("path", path), slog.Any("error", report.Err()))
+		printRepairCommands(os.Stderr, *dataDir, *difficulty, report.Problems[0].Index)
+		return failed(report.Err())
+	case (*paramsPath != "" || flagPassed(fs, "network")) && !bytes.Equal(chain[0].Hash, genesis.Hash):
+		err := fmt.Errorf("stored chain does not start with the genesis block of chain %q", params.ChainID)
+		logger.Error("chain_load_failed", slog.String("path", path), slog.Any("error", err))
+		return failed(err)
+	}
+	if report != nil {
+		// The checkpoint was just written or verified by loadStoredChain
+		cp, err := readCheckpoint(*dataDir)
+		if err != nil {
+			logger.Error("chain_load_failed", slog.String("path", checkpointPath(*dataDir)), slog.Any("error", err))
+			return failed(err)
+		}
+		sums, err := readChainSums(*dataDir)
+		if err != nil {
+			logger.Error("chain_load_failed", slog.String("path", chainSumsPath(*dataDir)), slog.Any("error", err))
+			return failed(err)
+		}
+		check := selfCheck(chain, engine, cp, sums, *samples)
+		if !check.OK() {
+			logger.Error("self_check_failed", slog.Int("checked", check.Checked), slog.Int("first_bad", check.FirstBad()), slog.Any("error", check.Err()))
+			printRepairCommands(os.Stderr, *dataDir, *difficulty, check.FirstBad())
+			return failed(check.Err())
+		}
+		logger.Info("self_check_passed", slog.Int("checked", check.Checked), slog.Int("height", len(chain)-1))
+	}
+	var feed *feedPublisher
+	if sink != nil {
+		if feed, err = newFeedPublisher(sink, *feedFormat, *dataDir); err != nil {
+			logger.Error("feed_offset_load_failed", slog.String("datadir", *dataDir), slog.Any("error", err))
+			return failed(err)
+		}
+	}
+	peers, err := newPeerManager(*dataDir)
+	if err != nil {
+		logger.Error("ban_list_load_failed", slog.String("path", filepath.Join(*dataDir, peerBansName)), slog.Any("error", err))
+		return failed(err)
+	}
+	for _, u := range peerURLs {
+		peers.AddPeer(u, peerStatic)
+	}
+	var usage map[string]*TenantUsage
+	if tenants != nil {
+		if usage, err = readQuotaUsage(*dataDir); err != nil {
+			logger.Error("quota_usage_load_failed", slog.String("path", quotaUsagePath(*dataDir)), slog.Any("error", err))
+			return failed(err)
+		}
+	}
+
+	// Only a node serving TLS advertises its key for peers to pin
+	var tlsKey ed25519.PublicKey
+	var tlsConfig *tls.Config
+	if *useTLS {
+		if tlsConfig, err = nodeTLSConfig(identity); err != nil {
+			logger.Error("tls_setup_failed", slog.Any("error", err))
+			return failed(err)
+		}
+		tlsKey = identity.PublicKey
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		logger.Error("listen_failed", slog.String("addr", *addr), slog.Any("error", err))
+		return failed(err)
+	}
+	if ip := ln.Addr().(*net.TCPAddr).IP; !ip.IsLoopback() && tenants == nil {
+		// Anyone reaching the address may mine, ban peers and stop the node
+		logger.Warn("admin_rpc_unauthenticated", slog.String("addr", ln.Addr().String()))
+	}
+	if tlsConfig != nil {
+		// Peers pin this key, giving it as the user name of the node's URL
+		logger.Info("tls_enabled")
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	var stratumLn net.Listener
+	if *stratumAddr != "" {
+		if stratumLn, err = net.Listen("tcp", *stratumAddr); err != nil {
+			ln.Close()
+			logger.Error("listen_failed", slog.String("addr", *stratumAddr), slog.Any("error", err))
+			return failed(err)
+		}
+	}
+
+	var grpcLn net.Listener
+	if *grpcAddr != "" {
+		if grpcLn, err = net.Listen("tcp", *grpcAddr); err != nil {
+			ln.Close()
+			if stratumLn != nil {
+				stratumLn.Close()
+			}
+			logger.Error("listen_failed", slog.String("addr", *grpcAddr), slog.Any("error", err))
+			return failed(err)
+		}
+	}
+
+	server = newRPCServer(chain, *difficulty)
+	server.useEngine(engine)
+	server.logger = logger
+	server.magic = params.NetworkMagic
+	server.pruneDepth, server.pruneHeight = *pruneDepth, pruneHeightOf(chain)
+	server.maxReorgDepth = *maxReorgDepth
+	server.wsOrigins = origins
+	server.peers = peers
+	server.identity = identity
+	if *rateLimit > 0 || tenantRates(tenants) {
+		server.limiter = newRateLimiter(*rateLimit, *rateBurst)
+	}
+	if tenants != nil {
+		server.quotas = newQuotaTracker(tenants, usage)
+	}
+
+	// SIGINT/SIGTERM cancel the context, which stops mining and starts the
+	// shutdown below
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// The stop RPC cancels it too
+	ctx, server.shutdown = context.WithCancel(ctx)
+	defer server.shutdown()
+	if exporter != nil {
+		go exportMetrics(ctx, server, exporter, *metricsInterval)
+	}
+	if feed != nil {
+		go runFeed(ctx, server, feed)
+	}
+	if retention != nil {
+		go runRetention(ctx, server, retention, *retentionInterval, *retentionDryRun)
+	}
+	if *mdns {
+		port := ln.Addr().(*net.TCPAddr).Port
+		if ip := ln.Addr().(*net.TCPAddr).IP; ip.IsLoopback() {
+			logger.Warn("mdns_loopback_listener", slog.String("addr", ln.Addr().String()))
+		}
+		discovery := &mdnsDiscovery{advert: newMDNSAdvert(uint16(port), params.NetworkMagic, tlsKey), peers: peers, logger: logger}
+		logger.Info("mdns_started", slog.String("instance", discovery.advert.Instance))
+		go func() {
+			if err := discovery.run(ctx); err != nil {
+				logger.Error("mdns_failed", slog.Any("error", err))
+			}
+		}()
+	}
+	go identifyPeers(ctx, server, peerHandshakeInterval)
+	if _, ok := engine.(*PoWEngine); ok {
+		go func() {
+			from := server.tip().Index
+			switch err := catchUp(ctx, server, engine.MinDifficulty()); {
+			case ctx.Err() != nil:
+			case err != nil:
+				logger.Warn("catch_up_failed", slog.Int("from", from), slog.Int("height", server.tip().Index), slog.Any("error", err))
+			case server.tip().Index > from:
+				logger.Info("caught_up", slog.Int("from", from), slog.Int("height", server.tip().Index))
+			}
+		}()
+	}
+	if stratumLn != nil {
+		logger.Info("stratum_started", slog.String("addr", stratumLn.Addr().String()))
+		go func() {
+			if err := newStratumServer(server, *difficulty).serve(ctx, stratumLn); err != nil {
+				logger.Error("stratum_failed", slog.Any("error", err))
+			}
+		}()
+	}
+
+	if grpcLn != nil {
+		logger.Info("grpc_started", slog.String("addr", grpcLn.Addr().String()))
+		go func() {
+			if err := serveGRPCListener(ctx, grpcLn, server, tlsConfig); err != nil {
+				logger.Error("grpc_failed", slog.Any("error", err))
+			}
+		}()
+	}
+
+	logger.Info("daemon_started", slog.Int("height", len(chain)-1), slog.String("addr", ln.Addr().String()), slog.String("datadir", *dataDir), slog.String("chain_id", params.ChainID))
+	if err := runNode(ctx, ln, server, *dataDir, *difficulty, *workers, *saveInterval); err != nil {
+		logger.Error("daemon_failed", slog.Any("error", err))
+		return failed(err)
+	}
+	logger.Info("daemon_stopped", slog.Int("height", server.tip().Index), slog.String("path", path),
+		slog.Uint64("blocks_mined", server.miner.blocks.Load()), slog.Uint64("hashes", server.miner.hashes.Load()),
+		slog.Uint64("stale_work", server.miner.stale.Load()), slog.Uint64("stale_hashes", server.miner.staleHashes.Load()))
+	return 0
+}
+
+// runNode mines on top of the server's tip, serves it on ln and saves the
+// chain to dataDir every saveInterval until ctx is cancelled. It then stops
+// mining, closes the listener, waits for in-flight requests and writes the
+// chain one last time. Every block was validated as it was added, so each
+// save also moves the checkpoint to the saved tip, and the quota usage
+// counters are saved along with the chain.
+func runNode(ctx context.Context, ln net.Listener, server *rpcServer, dataDir string, difficulty, workers int, saveInterval time.Duration) error {
+	path := chainStorePath(dataDir)
+	var cp *Checkpoint
+	save := func() error {
+		if server.quotas != nil {
+			if err := server.quotas.save(dataDir); err != nil {
+				return fmt.Errorf("saving quota usage: %w", err)
+			}
+		}
+		if n, height := server.prune(); n > 0 {
+			server.logger.Info("blocks_pruned", slog.Int("blocks", n), slog.Int("prune_height", height))
+		}
+		chain := server.snapshot()
+		if err := saveStore(dataDir, chain); err != nil {
+			return err
+		}
+		cp = newCheckpoint(chain, difficulty, cp)
+		return writeCheckpoint(dataDir, cp)
+	}
+
+	// The miner is in place before the first request, so setgenerate
+	// always finds it. Only proof-of-work chains are mined.
+	var miner *Miner
+	if _, ok := server.consensus().(*PoWEngine); ok {
+		miner = newMiner(server, difficulty, workers)
+		server.mining = miner
+	}
+
+	httpServer := &http.Server{Handler: server}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.Serve(ln)
+	}()
+
+	if miner != nil {
+		if err := miner.Start(ctx); err != nil {
+			return err
+		}
+		defer miner.Stop()
+	}
+
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+
+	var err error
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case err = <-serveErr:
+			break loop
+		case <-ticker.C:
+			if err := save(); err != nil {
+				server.logger.Error("chain_save_failed", slog.String("path", path), slog.Any("error", err))
+			}
+		}
+	}
+
+	// Mining stops before the final save so that no mined block is lost
+	if miner != nil {
+		miner.Stop()
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if serr := httpServer.Shutdown(shutdownCtx); serr != nil && err == nil {
+		err = serr
+	}
+
+	if serr := save(); serr != nil {
+		return fmt.Errorf("saving chain: %w", serr)
+	}
+	return err
+}
+
+// minerStats counts the work of the background miner. Work is stale when
+// the tip it was building on is replaced, by a block submitted over RPC,
+// before it produced a block of its own. hashRate holds the float64 bits
+// of the hash rate of the current search, as of its last progress report.
+type minerStats struct {
+	blocks      atomic.Uint64
+	hashes      atomic.Uint64
+	stale       atomic.Uint64
+	staleHashes atomic.Uint64
+	hashRate    atomic.Uint64
+}
+
+// saveChain writes the chain next to path and renames it into place, so a
+// crash during the write never leaves a truncated store behind.
+func saveChain(chain []*Block, path string) error {
+	tmp := filepath.Join(filepath.Dir(path), "tmp-"+filepath.Base(path))
+	if err := writeChainFile(chain, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRunNode mines and serves until cancelled, then checks that the
+// listener is closed and the saved chain holds every mined block.
+func TestRunNode(t *testing.T) {
+	dataDir := t.TempDir()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + ln.Addr().String() + "/"
+	server := newRPCServer([]*Block{newGenesisBlock(sha256Hasher{})}, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- runNode(ctx, ln, server, dataDir, 1, 2, 10*time.Millisecond)
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for server.tip().Index < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("node did not mine 3 blocks")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	resp, err := http.Post(url, "application/json", strings.NewReader(`{"jsonrpc":"2.0","method":"getblockcount","id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("runNode: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("runNode did not return after cancellation")
+	}
+
+	if _, err := http.Post(url, "application/json", strings.NewReader(`{}`)); err == nil {
+		t.Error("server still accepting connections after shutdown")
+	}
+	saved, err := importChain(chainStorePath(dataDir), &PoWEngine{Difficulty: 1}, DecodePolicy{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp, err := readCheckpoint(dataDir); err != nil || cp == nil || !cp.matches(saved, 1) {
+		t.Errorf("checkpoint %+v does not match the saved chain (%v)", cp, err)
+	}
+	if tip := server.tip(); len(saved) != tip.Index+1 || string(saved[len(saved)-1].Hash) != string(tip.Hash) {
+		t.Errorf("saved %d blocks, expected %d ending at the tip", len(saved), tip.Index+1)
+	}
+}
+
+// TestRPC_AddBlockStaleTip rejects a locally mined block whose parent is no
+// longer the tip.
+func TestRPC_AddBlockStaleTip(t *testing.T) {
+	chain := makeBlockchain(3, 1)
+	s := newRPCServer(append([]*Block(nil), chain[:2]...), 1)
+
+	stale, _, err := mineBlock(context.Background(), chain[0], "stale", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.Index = 2
+	if reason := s.addBlock(context.Background(), stale); reason == "" {
+		t.Error("accepted a block mined on a stale tip")
+	}
+	if reason := s.addBlock(context.Background(), chain[2]); reason != "" {
+		t.Errorf("rejected the next block: %s", reason)
+	}
+}
+
+// TestMinerStaleWork replaces the tip while the miner is searching and
+// checks that the search is abandoned and counted as stale.
+func TestMinerStaleWork(t *testing.T) {
+	genesis := newGenesisBlock(sha256Hasher{})
+	server := newRPCServer([]*Block{genesis}, 1)
+
+	// Too hard to finish during the test, so only stale work is counted
+	miner := newMiner(server, 8, 1)
+	if err := miner.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	block, _, err := mineBlock(context.Background(), genesis, "peer block", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if reason := server.addBlock(context.Background(), block); reason != "" {
+		t.Fatalf("peer block rejected: %s", reason)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for server.miner.stale.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("miner did not abandon its stale template")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	miner.Stop()
+
+	if server.miner.staleHashes.Load() == 0 || server.miner.blocks.Load() != 0 {
+		t.Errorf("unexpected stats: %d stale hashes, %d blocks", server.miner.staleHashes.Load(), server.miner.blocks.Load())
+	}
+	var info struct{ Result map[string]any }
+	rpcPost(t, server, `{"jsonrpc":"2.0","method":"getmininginfo","id":1}`, &info)
+	if _, ok := info.Result["hashespersec"]; !ok || info.Result["stalework"] != 1.0 || info.Result["blocks"] != 1.0 {
+		t.Errorf("getmininginfo: %v", info.Result)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrMalformedBlock reports bytes that are not a block as serializeBlock
+// writes it.
+var ErrMalformedBlock = errors.New("malformed block encoding")
+
+// hashSize is the size of every block hash and Merkle root.
+const hashSize = 32
+
+// deserializeBlock is the inverse of serializeBlock: it decodes the bytes
+// a block's hash covers and sets Hash to their hash. It is strict, since
+// the bytes may come from anywhere. The version must be known, and every
+// length must fit what is left. Flags must be 0 or 1, and nothing may
+// follow the previous hash. The encoding must also be the one
+// serializeBlock gives the decoded block, so that two different byte
+// strings never decode to the same block. Signature and Redaction are
+// not serialized and stay unset, as does the data of a block with a
+// Merkle root.
+//
+// Version 4 blocks carry a Merkle root in place of their data. So do
+// version 5 blocks, unless the body is not hash-sized, which only a
+// version 5 block built without a root has; its body is then its data.
+func deserializeBlock(raw []byte) (*Block, error) {
+	if n := int64(len(raw)); n > blockLimits.MaxBlockBytes {
+		return nil, fmt.Errorf("%w: encoding is %d bytes, limit %d", ErrBlockTooLarge, n, blockLimits.MaxBlockBytes)
+	}
+	d := &blockDecoder{raw: raw}
+	version := d.byte("version")
+	if d.err == nil && (version < blockFormatV1 || version > latestBlockFormat) {
+		return nil, d.fail("unknown version %#02x", version)
+	}
+	block := &Block{HashAlgo: d.byte("hash algorithm")}
+	if d.err == nil {
+		if _, err := hasherByID(block.HashAlgo); err != nil {
+			return nil, d.fail("%v", err)
+		}
+	}
+	index := d.int64("index")
+	if d.err == nil && (index < 0 || int64(int(index)) != index) {
+		return nil, d.fail("index %d out of range", index)
+	}
+	block.Index = int(index)
+	block.Timestamp = d.int64("timestamp")
+	block.Nonce = d.uint64("nonce")
+	if version >= blockFormatV5 {
+		block.ExtraNonce = d.uint64("extra nonce")
+	}
+	if version >= blockFormatV2 {
+		block.Bits = d.uint32("bits")
+	}
+	switch {
+	case version == blockFormatV3:
+		block.Epoch = d.epoch()
+	case version >= blockFormatV4:
+		flag := d.byte("epoch flag")
+		if d.err == nil && flag > 1 {
+			return nil, d.fail("epoch flag %d, want 0 or 1", flag)
+		}
+		if flag == 1 {
+			block.Epoch = d.epoch()
+		}
+		if block.Epoch != nil && version >= blockFormatV6 {
+			block.Epoch.ValidatorsHash = d.bytes("epoch validators hash")
+		}
+	}
+	body := d.bytes("body")
+	if version == blockFormatV4 || version >= blockFormatV5 && len(body) == hashSize {
+		block.MerkleRoot = body
+	} else {
+		if n := int64(len(body)); n > blockLimits.MaxDataBytes {
+			return nil, fmt.Errorf("%w: data is %d bytes, limit %d", ErrBlockTooLarge, n, blockLimits.MaxDataBytes)
+		}
+		block.Data = body
+	}
+	block.PrevHash = d.bytes("previous hash")
+	if d.err != nil {
+		return nil, d.err
+	}
+	if d.off != len(raw) {
+		return nil, d.fail("%d trailing bytes", len(raw)-d.off)
+	}
+
+	if block.MerkleRoot != nil && len(block.MerkleRoot) != hashSize {
+		return nil, fmt.Errorf("%w: Merkle root is %d bytes, want %d", ErrMalformedBlock, len(block.MerkleRoot), hashSize)
+	}
+	if want := blockFormatVersion(block); want != version {
+		return nil, fmt.Errorf("%w: version %d, but the fields need version %d", ErrMalformedBlock, version, want)
+	}
+	if !bytes.Equal(serializeBlock(block), raw) {
+		return nil, fmt.Errorf("%w: not the canonical encoding of its block", ErrMalformedBlock)
+	}
+	if block.Data == nil {
+		block.Data = []byte{}
+	}
+	block.Hash = calculateHash(block)
+	return block, nil
+}
+
+// blockDecoder reads the fields of a serialized block in order. After the
+// first error, reads return zero values and err keeps the error.
+type blockDecoder struct {
+	raw []byte
+	off int
+	err error
+}
+
+func (d *blockDecoder) fail(format string, args ...any) error {
+	d.err = fmt.Errorf("%w: offset %d: %s", ErrMalformedBlock, d.off, fmt.Sprintf(format, args...))
+	return d.err
+}
+
+// take returns the next n bytes of field, or nil if there are fewer left.
+func (d *blockDecoder) take(n int, field string) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.raw)-d.off {
+		d.fail("%s needs %d bytes, %d left", field, n, len(d.raw)-d.off)
+		return nil
+	}
+	b := d.raw[d.off : d.off+n]
+	d.off += n
+	return b
+}
+
+func (d *blockDecoder) byte(field string) byte {
+	if b := d.take(1, field); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *blockDecoder) uint32(field string) uint32 {
+	if b := d.take(4, field); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *blockDecoder) uint64(field string) uint64 {
+	if b := d.take(8, field); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *blockDecoder) int64(field string) int64 {
+	return int64(d.uint64(field))
+}
+
+// bytes reads a length-prefixed field. The length is a signed 32-bit
+// count, as serializeBlock writes it.
+func (d *blockDecoder) bytes(field string) []byte {
+	n := int32(d.uint32(field + " length"))
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 {
+		d.fail("%s length %d is negative", field, n)
+		return nil
+	}
+	b := d.take(int(n), field)
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+// epoch reads an epoch summary as serializeEpochSummary writes it.
+func (d *blockDecoder) epoch() *EpochSummary {
+	e := new(EpochSummary)
+	epoch := d.int64("epoch number")
+	e.StartTime = d.int64("epoch start time")
+	e.EndTime = d.int64("epoch end time")
+	e.DataBytes = d.int64("epoch data bytes")
+	e.Hash = d.bytes("epoch hash")
+	if d.err != nil {
+		return nil
+	}
+	if epoch < 0 || int64(int(epoch)) != epoch {
+		d.fail("epoch %d out of range", epoch)
+		return nil
+	}
+	e.Epoch = int(epoch)
+	return e
+}
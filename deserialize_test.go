@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// encodingSamples returns one block of each serialization version, plus a
+// version 5 block without a Merkle root and one with an unusual hasher.
+func encodingSamples() []*Block {
+	chain := makeBlockchain(3, 1)
+	epoch := &EpochSummary{Epoch: 2, Hash: bytes.Repeat([]byte{7}, 32), StartTime: 100, EndTime: 200, DataBytes: 42}
+	samples := []*Block{
+		chain[0],
+		chain[1],
+		{Index: 1, Timestamp: 1, Data: []byte("legacy"), PrevHash: chain[0].Hash, Nonce: 9},
+		{Index: 2, Timestamp: 2, Data: []byte("bits"), PrevHash: chain[0].Hash, Nonce: 1 << 40, Bits: 0x1f00ffff},
+		{Index: 200, Timestamp: 3, Data: []byte("epoch"), PrevHash: chain[0].Hash, Bits: 0x1f00ffff, Epoch: epoch},
+		{Index: 3, Timestamp: -4, PrevHash: chain[0].Hash, MerkleRoot: dataMerkleRoot(HashSHA256, []byte("root")), Epoch: epoch},
+		{Index: 4, Timestamp: 5, Data: []byte("no root"), PrevHash: chain[0].Hash, ExtraNonce: 3},
+		{Index: 5, Timestamp: 6, PrevHash: chain[0].Hash, HashAlgo: HashBLAKE3, MerkleRoot: dataMerkleRoot(HashBLAKE3, []byte("b3")), ExtraNonce: 1},
+	}
+	for _, b := range samples {
+		b.Hash = calculateHash(b)
+	}
+	return samples
+}
+
+func TestDeserializeBlock(t *testing.T) {
+	for _, want := range encodingSamples() {
+		raw := serializeBlock(want)
+		got, err := deserializeBlock(raw)
+		if err != nil {
+			t.Errorf("version %d block %d: %v", raw[0], want.Index, err)
+			continue
+		}
+		if !bytes.Equal(got.Hash, want.Hash) || !bytes.Equal(serializeBlock(got), raw) {
+			t.Errorf("version %d block %d decoded to %+v", raw[0], want.Index, got)
+		}
+	}
+}
+
+func TestDeserializeBlockMalformed(t *testing.T) {
+	samples := encodingSamples()
+	v1, v4 := serializeBlock(samples[2]), serializeBlock(samples[5])
+	edit := func(raw []byte, off int, b ...byte) []byte {
+		raw = append([]byte{}, raw...)
+		copy(raw[off:], b)
+		return raw
+	}
+	// Offsets into v1: version 0, algorithm 1, index 2, timestamp 10,
+	// nonce 18, data length 26. In v4 the epoch flag is at 30.
+	negative := make([]byte, 4)
+	binary.LittleEndian.PutUint32(negative, 0xffffffff)
+	tests := []struct {
+		name string
+		raw  []byte
+	}{
+		{"empty", nil},
+		{"unknown version", edit(v1, 0, 0x06)},
+		{"version zero", edit(v1, 0, 0x00)},
+		{"unknown hash algorithm", edit(v1, 1, 9)},
+		{"negative index", edit(v1, 2, 0, 0, 0, 0, 0, 0, 0, 0x80)},
+		{"negative data length", edit(v1, 26, negative...)},
+		{"data length past the end", edit(v1, 26, 0xff, 0xff, 0, 0)},
+		{"trailing byte", append(append([]byte{}, v1...), 0)},
+		{"epoch flag 2", edit(v4, 30, 2)},
+		{"newer version than the fields need", edit(v1, 0, 0x02)},
+		{"older version than the fields need", edit(v4, 0, 0x03)},
+	}
+	for _, tt := range tests {
+		if b, err := deserializeBlock(tt.raw); !errors.Is(err, ErrMalformedBlock) {
+			t.Errorf("%s: got %+v, %v", tt.name, b, err)
+		}
+	}
+	for n := range len(v4) {
+		if _, err := deserializeBlock(v4[:n]); !errors.Is(err, ErrMalformedBlock) {
+			t.Errorf("truncated to %d bytes: %v", n, err)
+		}
+	}
+
+	t.Cleanup(func() { blockLimits = defaultBlockLimits })
+	blockLimits = BlockLimits{MaxBlockBytes: int64(len(v1)) - 1, MaxDataBytes: 100}
+	if _, err := deserializeBlock(v1); !errors.Is(err, ErrBlockTooLarge) {
+		t.Errorf("oversized encoding: %v", err)
+	}
+	blockLimits = BlockLimits{MaxBlockBytes: 1000, MaxDataBytes: 3}
+	if _, err := deserializeBlock(v1); !errors.Is(err, ErrBlockTooLarge) {
+		t.Errorf("oversized data: %v", err)
+	}
+}
+
+// FuzzDeserializeBlock checks that no input makes deserializeBlock panic,
+// and that whatever it accepts serializes back to the same bytes.
+func FuzzDeserializeBlock(f *testing.F) {
+	for _, b := range encodingSamples() {
+		f.Add(serializeBlock(b))
+	}
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		block, err := deserializeBlock(raw)
+		if err != nil {
+			if !errors.Is(err, ErrMalformedBlock) && !errors.Is(err, ErrBlockTooLarge) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			return
+		}
+		if !bytes.Equal(serializeBlock(block), raw) {
+			t.Fatalf("%+v serializes to other bytes", block)
+		}
+		if !bytes.Equal(block.Hash, calculateHash(block)) {
+			t.Fatalf("%+v has the wrong hash", block)
+		}
+	})
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A node learns the JSON-RPC URLs of other nodes in three ways: from the
+// daemon's -peers list, from POST /peers at runtime, and over multicast
+// DNS on the local network. With -mdns, the daemon answers queries for
+// the _blockchain._tcp service with its port, network magic and, under
+// -tls, its identity key, and asks for the service every mdnsInterval;
+// nodes of the same network that answer are added as peers at the address
+// their answer came from.
+
+// Peer sources, how a node learned of a peer.
+const (
+	peerStatic = "static"
+	peerMDNS   = "mdns"
+	peerAPI    = "api"
+)
+
+// KnownPeer is a node whose JSON-RPC URL this one knows.
+type KnownPeer struct {
+	URL    string    `json:"url"`
+	Source string    `json:"source"`
+	Added  time.Time `json:"added"`
+	Banned bool      `json:"banned"`
+	// NodeID is the ID the peer proved in a handshake, once it has
+	NodeID string `json:"node_id,omitempty"`
+}
+
+// parsePeerURL checks that raw is the http or https URL of a node, with
+// a well-formed identity key if it pins one, and returns it in canonical
+// form.
+func parsePeerURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("peer URL %q: %w", raw, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("peer URL %q must be http://host:port/ or https://host:port/", raw)
+	}
+	if _, err := peerPin(u); err != nil {
+		return "", err
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.Fragment = ""
+	return u.String(), nil
+}
+
+// AddPeer records the URL of a node learned from source. It reports
+// whether the URL was new.
+func (m *PeerManager) AddPeer(rawURL, source string) (bool, error) {
+	u, err := parsePeerURL(rawURL)
+	if err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.known[u]; ok {
+		return false, nil
+	}
+	m.known[u] = KnownPeer{URL: u, Source: source, Added: m.now()}
+	return true, nil
+}
+
+// Known returns the nodes whose URLs are known, by URL.
+func (m *PeerManager) Known() []KnownPeer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	peers := make([]KnownPeer, 0, len(m.known))
+	for u, p := range m.known {
+		ban, banned := m.bans[u]
+		p.Banned = banned && ban.Until.After(m.now())
+		peers = append(peers, p)
+	}
+	slices.SortFunc(peers, func(a, b KnownPeer) int { return strings.Compare(a.URL, b.URL) })
+	return peers
+}
+
+// servePeers answers GET /peers with the known peers, and adds the peer
+// {"url": ...} in the body of a POST. On a node with tenants only admins
+// may add peers.
+func (s *rpcServer) servePeers(w http.ResponseWriter, r *http.Request) {
+	if s.peers == nil {
+		writeRESTError(w, http.StatusNotFound, "this node has no peer manager")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.peers.Known())
+		return
+	case http.MethodPost:
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeRESTError(w, http.StatusMethodNotAllowed, "peers are listed with GET and added with POST")
+		return
+	}
+
+	if err := authorize(r.Context(), "POST /peers"); err != nil {
+		writeRESTError(w, http.StatusForbidden, err.Message)
+		return
+	}
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, rpcMaxBodyBytes)).Decode(&req); err != nil {
+		writeRESTError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	u, err := parsePeerURL(req.URL)
+	if err != nil {
+		writeRESTError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if s.peers.Banned(u) {
+		writeRESTError(w, http.StatusForbidden, "peer is banned")
+		return
+	}
+	added, err := s.peers.AddPeer(u, peerAPI)
+	if err != nil {
+		writeRESTError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	status := http.StatusOK
+	if added {
+		s.logger.LogAttrs(r.Context(), slog.LevelInfo, "peer_added", slog.String("request_id", requestID(r.Context())), slog.String("url", u), slog.String("source", peerAPI))
+		status = http.StatusCreated
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"url": u})
+}
+
+// mdnsServiceName is the DNS-SD service nodes advertise.
+const mdnsServiceName = "_blockchain._tcp.local."
+
+// mdnsInterval is how often a node asks the local network for peers.
+const mdnsInterval = time.Minute
+
+// mdnsTTL is the lifetime, in seconds, of the records a node advertises.
+const mdnsTTL = 120
+
+// mdnsGroup is the mDNS multicast group.
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// DNS record types and classes used by mDNS discovery.
+const (
+	dnsTypePTR = 12
+	dnsTypeTXT = 16
+	dnsTypeSRV = 33
+	dnsTypeANY = 255
+
+	dnsClassIN     = 1
+	dnsCacheFlush  = 0x8000
+	dnsFlagReply   = 0x8400 // a response, with the authoritative bit
+	dnsHeaderBytes = 12
+)
+
+var errDNSTruncated = errors.New("truncated DNS message")
+
+// mdnsAdvert is what a node advertises about itself.
+type mdnsAdvert struct {
+	Instance string // DNS label naming this node, unique on the network
+	Port     uint16
+	Magic    uint32 // network magic, so nodes of other networks are ignored
+	// Key, when set, is the node's identity key; the node serves TLS and
+	// is added as a peer with the key pinned
+	Key ed25519.PublicKey
+}
+
+// newMDNSAdvert returns an advert for a node listening on port with a
+// random instance name.
+func newMDNSAdvert(port uint16, magic uint32, key ed25519.PublicKey) *mdnsAdvert {
+	var id [4]byte
+	rand.Read(id[:])
+	return &mdnsAdvert{Instance: "node-" + hex.EncodeToString(id[:]), Port: port, Magic: magic, Key: key}
+}
+
+// mdnsService is a node found in an mDNS response.
+type mdnsService struct {
+	Instance string
+	Port     uint16
+	TXT      map[string]string
+}
+
+// mdnsMessage is the part of an mDNS message discovery uses.
+type mdnsMessage struct {
+	Response  bool
+	Questions []string // names asked for PTR or any records
+	Services  []mdnsService
+}
+
+// asks reports whether m is a query for name.
+func (m *mdnsMessage) asks(name string) bool {
+	return !m.Response && slices.ContainsFunc(m.Questions, func(q string) bool { return strings.EqualFold(q, name) })
+}
+
+// mdnsQuery returns a query for the nodes on the network.
+func mdnsQuery() []byte {
+	msg := make([]byte, dnsHeaderBytes)
+	binary.BigEndian.PutUint16(msg[4:], 1) // one question
+	msg = appendDNSName(msg, mdnsServiceName)
+	msg = binary.BigEndian.AppendUint16(msg, dnsTypePTR)
+	return binary.BigEndian.AppendUint16(msg, dnsClassIN)
+}
+
+// response returns the answer to a query for the service: a PTR record
+// naming the node, an SRV record with its port and a TXT record with its
+// network magic and identity key.
+func (a *mdnsAdvert) response() []byte {
+	instance := a.Instance + "." + mdnsServiceName
+	msg := make([]byte, dnsHeaderBytes)
+	binary.BigEndian.PutUint16(msg[2:], dnsFlagReply)
+	binary.BigEndian.PutUint16(msg[6:], 3) // three answers
+
+	msg = appendDNSRecord(msg, mdnsServiceName, dnsTypePTR, dnsClassIN, appendDNSName(nil, instance))
+	srv := binary.BigEndian.AppendUint32(nil, 0) // priority and weight
+	srv = binary.BigEndian.AppendUint16(srv, a.Port)
+	srv = appendDNSName(srv, a.Instance+".local.")
+	msg = appendDNSRecord(msg, instance, dnsTypeSRV, dnsClassIN|dnsCacheFlush, srv)
+	var txt []byte
+	for _, s := range []string{fmt.Sprintf("net=%08x", a.Magic), "key=" + hex.EncodeToString(a.Key)} {
+		if !strings.HasSuffix(s, "=") {
+			txt = append(append(txt, byte(len(s))), s...)
+		}
+	}
+	return appendDNSRecord(msg, instance, dnsTypeTXT, dnsClassIN|dnsCacheFlush, txt)
+}
+
+func appendDNSName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+func appendDNSRecord(b []byte, name string, typ, class uint16, rdata []byte) []byte {
+	b = appendDNSName(b, name)
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint16(b, class)
+	b = binary.BigEndian.AppendUint32(b, mdnsTTL)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(rdata)))
+	return append(b, rdata...)
+}
+
+// readDNSName reads the possibly compressed name at off in msg and
+// returns it with the offset just past it.
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1 // where the name ends in place, before any pointer
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errDNSTruncated
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, errDNSTruncated
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, errors.New("DNS name pointers loop")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+		case n&0xc0 != 0:
+			return "", 0, fmt.Errorf("bad DNS label length %#x", n)
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, errDNSTruncated
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// parseMDNS decodes the questions of a query, or the services named in a
+// response's answers and additional records.
+func parseMDNS(msg []byte) (*mdnsMessage, error) {
+	if len(msg) < dnsHeaderBytes {
+		return nil, errDNSTruncated
+	}
+	m := &mdnsMessage{Response: binary.BigEndian.Uint16(msg[2:])&0x8000 != 0}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	records := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+
+	off := dnsHeaderBytes
+	for range questions {
+		name, next, err := readDNSName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(msg) {
+			return nil, errDNSTruncated
+		}
+		if typ := binary.BigEndian.Uint16(msg[next:]); typ == dnsTypePTR || typ == dnsTypeANY {
+			m.Questions = append(m.Questions, name)
+		}
+		off = next + 4
+	}
+
+	var instances []string
+	ports := make(map[string]uint16)
+	txts := make(map[string]map[string]string)
+	for range records {
+		name, next, err := readDNSName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(msg) {
+			return nil, errDNSTruncated
+		}
+		typ := binary.BigEndian.Uint16(msg[next:])
+		size := int(binary.BigEndian.Uint16(msg[next+8:]))
+		start := next + 10
+		if start+size > len(msg) {
+			return nil, errDNSTruncated
+		}
+		rdata := msg[start : start+size]
+		name = strings.ToLower(name)
+		switch typ {
+		case dnsTypePTR:
+			if name == mdnsServiceName {
+				target, _, err := readDNSName(msg, start)
+				if err != nil {
+					return nil, err
+				}
+				instances = append(instances, strings.ToLower(target))
+			}
+		case dnsTypeSRV:
+			if size < 6 {
+				return nil, errDNSTruncated
+			}
+			ports[name] = binary.BigEndian.Uint16(rdata[4:])
+		case dnsTypeTXT:
+			txt := make(map[string]string)
+			for i := 0; i < len(rdata); {
+				n := int(rdata[i])
+				if i+1+n > len(rdata) {
+					return nil, errDNSTruncated
+				}
+				k, v, _ := strings.Cut(string(rdata[i+1:i+1+n]), "=")
+				txt[k] = v
+				i += 1 + n
+			}
+			txts[name] = txt
+		}
+		off = start + size
+	}
+
+	for _, instance := range instances {
+		port, ok := ports[instance]
+		if !ok {
+			continue
+		}
+		m.Services = append(m.Services, mdnsService{
+			Instance: strings.TrimSuffix(instance, "."+mdnsServiceName),
+			Port:     port,
+			TXT:      txts[instance],
+		})
+	}
+	return m, nil
+}
+
+// mdnsDiscovery advertises a node over mDNS and adds the nodes it hears
+// from to a peer manager.
+type mdnsDiscovery struct {
+	advert *mdnsAdvert
+	peers  *PeerManager
+	logger *slog.Logger
+}
+
+// handle processes a message received from from and returns the reply to
+// send to the group, if any.
+func (d *mdnsDiscovery) handle(ctx context.Context, raw []byte, from *net.UDPAddr) []byte {
+	msg, err := parseMDNS(raw)
+	if err != nil {
+		d.logger.LogAttrs(ctx, slog.LevelDebug, "mdns_message_invalid", slog.String("from", from.String()), slog.Any("error", err))
+		return nil
+	}
+	if !msg.Response {
+		if msg.asks(mdnsServiceName) {
+			return d.advert.response()
+		}
+		return nil
+	}
+	magic := fmt.Sprintf("%08x", d.advert.Magic)
+	for _, svc := range msg.Services {
+		if strings.EqualFold(svc.Instance, d.advert.Instance) || svc.TXT["net"] != magic {
+			continue
+		}
+		u := &url.URL{Scheme: "http", Host: net.JoinHostPort(from.IP.String(), strconv.Itoa(int(svc.Port))), Path: "/"}
+		if key := svc.TXT["key"]; key != "" {
+			u.Scheme, u.User = "https", url.User(key)
+		}
+		added, err := d.peers.AddPeer(u.String(), peerMDNS)
+		if err != nil {
+			d.logger.LogAttrs(ctx, slog.LevelDebug, "mdns_message_invalid", slog.String("from", from.String()), slog.Any("error", err))
+			continue
+		}
+		if added {
+			d.logger.LogAttrs(ctx, slog.LevelInfo, "peer_added", slog.String("url", u.String()), slog.String("source", peerMDNS), slog.String("instance", svc.Instance))
+		}
+	}
+	return nil
+}
+
+// run joins the mDNS group, announces the node and asks for peers every
+// mdnsInterval until ctx is cancelled.
+func (d *mdnsDiscovery) run(ctx context.Context) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	send := func(msg []byte) {
+		if _, err := conn.WriteToUDP(msg, mdnsGroup); err != nil && ctx.Err() == nil {
+			d.logger.LogAttrs(ctx, slog.LevelWarn, "mdns_send_failed", slog.Any("error", err))
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(mdnsInterval)
+		defer ticker.Stop()
+		send(d.advert.response())
+		for {
+			send(mdnsQuery())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if reply := d.handle(ctx, buf[:n], from); reply != nil {
+			send(reply)
+		}
+	}
+}
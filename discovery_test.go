@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMDNSMessages(t *testing.T) {
+	query, err := parseMDNS(mdnsQuery())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !query.asks(mdnsServiceName) || query.asks("_http._tcp.local.") {
+		t.Errorf("query asks for %v", query.Questions)
+	}
+
+	advert := &mdnsAdvert{Instance: "node-1", Port: 7544, Magic: 0x4d464274}
+	resp, err := parseMDNS(advert.response())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Response || resp.asks(mdnsServiceName) {
+		t.Error("response parsed as a query")
+	}
+	if len(resp.Services) != 1 {
+		t.Fatalf("got %d services, want 1", len(resp.Services))
+	}
+	if svc := resp.Services[0]; svc.Instance != "node-1" || svc.Port != 7544 || svc.TXT["net"] != "4d464274" {
+		t.Errorf("service %+v", svc)
+	}
+
+	// Other responders compress names; the PTR target here points back
+	// into the record's own name
+	msg := make([]byte, dnsHeaderBytes)
+	binary.BigEndian.PutUint16(msg[2:], dnsFlagReply)
+	binary.BigEndian.PutUint16(msg[6:], 2)
+	serviceAt := len(msg)
+	msg = appendDNSRecord(msg, mdnsServiceName, dnsTypePTR, dnsClassIN, []byte{4, 'p', 'e', 'e', 'r', 0xc0, byte(serviceAt)})
+	srv := []byte{0, 0, 0, 0, 0x1f, 0x90, 0xc0, byte(serviceAt)}
+	msg = appendDNSRecord(msg, "PEER."+mdnsServiceName, dnsTypeSRV, dnsClassIN, srv)
+	resp, err = parseMDNS(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Services) != 1 || resp.Services[0].Instance != "peer" || resp.Services[0].Port != 8080 {
+		t.Errorf("compressed response gave %+v", resp.Services)
+	}
+
+	for name, bad := range map[string][]byte{
+		"short header":   msg[:5],
+		"cut record":     msg[:len(msg)-3],
+		"pointer loop":   append(append([]byte{}, msg[:dnsHeaderBytes]...), 0xc0, dnsHeaderBytes),
+		"bad label type": append(append([]byte{}, msg[:dnsHeaderBytes]...), 0x80),
+	} {
+		if _, err := parseMDNS(bad); err == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+}
+
+func TestMDNSDiscovery(t *testing.T) {
+	peers, _ := newPeerManager("")
+	d := &mdnsDiscovery{
+		advert: &mdnsAdvert{Instance: "node-self", Port: 7544, Magic: 0x4d464274},
+		peers:  peers,
+		logger: slog.New(slog.DiscardHandler),
+	}
+	ctx := context.Background()
+	from := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 5353}
+
+	if reply := d.handle(ctx, mdnsQuery(), from); reply == nil {
+		t.Fatal("query not answered")
+	}
+	for _, advert := range []*mdnsAdvert{
+		{Instance: "node-self", Port: 7544, Magic: 0x4d464274}, // our own, looped back
+		{Instance: "node-main", Port: 7544, Magic: 0x4d46426d}, // another network
+		{Instance: "node-peer", Port: 17544, Magic: 0x4d464274},
+	} {
+		if reply := d.handle(ctx, advert.response(), from); reply != nil {
+			t.Errorf("replied to the response of %s", advert.Instance)
+		}
+	}
+	d.handle(ctx, []byte("not dns"), from)
+
+	known := peers.Known()
+	if len(known) != 1 || known[0].URL != "http://192.168.1.20:17544/" || known[0].Source != peerMDNS {
+		t.Errorf("known peers %+v", known)
+	}
+}
+
+func TestPeersEndpoint(t *testing.T) {
+	s := newRPCServer(makeBlockchain(2, 1), 1)
+	do := func(method, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(method, "/peers", strings.NewReader(body)))
+		return rec
+	}
+	if rec := do(http.MethodGet, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("without a peer manager: status %d", rec.Code)
+	}
+
+	s.peers, _ = newPeerManager("")
+	s.peers.AddPeer("http://10.0.0.1:7544", peerStatic)
+	if rec := do(http.MethodPost, `{"url":"http://10.0.0.2:7544/"}`); rec.Code != http.StatusCreated {
+		t.Errorf("adding a peer: status %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodPost, `{"url":"http://10.0.0.2:7544/"}`); rec.Code != http.StatusOK {
+		t.Errorf("adding a known peer: status %d", rec.Code)
+	}
+	for _, body := range []string{`{"url":"ftp://10.0.0.3/"}`, `{"url":""}`, `not json`} {
+		if rec := do(http.MethodPost, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("POST %s: status %d", body, rec.Code)
+		}
+	}
+	s.peers.Ban("http://10.0.0.4:7544/", time.Hour, "test")
+	if rec := do(http.MethodPost, `{"url":"http://10.0.0.4:7544"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("adding a banned peer: status %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE: status %d", rec.Code)
+	}
+
+	var known []KnownPeer
+	rec := do(http.MethodGet, "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &known); err != nil {
+		t.Fatal(err)
+	}
+	if len(known) != 2 || known[0].URL != "http://10.0.0.1:7544/" || known[0].Source != peerStatic || known[1].Source != peerAPI {
+		t.Errorf("GET /peers = %+v", known)
+	}
+
+	if code := runDaemon([]string{"-datadir", t.TempDir(), "-peers", "http://10.0.0.1:7544,10.0.0.2"}); code != exitConfig {
+		t.Errorf("daemon with a bad peer URL: exit code %d, want %d", code, exitConfig)
+	}
+}
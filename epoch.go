@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// epochLength is the number of blocks in an epoch. Epoch e holds blocks
+// e*epochLength through (e+1)*epochLength-1.
+const epochLength = 100
+
+// EpochSummary commits to a completed epoch. It is carried by the first
+// block of the following epoch and covered by that block's hash, so a
+// syncing node can check a whole epoch against one header. On a
+// proof-of-stake chain it also commits to the validator set allowed to
+// sign the epoch it starts; proof-of-work chains have none.
+type EpochSummary struct {
+	Epoch     int    `json:"epoch"`
+	Hash      []byte `json:"hash"` // hash of the epoch's block hashes, in order
+	StartTime int64  `json:"start_time"`
+	EndTime   int64  `json:"end_time"`
+	DataBytes int64  `json:"data_bytes"`
+	// ValidatorsHash is the validatorSetHash of the validators of the
+	// next epoch. Setting it makes the block format version 6.
+	ValidatorsHash []byte `json:"validators_hash,omitempty"`
+}
+
+// serializeEpochSummary writes the summary in the layout committed to by
+// the block header.
+func serializeEpochSummary(w io.Writer, e *EpochSummary) {
+	var buf [8]byte
+	for _, v := range []int64{int64(e.Epoch), e.StartTime, e.EndTime, e.DataBytes} {
+		binary.LittleEndian.PutUint64(buf[:], uint64(v))
+		w.Write(buf[:])
+	}
+	binary.LittleEndian.PutUint32(buf[:4], uint32(len(e.Hash)))
+	w.Write(buf[:4])
+	w.Write(e.Hash)
+	if len(e.ValidatorsHash) != 0 {
+		binary.LittleEndian.PutUint32(buf[:4], uint32(len(e.ValidatorsHash)))
+		w.Write(buf[:4])
+		w.Write(e.ValidatorsHash)
+	}
+}
+
+// chainValidatorsHash is the validatorSetHash of the chain this process
+// works on, set from its chain parameters like formatActivations, or nil
+// on a proof-of-work chain.
+var chainValidatorsHash []byte
+
+// serializeOptionalEpochSummary writes a presence byte followed by the
+// summary, if any, as block format version 4 does.
+func serializeOptionalEpochSummary(w io.Writer, e *EpochSummary) {
+	if e == nil {
+		w.Write([]byte{0})
+		return
+	}
+	w.Write([]byte{1})
+	serializeEpochSummary(w, e)
+}
+
+// summarizeEpoch computes the summary of epoch from its blocks.
+func summarizeEpoch(epoch int, blocks []*Block) *EpochSummary {
+	h, err := hasherByID(blocks[0].HashAlgo)
+	if err != nil {
+		// Blocks with unknown algorithms fail validation before this
+		h = sha256Hasher{}
+	}
+	hasher := h.New()
+	summary := &EpochSummary{
+		Epoch:     epoch,
+		StartTime: blocks[0].Timestamp,
+		EndTime:   blocks[len(blocks)-1].Timestamp,
+	}
+	for _, block := range blocks {
+		hasher.Write(block.Hash)
+		summary.DataBytes += int64(dataLength(block))
+	}
+	summary.Hash = hasher.Sum(nil)
+	return summary
+}
+
+// epochSummaryFor returns the summary the block following chain must carry,
+// or nil if that block does not start an epoch.
+func epochSummaryFor(chain []*Block) *EpochSummary {
+	height := len(chain)
+	if height == 0 || height%epochLength != 0 {
+		return nil
+	}
+	summary := summarizeEpoch(height/epochLength-1, chain[height-epochLength:])
+	// The validator set joins the summary once format version 6 does, so
+	// chains from before it stay valid
+	if formatActive(blockFormatV6, height) {
+		summary.ValidatorsHash = chainValidatorsHash
+	}
+	return summary
+}
+
+// checkEpochSummary checks the summary of block, the successor of prev.
+// Chains from before epochs existed have no summaries, but once a chain
+// carries one every later epoch boundary must carry one too.
+func checkEpochSummary(prev []*Block, block *Block) error {
+	height := len(prev)
+	boundary := height > 0 && height%epochLength == 0
+	if block.Epoch == nil {
+		if boundary && height >= 2*epochLength && prev[height-epochLength].Epoch != nil {
+			return &BlockValidationError{Index: height, Err: fmt.Errorf("%w: missing at epoch boundary", ErrEpochSummary)}
+		}
+		return nil
+	}
+	if !boundary {
+		return &BlockValidationError{Index: height, Err: fmt.Errorf("%w: block does not start an epoch", ErrEpochSummary)}
+	}
+
+	want := epochSummaryFor(prev)
+	var got, expected bytes.Buffer
+	serializeEpochSummary(&got, block.Epoch)
+	serializeEpochSummary(&expected, want)
+	if !bytes.Equal(got.Bytes(), expected.Bytes()) {
+		return &BlockValidationError{
+			Index: height,
+			Err:   fmt.Errorf("%w: does not match epoch %d (hash %x)", ErrEpochSummary, want.Epoch, want.Hash),
+		}
+	}
+	return nil
+}
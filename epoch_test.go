@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// mineEpochChain mines a chain of size blocks with epoch summaries.
+func mineEpochChain(t *testing.T, size int) []*Block {
+	t.Helper()
+	chain := []*Block{newGenesisBlock(sha256Hasher{})}
+	for i := 1; i < size; i++ {
+		block, _, err := mineNext(context.Background(), chain, fmt.Sprintf("Block %d", i), 0, MinerOptions{Workers: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		chain = append(chain, block)
+	}
+	return chain
+}
+
+// TestEpochSummaries checks that mined chains carry valid summaries at
+// every epoch boundary and that the summaries survive a protobuf round trip.
+func TestEpochSummaries(t *testing.T) {
+	chain := mineEpochChain(t, 2*epochLength+1)
+	for i, block := range chain {
+		if want := i > 0 && i%epochLength == 0; (block.Epoch != nil) != want {
+			t.Fatalf("block %d: has summary %v, want %v", i, block.Epoch != nil, want)
+		}
+	}
+	if e := chain[2*epochLength].Epoch; e.Epoch != 1 || e.StartTime != chain[epochLength].Timestamp {
+		t.Errorf("unexpected summary %+v", e)
+	}
+	if err := validateChain(chain, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := unmarshalChainProto(marshalChainProto(chain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded[epochLength].Epoch, chain[epochLength].Epoch) {
+		t.Error("epoch summary changed in protobuf round trip")
+	}
+	if err := validateChain(decoded, 0); err != nil {
+		t.Errorf("decoded chain: %v", err)
+	}
+}
+
+// TestEpochSummaryRejected covers forged, misplaced and missing summaries.
+func TestEpochSummaryRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(chain []*Block)
+	}{
+		{"forged", func(chain []*Block) {
+			chain[2*epochLength].Epoch.DataBytes++
+		}},
+		{"misplaced", func(chain []*Block) {
+			chain[2*epochLength].Epoch = nil
+			chain[2*epochLength-1].Epoch = epochSummaryFor(chain[:epochLength])
+		}},
+		{"missing", func(chain []*Block) {
+			chain[2*epochLength].Epoch = nil
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := mineEpochChain(t, 2*epochLength+1)
+			tt.tamper(chain)
+			// Rehash and relink from the first changed block so only the
+			// summary rule can fail
+			for i := 2*epochLength - 1; i < len(chain); i++ {
+				chain[i].PrevHash = chain[i-1].Hash
+				chain[i].Hash = calculateHash(chain[i])
+			}
+
+			err := validateChain(chain, 0)
+			if !errors.Is(err, ErrEpochSummary) {
+				t.Fatalf("expected ErrEpochSummary, got %v", err)
+			}
+			if report := validateChainReport(chain, 0); report.Valid() {
+				t.Error("report found no problems")
+			}
+		})
+	}
+}
+
+// TestEpochSummaryLegacyChain keeps chains mined before epochs valid.
+func TestEpochSummaryLegacyChain(t *testing.T) {
+	chain := makeBlockchain(epochLength+2, 0)
+	if err := validateChain(chain, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := blockFormatVersion(chain[epochLength]); got == 0x03 {
+		t.Errorf("block without a summary has format version %d", got)
+	}
+}
+
+// TestEpochSummaryValidators checks that summaries commit to the chain's
+// validator set from format version 6 on, and that a chain committing to
+// another set, or to none, is refused.
+func TestEpochSummaryValidators(t *testing.T) {
+	t.Cleanup(func() { chainValidatorsHash, formatActivations = nil, nil })
+	validators := []Validator{{PublicKey: hex.EncodeToString(make([]byte, 32)), Stake: 1}}
+	chainValidatorsHash = validatorSetHash(validators)
+
+	chain := mineEpochChain(t, epochLength+1)
+	summary := chain[epochLength].Epoch
+	if !bytes.Equal(summary.ValidatorsHash, chainValidatorsHash) || blockFormatVersion(chain[epochLength]) != blockFormatV6 {
+		t.Fatalf("summary %+v of format %d does not commit to the validators", summary, blockFormatVersion(chain[epochLength]))
+	}
+	if err := validateChain(chain, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := deserializeBlock(serializeBlock(chain[epochLength]))
+	if err != nil || !reflect.DeepEqual(decoded.Epoch, summary) {
+		t.Errorf("binary round trip: %+v, %v", decoded, err)
+	}
+	var fromProto, fromCBOR Block
+	if err := fromProto.UnmarshalProto(chain[epochLength].MarshalProto()); err != nil || !reflect.DeepEqual(fromProto.Epoch, summary) {
+		t.Errorf("protobuf round trip: %+v, %v", fromProto.Epoch, err)
+	}
+	encoded, _ := chain[epochLength].MarshalCBOR()
+	if err := fromCBOR.UnmarshalCBOR(encoded); err != nil || !reflect.DeepEqual(fromCBOR.Epoch, summary) {
+		t.Errorf("CBOR round trip: %+v, %v", fromCBOR.Epoch, err)
+	}
+
+	// Another validator set, as a chain forged for a long-range attack
+	// would name
+	validators[0].Stake = 2
+	chainValidatorsHash = validatorSetHash(validators)
+	if err := validateChain(chain, 0); !errors.Is(err, ErrEpochSummary) {
+		t.Errorf("summary of another validator set: got %v, want ErrEpochSummary", err)
+	}
+
+	stripped := *chain[epochLength]
+	stripped.Epoch = &EpochSummary{}
+	*stripped.Epoch = *summary
+	stripped.Epoch.ValidatorsHash = nil
+	stripped.Hash = calculateHash(&stripped)
+	if err := validateChain(append(chain[:epochLength:epochLength], &stripped), 0); !errors.Is(err, ErrEpochSummary) {
+		t.Errorf("summary without the validator set: got %v, want ErrEpochSummary", err)
+	}
+
+	// Before version 6 activates, summaries leave the set out
+	formatActivations = []FormatActivation{{Version: blockFormatV6, Height: 2 * epochLength}}
+	if err := validateChain(append(chain[:epochLength:epochLength], &stripped), 0); err != nil {
+		t.Errorf("summary without the validator set before version 6: %v", err)
+	}
+}
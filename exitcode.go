@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// Exit codes of the command-line interface. They are stable, so scripts
+// can branch on the class of a failure instead of parsing messages.
+const (
+	exitOK         = 0
+	exitFailure    = 1 // any failure not covered below
+	exitConfig     = 2 // invalid flags, arguments or settings
+	exitValidation = 3 // a chain or block failed validation
+	exitTimeout    = 4 // an operation ran out of time
+	exitStorage    = 5 // a chain file or data directory is missing, unreadable or corrupt
+)
+
+var exitClasses = map[int]string{
+	exitFailure:    "failure",
+	exitConfig:     "config",
+	exitValidation: "validation",
+	exitTimeout:    "timeout",
+	exitStorage:    "storage",
+}
+
+// errorJSON makes commands report failures as JSON on stderr. It is set by
+// the global -error-json flag.
+var errorJSON bool
+
+// ChainDecodeError reports a stored chain or checkpoint that could not be
+// decoded because it is truncated or malformed, or breaks a strict decode
+// policy.
+type ChainDecodeError struct {
+	Path string
+	Err  error
+}
+
+func (e *ChainDecodeError) Error() string {
+	return fmt.Sprintf("decode %s: %v", e.Path, e.Err)
+}
+
+func (e *ChainDecodeError) Unwrap() error {
+	return e.Err
+}
+
+// exitCodeFor classifies err into an exit code.
+func exitCodeFor(err error) int {
+	var blockErr *BlockValidationError
+	var mismatch *ImportMismatchError
+	var decodeErr *ChainDecodeError
+	var pathErr *fs.PathError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return exitTimeout
+	case errors.As(err, &blockErr), errors.As(err, &mismatch):
+		return exitValidation
+	case errors.As(err, &decodeErr), errors.As(err, &pathErr), errors.Is(err, fs.ErrNotExist):
+		return exitStorage
+	}
+	return exitFailure
+}
+
+// cliError is the -error-json form of a failure.
+type cliError struct {
+	Code    int    `json:"code"`
+	Class   string `json:"class"`
+	Message string `json:"message"`
+	// Block is the index of the offending block, for validation failures.
+	Block *int `json:"block,omitempty"`
+}
+
+// writeErrorJSON writes err as a single-line JSON object.
+func writeErrorJSON(w io.Writer, code int, err error) {
+	e := cliError{Code: code, Class: exitClasses[code], Message: err.Error()}
+	var blockErr *BlockValidationError
+	var mismatch *ImportMismatchError
+	switch {
+	case errors.As(err, &blockErr):
+		e.Block = &blockErr.Index
+	case errors.As(err, &mismatch):
+		e.Block = &mismatch.Index
+	}
+	data, _ := json.Marshal(e)
+	fmt.Fprintf(w, "%s\n", data)
+}
+
+// fail reports err, classified by exitCodeFor, and returns its exit code.
+func fail(err error) int {
+	return failCode(exitCodeFor(err), err)
+}
+
+// failCode reports err as a failure of the given class and returns code.
+// Failures are printed to stdout, or written as JSON to stderr with
+// -error-json.
+func failCode(code int, err error) int {
+	if errorJSON {
+		writeErrorJSON(os.Stderr, code, err)
+	} else {
+		fmt.Printf("Error: %v\n", err)
+	}
+	return code
+}
+
+// failf is failCode with a formatted message.
+func failf(code int, format string, args ...any) int {
+	return failCode(code, fmt.Errorf(format, args...))
+}
+
+// failReported returns the exit code for err, which the command has
+// already reported in its logs or output, writing it as JSON too with
+// -error-json.
+func failReported(err error) int {
+	code := exitCodeFor(err)
+	if errorJSON {
+		writeErrorJSON(os.Stderr, code, err)
+	}
+	return code
+}
+
+// usage prints a command's usage line for missing or conflicting flags
+// and returns exitConfig.
+func usage(line string) int {
+	if errorJSON {
+		writeErrorJSON(os.Stderr, exitConfig, errors.New(line))
+	} else {
+		fmt.Println(line)
+	}
+	return exitConfig
+}
+
+// flagError returns the exit code for a flag parse error, which the flag
+// package has already printed.
+func flagError(err error) int {
+	if errorJSON {
+		writeErrorJSON(os.Stderr, exitConfig, err)
+	}
+	return exitConfig
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExitCodeFor(t *testing.T) {
+	_, missing := os.Open(filepath.Join(t.TempDir(), "missing.json"))
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"timeout", fmt.Errorf("proof of work failed: %w", context.DeadlineExceeded), exitTimeout},
+		{"validation", &BlockValidationError{Index: 3, Err: ErrInsufficientWork}, exitValidation},
+		{"mismatch", &ImportMismatchError{Index: 1}, exitValidation},
+		{"joined report", errors.Join(&BlockValidationError{Index: 2, Err: ErrBrokenLink}), exitValidation},
+		{"missing file", missing, exitStorage},
+		{"corrupt file", &ChainDecodeError{Path: "chain.pb", Err: errProtoTruncated}, exitStorage},
+		{"other", errors.New("boom"), exitFailure},
+	}
+	for _, tt := range tests {
+		if got := exitCodeFor(tt.err); got != tt.want {
+			t.Errorf("%s: exit code %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteErrorJSON(t *testing.T) {
+	var out strings.Builder
+	writeErrorJSON(&out, exitValidation, &BlockValidationError{Index: 4, Err: ErrHashMismatch})
+	if strings.Count(out.String(), "\n") != 1 {
+		t.Errorf("error JSON is not a single line: %q", out.String())
+	}
+	var got cliError
+	if err := json.Unmarshal([]byte(out.String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Code != exitValidation || got.Class != "validation" || got.Block == nil || *got.Block != 4 {
+		t.Errorf("unexpected error JSON %s", out.String())
+	}
+}
+
+// TestRunErrorJSON checks the exit codes of the global -error-json mode,
+// whose output goes to stderr.
+func TestRunErrorJSON(t *testing.T) {
+	t.Cleanup(func() { errorJSON = false })
+
+	dir := t.TempDir()
+	corrupt := filepath.Join(dir, "chain.pb")
+	if err := os.WriteFile(corrupt, []byte{0x0a, 0x40}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code := run([]string{"--error-json", "validate", "-file", corrupt, "-difficulty", "1"}); code != exitStorage {
+		t.Errorf("corrupt chain: expected exit code %d, got %d", exitStorage, code)
+	}
+	if !errorJSON {
+		t.Error("--error-json was not applied")
+	}
+	if code := run([]string{"-error-json", "no-such-command"}); code != exitConfig {
+		t.Errorf("unknown command: expected exit code %d, got %d", exitConfig, code)
+	}
+	if code := run([]string{"-error-json", "daemon", "-datadir", dir, "-workers", "0"}); code != exitConfig {
+		t.Errorf("invalid workers: expected exit code %d, got %d", exitConfig, code)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// The explorer is a single page served from the node binary at /explorer/.
+// It lists the latest blocks, shows a block found by height or hash, and
+// follows the /ws feed for new ones. It reads the chain only through the
+// JSON-RPC API, so it sees what any client would, under the same keys and
+// limits.
+
+//go:embed explorer.html
+var explorerPage []byte
+
+// serveExplorer serves the explorer page.
+func (s *rpcServer) serveExplorer(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/explorer" {
+		// Keep the query, which may carry the API key
+		target := "/explorer/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+	if r.URL.Path != "/explorer/" {
+		writeRESTError(w, http.StatusNotFound, "the explorer is a single page at /explorer/")
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeRESTError(w, http.StatusMethodNotAllowed, "the explorer must be requested with GET")
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	// Block data is shown as text, never markup, and the page talks only
+	// to this node
+	h.Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.Write(explorerPage)
+}
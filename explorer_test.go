@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExplorer(t *testing.T) {
+	s := newRPCServer(makeBlockchain(2, 1), 1)
+	get := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	rec := get(http.MethodGet, "/explorer/")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("GET /explorer/: status %d, type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{"getblockcount", "/ws?topics=blocks"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("page does not use %s", want)
+		}
+	}
+	if csp := rec.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "connect-src 'self'") {
+		t.Errorf("Content-Security-Policy %q", csp)
+	}
+
+	if rec := get(http.MethodGet, "/explorer?apikey=k"); rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/explorer/?apikey=k" {
+		t.Errorf("GET /explorer: status %d, location %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := get(http.MethodPost, "/explorer/"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /explorer/: status %d", rec.Code)
+	}
+	if rec := get(http.MethodGet, "/explorer/app.js"); rec.Code != http.StatusNotFound {
+		t.Errorf("GET /explorer/app.js: status %d", rec.Code)
+	}
+
+	// With tenants the page needs a key, like the API it calls
+	s.quotas = newQuotaTracker([]Tenant{{Name: "viewer", Key: "viewer-key", Role: roleReadOnly}}, nil)
+	if rec := get(http.MethodGet, "/explorer/"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET /explorer/ without a key: status %d", rec.Code)
+	}
+	if rec := get(http.MethodGet, "/explorer/?apikey=viewer-key"); rec.Code != http.StatusOK {
+		t.Errorf("GET /explorer/ with a key: status %d", rec.Code)
+	}
+}
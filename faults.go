@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Faults are failures injected on purpose into the node's storage writes
+// and peer requests, so that tests can exercise crash recovery and sync
+// retries. Chain files, segment records and requests to peers go through
+// injectedFaults, which is nil, and injects nothing, except in tests and
+// in binaries built with -tags chaos, whose daemon takes a -chaos flag.
+
+// ErrInjectedFault is returned by writes and requests a Faults failed.
+var ErrInjectedFault = errors.New("injected fault")
+
+// injectedFaults applies to every storage write and peer request.
+var injectedFaults *Faults
+
+// Faults decides which writes and requests fail. Probabilities are from 0
+// to 1. A nil *Faults injects nothing. It is safe for concurrent use.
+type Faults struct {
+	WriteError float64       // a write fails before writing anything
+	TornWrite  float64       // a write stops partway, as a crash leaves it
+	Drop       float64       // a peer request is lost
+	Delay      time.Duration // added to every peer request
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewFaults returns a Faults drawing from a generator seeded with seed,
+// so that a run can be repeated.
+func NewFaults(seed uint64) *Faults {
+	return &Faults{rand: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// parseFaults reads a fault spec such as "write=0.01,torn=0.01,drop=0.1,
+// delay=50ms,seed=7".
+func parseFaults(spec string) (*Faults, error) {
+	f := NewFaults(1)
+	for _, field := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, fmt.Errorf("fault %q: want key=value", field)
+		}
+		var err error
+		switch key {
+		case "write":
+			f.WriteError, err = parseChance(value)
+		case "torn":
+			f.TornWrite, err = parseChance(value)
+		case "drop":
+			f.Drop, err = parseChance(value)
+		case "delay":
+			f.Delay, err = time.ParseDuration(value)
+			if err == nil && f.Delay < 0 {
+				err = errors.New("must not be negative")
+			}
+		case "seed":
+			var seed uint64
+			if seed, err = strconv.ParseUint(value, 10, 64); err == nil {
+				f.rand = rand.New(rand.NewPCG(seed, seed))
+			}
+		default:
+			return nil, fmt.Errorf("unknown fault %q: want write, torn, drop, delay or seed", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("fault %s: %w", key, err)
+		}
+	}
+	return f, nil
+}
+
+func parseChance(s string) (float64, error) {
+	p, err := strconv.ParseFloat(s, 64)
+	if err == nil && (p < 0 || p > 1) {
+		err = errors.New("must be between 0 and 1")
+	}
+	return p, err
+}
+
+// hit reports whether an event of probability p happens.
+func (f *Faults) hit(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rand == nil {
+		f.rand = rand.New(rand.NewPCG(1, 1))
+	}
+	return f.rand.Float64() < p
+}
+
+// Writer returns w with faults injected into its writes, or w itself for
+// a nil f.
+func (f *Faults) Writer(w io.Writer) io.Writer {
+	if f == nil {
+		return w
+	}
+	return &faultWriter{w: w, f: f}
+}
+
+type faultWriter struct {
+	w io.Writer
+	f *Faults
+}
+
+func (fw *faultWriter) Write(p []byte) (int, error) {
+	if fw.f.hit(fw.f.WriteError) {
+		return 0, fmt.Errorf("write: %w", ErrInjectedFault)
+	}
+	if len(p) > 1 && fw.f.hit(fw.f.TornWrite) {
+		n, err := fw.w.Write(p[:len(p)/2])
+		if err == nil {
+			err = fmt.Errorf("torn write: %w", ErrInjectedFault)
+		}
+		return n, err
+	}
+	return fw.w.Write(p)
+}
+
+// Transport returns next, or http.DefaultTransport when next is nil, with
+// faults injected into its requests. For a nil f it returns next.
+func (f *Faults) Transport(next http.RoundTripper) http.RoundTripper {
+	if f == nil {
+		return next
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &faultTransport{next: next, f: f}
+}
+
+type faultTransport struct {
+	next http.RoundTripper
+	f    *Faults
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.f.Delay > 0 {
+		timer := time.NewTimer(t.f.Delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+	if t.f.hit(t.f.Drop) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("%s %s dropped: %w", req.Method, req.URL, ErrInjectedFault)
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,23 @@
+//go:build chaos
+
+package main
+
+import "flag"
+
+// addChaosFlag registers -chaos, which injects faults into the daemon's
+// storage writes and peer requests. The returned function applies it
+// once the flags are parsed.
+func addChaosFlag(fs *flag.FlagSet) func() error {
+	spec := fs.String("chaos", "", "faults to inject, e.g. write=0.01,torn=0.01,drop=0.1,delay=50ms,seed=7")
+	return func() error {
+		if *spec == "" {
+			return nil
+		}
+		f, err := parseFaults(*spec)
+		if err != nil {
+			return err
+		}
+		injectedFaults = f
+		return nil
+	}
+}
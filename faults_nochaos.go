@@ -0,0 +1,11 @@
+//go:build !chaos
+
+package main
+
+import "flag"
+
+// addChaosFlag registers nothing: only binaries built with -tags chaos
+// can inject faults.
+func addChaosFlag(*flag.FlagSet) func() error {
+	return func() error { return nil }
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// injectFaults sets injectedFaults for the rest of the test.
+func injectFaults(t *testing.T, f *Faults) {
+	t.Helper()
+	injectedFaults = f
+	t.Cleanup(func() { injectedFaults = nil })
+}
+
+func TestParseFaults(t *testing.T) {
+	f, err := parseFaults("write=0.5, torn=0.25,drop=1,delay=50ms,seed=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.WriteError != 0.5 || f.TornWrite != 0.25 || f.Drop != 1 || f.Delay != 50*time.Millisecond {
+		t.Errorf("parsed %+v", f)
+	}
+	for _, bad := range []string{"write", "write=2", "drop=-0.1", "delay=-1s", "seed=x", "flood=1"} {
+		if _, err := parseFaults(bad); err == nil {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+
+	// The same seed fails the same writes
+	a, b := NewFaults(3), NewFaults(3)
+	a.WriteError, b.WriteError = 0.5, 0.5
+	for i := range 100 {
+		if a.hit(a.WriteError) != b.hit(b.WriteError) {
+			t.Fatalf("draw %d differs between generators with one seed", i)
+		}
+	}
+}
+
+// TestFaultsSegmentStore checks that a torn record leaves the store
+// usable: the blocks appended after it read back, before and after a
+// reopen.
+func TestFaultsSegmentStore(t *testing.T) {
+	dir := t.TempDir()
+	chain := makeBlockchain(4, 1)
+	s, err := openSegmentStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { s.Close() }()
+	if err := s.Append(chain[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	torn := NewFaults(1)
+	torn.TornWrite = 1
+	injectFaults(t, torn)
+	if err := s.Append(chain[1]); !errors.Is(err, ErrInjectedFault) {
+		t.Fatalf("torn append: %v", err)
+	}
+	injectedFaults = nil
+	for _, block := range chain[1:] {
+		if err := s.Append(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(when string) {
+		for _, want := range chain {
+			got, err := s.Block(want.Index)
+			if err != nil || !bytes.Equal(got.Hash, want.Hash) {
+				t.Errorf("%s: block %d: %v", when, want.Index, err)
+			}
+		}
+	}
+	check("after the torn write")
+	s.Close()
+	if s, err = openSegmentStore(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	check("after reopening")
+}
+
+// TestFaultsSaveStore checks that a save failing partway leaves the
+// previous store, which still loads and validates.
+func TestFaultsSaveStore(t *testing.T) {
+	dataDir := t.TempDir()
+	chain := makeBlockchain(4, 1)
+	if err := saveStore(dataDir, chain[:3]); err != nil {
+		t.Fatal(err)
+	}
+	for name, f := range map[string]*Faults{
+		"write error": {WriteError: 1},
+		"torn write":  {TornWrite: 1},
+	} {
+		injectFaults(t, f)
+		if err := saveStore(dataDir, chain); !errors.Is(err, ErrInjectedFault) {
+			t.Fatalf("%s: save returned %v", name, err)
+		}
+		injectedFaults = nil
+		loaded, report, err := loadStoredChain(dataDir, &PoWEngine{Difficulty: 1}, true)
+		if err != nil || !report.Valid() || len(loaded) != 3 {
+			t.Errorf("%s: store has %d blocks, %v, %v", name, len(loaded), report.Err(), err)
+		}
+	}
+}
+
+// TestFaultsSync checks that a light client syncs from the peers it can
+// reach when requests to another are lost, and catches up from that one
+// once they get through.
+func TestFaultsSync(t *testing.T) {
+	chain := makeBlockchain(5, 1)
+	node := httptest.NewServer(newRPCServer(chain, 1))
+	defer node.Close()
+
+	lossy := NewFaults(1)
+	lossy.Drop = 1
+	injectFaults(t, lossy)
+	dropping := newRPCClient(node.URL)
+	injectedFaults = nil
+	reliable := newRPCClient(node.URL)
+
+	client := NewLightClient(chain[0].Header(), 1)
+	if err := client.Sync(context.Background(), []*rpcClient{dropping}); !errors.Is(err, ErrInjectedFault) {
+		t.Fatalf("sync through a dropping transport: %v", err)
+	}
+	if tip := client.Tip(); tip.Index != 0 {
+		t.Fatalf("tip %d after every request was lost", tip.Index)
+	}
+	err := client.Sync(context.Background(), []*rpcClient{dropping, reliable})
+	if !errors.Is(err, ErrInjectedFault) {
+		t.Errorf("the dropping peer's failure was not reported: %v", err)
+	}
+	if tip := client.Tip(); !bytes.Equal(tip.Hash, chain[4].Hash) {
+		t.Errorf("tip %d, want the reachable peer's %d", tip.Index, chain[4].Index)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Experimental subsystems ship dark: every binary has them, but a node
+// runs one only once it enables its feature, in the "features" of its
+// params file or with -features. featureRegistry lists them with the
+// features each cannot run with, so that a node with a combination that
+// does not work refuses to start instead of failing later.
+
+// Feature names.
+const (
+	FeaturePoS     = "pos"
+	FeatureGRPC    = "grpc"
+	FeatureStratum = "stratum"
+)
+
+// Feature is an experimental subsystem a node may enable.
+type Feature struct {
+	Name        string
+	Description string
+	// Conflicts are the features that cannot be enabled with this one
+	Conflicts []string
+}
+
+// featureRegistry lists every feature a node may enable.
+var featureRegistry = []Feature{
+	{Name: FeaturePoS, Description: `proof-of-stake consensus, for chains with "consensus": "pos"`, Conflicts: []string{FeatureStratum}},
+	{Name: FeatureGRPC, Description: "the gRPC BlockchainService, served with -grpc-addr"},
+	{Name: FeatureStratum, Description: "block templates for external miners, served with -stratum-addr", Conflicts: []string{FeaturePoS}},
+}
+
+// enabledFeatures are the features enabled in this process, set from its
+// chain parameters and -features like formatActivations.
+var enabledFeatures []string
+
+// featureEnabled reports whether the feature name is enabled.
+func featureEnabled(name string) bool {
+	return slices.Contains(enabledFeatures, name)
+}
+
+// needFeature fails unless the feature name is enabled; what is the
+// setting that needs it.
+func needFeature(name, what string) error {
+	if featureEnabled(name) {
+		return nil
+	}
+	return fmt.Errorf("%s needs the experimental %s feature (enable it with -features %s)", what, name, name)
+}
+
+// checkFeatures checks that names are registered features, each named
+// once, and that none of them conflicts with another.
+func checkFeatures(names []string) error {
+	for _, name := range names {
+		i := slices.IndexFunc(featureRegistry, func(f Feature) bool { return f.Name == name })
+		if i < 0 {
+			known := make([]string, len(featureRegistry))
+			for j, f := range featureRegistry {
+				known[j] = f.Name
+			}
+			return fmt.Errorf("unknown feature %q (want one of %s)", name, strings.Join(known, ", "))
+		}
+		for _, other := range featureRegistry[i].Conflicts {
+			if slices.Contains(names, other) {
+				return fmt.Errorf("features %s and %s cannot be enabled together", name, other)
+			}
+		}
+	}
+	for i, name := range names {
+		if slices.Contains(names[:i], name) {
+			return fmt.Errorf("feature %q is listed twice", name)
+		}
+	}
+	return nil
+}
+
+// parseFeatures splits a comma-separated -features list.
+func parseFeatures(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// serveStatus answers GET /status with the node's height, tip and
+// enabled features.
+func (s *rpcServer) serveStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeRESTError(w, http.StatusMethodNotAllowed, "the status must be requested with GET")
+		return
+	}
+	tip := s.tip()
+	features := append([]string{}, enabledFeatures...)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"blocks":        tip.Index,
+		"bestblockhash": hex.EncodeToString(tip.Hash),
+		"features":      features,
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// TestCheckFeatures checks that unknown, repeated and conflicting
+// features are refused.
+func TestCheckFeatures(t *testing.T) {
+	for _, names := range [][]string{nil, {FeaturePoS}, {FeatureGRPC, FeatureStratum}, {FeaturePoS, FeatureGRPC}} {
+		if err := checkFeatures(names); err != nil {
+			t.Errorf("%v: %v", names, err)
+		}
+	}
+	for _, names := range [][]string{{"graphql"}, {FeatureGRPC, FeatureGRPC}, {FeaturePoS, FeatureStratum}, {FeatureStratum, FeatureGRPC, FeaturePoS}} {
+		if err := checkFeatures(names); err == nil {
+			t.Errorf("%v: accepted", names)
+		}
+	}
+}
+
+// TestFeatureFlags checks that features are enabled from the params file
+// and -features, that a proof-of-stake chain needs the pos feature, and
+// that the daemon refuses the addresses of disabled features.
+func TestFeatureFlags(t *testing.T) {
+	t.Cleanup(func() { enabledFeatures = nil })
+	params, _, _ := newTestStake(t)
+	resolve := func(p *ChainParams, args ...string) error {
+		t.Helper()
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		network, paramsPath := addChainFlags(fs)
+		if err := fs.Parse(append([]string{"-params", writeStakeParams(t, p)}, args...)); err != nil {
+			t.Fatal(err)
+		}
+		_, err := chainParamsFlags(fs, *network, *paramsPath)
+		return err
+	}
+
+	if err := resolve(params, "-features", "grpc,pos"); err != nil {
+		t.Fatal(err)
+	}
+	if !featureEnabled(FeaturePoS) || !featureEnabled(FeatureGRPC) || featureEnabled(FeatureStratum) {
+		t.Errorf("enabled features %v, want pos and grpc", enabledFeatures)
+	}
+	if err := resolve(params, "-features", "stratum"); err == nil {
+		t.Error("stratum enabled on a proof-of-stake chain")
+	}
+	if err := resolve(params, "-features", "graphql"); err == nil {
+		t.Error("unknown feature enabled")
+	}
+	dark := *params
+	dark.Features = nil
+	if err := resolve(&dark); err == nil {
+		t.Error("proof-of-stake chain accepted without the pos feature")
+	}
+	if err := resolve(&dark, "-features", "pos"); err != nil {
+		t.Errorf("pos enabled with -features: %v", err)
+	}
+
+	for _, args := range [][]string{
+		{"-network", "regtest", "-grpc-addr", "127.0.0.1:0"},
+		{"-network", "regtest", "-stratum-addr", "127.0.0.1:0"},
+	} {
+		if code := runDaemon(append([]string{"-datadir", t.TempDir()}, args...)); code != exitConfig {
+			t.Errorf("%v without its feature: exit code %d, want %d", args, code, exitConfig)
+		}
+	}
+}
+
+// TestServeStatus checks that /status reports the tip and the enabled
+// features.
+func TestServeStatus(t *testing.T) {
+	t.Cleanup(func() { enabledFeatures = nil })
+	chain := makeBlockchain(3, 1)
+	s := newRPCServer(chain, 1)
+
+	status := func() (got struct {
+		Blocks        int      `json:"blocks"`
+		BestBlockHash string   `json:"bestblockhash"`
+		Features      []string `json:"features"`
+	}) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /status: %d %s", rec.Code, rec.Body)
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	enabledFeatures = nil
+	if got := status(); got.Blocks != 2 || got.BestBlockHash != hex.EncodeToString(chain[2].Hash) || got.Features == nil || len(got.Features) != 0 {
+		t.Errorf("status %+v, want height 2 and no features", got)
+	}
+	enabledFeatures = []string{FeatureGRPC}
+	if got := status(); !slices.Equal(got.Features, enabledFeatures) {
+		t.Errorf("features %v, want %v", got.Features, enabledFeatures)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/status", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /status: %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
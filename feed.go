@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// feedOffsetName is the file in a data directory recording how far the
+	// block feed has been published.
+	feedOffsetName = "feed-offset.json"
+	// feedReorgDepth is the number of published blocks the feed remembers
+	// to detect reorgs.
+	feedReorgDepth = 100
+	// feedRetryInterval is how long the feed waits after a failed publish.
+	feedRetryInterval = 5 * time.Second
+	// feedTimeout bounds one publish, including the broker's acknowledgement.
+	feedTimeout = 10 * time.Second
+)
+
+// Feed serialization formats.
+const (
+	feedJSON     = "json"
+	feedProtobuf = "protobuf"
+)
+
+// feedSink is a broker topic the block feed publishes to. publish returns
+// once the broker has acknowledged the message.
+type feedSink interface {
+	publish(ctx context.Context, key string, payload []byte) error
+	Close() error
+}
+
+// newFeedSink returns the sink for a feed URL: nats://host:port/subject for
+// NATS, or kafka://host:port/topic for Kafka through its REST Proxy.
+func newFeedSink(rawURL string) (feedSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid feed URL: %w", err)
+	}
+	topic := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || topic == "" || strings.Contains(topic, "/") {
+		return nil, fmt.Errorf("feed URL %q must be scheme://host:port/topic", rawURL)
+	}
+	switch u.Scheme {
+	case "nats":
+		return &natsSink{addr: u.Host, subject: topic}, nil
+	case "kafka":
+		return &kafkaRESTSink{
+			url:    (&url.URL{Scheme: "http", Host: u.Host, Path: "/topics/" + topic}).String(),
+			client: &http.Client{Timeout: feedTimeout},
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported feed URL %q (want nats:// or kafka://)", rawURL)
+}
+
+// natsSink publishes to a NATS subject over the client protocol. Each
+// message is followed by a PING; the server's PONG confirms that it
+// processed the message.
+type natsSink struct {
+	addr    string
+	subject string
+	conn    net.Conn
+	r       *bufio.Reader
+}
+
+func (s *natsSink) dial(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err == nil && !strings.HasPrefix(line, "INFO ") {
+		err = fmt.Errorf("nats: unexpected greeting %q", strings.TrimSpace(line))
+	}
+	if err == nil {
+		_, err = io.WriteString(conn, `CONNECT {"verbose":false,"pedantic":false,"name":"blockchain-feed"}`+"\r\n")
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	s.conn, s.r = conn, r
+	return nil
+}
+
+func (s *natsSink) publish(ctx context.Context, key string, payload []byte) error {
+	if s.conn == nil {
+		if err := s.dial(ctx); err != nil {
+			return err
+		}
+	}
+	err := s.roundTrip(ctx, payload)
+	if err != nil {
+		// Start over on a fresh connection; the message may be sent twice
+		s.Close()
+	}
+	return err
+}
+
+func (s *natsSink) roundTrip(ctx context.Context, payload []byte) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(feedTimeout)
+	}
+	s.conn.SetDeadline(deadline)
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "PUB %s %d\r\n", s.subject, len(payload))
+	msg.Write(payload)
+	msg.WriteString("\r\nPING\r\n")
+	if _, err := s.conn.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := io.WriteString(s.conn, "PONG\r\n"); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		// +OK and INFO updates need no answer
+	}
+}
+
+func (s *natsSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn, s.r = nil, nil
+	return err
+}
+
+// kafkaRESTSink produces to a Kafka topic through the Confluent REST Proxy
+// (API v2), which replies once the brokers have acknowledged the record.
+type kafkaRESTSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *kafkaRESTSink) publish(ctx context.Context, key string, payload []byte) error {
+	body, err := json.Marshal(map[string]any{
+		"records": []map[string]string{{
+			"key":   base64.StdEncoding.EncodeToString([]byte(key)),
+			"value": base64.StdEncoding.EncodeToString(payload),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.binary.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		Offsets []struct {
+			Error string `json:"error"`
+		} `json:"offsets"`
+		Message string `json:"message"`
+	}
+	json.NewDecoder(resp.Body).Decode(&reply)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kafka rest proxy returned %s: %s", resp.Status, reply.Message)
+	}
+	if len(reply.Offsets) != 1 {
+		return fmt.Errorf("kafka rest proxy acknowledged %d records, want 1", len(reply.Offsets))
+	}
+	if reply.Offsets[0].Error != "" {
+		return fmt.Errorf("kafka: %s", reply.Offsets[0].Error)
+	}
+	return nil
+}
+
+func (s *kafkaRESTSink) Close() error { return nil }
+
+// feedMark identifies a published block.
+type feedMark struct {
+	Height int    `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+// feedOffset records the progress of the feed, so a restarted daemon
+// resumes after the last acknowledged message instead of starting over.
+type feedOffset struct {
+	// Next is the height of the next block to publish
+	Next int `json:"next"`
+	// Recent holds the published blocks still on the chain, oldest first
+	Recent []feedMark `json:"recent"`
+}
+
+func feedOffsetPath(dataDir string) string {
+	return filepath.Join(dataDir, feedOffsetName)
+}
+
+// readFeedOffset loads the feed offset in dataDir, or returns nil if there
+// is none.
+func readFeedOffset(dataDir string) (*feedOffset, error) {
+	data, err := os.ReadFile(feedOffsetPath(dataDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	off := new(feedOffset)
+	if err := json.Unmarshal(data, off); err != nil {
+		return nil, &ChainDecodeError{Path: feedOffsetPath(dataDir), Err: err}
+	}
+	return off, nil
+}
+
+// writeFeedOffset stores off in dataDir.
+func writeFeedOffset(dataDir string, off *feedOffset) error {
+	data, err := json.Marshal(off)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dataDir, "tmp-"+feedOffsetName)
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, feedOffsetPath(dataDir))
+}
+
+// feedMessage is a JSON feed message: a block appended to the chain, or,
+// with Removed set, a reorg notice for a published block that left it.
+type feedMessage struct {
+	Height  int       `json:"height"`
+	Hash    string    `json:"hash"`
+	Removed bool      `json:"removed,omitempty"`
+	Block   *rpcBlock `json:"block,omitempty"`
+}
+
+// marshalFeedProto encodes a FeedMessage as defined in
+// proto/blockchain.proto. block is nil for reorg notices.
+func marshalFeedProto(height int, hash []byte, removed bool, block *Block) []byte {
+	var out []byte
+	out = appendProtoVarint(out, 1, uint64(height))
+	out = appendProtoBytes(out, 2, hash)
+	if removed {
+		out = appendProtoVarint(out, 3, 1)
+	}
+	if block != nil {
+		msg := block.MarshalProto()
+		out = appendProtoTag(out, 4, protoBytes)
+		out = binary.AppendUvarint(out, uint64(len(msg)))
+		out = append(out, msg...)
+	}
+	return out
+}
+
+// feedPublisher publishes the blocks of a chain to a sink in order, with
+// at-least-once delivery: the offset is saved only after the broker
+// acknowledges a message, so a message is sent again if the daemon stops
+// in between. Consumers should deduplicate on height and hash.
+type feedPublisher struct {
+	sink    feedSink
+	format  string
+	dataDir string
+	offset  feedOffset
+}
+
+// newFeedPublisher resumes from the offset stored in dataDir, if any.
+func newFeedPublisher(sink feedSink, format, dataDir string) (*feedPublisher, error) {
+	if format != feedJSON && format != feedProtobuf {
+		return nil, fmt.Errorf("unknown feed format %q (want %s or %s)", format, feedJSON, feedProtobuf)
+	}
+	p := &feedPublisher{sink: sink, format: format, dataDir: dataDir}
+	off, err := readFeedOffset(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if off != nil {
+		p.offset = *off
+	}
+	return p, nil
+}
+
+// poll publishes the blocks of chain not published yet. Published blocks
+// that chain no longer contains are first announced as removed, newest
+// first, so consumers see the new branch from the fork point.
+func (p *feedPublisher) poll(ctx context.Context, chain []*Block) error {
+	for len(p.offset.Recent) > 0 {
+		last := p.offset.Recent[len(p.offset.Recent)-1]
+		if last.Height < len(chain) && hex.EncodeToString(chain[last.Height].Hash) == last.Hash {
+			break
+		}
+		hash, _ := hex.DecodeString(last.Hash)
+		if err := p.send(ctx, last.Height, hash, true, nil, chain); err != nil {
+			return err
+		}
+		p.offset.Recent = p.offset.Recent[:len(p.offset.Recent)-1]
+		p.offset.Next = last.Height
+		if err := writeFeedOffset(p.dataDir, &p.offset); err != nil {
+			return err
+		}
+	}
+	// A chain that shrank below a resumed offset deeper than Recent
+	p.offset.Next = min(p.offset.Next, len(chain))
+
+	for p.offset.Next < len(chain) {
+		block := chain[p.offset.Next]
+		if err := p.send(ctx, block.Index, block.Hash, false, block, chain); err != nil {
+			return err
+		}
+		p.offset.Next++
+		p.offset.Recent = append(p.offset.Recent, feedMark{Height: block.Index, Hash: hex.EncodeToString(block.Hash)})
+		if len(p.offset.Recent) > feedReorgDepth {
+			p.offset.Recent = append(p.offset.Recent[:0], p.offset.Recent[1:]...)
+		}
+		if err := writeFeedOffset(p.dataDir, &p.offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *feedPublisher) send(ctx context.Context, height int, hash []byte, removed bool, block *Block, chain []*Block) error {
+	var payload []byte
+	if p.format == feedProtobuf {
+		payload = marshalFeedProto(height, hash, removed, block)
+	} else {
+		msg := feedMessage{Height: height, Hash: hex.EncodeToString(hash), Removed: removed}
+		if block != nil {
+			view := blockView(chain, block)
+			msg.Block = &view
+		}
+		var err error
+		if payload, err = json.Marshal(msg); err != nil {
+			return err
+		}
+	}
+	pubCtx, cancel := context.WithTimeout(ctx, feedTimeout)
+	defer cancel()
+	// Keying by genesis keeps a chain's messages on one Kafka partition,
+	// in order
+	return p.sink.publish(pubCtx, hex.EncodeToString(chain[0].Hash), payload)
+}
+
+// runFeed publishes the server's blocks as they are appended until ctx is
+// cancelled. After a failure it retries from the last acknowledged
+// message every feedRetryInterval.
+func runFeed(ctx context.Context, server *rpcServer, p *feedPublisher) {
+	defer p.sink.Close()
+	for {
+		chain, changed := server.work()
+		var retry <-chan time.Time
+		if err := p.poll(ctx, chain); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			server.logger.Warn("feed_publish_failed", slog.Int("height", p.offset.Next), slog.Any("error", err))
+			changed, retry = nil, time.After(feedRetryInterval)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		case <-retry:
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeNATS is a NATS server that records the payloads published to it.
+type fakeNATS struct {
+	ln   net.Listener
+	mu   sync.Mutex
+	msgs []string
+}
+
+func newFakeNATS(t *testing.T) *fakeNATS {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &fakeNATS{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeNATS) serve(conn net.Conn) {
+	defer conn.Close()
+	io.WriteString(conn, `INFO {"server_id":"fake"}`+"\r\n")
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		var subject string
+		var n int
+		switch {
+		case strings.HasPrefix(line, "PUB "):
+			fmt.Sscanf(line, "PUB %s %d", &subject, &n)
+			payload := make([]byte, n+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.msgs = append(s.msgs, string(payload[:n]))
+			s.mu.Unlock()
+		case line == "PING\r\n":
+			io.WriteString(conn, "PONG\r\n")
+		}
+	}
+}
+
+func (s *fakeNATS) take() []feedMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []feedMessage
+	for _, m := range s.msgs {
+		var msg feedMessage
+		json.Unmarshal([]byte(m), &msg)
+		out = append(out, msg)
+	}
+	s.msgs = nil
+	return out
+}
+
+func TestFeedNATS(t *testing.T) {
+	nats := newFakeNATS(t)
+	dir := t.TempDir()
+	sink, err := newFeedSink("nats://" + nats.ln.Addr().String() + "/blocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	pub, err := newFeedPublisher(sink, feedJSON, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	chain := makeBlockchain(3, 1)
+	if err := pub.poll(ctx, chain); err != nil {
+		t.Fatal(err)
+	}
+	msgs := nats.take()
+	if len(msgs) != 3 || msgs[2].Height != 2 || msgs[2].Block == nil || msgs[2].Block.Hash != msgs[2].Hash {
+		t.Fatalf("published %+v", msgs)
+	}
+
+	// A branch from block 1 replaces block 2
+	fork := extendChain(t, chain[:2], 2, "fork")
+	if err := pub.poll(ctx, fork); err != nil {
+		t.Fatal(err)
+	}
+	msgs = nats.take()
+	var got []string
+	for _, m := range msgs {
+		got = append(got, fmt.Sprintf("%d %v", m.Height, m.Removed))
+	}
+	if want := []string{"2 true", "2 false", "3 false"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after the reorg published %v, want %v", got, want)
+	}
+
+	// A restarted publisher resumes from the stored offset
+	resumed, err := newFeedPublisher(sink, feedJSON, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resumed.poll(ctx, extendChain(t, fork, 1, "more")); err != nil {
+		t.Fatal(err)
+	}
+	if msgs := nats.take(); len(msgs) != 1 || msgs[0].Height != 4 {
+		t.Errorf("resumed publisher sent %+v, want only block 4", msgs)
+	}
+}
+
+func TestFeedKafkaREST(t *testing.T) {
+	var mu sync.Mutex
+	var values [][]byte
+	fail := false
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/topics/blocks" || r.Header.Get("Content-Type") != "application/vnd.kafka.binary.v2+json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var body struct {
+			Records []struct{ Key, Value string } `json:"records"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			fmt.Fprint(w, `{"offsets":[{"partition":null,"offset":null,"error_code":50003,"error":"broker unavailable"}]}`)
+			return
+		}
+		value, _ := base64.StdEncoding.DecodeString(body.Records[0].Value)
+		values = append(values, value)
+		fmt.Fprintf(w, `{"offsets":[{"partition":0,"offset":%d}]}`, len(values)-1)
+	}))
+	defer proxy.Close()
+
+	sink, err := newFeedSink("kafka://" + strings.TrimPrefix(proxy.URL, "http://") + "/blocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := newFeedPublisher(sink, feedProtobuf, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := makeBlockchain(2, 1)
+	if err := pub.poll(context.Background(), chain); err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 {
+		t.Fatalf("produced %d records, want 2", len(values))
+	}
+	var block Block
+	walkProto(values[1], func(field int, wireType int, v uint64, raw []byte) error {
+		if field == 4 {
+			return block.UnmarshalProto(raw)
+		}
+		return nil
+	})
+	if !reflect.DeepEqual(&block, chain[1]) {
+		t.Errorf("produced block %+v, want %+v", block, chain[1])
+	}
+
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	chain = extendChain(t, chain, 1, "next")
+	if err := pub.poll(context.Background(), chain); err == nil {
+		t.Fatal("poll succeeded although the broker failed")
+	}
+	if pub.offset.Next != 2 {
+		t.Errorf("offset advanced to %d past an unacknowledged message", pub.offset.Next)
+	}
+}
+
+func TestNewFeedSink(t *testing.T) {
+	for _, u := range []string{"nats://host:4222", "kafka://host:8082/a/b", "amqp://host/q", "nats:///blocks"} {
+		if _, err := newFeedSink(u); err == nil {
+			t.Errorf("newFeedSink(%q) succeeded", u)
+		}
+	}
+}
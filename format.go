@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrFormatInactive is reported for a block whose format version is not
+// yet active at its height.
+var ErrFormatInactive = errors.New("block format not active")
+
+// FormatActivation makes a block format version valid from a height on.
+// Below it, blocks of that version or any later one are invalid. This is
+// how a chain introduces a new header field: the version adding it gets
+// an activation height in the chain parameters, and nodes build blocks
+// with the field only once the chain reaches that height. Blocks mined
+// before it keep the older version, so old chains stay valid.
+type FormatActivation struct {
+	Version byte `json:"version"`
+	Height  int  `json:"height"`
+}
+
+// formatActivations are those of the chain this process works on, set
+// from its chain parameters like blockLimits. Versions without one are
+// active at every height.
+var formatActivations []FormatActivation
+
+// formatActive reports whether blocks of the given format version are
+// valid at height.
+func formatActive(version byte, height int) bool {
+	for _, a := range formatActivations {
+		if a.Version <= version && height < a.Height {
+			return false
+		}
+	}
+	return true
+}
+
+// checkBlockFormat reports an ErrFormatInactive if the block's format
+// version is not active at its height.
+func checkBlockFormat(block *Block) error {
+	if version := blockFormatVersion(block); !formatActive(version, block.Index) {
+		return fmt.Errorf("%w: version %d at height %d", ErrFormatInactive, version, block.Index)
+	}
+	return nil
+}
+
+// checkFormatActivations checks the activations of chain parameters.
+// Version 1 is active from genesis on every chain, and a version cannot
+// activate before an older one, since it has the older one's fields.
+func checkFormatActivations(activations []FormatActivation) error {
+	seen := make(map[byte]int)
+	for _, a := range activations {
+		if a.Version <= blockFormatV1 || a.Version > latestBlockFormat {
+			return fmt.Errorf("format_activations: version %d can not be activated (want %d to %d)", a.Version, blockFormatV1+1, latestBlockFormat)
+		}
+		if a.Height < 1 {
+			return fmt.Errorf("format_activations: version %d activates at height %d, want at least 1", a.Version, a.Height)
+		}
+		if _, dup := seen[a.Version]; dup {
+			return fmt.Errorf("format_activations: version %d is listed twice", a.Version)
+		}
+		seen[a.Version] = a.Height
+	}
+	for _, a := range activations {
+		for v, h := range seen {
+			if v < a.Version && h > a.Height {
+				return fmt.Errorf("format_activations: version %d activates at %d, before version %d at %d", a.Version, a.Height, v, h)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestFormatActivation activates Merkle roots at height 3 and bits at
+// height 2: blocks below are built and accepted without them, and a block
+// using a version early is refused.
+func TestFormatActivation(t *testing.T) {
+	t.Cleanup(func() { formatActivations = nil })
+	formatActivations = []FormatActivation{{Version: blockFormatV2, Height: 2}, {Version: blockFormatV4, Height: 3}}
+
+	chain := makeBlockchain(5, 1)
+	want := []byte{blockFormatV1, blockFormatV1, blockFormatV2, blockFormatV4, blockFormatV4}
+	var got []byte
+	for _, block := range chain {
+		got = append(got, blockFormatVersion(block))
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("format versions %v, want %v", got, want)
+	}
+	if err := validateChain(chain, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	early := newCandidateBlock(SystemClock, chain[1], "early", 1)
+	early.MerkleRoot = dataMerkleRoot(early.HashAlgo, early.Data)
+	if _, _, err := solveCandidate(context.Background(), early, 1, MinerOptions{}, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	var verr *BlockValidationError
+	if err := validateChain(append(chain[:2:2], early), 1); !errors.As(err, &verr) || verr.Index != 2 || !errors.Is(err, ErrFormatInactive) {
+		t.Errorf("version 4 block at height 2: %v", err)
+	}
+
+	// Without activations every version is valid at every height
+	formatActivations = nil
+	if err := validateChain(append(chain[:2:2], early), 1); err != nil {
+		t.Errorf("without activations: %v", err)
+	}
+}
+
+// TestFormatActivationExtraNonce checks that the miner gives up instead of
+// rolling the extra nonce before version 5 is active.
+func TestFormatActivationExtraNonce(t *testing.T) {
+	t.Cleanup(func() { formatActivations = nil })
+	formatActivations = []FormatActivation{{Version: blockFormatV5, Height: 10}}
+	chain := makeBlockchain(2, 1)
+	candidate := newCandidateBlock(SystemClock, chain[1], "hard", 8)
+	if _, _, err := solveCandidate(context.Background(), candidate, 8, MinerOptions{}, 16); !errors.Is(err, errNonceSpaceExhausted) || candidate.ExtraNonce != 0 {
+		t.Errorf("got %v with extra nonce %d", err, candidate.ExtraNonce)
+	}
+}
+
+func TestFormatActivationParams(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "params.json")
+	os.WriteFile(path, []byte(`{"chain_id": "upgrade", "format_activations": [{"version": 4, "height": 1000}, {"version": 5, "height": 2000}]}`), 0o644)
+	params, err := loadChainParams(path, networks["regtest"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []FormatActivation{{4, 1000}, {5, 2000}}; !slices.Equal(params.FormatActivations, want) {
+		t.Errorf("activations %+v, want %+v", params.FormatActivations, want)
+	}
+
+	for name, activations := range map[string][]FormatActivation{
+		"version 1":         {{Version: 1, Height: 5}},
+		"unknown version":   {{Version: latestBlockFormat + 1, Height: 5}},
+		"genesis":           {{Version: 4, Height: 0}},
+		"duplicate":         {{Version: 4, Height: 5}, {Version: 4, Height: 6}},
+		"newer one earlier": {{Version: 4, Height: 5}, {Version: 5, Height: 4}},
+	} {
+		if err := checkFormatActivations(activations); err == nil {
+			t.Errorf("%s: accepted %+v", name, activations)
+		}
+	}
+}
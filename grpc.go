@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// The node also serves the BlockchainService of proto/blockchain.proto
+// over gRPC, for services that want typed messages and streams rather
+// than JSON-RPC. gRPC is HTTP/2 with a framing of its own on top, which
+// net/http speaks without TLS too ("h2c"), so as with the protobuf
+// messages the service needs no generated code: a call is a POST to
+// /<service>/<method> whose body is length-prefixed messages, and its
+// status comes back in the grpc-status and grpc-message trailers. Calls
+// go through ServeHTTP, so API keys, bans, rate limits and the network
+// magic apply to them as to JSON-RPC; gRPC clients send them as
+// metadata.
+
+// grpcService is the path prefix of the service's methods.
+const grpcService = "/myfirstblockchain.BlockchainService/"
+
+// grpcMaxMessage bounds a request message, as gRPC's default does.
+const grpcMaxMessage = 4 << 20
+
+// gRPC status codes.
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcResourceExhausted = 8
+	grpcOutOfRange        = 11
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+)
+
+// grpcStatus is an error ending a call with a status other than OK.
+type grpcStatus struct {
+	Code    int
+	Message string
+}
+
+func (e *grpcStatus) Error() string {
+	return fmt.Sprintf("grpc status %d: %s", e.Code, e.Message)
+}
+
+// isGRPC reports whether r is a gRPC call.
+func isGRPC(r *http.Request) bool {
+	ct := r.Header.Get("Content-Type")
+	return r.ProtoMajor == 2 && (ct == "application/grpc" || strings.HasPrefix(ct, "application/grpc+"))
+}
+
+// serveGRPC answers a gRPC call.
+func (s *rpcServer) serveGRPC(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+	// Sent at once, so that a stream's client sees the call start
+	http.NewResponseController(w).Flush()
+	send := func(msg []byte) error {
+		if _, err := w.Write(grpcFrame(msg)); err != nil {
+			return err
+		}
+		return http.NewResponseController(w).Flush()
+	}
+
+	var err error
+	if r.Method != http.MethodPost {
+		err = &grpcStatus{Code: grpcUnimplemented, Message: "gRPC calls must use POST"}
+	} else if enc := r.Header.Get("Grpc-Encoding"); enc != "" && enc != "identity" {
+		err = &grpcStatus{Code: grpcUnimplemented, Message: fmt.Sprintf("message encoding %q is not supported", enc)}
+	} else {
+		var req []byte
+		if req, err = readGRPCMessage(r.Body); err == nil {
+			err = s.grpcCall(r.Context(), strings.TrimPrefix(r.URL.Path, grpcService), req, send)
+		}
+	}
+
+	code, message := grpcOK, ""
+	var status *grpcStatus
+	switch {
+	case errors.As(err, &status):
+		code, message = status.Code, status.Message
+	case err != nil && r.Context().Err() != nil:
+		// The client went away; there is no one to tell
+		return
+	case err != nil:
+		code, message = grpcInternal, err.Error()
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", url.PathEscape(message))
+	}
+}
+
+// grpcCall runs the method named method with the request message req,
+// passing the response messages to send.
+func (s *rpcServer) grpcCall(ctx context.Context, method string, req []byte, send func([]byte) error) error {
+	switch method {
+	case "StreamBlocks":
+		return s.grpcStreamBlocks(ctx, send)
+	case "GetRange":
+		return s.grpcGetRange(ctx, req, send)
+	case "SubmitBlock":
+		block := new(Block)
+		err := block.UnmarshalProto(req)
+		if err == nil {
+			err = checkBlockFields(block, block.Index, DecodePolicy{Strict: true})
+		}
+		s.mu.Lock()
+		reason := s.submit(ctx, block, err)
+		s.mu.Unlock()
+		return send(appendProtoBytes(nil, 1, []byte(reason)))
+	case "SubmitTransaction":
+		return &grpcStatus{Code: grpcUnimplemented, Message: "blocks carry opaque data; the chain has no transactions"}
+	}
+	return &grpcStatus{Code: grpcUnimplemented, Message: fmt.Sprintf("unknown method %q", method)}
+}
+
+// grpcStreamBlocks sends each block accepted until the call ends.
+func (s *rpcServer) grpcStreamBlocks(ctx context.Context, send func([]byte) error) error {
+	sub := s.bus.Subscribe(KindBlockAccepted)
+	defer sub.Close()
+	for {
+		select {
+		case ev, ok := <-sub.C:
+			if !ok {
+				return &grpcStatus{Code: grpcResourceExhausted, Message: "dropped for falling behind"}
+			}
+			if err := send(ev.(BlockAccepted).Block.MarshalProto()); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return &grpcStatus{Code: grpcUnavailable, Message: "the stream was closed"}
+		}
+	}
+}
+
+// grpcGetRange sends the blocks a GetRangeRequest asks for, oldest first.
+func (s *rpcServer) grpcGetRange(ctx context.Context, req []byte, send func([]byte) error) error {
+	var from, count int64
+	err := walkProto(req, func(field int, wireType int, v uint64, raw []byte) error {
+		if field <= 2 && wireType != protoVarint {
+			return fmt.Errorf("proto: range field %d has wire type %d, want %d", field, wireType, protoVarint)
+		}
+		switch field {
+		case 1:
+			from = int64(v)
+		case 2:
+			count = int64(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return &grpcStatus{Code: grpcInvalidArgument, Message: err.Error()}
+	}
+
+	chain := s.snapshot()
+	tip := int64(len(chain) - 1)
+	if from < 0 || from > tip || count < 0 {
+		return &grpcStatus{Code: grpcOutOfRange, Message: fmt.Sprintf("range from %d of %d blocks, tip %d", from, count, tip)}
+	}
+	to := tip
+	if count > 0 {
+		to = min(from+count-1, tip)
+	}
+	for _, block := range chain[from : to+1] {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := send(block.MarshalProto()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// grpcFrame prefixes msg with the uncompressed flag and its length.
+func grpcFrame(msg []byte) []byte {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+// readGRPCMessage reads the single request message of a call.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var head [5]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, &grpcStatus{Code: grpcInvalidArgument, Message: "missing request message"}
+	}
+	if head[0] != 0 {
+		return nil, &grpcStatus{Code: grpcUnimplemented, Message: "compressed messages are not supported"}
+	}
+	n := binary.BigEndian.Uint32(head[1:])
+	if n > grpcMaxMessage {
+		return nil, &grpcStatus{Code: grpcResourceExhausted, Message: fmt.Sprintf("request message of %d bytes exceeds %d", n, grpcMaxMessage)}
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, &grpcStatus{Code: grpcInvalidArgument, Message: "truncated request message"}
+	}
+	return msg, nil
+}
+
+// serveGRPCListener serves s over HTTP/2 on ln until ctx is cancelled:
+// with TLS when tlsConfig is set and in cleartext otherwise, as gRPC
+// clients expect. Calls still open, such as StreamBlocks, end with ctx.
+func serveGRPCListener(ctx context.Context, ln net.Listener, s *rpcServer, tlsConfig *tls.Config) error {
+	var protocols http.Protocols
+	srv := &http.Server{
+		Handler:     s,
+		Protocols:   &protocols,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	stop := context.AfterFunc(ctx, func() { srv.Close() })
+	defer stop()
+
+	var err error
+	if tlsConfig != nil {
+		protocols.SetHTTP2(true)
+		srv.TLSConfig = tlsConfig.Clone()
+		err = srv.ServeTLS(ln, "", "")
+	} else {
+		protocols.SetUnencryptedHTTP2(true)
+		err = srv.Serve(ln)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// grpcTestServer serves s's gRPC service on a local port and returns its
+// base URL. It enables the grpc feature for the rest of the test.
+func grpcTestServer(t *testing.T, s *rpcServer) string {
+	t.Helper()
+	enabledFeatures = []string{FeatureGRPC}
+	t.Cleanup(func() { enabledFeatures = nil })
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serveGRPCListener(ctx, ln, s, nil)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return "http://" + ln.Addr().String()
+}
+
+// grpcOpen starts a call of method with the request message req over
+// cleartext HTTP/2.
+func grpcOpen(t *testing.T, ctx context.Context, base, method string, req []byte) *http.Response {
+	t.Helper()
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, base+grpcService+method, bytes.NewReader(grpcFrame(req)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpReq.Header.Set("Content-Type", "application/grpc")
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/grpc" {
+		t.Fatalf("%s: %s, content type %q", method, resp.Status, resp.Header.Get("Content-Type"))
+	}
+	return resp
+}
+
+// grpcNext reads the next response message, or returns nil at the end of
+// the call.
+func grpcNext(t *testing.T, resp *http.Response) []byte {
+	t.Helper()
+	var head [5]byte
+	if _, err := io.ReadFull(resp.Body, head[:]); err == io.EOF {
+		return nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	msg := make([]byte, binary.BigEndian.Uint32(head[1:]))
+	if _, err := io.ReadFull(resp.Body, msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// grpcCallAll runs a call to its end and returns its messages and status.
+func grpcCallAll(t *testing.T, base, method string, req []byte) ([][]byte, int) {
+	t.Helper()
+	resp := grpcOpen(t, context.Background(), base, method, req)
+	var msgs [][]byte
+	for msg := grpcNext(t, resp); msg != nil; msg = grpcNext(t, resp) {
+		msgs = append(msgs, msg)
+	}
+	code, err := strconv.Atoi(resp.Trailer.Get("Grpc-Status"))
+	if err != nil {
+		t.Fatalf("%s: grpc-status trailer %q", method, resp.Trailer.Get("Grpc-Status"))
+	}
+	return msgs, code
+}
+
+// TestGRPCService checks GetRange, SubmitBlock, StreamBlocks and the
+// status of calls the service refuses.
+func TestGRPCService(t *testing.T) {
+	chain := makeBlockchain(4, 1)
+	s := newRPCServer(chain, 1)
+	base := grpcTestServer(t, s)
+
+	req := appendProtoVarint(appendProtoVarint(nil, 1, 1), 2, 2)
+	msgs, code := grpcCallAll(t, base, "GetRange", req)
+	if code != grpcOK || len(msgs) != 2 {
+		t.Fatalf("GetRange 1+2: %d messages, status %d", len(msgs), code)
+	}
+	for i, msg := range msgs {
+		var b Block
+		if err := b.UnmarshalProto(msg); err != nil || !bytes.Equal(b.Hash, chain[1+i].Hash) {
+			t.Errorf("GetRange message %d is not block %d: %v", i, 1+i, err)
+		}
+	}
+	if msgs, code := grpcCallAll(t, base, "GetRange", nil); code != grpcOK || len(msgs) != len(chain) {
+		t.Errorf("GetRange of the whole chain: %d messages, status %d", len(msgs), code)
+	}
+	if _, code := grpcCallAll(t, base, "GetRange", appendProtoVarint(nil, 1, 9)); code != grpcOutOfRange {
+		t.Errorf("GetRange past the tip: status %d, want %d", code, grpcOutOfRange)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := grpcOpen(t, ctx, base, "StreamBlocks", nil)
+	for deadline := time.Now().Add(5 * time.Second); s.bus.Subscribers() == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("StreamBlocks did not subscribe")
+		}
+	}
+
+	next, err := generateBlock(context.Background(), chain[3], "over grpc", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, code = grpcCallAll(t, base, "SubmitBlock", next.MarshalProto())
+	if code != grpcOK || len(msgs) != 1 || len(msgs[0]) != 0 {
+		t.Fatalf("SubmitBlock: %q, status %d, want an empty reason", msgs, code)
+	}
+	if tip := s.tip(); !bytes.Equal(tip.Hash, next.Hash) {
+		t.Fatalf("tip is block %d, not the submitted one", tip.Index)
+	}
+	var streamed Block
+	if err := streamed.UnmarshalProto(grpcNext(t, stream)); err != nil || !bytes.Equal(streamed.Hash, next.Hash) {
+		t.Errorf("StreamBlocks sent %d, %v; want the submitted block", streamed.Index, err)
+	}
+
+	msgs, code = grpcCallAll(t, base, "SubmitBlock", next.MarshalProto())
+	if code != grpcOK || len(msgs) != 1 || string(msgs[0][2:]) != "duplicate" {
+		t.Errorf("resubmitting: %q, status %d, want reason duplicate", msgs, code)
+	}
+	if _, code := grpcCallAll(t, base, "SubmitTransaction", nil); code != grpcUnimplemented {
+		t.Errorf("SubmitTransaction: status %d, want %d", code, grpcUnimplemented)
+	}
+	if _, code := grpcCallAll(t, base, "Mine", nil); code != grpcUnimplemented {
+		t.Errorf("unknown method: status %d, want %d", code, grpcUnimplemented)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// hashCacheEntryOverhead approximates the bytes an entry costs beyond its
+// key and hash: the list element, map slot and entry struct.
+const hashCacheEntryOverhead = 128
+
+// HashCache provides thread-safe hash caching with least-recently-used
+// eviction. Entries are keyed by the hash a block claims, so blocks from
+// competing forks at the same height never collide. A hit also requires
+// the very same *Block, so a forged block that claims a cached hash is
+// still hashed from its own contents.
+type HashCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	order      *list.List // front is most recently used
+	entries    map[string]*list.Element
+	stats      HashCacheStats
+}
+
+type hashCacheEntry struct {
+	key   string
+	block *Block
+	hash  []byte
+}
+
+// HashCacheStats counts cache activity.
+type HashCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+	Bytes     int64
+}
+
+// NewHashCache creates a new thread-safe hash cache holding up to capacity
+// entries.
+func NewHashCache(capacity int) *HashCache {
+	return NewBoundedHashCache(capacity, 0)
+}
+
+// NewBoundedHashCache creates a hash cache that evicts the least recently
+// used entries once it holds more than maxEntries entries or more than
+// maxBytes bytes. A limit of 0 disables it.
+func NewBoundedHashCache(maxEntries int, maxBytes int64) *HashCache {
+	return &HashCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// Get retrieves the computed hash of a block from cache
+func (hc *HashCache) Get(block *Block) ([]byte, bool) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	elem, ok := hc.entries[string(block.Hash)]
+	if !ok || elem.Value.(*hashCacheEntry).block != block {
+		hc.stats.Misses++
+		return nil, false
+	}
+	hc.stats.Hits++
+	hc.order.MoveToFront(elem)
+	// Return a copy to prevent modification
+	return append([]byte(nil), elem.Value.(*hashCacheEntry).hash...), true
+}
+
+// Set stores the computed hash of a block in cache
+func (hc *HashCache) Set(block *Block, hash []byte) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	key := string(block.Hash)
+	if elem, ok := hc.entries[key]; ok {
+		hc.remove(elem)
+	}
+
+	// Store a copy to prevent external modification
+	entry := &hashCacheEntry{key: key, block: block, hash: append([]byte(nil), hash...)}
+	hc.entries[key] = hc.order.PushFront(entry)
+	hc.bytes += entrySize(entry)
+
+	for hc.order.Len() > 1 && (hc.maxEntries > 0 && hc.order.Len() > hc.maxEntries || hc.maxBytes > 0 && hc.bytes > hc.maxBytes) {
+		hc.remove(hc.order.Back())
+		hc.stats.Evictions++
+	}
+}
+
+// Stats returns the cache's hit, miss and eviction counts and its size.
+func (hc *HashCache) Stats() HashCacheStats {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	stats := hc.stats
+	stats.Entries = hc.order.Len()
+	stats.Bytes = hc.bytes
+	return stats
+}
+
+func (hc *HashCache) remove(elem *list.Element) {
+	entry := hc.order.Remove(elem).(*hashCacheEntry)
+	delete(hc.entries, entry.key)
+	hc.bytes -= entrySize(entry)
+}
+
+func entrySize(e *hashCacheEntry) int64 {
+	return int64(len(e.key) + len(e.hash) + hashCacheEntryOverhead)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestHashCacheLRU checks eviction order, the entry and byte limits, and
+// the hit and miss counters.
+func TestHashCacheLRU(t *testing.T) {
+	chain := makeBlockchain(4, 1)
+	hc := NewHashCache(2)
+	for _, block := range chain[:2] {
+		hc.Set(block, block.Hash)
+	}
+	hc.Get(chain[0]) // chain[1] is now least recently used
+	hc.Set(chain[2], chain[2].Hash)
+
+	if _, ok := hc.Get(chain[1]); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	for _, block := range []*Block{chain[0], chain[2]} {
+		if hash, ok := hc.Get(block); !ok || !bytes.Equal(hash, block.Hash) {
+			t.Errorf("block %d missing from cache", block.Index)
+		}
+	}
+	stats := hc.Stats()
+	if stats.Hits != 3 || stats.Misses != 1 || stats.Evictions != 1 || stats.Entries != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// Room for two entries of a 32-byte key and hash
+	hc = NewBoundedHashCache(0, 2*(64+hashCacheEntryOverhead))
+	for _, block := range chain {
+		hc.Set(block, block.Hash)
+	}
+	if stats := hc.Stats(); stats.Entries != 2 || stats.Bytes > 2*(64+hashCacheEntryOverhead) {
+		t.Errorf("byte budget not enforced: %+v", stats)
+	}
+}
+
+// TestHashCacheForks checks that blocks at the same height do not collide
+// and that a block claiming a cached hash is not served the cached value.
+func TestHashCacheForks(t *testing.T) {
+	chain := makeBlockchain(2, 1)
+	fork := makeBlockchain(2, 2)
+	hc := NewHashCache(4)
+	hc.Set(chain[1], chain[1].Hash)
+	hc.Set(fork[1], fork[1].Hash)
+	if _, ok := hc.Get(chain[1]); !ok {
+		t.Error("fork block at the same height evicted the original")
+	}
+
+	forged := *chain[1]
+	forged.Data = []byte("forged")
+	if _, ok := hc.Get(&forged); ok {
+		t.Error("forged block claiming a cached hash hit the cache")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// TestBLAKE3_Vectors checks the implementation against the official test
+// vectors, whose input is the byte sequence 0, 1, ..., 250, 0, 1, ...
+func TestBLAKE3_Vectors(t *testing.T) {
+	vectors := map[int]string{
+		0:    "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262",
+		1:    "2d3adedff11b61f14c886e35afa036736dcd87a74d27b5c1510225d0f592e213",
+		1024: "42214739f095a406f3fc83deb889744ac00df831c10daa55189b5d121c855af7",
+		1025: "d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444",
+	}
+	for n, want := range vectors {
+		input := make([]byte, n)
+		for i := range input {
+			input[i] = byte(i % 251)
+		}
+		h := newBLAKE3()
+		h.Write(input)
+		if got := hex.EncodeToString(h.Sum(nil)); got != want {
+			t.Errorf("BLAKE3 of %d bytes = %s, want %s", n, got, want)
+		}
+	}
+}
+
+// TestBLAKE3_IncrementalWrites checks that the result does not depend on
+// how the input is split across writes, across several chunk boundaries.
+func TestBLAKE3_IncrementalWrites(t *testing.T) {
+	input := make([]byte, 9*1024+17)
+	for i := range input {
+		input[i] = byte(i * 7)
+	}
+	whole := newBLAKE3()
+	whole.Write(input)
+	want := whole.Sum(nil)
+
+	for _, step := range []int{1, 63, 64, 1000, 1024, 4096} {
+		h := newBLAKE3()
+		for i := 0; i < len(input); i += step {
+			h.Write(input[i:min(i+step, len(input))])
+		}
+		if got := h.Sum(nil); !bytes.Equal(got, want) {
+			t.Errorf("writes of %d bytes gave %x, want %x", step, got, want)
+		}
+	}
+}
+
+// TestHasher_ChainsPerAlgorithm mines and validates a short chain with
+// every algorithm and checks that the algorithm changes the hash.
+func TestHasher_ChainsPerAlgorithm(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, hasher := range hashers {
+		genesis := &Block{Index: 0, Data: []byte("Genesis"), PrevHash: []byte{}, HashAlgo: hasher.ID()}
+		genesis.Hash = calculateHash(genesis)
+		if len(genesis.Hash) != 32 {
+			t.Fatalf("%s: hash is %d bytes", hasher.Name(), len(genesis.Hash))
+		}
+		seen[hex.EncodeToString(genesis.Hash)] = true
+
+		chain := []*Block{genesis}
+		for i := 1; i <= 3; i++ {
+			block, err := generateBlock(context.Background(), chain[i-1], "block", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			chain = append(chain, block)
+		}
+		if err := validateChain(chain, 1); err != nil {
+			t.Errorf("%s: chain should be valid: %v", hasher.Name(), err)
+		}
+	}
+	if len(seen) != len(hashers) {
+		t.Error("genesis hashes should differ between algorithms")
+	}
+}
+
+// TestValidateChain_MixedHashAlgorithms verifies that a block switching
+// algorithm is rejected even though its own hash is consistent.
+func TestValidateChain_MixedHashAlgorithms(t *testing.T) {
+	chain := makeBlockchain(3, 1)
+
+	mixed := *chain[2]
+	mixed.HashAlgo = HashBLAKE3
+	hash, nonce, err := proofOfWork(context.Background(), &mixed, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mixed.Hash, mixed.Nonce = hash, nonce
+	chain[2] = &mixed
+
+	err = validateChain(chain, 1)
+	if !errors.Is(err, ErrHashAlgorithm) {
+		t.Fatalf("expected ErrHashAlgorithm, got %v", err)
+	}
+
+	mixed.HashAlgo = 9
+	if err := validateChain(chain, 1); !errors.Is(err, ErrHashAlgorithm) {
+		t.Errorf("unknown algorithm: expected ErrHashAlgorithm, got %v", err)
+	}
+}
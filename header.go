@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// BlockHeader is the part of a block its hash covers. Blocks in format
+// version 4 commit to their data through MerkleRoot, so a syncing node can
+// download the headers of a chain and check their linkage and
+// proof-of-work before fetching any bodies. Legacy blocks hash their data
+// directly; their headers cannot be checked without it.
+type BlockHeader struct {
+	Index      int           `json:"index"`
+	Timestamp  int64         `json:"timestamp"`
+	PrevHash   []byte        `json:"prev_hash"`
+	MerkleRoot []byte        `json:"merkle_root,omitempty"`
+	Hash       []byte        `json:"hash"`
+	Nonce      uint64        `json:"nonce"`
+	ExtraNonce uint64        `json:"extra_nonce,omitempty"`
+	Bits       uint32        `json:"bits,omitempty"`
+	HashAlgo   byte          `json:"hash_algo,omitempty"`
+	Epoch      *EpochSummary `json:"epoch,omitempty"`
+}
+
+// Header returns the block's header.
+func (b *Block) Header() *BlockHeader {
+	return &BlockHeader{
+		Index:      b.Index,
+		Timestamp:  b.Timestamp,
+		PrevHash:   b.PrevHash,
+		MerkleRoot: b.MerkleRoot,
+		Hash:       b.Hash,
+		Nonce:      b.Nonce,
+		ExtraNonce: b.ExtraNonce,
+		Bits:       b.Bits,
+		HashAlgo:   b.HashAlgo,
+		Epoch:      b.Epoch,
+	}
+}
+
+// withBody returns the block made of the header and data, without checking
+// that they belong together.
+func (h *BlockHeader) withBody(data []byte) *Block {
+	return &Block{
+		Index:      h.Index,
+		Timestamp:  h.Timestamp,
+		Data:       data,
+		PrevHash:   h.PrevHash,
+		Hash:       h.Hash,
+		Nonce:      h.Nonce,
+		ExtraNonce: h.ExtraNonce,
+		Bits:       h.Bits,
+		HashAlgo:   h.HashAlgo,
+		MerkleRoot: h.MerkleRoot,
+		Epoch:      h.Epoch,
+	}
+}
+
+// attachBody completes a validated header with its data, once fetched.
+// The data must match the header's Merkle root.
+func attachBody(h *BlockHeader, data []byte) (*Block, error) {
+	if h.MerkleRoot == nil {
+		return nil, &BlockValidationError{Index: h.Index, Err: fmt.Errorf("%w: header has no merkle root", ErrHashMismatch)}
+	}
+	block := h.withBody(data)
+	if err := checkBody(block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// checkBody checks a block's data against the Merkle root in its header.
+// Legacy blocks, whose hash covers the data, and redacted blocks, whose
+// data is gone, pass.
+func checkBody(block *Block) error {
+	if block.MerkleRoot == nil || block.Redaction != nil {
+		return nil
+	}
+	if !bytes.Equal(dataMerkleRoot(block.HashAlgo, block.Data), block.MerkleRoot) {
+		return &BlockValidationError{Index: block.Index, Err: fmt.Errorf("%w: data does not match merkle root", ErrHashMismatch)}
+	}
+	return nil
+}
+
+// validateHeaders checks a run of headers without their bodies. The first
+// header is the anchor the run extends, such as the local tip, and is
+// trusted; every later one must follow its predecessor's height, link to
+// it, hash correctly and meet its proof-of-work. Epoch summaries cover block data, so they
+// are checked once the bodies are attached.
+func validateHeaders(headers []*BlockHeader, difficulty int) error {
+	if len(headers) == 0 {
+		return nil
+	}
+	hashCache := NewHashCache(len(headers))
+	prev := headers[0].withBody(nil)
+	hashCache.Set(prev, headers[0].Hash)
+	for _, h := range headers[1:] {
+		if h.MerkleRoot == nil {
+			return &BlockValidationError{
+				Index: h.Index,
+				Err:   fmt.Errorf("%w: legacy header can only be checked with its data", ErrHashMismatch),
+			}
+		}
+		if h.Index != prev.Index+1 {
+			return &BlockValidationError{Index: h.Index, Err: fmt.Errorf("%w: header %d follows header %d", ErrBadHeight, h.Index, prev.Index)}
+		}
+		curr := h.withBody(nil)
+		if err := validateHeaderPair(prev, curr, difficulty, hashCache); err != nil {
+			return err
+		}
+		prev = curr
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMerkleRoot(t *testing.T) {
+	h := sha256Hasher{}
+	if got := len(dataChunks(nil)); got != 1 {
+		t.Errorf("empty data has %d leaves, want 1", got)
+	}
+
+	data := bytes.Repeat([]byte{'x'}, 2*dataChunkSize+1)
+	chunks := dataChunks(data)
+	if len(chunks) != 3 || len(chunks[2]) != 1 {
+		t.Fatalf("unexpected chunks: %d", len(chunks))
+	}
+	// The odd third leaf is carried up to pair with the first two
+	want := merkleNode(h, merkleNode(h, merkleLeaf(h, chunks[0]), merkleLeaf(h, chunks[1])), merkleLeaf(h, chunks[2]))
+	if got := merkleRoot(h, data); !bytes.Equal(got, want) {
+		t.Errorf("merkle root %x, want %x", got, want)
+	}
+	if bytes.Equal(merkleRoot(h, []byte("a")), merkleRoot(sha3Hasher{}, []byte("a"))) {
+		t.Error("merkle root does not depend on the hash algorithm")
+	}
+}
+
+// TestHeadersFirst validates a chain's headers alone, then attaches the
+// bodies one by one.
+func TestHeadersFirst(t *testing.T) {
+	chain := makeBlockchain(6, 1)
+	headers := make([]*BlockHeader, len(chain))
+	for i, block := range chain {
+		headers[i] = block.Header()
+	}
+	if err := validateHeaders(headers, 1); err != nil {
+		t.Fatalf("validateHeaders: %v", err)
+	}
+
+	synced := []*Block{chain[0]}
+	for _, h := range headers[1:] {
+		block, err := attachBody(h, chain[h.Index].Data)
+		if err != nil {
+			t.Fatalf("attachBody(%d): %v", h.Index, err)
+		}
+		synced = append(synced, block)
+	}
+	if err := validateChain(synced, 1); err != nil {
+		t.Errorf("synced chain: %v", err)
+	}
+
+	if _, err := attachBody(headers[2], []byte("forged")); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("attachBody accepted forged data: %v", err)
+	}
+
+	forged := *headers[3]
+	forged.Nonce++
+	err := validateHeaders([]*BlockHeader{headers[0], headers[1], headers[2], &forged}, 1)
+	var blockErr *BlockValidationError
+	if !errors.As(err, &blockErr) || blockErr.Index != 3 {
+		t.Errorf("expected the forged header to fail at block 3, got %v", err)
+	}
+
+	// A legacy block's hash covers its data, so its header alone is not enough
+	legacy := *headers[1]
+	legacy.MerkleRoot = nil
+	if err := validateHeaders([]*BlockHeader{headers[0], &legacy}, 1); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("legacy header accepted without data: %v", err)
+	}
+}
+
+// TestBodyMismatch checks that full validation catches data that does not
+// match the Merkle root, although the header still hashes correctly.
+func TestBodyMismatch(t *testing.T) {
+	chain := makeBlockchain(4, 1)
+	chain[2].Data = []byte("tampered")
+	if !bytes.Equal(calculateHash(chain[2]), chain[2].Hash) {
+		t.Fatal("the hash should cover only the header")
+	}
+	err := validateChain(chain, 1)
+	if !errors.Is(err, ErrHashMismatch) || !strings.Contains(err.Error(), "merkle root") {
+		t.Errorf("expected a merkle root mismatch, got %v", err)
+	}
+}
+
+func TestRPC_GetHeaders(t *testing.T) {
+	chain := makeBlockchain(5, 1)
+	s := newRPCServer(chain, 1)
+
+	var resp struct {
+		Result []*BlockHeader
+		Error  *rpcError
+	}
+	rpcPost(t, s, `{"jsonrpc":"2.0","method":"getheaders","params":[1,10],"id":1}`, &resp)
+	if resp.Error != nil || len(resp.Result) != 4 {
+		t.Fatalf("getheaders: %d headers, %v", len(resp.Result), resp.Error)
+	}
+	if err := validateHeaders(append([]*BlockHeader{chain[0].Header()}, resp.Result...), 1); err != nil {
+		t.Errorf("fetched headers: %v", err)
+	}
+
+	var bad struct{ Error *rpcError }
+	rpcPost(t, s, `{"jsonrpc":"2.0","method":"getheaders","params":[1,0],"id":2}`, &bad)
+	if bad.Error == nil || bad.Error.Code != rpcInvalidParam {
+		t.Errorf("getheaders with count 0: %+v", bad.Error)
+	}
+}
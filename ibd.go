@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Initial block download fetches the bodies of the blocks whose headers a
+// light client has verified, as a node catching up does once Sync has
+// found the best chain. Each peer gets a worker asking for one block at a
+// time with getblock, so fast peers ask more often and serve more of the
+// chain. Requests run at most ibdWindow blocks ahead of the next block to
+// commit. Bodies arrive in any order and wait until the ones before them
+// are in; then they are committed as a run. A peer's body only counts if
+// it matches the verified header, so a peer can slow the download but
+// not change what is committed.
+
+// ibdWindow bounds how far past the next block to commit bodies are
+// requested, and so how many received blocks wait for a slow one.
+const ibdWindow = 256
+
+// throughputWeight is the weight of a new sample in a peer's throughput
+// average, as latencyWeight is for latency.
+const throughputWeight = 0.2
+
+// errBodyUnavailable is reported for a block a peer has redacted, whose
+// body it cannot serve. Another peer may still have it.
+var errBodyUnavailable = errors.New("block body not available")
+
+// blockDownload is the state of one DownloadBlocks call.
+type blockDownload struct {
+	client  *LightClient
+	headers []*BlockHeader // of the blocks to fetch, headers[0] at height from
+	from    int
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	next      int                // lowest height not requested yet
+	committed int                // lowest height not committed yet
+	retry     []int              // heights to request again
+	received  map[int]*Block     // bodies waiting for the ones before them
+	inFlight  map[int]string     // peer each outstanding height was asked of
+	rates     map[string]float64 // bytes per second, per peer
+	workers   int
+	done      bool
+	errs      []error
+}
+
+// DownloadBlocks fetches the bodies of the best chain's blocks from
+// height from up to its tip from peers in parallel, and passes them to
+// commit in height order, in runs of the blocks received so far. A peer
+// that fails or serves a body not matching its header is dropped from the
+// download and its block asked of another; with a peer manager, an
+// invalid body counts as misbehavior and banned peers are not asked.
+//
+// When a peer holds up the next block to commit while every other block
+// in the window is in, a peer with more than twice its throughput asks
+// for that block too, and the first body to arrive is taken.
+//
+// DownloadBlocks returns once every block is committed, commit fails or
+// no peer is left, with the errors of the peers in the last case.
+func (c *LightClient) DownloadBlocks(ctx context.Context, peers []*rpcClient, from int, commit func([]*Block) error) error {
+	from = max(from, 1)
+	c.mu.RLock()
+	headers := slices.Clone(c.headers[min(from, len(c.headers)):])
+	c.mu.RUnlock()
+	d := &blockDownload{
+		client:    c,
+		headers:   headers,
+		from:      from,
+		next:      from,
+		committed: from,
+		received:  make(map[int]*Block),
+		inFlight:  make(map[int]string),
+		rates:     make(map[string]float64),
+	}
+	d.cond = sync.NewCond(&d.mu)
+	// Cancelled once the blocks are committed or commit fails, so that
+	// no worker waits on a request whose block is no longer needed
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.cond.Broadcast()
+	})
+	defer stop()
+
+	peers = slices.DeleteFunc(slices.Clone(peers), func(peer *rpcClient) bool {
+		return c.peers != nil && c.peers.Banned(peer.url)
+	})
+	d.workers = len(peers)
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.work(ctx, peer)
+		}()
+	}
+	err := d.commitAll(ctx, commit)
+	cancel()
+	wg.Wait()
+	return err
+}
+
+// commitAll passes the received blocks to commit in order until all are
+// committed.
+func (d *blockDownload) commitAll(ctx context.Context, commit func([]*Block) error) error {
+	d.mu.Lock()
+	defer func() {
+		d.done = true
+		d.cond.Broadcast()
+		d.mu.Unlock()
+	}()
+	end := d.from + len(d.headers)
+	for d.committed < end {
+		var run []*Block
+		for h := d.committed; d.received[h] != nil; h++ {
+			run = append(run, d.received[h])
+			delete(d.received, h)
+		}
+		if len(run) == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if d.workers == 0 {
+				err := errors.Join(d.errs...)
+				if err == nil {
+					err = errors.New("no peer to ask")
+				}
+				return fmt.Errorf("downloaded blocks up to %d of %d: %w", d.committed-1, end-1, err)
+			}
+			d.cond.Wait()
+			continue
+		}
+		d.mu.Unlock()
+		err := commit(run)
+		d.mu.Lock()
+		if err != nil {
+			return err
+		}
+		d.committed += len(run)
+		d.cond.Broadcast()
+	}
+	return nil
+}
+
+// work fetches blocks from peer until there are none left to ask for or
+// the peer fails.
+func (d *blockDownload) work(ctx context.Context, peer *rpcClient) {
+	defer func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.workers--
+		d.cond.Broadcast()
+	}()
+	for {
+		height, ok := d.take(ctx, peer.url)
+		if !ok {
+			return
+		}
+		began := time.Now()
+		block, size, err := d.fetch(ctx, peer, height)
+		if err != nil {
+			d.fail(peer.url, height, err)
+			return
+		}
+		d.deliver(peer.url, height, block, size, time.Since(began))
+	}
+}
+
+// take returns the next height for the peer at addr to fetch, waiting
+// while the window is full.
+func (d *blockDownload) take(ctx context.Context, addr string) (int, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	end := d.from + len(d.headers)
+	for {
+		switch {
+		case d.done || ctx.Err() != nil:
+			return 0, false
+		case len(d.retry) > 0:
+			height := slices.Min(d.retry)
+			d.retry = slices.DeleteFunc(d.retry, func(h int) bool { return h == height })
+			d.inFlight[height] = addr
+			return height, true
+		case d.next < end && d.next < d.committed+ibdWindow:
+			height := d.next
+			d.next++
+			d.inFlight[height] = addr
+			return height, true
+		case d.next == end && len(d.inFlight) == 0:
+			return 0, false
+		}
+		// The window is full: ask for the block holding it up again if a
+		// much slower peer has it
+		if owner, ok := d.inFlight[d.committed]; ok && owner != addr && d.rates[addr] > 2*d.rates[owner] {
+			d.inFlight[d.committed] = addr
+			return d.committed, true
+		}
+		d.cond.Wait()
+	}
+}
+
+// fetch asks peer for the block at height and attaches its data to the
+// verified header. A body claiming another height or hash than the header
+// is invalid. It returns the block and the size of its encoding.
+func (d *blockDownload) fetch(ctx context.Context, peer *rpcClient, height int) (*Block, int, error) {
+	header := d.headers[height-d.from]
+	var encoded string
+	if err := peer.call(ctx, "getblock", &encoded, hex.EncodeToString(header.Hash), 0); err != nil {
+		return nil, 0, err
+	}
+	msg, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, 0, fmt.Errorf("getblock: %w", err)
+	}
+	var body Block
+	if err := body.UnmarshalProto(msg); err != nil {
+		return nil, 0, &BlockValidationError{Index: height, Err: err}
+	}
+	if body.Index != height {
+		return nil, 0, &BlockValidationError{Index: height, Err: fmt.Errorf("%w: peer served block %d", ErrBadHeight, body.Index)}
+	}
+	if !bytes.Equal(body.Hash, header.Hash) {
+		return nil, 0, &BlockValidationError{Index: height, Err: fmt.Errorf("%w: peer served block %x for %x", ErrHashMismatch, body.Hash, header.Hash)}
+	}
+	if body.Redaction != nil {
+		return nil, 0, errBodyUnavailable
+	}
+	block, err := attachBody(header, body.Data)
+	return block, len(msg), err
+}
+
+// deliver takes the block fetched for height and updates the throughput
+// of the peer at addr.
+func (d *blockDownload) deliver(addr string, height int, block *Block, size int, took time.Duration) {
+	if d.client.peers != nil {
+		d.client.peers.RecordLatency(addr, took)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	rate := float64(size) / max(took.Seconds(), 1e-6)
+	if old, ok := d.rates[addr]; ok {
+		rate = old + throughputWeight*(rate-old)
+	}
+	d.rates[addr] = rate
+	delete(d.inFlight, height)
+	if height >= d.committed && d.received[height] == nil {
+		d.received[height] = block
+	}
+	d.cond.Broadcast()
+}
+
+// fail records the error of the peer at addr, which is dropped, and puts
+// its height back to be asked of another peer.
+func (d *blockDownload) fail(addr string, height int, err error) {
+	var invalid *BlockValidationError
+	if d.client.peers != nil && errors.As(err, &invalid) {
+		if _, perr := d.client.peers.Misbehaving(addr, penaltyInvalidBlock, err.Error()); perr != nil {
+			err = errors.Join(err, fmt.Errorf("saving the ban list: %w", perr))
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.errs = append(d.errs, fmt.Errorf("%s: block %d: %w", addr, height, err))
+	if d.inFlight[height] == addr {
+		delete(d.inFlight, height)
+		d.retry = append(d.retry, height)
+	}
+	d.cond.Broadcast()
+}
+
+// catchUp downloads the blocks the server's known peers have past its
+// tip, as a node starting behind the network does. It syncs the best
+// chain's headers with a light client anchored at the server's genesis
+// block and, if that chain extends the server's, downloads the bodies
+// into the server with AppendBatch. A best chain forking below the tip is
+// left to submitblock's fork choice. The light client checks headers by
+// their proof-of-work at difficulty, so only proof-of-work chains catch
+// up this way.
+func catchUp(ctx context.Context, server *rpcServer, difficulty int) error {
+	var peers []*rpcClient
+	for _, peer := range server.peers.Known() {
+		if peer.Banned {
+			continue
+		}
+		client, err := newPeerClient(peer.URL)
+		if err != nil {
+			continue
+		}
+		client.magic = server.magic
+		peers = append(peers, client)
+	}
+	if len(peers) == 0 {
+		return nil
+	}
+
+	chain := server.snapshot()
+	client := NewLightClient(chain[0].Header(), difficulty)
+	client.UsePeers(server.peers)
+	headers := make([]*BlockHeader, 0, len(chain)-1)
+	for _, block := range chain[1:] {
+		headers = append(headers, block.Header())
+	}
+	if _, err := client.AddHeaders(headers); err != nil {
+		return fmt.Errorf("local chain: %w", err)
+	}
+	syncErr := client.Sync(ctx, peers)
+	tip := len(chain) - 1
+	if client.Tip().Index <= tip {
+		return syncErr
+	}
+	if h := client.Header(tip); !bytes.Equal(h.Hash, chain[tip].Hash) {
+		return fmt.Errorf("the peers' best chain forks from this node's below its tip %d", tip)
+	}
+	return client.DownloadBlocks(ctx, peers, tip+1, func(blocks []*Block) error {
+		return server.AppendBatch(ctx, blocks)
+	})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestDownloadBlocks syncs headers from one node and downloads the bodies
+// from three, one of which serves a forged body. The blocks reach the
+// local node whole and in order, and the forger is banned once caught.
+func TestDownloadBlocks(t *testing.T) {
+	chain := makeBlockchain(40, 1)
+	forged := append([]*Block(nil), chain...)
+	bad := *forged[7]
+	bad.Data = []byte("forged")
+	forged[7] = &bad
+
+	var peers []*rpcClient
+	for _, c := range [][]*Block{chain, forged, chain} {
+		node := httptest.NewServer(newRPCServer(c, 1))
+		defer node.Close()
+		peers = append(peers, newRPCClient(node.URL))
+	}
+	manager, err := newPeerManager("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewLightClient(chain[0].Header(), 1)
+	client.UsePeers(manager)
+	if err := client.Sync(context.Background(), peers[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	local := newRPCServer(chain[:1:1], 1)
+	next := 1
+	commit := func(blocks []*Block) error {
+		if blocks[0].Index != next {
+			t.Errorf("run starts at %d, want %d", blocks[0].Index, next)
+		}
+		next += len(blocks)
+		return local.AppendBatch(context.Background(), blocks)
+	}
+	if err := client.DownloadBlocks(context.Background(), peers, 1, commit); err != nil {
+		t.Fatalf("DownloadBlocks: %v", err)
+	}
+	got := local.snapshot()
+	if len(got) != len(chain) || validateChain(got, 1) != nil || string(got[7].Data) != string(chain[7].Data) {
+		t.Fatalf("downloaded %d blocks", len(got))
+	}
+	if manager.Banned(peers[0].url) || manager.Banned(peers[2].url) {
+		t.Errorf("honest peer banned: %v", manager.Bans())
+	}
+
+	// With only the forger to ask, the download stops below its block
+	if manager, err = newPeerManager(""); err != nil {
+		t.Fatal(err)
+	}
+	client.UsePeers(manager)
+	local = newRPCServer(chain[:1:1], 1)
+	next = 1
+	err = client.DownloadBlocks(context.Background(), peers[1:2], 1, commit)
+	var invalid *BlockValidationError
+	if !errors.As(err, &invalid) || invalid.Index != 7 {
+		t.Errorf("download from the forger: %v", err)
+	}
+	if n := len(local.snapshot()); n > 7 {
+		t.Errorf("committed %d blocks past the forged one", n)
+	}
+	if !manager.Banned(peers[1].url) {
+		t.Error("forger not banned")
+	}
+
+	// A body claiming another height is refused whole, rather than kept
+	// at the height it claims, where the download would wait on it
+	mislabeled := append([]*Block(nil), chain...)
+	moved := *mislabeled[12]
+	moved.Index = 30
+	mislabeled[12] = &moved
+	node := httptest.NewServer(newRPCServer(mislabeled, 1))
+	defer node.Close()
+	peers = append(peers, newRPCClient(node.URL))
+	if manager, err = newPeerManager(""); err != nil {
+		t.Fatal(err)
+	}
+	client.UsePeers(manager)
+	local = newRPCServer(chain[:1:1], 1)
+	next = 1
+	err = client.DownloadBlocks(context.Background(), peers[3:], 1, commit)
+	if !errors.As(err, &invalid) || invalid.Index != 12 || !errors.Is(err, ErrBadHeight) {
+		t.Errorf("download from a peer mislabeling a block: %v", err)
+	}
+	if !manager.Banned(peers[3].url) {
+		t.Error("mislabeling peer not banned")
+	}
+	client.UsePeers(nil)
+	local = newRPCServer(chain[:1:1], 1)
+	next = 1
+	if err := client.DownloadBlocks(context.Background(), []*rpcClient{peers[3], peers[0]}, 1, commit); err != nil {
+		t.Errorf("download with an honest peer left: %v", err)
+	}
+	if n := len(local.snapshot()); n != len(chain) {
+		t.Errorf("downloaded %d blocks, want %d", n, len(chain))
+	}
+}
+
+// TestBlockDownloadTake checks the order heights are handed out in: first
+// those to ask again, then new ones within the window, and with the
+// window full the block holding it up, to a much faster peer.
+func TestBlockDownloadTake(t *testing.T) {
+	d := &blockDownload{
+		headers:   make([]*BlockHeader, 2*ibdWindow),
+		from:      1,
+		next:      1,
+		committed: 1,
+		received:  make(map[int]*Block),
+		inFlight:  make(map[int]string),
+		rates:     map[string]float64{"slow": 100, "fast": 1000},
+	}
+	ctx := context.Background()
+	if h, _ := d.take(ctx, "slow"); h != 1 {
+		t.Fatalf("first height %d", h)
+	}
+	for range ibdWindow - 1 {
+		d.take(ctx, "fast")
+	}
+	if d.next != 1+ibdWindow {
+		t.Fatalf("requested up to %d, want the window of %d", d.next-1, ibdWindow)
+	}
+	d.retry = []int{9, 5}
+	if h, _ := d.take(ctx, "fast"); h != 5 || d.inFlight[5] != "fast" {
+		t.Errorf("took %d, want 5 to ask again", h)
+	}
+	d.take(ctx, "fast")
+	if h, _ := d.take(ctx, "fast"); h != 1 || d.inFlight[1] != "fast" {
+		t.Errorf("took %d from the stalling peer, want 1", h)
+	}
+}
+
+// TestCatchUp checks that a node behind its peers downloads their blocks
+// into its chain, and leaves it alone when their chain forks below its
+// tip.
+func TestCatchUp(t *testing.T) {
+	chain := makeBlockchain(30, 1)
+	remote := httptest.NewServer(newRPCServer(chain, 1))
+	defer remote.Close()
+	manager, err := newPeerManager("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.AddPeer(remote.URL, peerStatic); err != nil {
+		t.Fatal(err)
+	}
+
+	local := newRPCServer(append([]*Block(nil), chain[:10]...), 1)
+	local.peers = manager
+	if err := catchUp(context.Background(), local, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := local.snapshot(); len(got) != len(chain) || validateChain(got, 1) != nil {
+		t.Fatalf("caught up to %d blocks, want %d", len(got), len(chain))
+	}
+	if err := catchUp(context.Background(), local, 1); err != nil {
+		t.Errorf("catching up at the peers' tip: %v", err)
+	}
+
+	forked := newRPCServer(extendChain(t, chain[:10], 1, "fork"), 1)
+	forked.peers = manager
+	if err := catchUp(context.Background(), forked, 1); err == nil {
+		t.Error("caught up across a fork")
+	}
+	if n := len(forked.snapshot()); n != 11 {
+		t.Errorf("forked node has %d blocks, want 11", n)
+	}
+}
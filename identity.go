@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Every node has an identity key, kept in node.key in its data directory
+// (see tls.go), and is known by its node ID, the hex public key. Before a
+// node trusts a peer's ID it asks the peer to prove it: the handshake RPC
+// takes a random challenge and returns the peer's ID with its signature
+// of the challenge, the ID and the network magic. A replayed answer fails
+// a fresh challenge, and an answer from another network fails the magic.
+
+// handshakeDomain separates handshake signatures from block signatures
+// made with the same kind of key.
+const handshakeDomain = "blockchain handshake v1\x00"
+
+// Handshake challenges are between these sizes, in bytes.
+const (
+	minChallengeBytes = 16
+	maxChallengeBytes = 64
+)
+
+// peerHandshakeInterval is how often the daemon handshakes with the known
+// peers it has not yet identified.
+const peerHandshakeInterval = time.Minute
+
+// Handshake is a node's answer to a handshake challenge.
+type Handshake struct {
+	NodeID    string `json:"node_id"`
+	Network   uint32 `json:"network_magic"`
+	Height    int    `json:"height"`
+	Signature string `json:"signature"`
+}
+
+// nodeID returns the ID of the node with identity key pub.
+func nodeID(pub ed25519.PublicKey) string {
+	return hex.EncodeToString(pub)
+}
+
+// handshakeMessage returns what a node signs to answer challenge.
+func handshakeMessage(challenge []byte, pub ed25519.PublicKey, magic uint32) []byte {
+	h := sha256.New()
+	h.Write([]byte(handshakeDomain))
+	h.Write(challenge)
+	h.Write(pub)
+	binary.Write(h, binary.BigEndian, magic)
+	return h.Sum(nil)
+}
+
+// callHandshake answers the handshake RPC.
+func (s *rpcServer) callHandshake(params []json.RawMessage) (any, error) {
+	if s.identity == nil {
+		return nil, &rpcError{Code: rpcInvalidRequest, Message: "this node has no identity key"}
+	}
+	var challengeHex string
+	if err := rpcArgs(params, &challengeHex); err != nil {
+		return nil, err
+	}
+	challenge, err := hex.DecodeString(challengeHex)
+	if err != nil || len(challenge) < minChallengeBytes || len(challenge) > maxChallengeBytes {
+		return nil, &rpcError{Code: rpcInvalidParam, Message: fmt.Sprintf("challenge must be %d to %d hex bytes", minChallengeBytes, maxChallengeBytes)}
+	}
+	return Handshake{
+		NodeID:    nodeID(s.identity.PublicKey),
+		Network:   s.magic,
+		Height:    s.tip().Index,
+		Signature: hex.EncodeToString(s.identity.Sign(handshakeMessage(challenge, s.identity.PublicKey, s.magic))),
+	}, nil
+}
+
+// handshake challenges the node and checks its answer: the signature must
+// be by the node ID it claims, for the client's network when it names one,
+// and by the pinned key when the client has one.
+func (c *rpcClient) handshake(ctx context.Context) (*Handshake, error) {
+	challenge := make([]byte, 32)
+	rand.Read(challenge)
+	var hs Handshake
+	if err := c.call(ctx, "handshake", &hs, hex.EncodeToString(challenge)); err != nil {
+		return nil, err
+	}
+	pub, err := hex.DecodeString(hs.NodeID)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: malformed node ID %q", ErrPeerIdentity, hs.NodeID)
+	}
+	sig, err := hex.DecodeString(hs.Signature)
+	if err != nil || !Verify(pub, handshakeMessage(challenge, pub, hs.Network), sig) {
+		return nil, fmt.Errorf("%w: bad handshake signature from %s", ErrPeerIdentity, hs.NodeID)
+	}
+	if c.magic != 0 && hs.Network != c.magic {
+		return nil, fmt.Errorf("peer runs network %08x, want %08x", hs.Network, c.magic)
+	}
+	if c.pin != nil && !c.pin.Equal(ed25519.PublicKey(pub)) {
+		return nil, fmt.Errorf("%w: node %s answered for pinned key %x", ErrPeerIdentity, hs.NodeID, c.pin)
+	}
+	return &hs, nil
+}
+
+// Identify records the node ID a peer proved in a handshake.
+func (m *PeerManager) Identify(url, id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p, ok := m.known[url]; ok {
+		p.NodeID = id
+		m.known[url] = p
+	}
+}
+
+// Forget drops a peer's URL.
+func (m *PeerManager) Forget(url string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.known, url)
+}
+
+// identifyPeers handshakes with every known peer not yet identified, every
+// interval until ctx is cancelled. A URL leading back to this node, as
+// mDNS can find, is dropped; a peer failing the proof is penalized.
+func identifyPeers(ctx context.Context, server *rpcServer, interval time.Duration) {
+	self := nodeID(server.identity.PublicKey)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, peer := range server.peers.Known() {
+			if peer.NodeID != "" || peer.Banned {
+				continue
+			}
+			client, err := newPeerClient(peer.URL)
+			if err != nil {
+				continue
+			}
+			client.magic = server.magic
+			callCtx, cancel := context.WithTimeout(ctx, interval)
+			hs, err := client.handshake(callCtx)
+			cancel()
+			switch {
+			case ctx.Err() != nil:
+				return
+			case err != nil:
+				server.logger.Warn("peer_handshake_failed", slog.String("url", peer.URL), slog.Any("error", err))
+				if errors.Is(err, ErrPeerIdentity) {
+					server.peers.Misbehaving(peer.URL, penaltyProtocol, err.Error())
+				}
+			case hs.NodeID == self:
+				server.peers.Forget(peer.URL)
+				server.logger.Info("peer_is_self", slog.String("url", peer.URL))
+			default:
+				server.peers.Identify(peer.URL, hs.NodeID)
+				server.bus.Publish(PeerConnected{Addr: peer.URL, NodeID: hs.NodeID})
+				server.logger.Info("peer_identified", slog.String("url", peer.URL), slog.String("peer_id", hs.NodeID), slog.Int("height", hs.Height))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
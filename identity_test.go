@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newIdentifiedNode serves a node with a fresh identity key.
+func newIdentifiedNode(t *testing.T, magic uint32) (*httptest.Server, *rpcServer) {
+	t.Helper()
+	s := newRPCServer(makeBlockchain(3, 1), 1)
+	key, err := NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.identity, s.magic = key, magic
+	node := httptest.NewServer(s)
+	t.Cleanup(node.Close)
+	return node, s
+}
+
+func TestHandshake(t *testing.T) {
+	ctx := context.Background()
+	node, s := newIdentifiedNode(t, 0x4d464274)
+	client := newRPCClient(node.URL)
+	client.magic = 0x4d464274
+	hs, err := client.handshake(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hs.NodeID != nodeID(s.identity.PublicKey) || hs.Height != 2 {
+		t.Errorf("handshake = %+v", hs)
+	}
+
+	client.pin = s.identity.PublicKey
+	if _, err := client.handshake(ctx); err != nil {
+		t.Errorf("handshake with the pinned key: %v", err)
+	}
+	other, _ := NewWallet()
+	client.pin = other.PublicKey
+	if _, err := client.handshake(ctx); !errors.Is(err, ErrPeerIdentity) {
+		t.Errorf("handshake with another pinned key: expected %v, got %v", ErrPeerIdentity, err)
+	}
+
+	// An answer replayed from an earlier challenge fails a new one
+	replayed := fmt.Sprintf(`{"jsonrpc":"2.0","result":{"node_id":%q,"network_magic":%d,"height":2,"signature":%q},"id":1}`,
+		hs.NodeID, hs.Network, hs.Signature)
+	replayer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(replayed))
+	}))
+	defer replayer.Close()
+	if _, err := newRPCClient(replayer.URL).handshake(ctx); !errors.Is(err, ErrPeerIdentity) {
+		t.Errorf("replayed handshake: expected %v, got %v", ErrPeerIdentity, err)
+	}
+
+	var resp struct {
+		Error *rpcError
+	}
+	for _, challenge := range []string{"abcd", strings.Repeat("zz", 32), strings.Repeat("00", maxChallengeBytes+1)} {
+		rpcPost(t, s, `{"jsonrpc":"2.0","method":"handshake","params":["`+challenge+`"],"id":1}`, &resp)
+		if resp.Error == nil || resp.Error.Code != rpcInvalidParam {
+			t.Errorf("challenge %q: %v", challenge, resp.Error)
+		}
+	}
+	anonymous := newRPCServer(makeBlockchain(2, 1), 1)
+	rpcPost(t, anonymous, `{"jsonrpc":"2.0","method":"handshake","params":["`+strings.Repeat("00", 32)+`"],"id":1}`, &resp)
+	if resp.Error == nil {
+		t.Error("a node without an identity key answered a handshake")
+	}
+}
+
+func TestIdentifyPeers(t *testing.T) {
+	peerNode, peer := newIdentifiedNode(t, 1)
+	selfNode, self := newIdentifiedNode(t, 1)
+	self.peers, _ = newPeerManager("")
+	self.peers.AddPeer(peerNode.URL, peerStatic)
+	self.peers.AddPeer(selfNode.URL, peerMDNS)
+	otherNet, _ := newIdentifiedNode(t, 2)
+	self.peers.AddPeer(otherNet.URL, peerAPI)
+
+	connected := self.bus.Subscribe(KindPeerConnected)
+	defer connected.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		identifyPeers(ctx, self, time.Hour)
+		close(done)
+	}()
+	byURL := func() map[string]KnownPeer {
+		known := make(map[string]KnownPeer)
+		for _, p := range self.peers.Known() {
+			known[p.URL] = p
+		}
+		return known
+	}
+	// The first round handshakes with every peer
+	for deadline := time.Now().Add(5 * time.Second); len(byURL()) != 2 || byURL()[peerNode.URL+"/"].NodeID == ""; {
+		if time.Now().After(deadline) {
+			t.Fatalf("known peers %+v", self.peers.Known())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	known := byURL()
+	if p := known[peerNode.URL+"/"]; p.NodeID != nodeID(peer.identity.PublicKey) {
+		t.Errorf("peer identified as %q", p.NodeID)
+	}
+	if p, ok := known[otherNet.URL+"/"]; !ok || p.NodeID != "" {
+		t.Errorf("node of another network: %+v", p)
+	}
+	if ev := (<-connected.C).(PeerConnected); ev.Addr != peerNode.URL+"/" || ev.NodeID != nodeID(peer.identity.PublicKey) || len(connected.C) != 0 {
+		t.Errorf("connected %+v, %d more", ev, len(connected.C))
+	}
+}
+
+func TestMetricsNodeID(t *testing.T) {
+	sample := metricsSample{Time: time.Unix(1, 0), Height: 7, NodeID: "ab12"}
+	var buf bytes.Buffer
+	writeInfluxLine(&buf, "blockchain", sample)
+	if !strings.HasPrefix(buf.String(), "blockchain,node_id=ab12 height=7i,") {
+		t.Errorf("influx line %q", buf.String())
+	}
+	buf.Reset()
+	writeGraphite(&buf, "blockchain", sample)
+	if !strings.HasPrefix(buf.String(), "blockchain.height;node_id=ab12 7 1\n") {
+		t.Errorf("graphite lines %q", buf.String())
+	}
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// DecodePolicy controls how chain input is checked while decoding. Strict
+// decoding is meant for untrusted input and rejects anything unexpected;
+// lenient decoding is meant for local files and reports problems as
+// warnings instead of failing.
+type DecodePolicy struct {
+	Strict bool
+
+	// Warn receives the problems tolerated in lenient mode. Nil discards them.
+	Warn func(DecodeWarning)
+
+	// Resource limits enforced while streaming the input: the decoder is
+	// never given more than MaxTotalBytes in all or MaxBlockBytes for one
+	// block, so oversized input fails before it is buffered. Zero means no
+	// limit.
+	MaxBlocks     int
+	MaxTotalBytes int64
+	MaxBlockBytes int64
+
+	// Trusted is the number of leading blocks already verified, for
+	// example up to a checkpoint. Their stored hashes are used as they are
+	// instead of being recomputed.
+	Trusted int
+
+	// TrustRedactions is set for input this node vouches for as a whole,
+	// such as its own store, whose redaction markers it wrote. Markers in
+	// other input fail strict decoding and, leniently, are kept but not
+	// trusted: see committedHash.
+	TrustRedactions bool
+}
+
+// ErrImportLimit is returned when chain input exceeds a DecodePolicy limit.
+var ErrImportLimit = errors.New("import limit exceeded")
+
+// defaultDecodeLimits are the limits of the commands reading chain files
+// from elsewhere. They leave room for any chain of default-sized blocks a
+// node could hold while keeping a hostile file from exhausting memory.
+var defaultDecodeLimits = DecodePolicy{MaxBlocks: 10_000_000, MaxTotalBytes: 16 << 30, MaxBlockBytes: 4 << 20}
+
+// addDecodeLimitFlags registers -max-blocks, -max-input-bytes and
+// -max-block-bytes on fs, defaulting to defaultDecodeLimits. The policy
+// returned holds only the limits; check it with checkLimits once the
+// flags are parsed.
+func addDecodeLimitFlags(fs *flag.FlagSet) *DecodePolicy {
+	limits := defaultDecodeLimits
+	fs.IntVar(&limits.MaxBlocks, "max-blocks", limits.MaxBlocks, "most blocks to read from the file, 0 for no limit")
+	fs.Int64Var(&limits.MaxTotalBytes, "max-input-bytes", limits.MaxTotalBytes, "most bytes to read from the file after decompression, 0 for no limit")
+	fs.Int64Var(&limits.MaxBlockBytes, "max-block-bytes", limits.MaxBlockBytes, "most bytes one encoded block may take in the file, 0 for no limit")
+	return &limits
+}
+
+// checkLimits rejects negative limits.
+func (p DecodePolicy) checkLimits() error {
+	if p.MaxBlocks < 0 || p.MaxTotalBytes < 0 || p.MaxBlockBytes < 0 {
+		return errors.New("decode limits must not be negative")
+	}
+	return nil
+}
+
+// DecodeWarning is a problem tolerated while decoding in lenient mode.
+type DecodeWarning struct {
+	Index   int
+	Message string
+}
+
+func (w DecodeWarning) String() string {
+	return fmt.Sprintf("block %d: %s", w.Index, w.Message)
+}
+
+// report fails in strict mode and warns otherwise.
+func (p DecodePolicy) report(index int, format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	if p.Strict {
+		return fmt.Errorf("block %d: %s", index, msg)
+	}
+	if p.Warn != nil {
+		p.Warn(DecodeWarning{Index: index, Message: msg})
+	}
+	return nil
+}
+
+// FieldDiff is a single field whose stored value disagrees with the value
+// derived from the canonical encoding.
+type FieldDiff struct {
+	Field     string
+	Stored    string
+	Canonical string
+}
+
+// ImportMismatchError reports the first block of an imported chain whose
+// stored fields are inconsistent with the canonical binary serialization,
+// typically because the JSON was edited by hand.
+type ImportMismatchError struct {
+	Index int
+	Diffs []FieldDiff
+}
+
+func (e *ImportMismatchError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "block %d: stored fields disagree with canonical encoding", e.Index)
+	for _, d := range e.Diffs {
+		fmt.Fprintf(&b, "\n  %s: stored %s, canonical %s", d.Field, d.Stored, d.Canonical)
+	}
+	return b.String()
+}
+
+// Unwrap exposes the validation error classes the diff corresponds to.
+func (e *ImportMismatchError) Unwrap() []error {
+	var errs []error
+	for _, d := range e.Diffs {
+		switch d.Field {
+		case "hash", "merkle_root":
+			errs = append(errs, ErrHashMismatch)
+		case "prev_hash":
+			errs = append(errs, ErrBrokenLink)
+		}
+	}
+	return errs
+}
+
+// readChainJSON loads a chain written by writeChainJSON, decoding it under
+// the given policy, and checks that every block is consistent with the
+// canonical serializer before returning.
+func readChainJSON(path string, policy DecodePolicy) ([]*Block, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	chain, err := decodeChainJSON(f, policy)
+	if err != nil {
+		return nil, &ChainDecodeError{Path: path, Err: err}
+	}
+
+	if err := verifyCanonicalHashes(chain, policy.Trusted); err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// decodeChainJSON decodes a JSON array of blocks one element at a time,
+// enforcing the policy's resource limits as it goes and checking each
+// block's fields.
+func decodeChainJSON(r io.Reader, policy DecodePolicy) ([]*Block, error) {
+	r, elems := policy.limitReader(r)
+	dec := json.NewDecoder(r)
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, errors.New("expected a JSON array of blocks")
+	}
+	more := func() bool {
+		elems.next(dec.InputOffset())
+		return dec.More()
+	}
+
+	var chain []*Block
+	for i := 0; more(); i++ {
+		if policy.MaxBlocks > 0 && i >= policy.MaxBlocks {
+			return nil, fmt.Errorf("%w: more than %d blocks", ErrImportLimit, policy.MaxBlocks)
+		}
+
+		var msg json.RawMessage
+		if err := dec.Decode(&msg); err != nil {
+			return nil, fmt.Errorf("block %d: %w", i, err)
+		}
+
+		block, err := decodeBlockJSON(msg, i, policy)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, block)
+	}
+
+	// Consume the closing bracket so truncated input is reported
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// limitedReader fails with ErrImportLimit once more than limit bytes have
+// been read, unlike io.LimitReader which silently reports EOF.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	limit     int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// Input of exactly the limit is fine; only fail if more follows
+		var probe [1]byte
+		if n, err := l.r.Read(probe[:]); n == 0 {
+			return 0, err
+		}
+		return 0, fmt.Errorf("%w: input exceeds %d bytes", ErrImportLimit, l.limit)
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// limitReader wraps r in the readers enforcing the policy's byte limits.
+// The elementReader returned, nil without MaxBlockBytes, must be moved on
+// to each block with next.
+func (p DecodePolicy) limitReader(r io.Reader) (io.Reader, *elementReader) {
+	if p.MaxTotalBytes > 0 {
+		r = &limitedReader{r: r, remaining: p.MaxTotalBytes, limit: p.MaxTotalBytes}
+	}
+	if p.MaxBlockBytes <= 0 {
+		return r, nil
+	}
+	elems := &elementReader{r: r, end: p.MaxBlockBytes, limit: p.MaxBlockBytes}
+	return elems, elems
+}
+
+// elementReader keeps a JSON decoder from reading more than limit bytes
+// past the offset given to next, where the current block starts. A block
+// over the limit thus fails once limit bytes of it are buffered, instead
+// of being read whole first.
+type elementReader struct {
+	r     io.Reader
+	read  int64 // bytes handed to the decoder
+	end   int64 // offset the decoder may not read past
+	limit int64
+}
+
+// next allows limit bytes after offset. It does nothing on a nil reader.
+func (e *elementReader) next(offset int64) {
+	if e != nil {
+		e.end = offset + e.limit
+	}
+}
+
+func (e *elementReader) Read(p []byte) (int, error) {
+	room := e.end - e.read
+	if room <= 0 {
+		return 0, fmt.Errorf("%w: block exceeds %d bytes", ErrImportLimit, e.limit)
+	}
+	if int64(len(p)) > room {
+		p = p[:room]
+	}
+	n, err := e.r.Read(p)
+	e.read += int64(n)
+	return n, err
+}
+
+// decodeBlockJSON decodes a single block found at position i of a chain.
+func decodeBlockJSON(msg []byte, i int, policy DecodePolicy) (*Block, error) {
+	if bytes.Equal(bytes.TrimSpace(msg), []byte("null")) {
+		return nil, fmt.Errorf("block %d: block is null", i)
+	}
+
+	block := new(Block)
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(block); err != nil {
+		// Only unknown fields are tolerated; malformed blocks always fail
+		if !strings.HasPrefix(err.Error(), "json: unknown field") {
+			return nil, fmt.Errorf("block %d: %w", i, err)
+		}
+		if err := policy.report(i, "%v", err); err != nil {
+			return nil, err
+		}
+		block = new(Block)
+		if err := json.Unmarshal(msg, block); err != nil {
+			return nil, fmt.Errorf("block %d: %w", i, err)
+		}
+	}
+
+	if err := checkBlockFields(block, i, policy); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// checkBlockFields checks field lengths and ranges. Missing byte fields are
+// normalized to empty slices, which serialize identically. Blocks beyond
+// blockLimits are refused even in lenient mode.
+func checkBlockFields(block *Block, i int, policy DecodePolicy) error {
+	if block.Data == nil {
+		block.Data = []byte{}
+	}
+	if block.PrevHash == nil {
+		block.PrevHash = []byte{}
+	}
+	if err := checkBlockSize(block); err != nil {
+		return err
+	}
+
+	var problems []string
+	if block.Index < 0 {
+		problems = append(problems, fmt.Sprintf("negative index %d", block.Index))
+	}
+	if block.Timestamp < 0 {
+		problems = append(problems, fmt.Sprintf("negative timestamp %d", block.Timestamp))
+	}
+	if block.Bits&0x00800000 != 0 {
+		problems = append(problems, fmt.Sprintf("negative target in bits %08x", block.Bits))
+	}
+	if _, err := hasherByID(block.HashAlgo); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if block.Redaction != nil {
+		if len(block.Data) != 0 {
+			// Data that no hash covers is never tolerated
+			return fmt.Errorf("block %d: redacted block still has data", i)
+		}
+		if policy.TrustRedactions {
+			block.Redaction.trusted = true
+		} else {
+			problems = append(problems, "redaction marker from outside this node's store")
+		}
+	}
+	if block.MerkleRoot != nil && len(block.MerkleRoot) != sha256.Size {
+		problems = append(problems, fmt.Sprintf("merkle_root is %d bytes, want %d", len(block.MerkleRoot), sha256.Size))
+	}
+	if block.ExtraNonce != 0 && block.MerkleRoot == nil {
+		problems = append(problems, "extra_nonce is set on a block without a merkle_root")
+	}
+	if block.Epoch != nil && len(block.Epoch.Hash) != sha256.Size {
+		problems = append(problems, fmt.Sprintf("epoch hash is %d bytes, want %d", len(block.Epoch.Hash), sha256.Size))
+	}
+	if block.Signature != nil && len(block.Signature) != ed25519.SignatureSize {
+		problems = append(problems, fmt.Sprintf("signature is %d bytes, want %d", len(block.Signature), ed25519.SignatureSize))
+	}
+	if len(block.Hash) != sha256.Size {
+		problems = append(problems, fmt.Sprintf("hash is %d bytes, want %d", len(block.Hash), sha256.Size))
+	}
+	if i == 0 && len(block.PrevHash) != 0 {
+		problems = append(problems, "genesis block has a previous hash")
+	}
+	if i > 0 && len(block.PrevHash) != sha256.Size {
+		problems = append(problems, fmt.Sprintf("prev_hash is %d bytes, want %d", len(block.PrevHash), sha256.Size))
+	}
+
+	for _, p := range problems {
+		if err := policy.report(i, "%s", p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyCanonicalHashes recomputes every block hash and compares the stored
+// index, previous hash, hash and Merkle root against their canonical values. It returns
+// an *ImportMismatchError describing the first inconsistent block. The
+// first trusted blocks only have their index and links checked.
+func verifyCanonicalHashes(chain []*Block, trusted int) error {
+	var prevHash []byte
+	for i, block := range chain {
+		if block == nil {
+			return fmt.Errorf("block at position %d is null", i)
+		}
+
+		var diffs []FieldDiff
+		if block.Index != i {
+			diffs = append(diffs, FieldDiff{
+				Field:     "index",
+				Stored:    fmt.Sprint(block.Index),
+				Canonical: fmt.Sprint(i),
+			})
+		}
+		if i > 0 && !bytes.Equal(block.PrevHash, prevHash) {
+			diffs = append(diffs, FieldDiff{
+				Field:     "prev_hash",
+				Stored:    fmt.Sprintf("%x", block.PrevHash),
+				Canonical: fmt.Sprintf("%x", prevHash),
+			})
+		}
+
+		hash := block.Hash
+		if i >= trusted {
+			hash = committedHash(block)
+			if block.MerkleRoot != nil && block.Redaction == nil {
+				if root := dataMerkleRoot(block.HashAlgo, block.Data); !bytes.Equal(block.MerkleRoot, root) {
+					diffs = append(diffs, FieldDiff{
+						Field:     "merkle_root",
+						Stored:    fmt.Sprintf("%x", block.MerkleRoot),
+						Canonical: fmt.Sprintf("%x", root),
+					})
+				}
+			}
+		}
+		if !bytes.Equal(block.Hash, hash) {
+			diffs = append(diffs, FieldDiff{
+				Field:     "hash",
+				Stored:    fmt.Sprintf("%x", block.Hash),
+				Canonical: fmt.Sprintf("%x", hash),
+			})
+		}
+
+		if len(diffs) > 0 {
+			return &ImportMismatchError{Index: i, Diffs: diffs}
+		}
+		prevHash = hash
+	}
+	return nil
+}
+
+// importChain reads a chain file in any supported format and fully
+// validates it, checking structure, hash links and seals as engine does,
+// before returning it.
+func importChain(path string, engine Engine, policy DecodePolicy) ([]*Block, error) {
+	format, _ := chainFileFormat(path)
+	return importChainAs(path, format, engine, policy)
+}
+
+// importChainAs is importChain for a file in the given format.
+func importChainAs(path string, format chainFormat, engine Engine, policy DecodePolicy) ([]*Block, error) {
+	chain, err := readChainFileAs(path, format, policy)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("%s contains no blocks", path)
+	}
+	if err := validateChainWith(chain, engine); err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// runValidate implements the validate subcommand, an offline audit of an
+// exported chain file. It reports every problem found, not just the first.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	file := fs.String("file", "", "chain file to validate: .json, .jsonl, .pb or .cbor, optionally .gz (required)")
+	fs.Int("difficulty", 4, "proof-of-work difficulty the chain was mined at")
+	strict := fs.Bool("strict", false, "fail on unknown fields and other tolerated problems")
+	dataDir := fs.String("datadir", "", "data directory holding an imported chain (instead of -file)")
+	full := fs.Bool("full", false, "with -datadir, revalidate every block instead of those after the checkpoint")
+	network, paramsPath := addChainFlags(fs)
+	policy := addDecodeLimitFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return flagError(err)
+	}
+	if (*file == "") == (*dataDir == "") {
+		return usage("Usage: blockchain validate (-file chain.json | -datadir dir [-full]) [-difficulty n] [-network name] [-params file] [-strict]")
+	}
+	if err := policy.checkLimits(); err != nil {
+		return failCode(exitConfig, err)
+	}
+	params, engine, err := chainEngineFlags(fs, *network, *paramsPath)
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+	policy.Strict = *strict
+	policy.Warn = func(w DecodeWarning) {
+		fmt.Printf("Warning: %v\n", w)
+	}
+
+	var chain []*Block
+	var report *ValidationReport
+	if *dataDir != "" {
+		chain, report, err = loadStoredChain(*dataDir, engine, *full)
+	} else {
+		chain, err = readChainFile(*file, *policy)
+		if err == nil && len(chain) == 0 {
+			err = fmt.Errorf("%s contains no blocks", *file)
+		}
+		if err == nil {
+			report = validateChainReportFrom(chain, engine, 1)
+		}
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	if !report.Valid() {
+		fmt.Printf("Chain is invalid (%d blocks, %d problems):\n", report.Blocks, len(report.Problems))
+		for _, problem := range report.Problems {
+			fmt.Printf("- %v\n", problem)
+		}
+		return failReported(report.Err())
+	}
+	if params.Consensus == ConsensusPoS {
+		fmt.Printf("Chain is valid (%d blocks, proof-of-stake)\n", len(chain))
+	} else {
+		fmt.Printf("Chain is valid (%d blocks, difficulty %d)\n", len(chain), params.Difficulty)
+	}
+	if report.Skipped > 0 {
+		fmt.Printf("Checked the %d blocks after the checkpoint at height %d; use -full to check all\n",
+			len(chain)-1-report.Skipped, report.Skipped)
+	}
+	if len(report.Redacted) > 0 {
+		fmt.Printf("%d blocks are redacted; their data cannot be checked: %v\n", len(report.Redacted), report.Redacted)
+	}
+	return 0
+}
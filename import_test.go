@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestReadChainJSON_RoundTrip checks that an exported chain imports cleanly.
+func TestReadChainJSON_RoundTrip(t *testing.T) {
+	chain := makeBlockchain(4, 1)
+	path := filepath.Join(t.TempDir(), "chain.json")
+	if err := writeChainJSON(chain, path); err != nil {
+		t.Fatal(err)
+	}
+
+	imported, err := readChainJSON(path, DecodePolicy{})
+	if err != nil {
+		t.Fatalf("readChainJSON failed: %v", err)
+	}
+	if len(imported) != len(chain) {
+		t.Fatalf("expected %d blocks, got %d", len(chain), len(imported))
+	}
+	if !isChainValidCached(imported, 1) {
+		t.Error("imported chain should still be valid")
+	}
+}
+
+// TestReadChainJSON_HandEdited verifies that an edited block is reported with a field-level diff.
+func TestReadChainJSON_HandEdited(t *testing.T) {
+	chain := makeBlockchain(4, 1)
+	chain[2].Data = []byte("edited by hand")
+
+	path := filepath.Join(t.TempDir(), "chain.json")
+	if err := writeChainJSON(chain, path); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := readChainJSON(path, DecodePolicy{})
+	var mismatch *ImportMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected ImportMismatchError, got %v", err)
+	}
+	if mismatch.Index != 2 {
+		t.Errorf("expected first mismatch at block 2, got %d", mismatch.Index)
+	}
+	// The header commits to the data through its Merkle root, so only the root disagrees
+	if len(mismatch.Diffs) != 1 || mismatch.Diffs[0].Field != "merkle_root" {
+		t.Errorf("expected a single merkle_root diff, got %+v", mismatch.Diffs)
+	}
+	if !errors.Is(err, ErrHashMismatch) {
+		t.Error("expected mismatch to match ErrHashMismatch")
+	}
+	if !strings.Contains(err.Error(), "merkle_root: stored") {
+		t.Errorf("error does not describe the diff: %v", err)
+	}
+}
+
+// TestDecodeChainJSON_Policies checks that strict mode rejects what lenient mode only warns about.
+func TestDecodeChainJSON_Policies(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+	}{
+		{"unknown field", `[{"index":0,"hash":"` + strings.Repeat("A", 43) + `=","extra":true}]`},
+		{"short hash", `[{"index":0,"hash":"AAAA"}]`},
+		{"extra nonce without merkle root", `[{"index":0,"extra_nonce":1,"hash":"` + strings.Repeat("A", 43) + `="}]`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := decodeChainJSON(strings.NewReader(tc.input), DecodePolicy{Strict: true}); err == nil {
+				t.Error("strict decoding should reject the input")
+			}
+
+			var warnings []DecodeWarning
+			lenient := DecodePolicy{Warn: func(w DecodeWarning) { warnings = append(warnings, w) }}
+			chain, err := decodeChainJSON(strings.NewReader(tc.input), lenient)
+			if err != nil {
+				t.Fatalf("lenient decoding failed: %v", err)
+			}
+			if len(chain) != 1 || len(warnings) != 1 {
+				t.Errorf("expected 1 block and 1 warning, got %d blocks and %v", len(chain), warnings)
+			}
+		})
+	}
+}
+
+// TestDecodeChainJSON_Malformed ensures malformed blocks fail even in lenient mode.
+func TestDecodeChainJSON_Malformed(t *testing.T) {
+	for _, input := range []string{`[{"index":"zero"}]`, `[null]`, `{"index":0}`, `[{"index":0,"nonce":-1}]`} {
+		if _, err := decodeChainJSON(strings.NewReader(input), DecodePolicy{}); err == nil {
+			t.Errorf("expected %s to be rejected", input)
+		}
+	}
+}
+
+// TestDecodeChainJSON_Limits checks that each resource guard stops an oversized import.
+func TestDecodeChainJSON_Limits(t *testing.T) {
+	chain := makeBlockchain(5, 1)
+	chain[3].Data = bytes.Repeat([]byte("x"), 4096)
+	chain[3].Hash = calculateHash(chain[3])
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(chain); err != nil {
+		t.Fatal(err)
+	}
+	input := buf.Bytes()
+
+	cases := []struct {
+		name   string
+		policy DecodePolicy
+	}{
+		{"max blocks", DecodePolicy{MaxBlocks: 4}},
+		{"max total bytes", DecodePolicy{MaxTotalBytes: int64(len(input)) / 2}},
+		{"max block bytes", DecodePolicy{MaxBlockBytes: 1024}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := decodeChainJSON(bytes.NewReader(input), tc.policy)
+			if !errors.Is(err, ErrImportLimit) {
+				t.Fatalf("expected ErrImportLimit, got %v", err)
+			}
+		})
+	}
+
+	exact := DecodePolicy{MaxBlocks: 5, MaxTotalBytes: int64(len(input)), MaxBlockBytes: 8192}
+	decoded, err := decodeChainJSON(bytes.NewReader(input), exact)
+	if err != nil {
+		t.Fatalf("input within limits was rejected: %v", err)
+	}
+	if len(decoded) != 5 {
+		t.Errorf("expected 5 blocks, got %d", len(decoded))
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// TestDecodeChainJSON_BlockLimitBeforeBuffering feeds a block that never
+// ends: the block limit must stop the decoder once it has read that much,
+// for arrays and JSON Lines alike.
+func TestDecodeChainJSON_BlockLimitBeforeBuffering(t *testing.T) {
+	const limit = 4096
+	endless := func(prefix string) *countingReader {
+		return &countingReader{r: io.MultiReader(strings.NewReader(prefix), repeatReader('A'))}
+	}
+
+	r := endless(`[{"index": 0, "data": "`)
+	if _, err := decodeChainJSON(r, DecodePolicy{MaxBlockBytes: limit}); !errors.Is(err, ErrImportLimit) {
+		t.Fatalf("expected ErrImportLimit, got %v", err)
+	}
+	if r.n > limit+1 {
+		t.Errorf("read %d bytes with a %d byte block limit", r.n, limit)
+	}
+
+	r = endless(`{"index": 0, "data": "`)
+	if err := decodeChainJSONL(r, DecodePolicy{MaxBlockBytes: limit}, func(*Block) error { return nil }); !errors.Is(err, ErrImportLimit) {
+		t.Fatalf("expected ErrImportLimit from JSON Lines, got %v", err)
+	}
+	if r.n > limit {
+		t.Errorf("read %d bytes of JSON Lines with a %d byte block limit", r.n, limit)
+	}
+}
+
+// repeatReader reads as an endless run of one byte.
+type repeatReader byte
+
+func (b repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(b)
+	}
+	return len(p), nil
+}
+
+// TestImportChain_ValidatesProofOfWork checks that importing applies full
+// chain validation, not just structural checks.
+func TestImportChain_ValidatesProofOfWork(t *testing.T) {
+	chain := makeBlockchain(4, 1)
+	path := filepath.Join(t.TempDir(), "chain.jsonl")
+	if err := writeChainFile(chain, path); err != nil {
+		t.Fatal(err)
+	}
+
+	imported, err := importChain(path, &PoWEngine{Difficulty: 1}, DecodePolicy{Strict: true})
+	if err != nil {
+		t.Fatalf("importChain failed: %v", err)
+	}
+	if len(imported) != len(chain) {
+		t.Fatalf("expected %d blocks, got %d", len(chain), len(imported))
+	}
+
+	// The blocks are internally consistent but were not mined hard enough
+	if _, err := importChain(path, &PoWEngine{Difficulty: 8}, DecodePolicy{}); !errors.Is(err, ErrInsufficientWork) {
+		t.Errorf("expected ErrInsufficientWork, got %v", err)
+	}
+}
+
+// TestRunValidate checks the exit codes of the validate subcommand.
+func TestRunValidate(t *testing.T) {
+	chain := makeBlockchain(3, 1)
+	path := filepath.Join(t.TempDir(), "chain.json.gz")
+	if err := writeChainFile(chain, path); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := runValidate([]string{"-file", path, "-difficulty", "1"}); code != 0 {
+		t.Errorf("valid chain: expected exit code 0, got %d", code)
+	}
+	if code := runValidate([]string{"-file", path, "-difficulty", "8"}); code != exitValidation {
+		t.Errorf("underworked chain: expected exit code %d, got %d", exitValidation, code)
+	}
+	if code := runValidate(nil); code != 2 {
+		t.Errorf("missing -file: expected exit code 2, got %d", code)
+	}
+}
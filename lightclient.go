@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// lightClientReorgWindow is how far below its tip a light client asks
+// peers for headers, so that it notices forks up to that depth.
+const lightClientReorgWindow = 100
+
+// LightClient follows a chain by its headers alone, for consumers that
+// cannot store or check block data. It validates the linkage and
+// proof-of-work of every header, keeps the branch with the most work among
+// those its peers offer, and checks Merkle proofs of block data against
+// that branch.
+type LightClient struct {
+	difficulty int
+	peers      *PeerManager // nil leaves peers unscored
+	bus        *EventBus    // nil publishes no reorgs
+
+	mu      sync.RWMutex
+	headers []*BlockHeader // best chain, headers[i] at height i
+	work    []*big.Int     // cumulative work of headers[:i+1]
+}
+
+// NewLightClient returns a client trusting genesis, the header of the
+// chain's first block, and checking later headers at difficulty.
+func NewLightClient(genesis *BlockHeader, difficulty int) *LightClient {
+	return &LightClient{
+		difficulty: difficulty,
+		headers:    []*BlockHeader{genesis},
+		work:       []*big.Int{new(big.Int)},
+	}
+}
+
+// UsePeers has the client record its peers' latency and misbehavior in
+// m, and skip the peers m has banned.
+func (c *LightClient) UsePeers(m *PeerManager) {
+	c.peers = m
+}
+
+// UseBus has the client publish ChainReorged on bus when a branch with
+// more work replaces blocks of its best chain.
+func (c *LightClient) UseBus(bus *EventBus) {
+	c.bus = bus
+}
+
+// Tip returns the last header of the best chain.
+func (c *LightClient) Tip() *BlockHeader {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.headers[len(c.headers)-1]
+}
+
+// TotalWork returns the cumulative work of the best chain.
+func (c *LightClient) TotalWork() *big.Int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return new(big.Int).Set(c.work[len(c.work)-1])
+}
+
+// Header returns the header at height on the best chain, or nil.
+func (c *LightClient) Header(height int) *BlockHeader {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if height < 0 || height >= len(c.headers) {
+		return nil
+	}
+	return c.headers[height]
+}
+
+// AddHeaders offers a run of consecutive headers, such as a peer's
+// getheaders reply. The first must follow a header on the best chain. The
+// run is validated, and the branch it forms replaces the best chain if it
+// has more work. AddHeaders reports whether the best chain changed.
+func (c *LightClient) AddHeaders(headers []*BlockHeader) (bool, error) {
+	if len(headers) == 0 {
+		return false, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fork := headers[0].Index
+	if fork < 1 || fork > len(c.headers) || !bytes.Equal(headers[0].PrevHash, c.headers[fork-1].Hash) {
+		return false, fmt.Errorf("headers from height %d do not connect to the best chain", fork)
+	}
+	if err := validateHeaders(append([]*BlockHeader{c.headers[fork-1]}, headers...), c.difficulty); err != nil {
+		return false, err
+	}
+	// Peers resend headers the client has; the branch starts at the
+	// first one it does not
+	for _, h := range headers {
+		if h.Index < len(c.headers) && bytes.Equal(h.Hash, c.headers[h.Index].Hash) {
+			continue
+		}
+		if err := checkCheckpointFork(h.Index, len(c.headers)-1); err != nil {
+			return false, err
+		}
+		break
+	}
+
+	work := make([]*big.Int, len(headers))
+	total := c.work[fork-1]
+	for i, h := range headers {
+		total = new(big.Int).Add(total, blockWork(h.withBody(nil), c.difficulty))
+		work[i] = total
+	}
+	if total.Cmp(c.work[len(c.work)-1]) <= 0 {
+		return false, nil
+	}
+	if removed := len(c.headers) - fork; removed > 0 {
+		c.bus.Publish(ChainReorged{
+			Fork:    fork - 1,
+			OldTip:  c.headers[len(c.headers)-1].Hash,
+			NewTip:  headers[len(headers)-1].Hash,
+			Removed: removed,
+			Added:   len(headers),
+		})
+	}
+	c.headers = append(c.headers[:fork:fork], headers...)
+	c.work = append(c.work[:fork:fork], work...)
+	return true, nil
+}
+
+// VerifyProof checks that proof's chunk is included in the block at
+// height on the best chain.
+func (c *LightClient) VerifyProof(height int, proof *MerkleProof) error {
+	header := c.Header(height)
+	if header == nil {
+		return fmt.Errorf("no header at height %d", height)
+	}
+	return verifyChunkProof(header, proof)
+}
+
+// Sync fetches headers from every peer until each has nothing better to
+// offer. A peer that serves invalid headers or fails is skipped; the
+// errors of all peers are returned joined. With a peer manager, banned
+// peers are not asked, and a peer serving invalid headers is banned.
+func (c *LightClient) Sync(ctx context.Context, peers []*rpcClient) error {
+	var errs []error
+	for _, peer := range peers {
+		if c.peers != nil && c.peers.Banned(peer.url) {
+			continue
+		}
+		if err := c.syncPeer(ctx, peer); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", peer.url, err))
+			if c.peers == nil {
+				continue
+			}
+			var invalid *BlockValidationError
+			if errors.As(err, &invalid) {
+				if _, err := c.peers.Misbehaving(peer.url, penaltyInvalidBlock, err.Error()); err != nil {
+					errs = append(errs, fmt.Errorf("saving the ban list: %w", err))
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// syncPeer fetches the peer's headers from just below the tip and offers
+// them as one run, so a competing branch is weighed as a whole rather than
+// batch by batch.
+func (c *LightClient) syncPeer(ctx context.Context, peer *rpcClient) error {
+	var peerTip int
+	began := time.Now()
+	if err := peer.call(ctx, "getblockcount", &peerTip); err != nil {
+		return err
+	}
+	if c.peers != nil {
+		c.peers.RecordLatency(peer.url, time.Since(began))
+	}
+	var run []*BlockHeader
+	for start := max(1, c.Tip().Index+1-lightClientReorgWindow); start <= peerTip; {
+		var headers []*BlockHeader
+		if err := peer.call(ctx, "getheaders", &headers, start, maxHeadersPerCall); err != nil {
+			return err
+		}
+		if len(headers) == 0 {
+			break
+		}
+		run = append(run, headers...)
+		start = headers[len(headers)-1].Index + 1
+	}
+	_, err := c.AddHeaders(run)
+	return err
+}
+
+// FetchProof asks peer, a full node, for the proof of a data chunk of the
+// block at height and verifies it against the best chain. It returns the
+// chunk once verified.
+func (c *LightClient) FetchProof(ctx context.Context, peer *rpcClient, height, chunk int) ([]byte, error) {
+	proof, err := peer.proof(ctx, height, chunk)
+	if err != nil {
+		return nil, err
+	}
+	if proof.Index != chunk {
+		return nil, fmt.Errorf("peer sent the proof of chunk %d, want %d", proof.Index, chunk)
+	}
+	if err := c.VerifyProof(height, proof); err != nil {
+		return nil, err
+	}
+	return proof.Chunk, nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// extendChain mines n blocks on top of chain, returning a new slice.
+func extendChain(t *testing.T, chain []*Block, n int, tag string) []*Block {
+	t.Helper()
+	out := append([]*Block(nil), chain...)
+	for i := 0; i < n; i++ {
+		block, _, err := mineBlock(context.Background(), out[len(out)-1], fmt.Sprintf("%s %d", tag, i), 1, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, block)
+	}
+	return out
+}
+
+func TestLightClientBestChain(t *testing.T) {
+	base := makeBlockchain(2, 1)
+	short := extendChain(t, base, 2, "short")
+	long := extendChain(t, base, 4, "long")
+
+	client := NewLightClient(base[0].Header(), 1)
+	headersOf := func(chain []*Block, from int) []*BlockHeader {
+		var headers []*BlockHeader
+		for _, block := range chain[from:] {
+			headers = append(headers, block.Header())
+		}
+		return headers
+	}
+
+	if changed, err := client.AddHeaders(headersOf(short, 1)); err != nil || !changed {
+		t.Fatalf("AddHeaders(short) = %v, %v", changed, err)
+	}
+	// The competing branch is only adopted once it has more work
+	if changed, err := client.AddHeaders(headersOf(long, 2)[:2]); err != nil || changed {
+		t.Fatalf("AddHeaders(equal work branch) = %v, %v", changed, err)
+	}
+	if changed, err := client.AddHeaders(headersOf(long, 2)); err != nil || !changed {
+		t.Fatalf("AddHeaders(long) = %v, %v", changed, err)
+	}
+	if tip := client.Tip(); !bytes.Equal(tip.Hash, long[len(long)-1].Hash) {
+		t.Errorf("tip is block %d %x, want the long branch", tip.Index, tip.Hash)
+	}
+	if got, want := client.TotalWork(), chainWork(long, 1); got.Cmp(want) != 0 {
+		t.Errorf("total work %s, want %s", got, want)
+	}
+
+	forged := *long[3].Header()
+	forged.Nonce++
+	if _, err := client.AddHeaders([]*BlockHeader{long[2].Header(), &forged}); err == nil {
+		t.Error("forged header accepted")
+	}
+	if _, err := client.AddHeaders([]*BlockHeader{long[3].Header(), long[5].Header()}); err == nil {
+		t.Error("headers that skip a height accepted")
+	}
+
+	// A header that links to its parent but claims a later height would
+	// leave the client's headers out of step with their heights
+	parent := *long[5]
+	parent.Index = 9
+	skipped := mustMine(t, &parent, "skipped")
+	if skipped.Index != 10 || !bytes.Equal(skipped.PrevHash, long[5].Hash) {
+		t.Fatalf("mined block %d on %x", skipped.Index, skipped.PrevHash)
+	}
+	if _, err := client.AddHeaders([]*BlockHeader{long[5].Header(), skipped.Header()}); !errors.Is(err, ErrBadHeight) {
+		t.Errorf("header claiming height 10 after height 5: %v, want %v", err, ErrBadHeight)
+	}
+	if tip := client.Tip(); !bytes.Equal(tip.Hash, long[5].Hash) {
+		t.Errorf("tip is block %d after a bad header", tip.Index)
+	}
+}
+
+func TestLightClientSync(t *testing.T) {
+	base := makeBlockchain(2, 1)
+	short := extendChain(t, base, 1, "short")
+	long := extendChain(t, base, 3, "long")
+	data := strings.Repeat("y", dataChunkSize) + "proven"
+	long = append(long, mustMine(t, long[len(long)-1], data))
+
+	shortNode := httptest.NewServer(newRPCServer(short, 1))
+	defer shortNode.Close()
+	longNode := httptest.NewServer(newRPCServer(long, 1))
+	defer longNode.Close()
+	peers := []*rpcClient{newRPCClient(longNode.URL), newRPCClient(shortNode.URL)}
+
+	client := NewLightClient(base[0].Header(), 1)
+	if err := client.Sync(context.Background(), peers); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got := client.Tip().Index; got != len(long)-1 {
+		t.Fatalf("synced to height %d, want %d", got, len(long)-1)
+	}
+
+	ctx := context.Background()
+	chunk, err := client.FetchProof(ctx, peers[0], len(long)-1, 1)
+	if err != nil {
+		t.Fatalf("FetchProof: %v", err)
+	}
+	if string(chunk) != "proven" {
+		t.Errorf("proven chunk %q", chunk)
+	}
+	// The short node's block at that height is not on the best chain
+	if _, err := client.FetchProof(ctx, peers[1], 2, 0); err == nil {
+		t.Error("proof from a stale branch accepted")
+	}
+}
+
+func mustMine(t *testing.T, prev *Block, data string) *Block {
+	t.Helper()
+	block, _, err := mineBlock(context.Background(), prev, data, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestLightClientBansPeers(t *testing.T) {
+	base := makeBlockchain(2, 1)
+	forged := extendChain(t, base, 2, "forged")
+	bad := *forged[3]
+	bad.Nonce++
+	forged[3] = &bad
+
+	badNode := httptest.NewServer(newRPCServer(forged, 1))
+	defer badNode.Close()
+	goodNode := httptest.NewServer(newRPCServer(extendChain(t, base, 1, "good"), 1))
+	defer goodNode.Close()
+	peers := []*rpcClient{newRPCClient(badNode.URL), newRPCClient(goodNode.URL)}
+
+	manager, err := newPeerManager("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewLightClient(base[0].Header(), 1)
+	client.UsePeers(manager)
+	if err := client.Sync(context.Background(), peers); err == nil {
+		t.Fatal("invalid headers accepted")
+	}
+	if !manager.Banned(badNode.URL) || manager.Banned(goodNode.URL) {
+		t.Fatalf("bans after sync: %v", manager.Bans())
+	}
+	if got := client.Tip().Index; got != 2 {
+		t.Errorf("synced to height %d, want 2", got)
+	}
+
+	// The banned peer is not asked again
+	if err := client.Sync(context.Background(), peers); err != nil {
+		t.Errorf("second Sync: %v", err)
+	}
+	for _, p := range manager.Peers() {
+		if p.Addr == goodNode.URL && p.Latency == 0 {
+			t.Error("no latency recorded for the good peer")
+		}
+	}
+}
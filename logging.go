@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Logs are structured so that log pipelines can ingest them. Every line is
+// an event with a snake_case name, such as block_mined or
+// validation_failed, and its details as fields (index, hash, duration).
+// Logs go to stderr; the results a command prints, like reports, chain
+// listings and wallet data, stay on stdout.
+
+// logOptions holds the logging flags of a command.
+type logOptions struct {
+	level  string
+	format string
+}
+
+// addLogFlags registers -log-level and -log-format on fs.
+func addLogFlags(fs *flag.FlagSet) *logOptions {
+	opts := new(logOptions)
+	fs.StringVar(&opts.level, "log-level", "info", "minimum log level: debug, info, warn or error")
+	fs.StringVar(&opts.format, "log-format", "text", "log format: text or json")
+	return opts
+}
+
+// newLogger builds a logger writing to w as the options select.
+func (o *logOptions) newLogger(w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(o.level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", o.level)
+	}
+	handlerOpts := &slog.HandlerOptions{Level: level}
+
+	switch strings.ToLower(o.format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, handlerOpts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, handlerOpts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q (want text or json)", o.format)
+}
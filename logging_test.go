@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"testing"
+)
+
+// TestLogFlags checks the level and format options of the logger.
+func TestLogFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	opts := addLogFlags(fs)
+	if err := fs.Parse([]string{"-log-level", "warn", "-log-format", "json"}); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	logger, err := opts.newLogger(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Info("block_mined", slog.Int("index", 1))
+	logger.Warn("validation_failed", slog.Int("index", 2))
+	var line struct {
+		Level string
+		Msg   string
+		Index int
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected a single JSON line, got %q: %v", buf.String(), err)
+	}
+	if line.Level != "WARN" || line.Msg != "validation_failed" || line.Index != 2 {
+		t.Errorf("unexpected log line %+v", line)
+	}
+
+	for _, bad := range []logOptions{{level: "loud", format: "text"}, {level: "info", format: "xml"}} {
+		if _, err := bad.newLogger(&buf); err == nil {
+			t.Errorf("expected %+v to be rejected", bad)
+		}
+	}
+}
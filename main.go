@@ -5,12 +5,19 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/binary"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"hash"
+	"log/slog"
+	"math"
 	"os"
+	"os/signal"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -29,61 +36,180 @@ type Block struct {
 	Data      []byte `json:"data"`
 	PrevHash  []byte `json:"prev_hash"`
 	Hash      []byte `json:"hash"`
-	Nonce     int    `json:"nonce"`
+	Nonce     uint64 `json:"nonce"`
+	Bits      uint32 `json:"bits,omitempty"`      // compact PoW target; 0 for legacy blocks
+	HashAlgo  byte   `json:"hash_algo,omitempty"` // Hasher ID; 0 is SHA-256
+
+	// ExtraNonce is rolled by miners once every nonce has been tried, to
+	// get a fresh search space for the same block.
+	ExtraNonce uint64 `json:"extra_nonce,omitempty"`
+
+	// MerkleRoot commits to Data in place of the data itself, so the hash
+	// covers only the header. Legacy blocks have none and hash their data.
+	MerkleRoot []byte `json:"merkle_root,omitempty"`
+
+	Epoch *EpochSummary `json:"epoch,omitempty"` // set on the first block of each epoch
+
+	// Redaction is set once the block's data has been removed from storage.
+	// It is not part of the hash.
+	Redaction *Redaction `json:"redaction,omitempty"`
+
+	// Signature is the proposer's ed25519 signature of Hash on a
+	// proof-of-stake chain. It is not part of the hash.
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// Errors reported by chain validation. Validators wrap them in a
+// *BlockValidationError, so use errors.Is to check the failure class.
+var (
+	ErrBrokenLink       = errors.New("invalid previous hash")
+	ErrBadHeight        = errors.New("invalid height")
+	ErrTimeTooOld       = errors.New("timestamp before the previous block's")
+	ErrHashMismatch     = errors.New("invalid hash")
+	ErrInsufficientWork = errors.New("insufficient proof-of-work")
+	ErrDifficultyBits   = errors.New("invalid difficulty bits")
+	ErrHashAlgorithm    = errors.New("invalid hash algorithm")
+	ErrEpochSummary     = errors.New("invalid epoch summary")
+	ErrBlockTooLarge    = errors.New("block too large")
+	ErrSlot             = errors.New("invalid slot")
+	ErrWrongProposer    = errors.New("wrong proposer")
+	ErrBadSignature     = errors.New("invalid block signature")
+)
+
+// BlockLimits cap the size of a block, so that no peer or import can make
+// a node hold an arbitrarily large one. They are enforced when blocks are
+// generated, decoded and validated.
+type BlockLimits struct {
+	// MaxBlockBytes bounds the protobuf encoding of the whole block
+	MaxBlockBytes int64
+	// MaxDataBytes bounds the block's data payload
+	MaxDataBytes int64
+}
+
+// defaultBlockLimits apply unless the chain parameters set their own.
+var defaultBlockLimits = BlockLimits{MaxBlockBytes: 1 << 20, MaxDataBytes: 1_000_000}
+
+// blockLimits are the limits of the chain this process works on, set from
+// its chain parameters.
+var blockLimits = defaultBlockLimits
+
+// checkBlockSize reports an ErrBlockTooLarge if the block exceeds
+// blockLimits.
+func checkBlockSize(block *Block) error {
+	if n := int64(len(block.Data)); n > blockLimits.MaxDataBytes {
+		return &BlockValidationError{
+			Index: block.Index,
+			Err:   fmt.Errorf("%w: data is %d bytes, limit %d", ErrBlockTooLarge, n, blockLimits.MaxDataBytes),
+		}
+	}
+	if n := block.protoSize(); n > blockLimits.MaxBlockBytes {
+		return &BlockValidationError{
+			Index: block.Index,
+			Err:   fmt.Errorf("%w: block is %d bytes, limit %d", ErrBlockTooLarge, n, blockLimits.MaxBlockBytes),
+		}
+	}
+	return nil
 }
 
-// ValidationResult represents the result of block validation
-type ValidationResult struct {
+// BlockValidationError records which block failed validation and why
+type BlockValidationError struct {
 	Index int
-	Valid bool
-	Error error
+	Err   error
 }
 
-// HashCache provides thread-safe hash caching
-type HashCache struct {
-	mu    sync.RWMutex
-	cache map[int][]byte
+func (e *BlockValidationError) Error() string {
+	return fmt.Sprintf("block %d: %v", e.Index, e.Err)
 }
 
-// NewHashCache creates a new thread-safe hash cache
-func NewHashCache(capacity int) *HashCache {
-	return &HashCache{
-		cache: make(map[int][]byte, capacity),
-	}
+func (e *BlockValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationReport aggregates every problem found in a chain rather
+// than stopping at the first one
+type ValidationReport struct {
+	Blocks   int
+	Problems []*BlockValidationError
+	// Skipped is the number of blocks after the genesis block that were
+	// not rechecked, such as those covered by a checkpoint.
+	Skipped int
+	// Redacted lists blocks whose data was removed. They count as valid
+	// when they link correctly, but their contents cannot be checked.
+	Redacted []int
+}
+
+// Valid reports whether the chain had no problems
+func (r *ValidationReport) Valid() bool {
+	return len(r.Problems) == 0
 }
 
-// Get retrieves a hash from cache
-func (hc *HashCache) Get(index int) ([]byte, bool) {
-	hc.mu.RLock()
-	defer hc.mu.RUnlock()
-	hash, exists := hc.cache[index]
-	if exists {
-		// Return a copy to prevent modification
-		result := make([]byte, len(hash))
-		copy(result, hash)
-		return result, true
+// Err returns all problems joined into one error, or nil if the chain is valid
+func (r *ValidationReport) Err() error {
+	errs := make([]error, len(r.Problems))
+	for i, p := range r.Problems {
+		errs[i] = p
 	}
-	return nil, false
+	return errors.Join(errs...)
 }
 
-// Set stores a hash in cache
-func (hc *HashCache) Set(index int, hash []byte) {
-	hc.mu.Lock()
-	defer hc.mu.Unlock()
-	// Store a copy to prevent external modification
-	hashCopy := make([]byte, len(hash))
-	copy(hashCopy, hash)
-	hc.cache[index] = hashCopy
+// Block format versions. Each is written as the first serialized byte and
+// decides the layout of the rest.
+const (
+	blockFormatV1 byte = 0x01 // index, timestamp, nonce, data, previous hash
+	blockFormatV2 byte = 0x02 // adds the compact target
+	blockFormatV3 byte = 0x03 // adds the epoch summary
+	blockFormatV4 byte = 0x04 // Merkle root in place of the data, optional epoch summary
+	blockFormatV5 byte = 0x05 // adds the extra nonce
+	blockFormatV6 byte = 0x06 // adds the validator set hash to the epoch summary
+
+	latestBlockFormat = blockFormatV6
+)
+
+// blockFormatVersion returns the serialization version of a block: the
+// oldest version that has every field the block sets. Blocks without the
+// newer fields keep the older layouts so their hashes are unchanged, and
+// every version stays readable. The nonce is written as 8 bytes in every
+// version, so making it unsigned left the hashes of existing blocks alone.
+func blockFormatVersion(block *Block) byte {
+	if block.Epoch != nil && len(block.Epoch.ValidatorsHash) != 0 {
+		return blockFormatV6
+	}
+	if block.ExtraNonce != 0 {
+		return blockFormatV5
+	}
+	if block.MerkleRoot != nil {
+		return blockFormatV4
+	}
+	if block.Epoch != nil {
+		return blockFormatV3
+	}
+	if block.Bits != 0 {
+		return blockFormatV2
+	}
+	return blockFormatV1
 }
 
 // serializeBlockHeader serializes the block header without data for efficiency
 func serializeBlockHeader(block *Block, buf *bytes.Buffer) {
-	buf.WriteByte(0x01) // Version marker
-	buf.WriteByte(0x00) // Reserved for future use
+	version := blockFormatVersion(block)
+	buf.WriteByte(version)        // Version marker
+	buf.WriteByte(block.HashAlgo) // Hash algorithm ID (formerly reserved, always 0)
 	
 	binary.Write(buf, binary.LittleEndian, int64(block.Index))
 	binary.Write(buf, binary.LittleEndian, int64(block.Timestamp))
-	binary.Write(buf, binary.LittleEndian, int64(block.Nonce))
+	binary.Write(buf, binary.LittleEndian, block.Nonce)
+	if version >= blockFormatV5 {
+		binary.Write(buf, binary.LittleEndian, block.ExtraNonce)
+	}
+	if version >= blockFormatV2 {
+		binary.Write(buf, binary.LittleEndian, block.Bits)
+	}
+	if version == blockFormatV3 {
+		serializeEpochSummary(buf, block.Epoch)
+	}
+	if version >= blockFormatV4 {
+		serializeOptionalEpochSummary(buf, block.Epoch)
+	}
 }
 
 // serializeBlock converts a block into a deterministic byte slice.
@@ -102,8 +228,12 @@ func serializeBlock(block *Block) []byte {
 
 	serializeBlockHeader(block, buf)
 
-	binary.Write(buf, binary.LittleEndian, int32(len(block.Data)))
-	buf.Write(block.Data)
+	body := block.Data
+	if block.MerkleRoot != nil {
+		body = block.MerkleRoot
+	}
+	binary.Write(buf, binary.LittleEndian, int32(len(body)))
+	buf.Write(body)
 
 	binary.Write(buf, binary.LittleEndian, int32(len(block.PrevHash)))
 	buf.Write(block.PrevHash)
@@ -117,10 +247,16 @@ func serializeBlock(block *Block) []byte {
 // calculateHashStreaming computes hash for large blocks using streaming
 // to avoid keeping entire serialized block in memory
 func calculateHashStreaming(block *Block) []byte {
-	hasher := sha256.New()
+	h, err := hasherByID(block.HashAlgo)
+	if err != nil {
+		// Unknown algorithms hash to nil, which never matches a stored hash
+		return nil
+	}
+	hasher := h.New()
 	
 	// Write header data directly to hasher
-	hasher.Write([]byte{0x01, 0x00}) // Version and reserved byte
+	version := blockFormatVersion(block)
+	hasher.Write([]byte{version, block.HashAlgo}) // Version and hash algorithm
 	
 	// Write fixed-size fields
 	var tmpBuf [8]byte
@@ -128,14 +264,33 @@ func calculateHashStreaming(block *Block) []byte {
 	hasher.Write(tmpBuf[:])
 	binary.LittleEndian.PutUint64(tmpBuf[:], uint64(block.Timestamp))
 	hasher.Write(tmpBuf[:])
-	binary.LittleEndian.PutUint64(tmpBuf[:], uint64(block.Nonce))
+	binary.LittleEndian.PutUint64(tmpBuf[:], block.Nonce)
 	hasher.Write(tmpBuf[:])
+	if version >= blockFormatV5 {
+		binary.LittleEndian.PutUint64(tmpBuf[:], block.ExtraNonce)
+		hasher.Write(tmpBuf[:])
+	}
 	
-	// Write data length and data
 	var lenBuf [4]byte
-	binary.LittleEndian.PutUint32(lenBuf[:], uint32(len(block.Data)))
+	if version >= blockFormatV2 {
+		binary.LittleEndian.PutUint32(lenBuf[:], block.Bits)
+		hasher.Write(lenBuf[:])
+	}
+	if version == blockFormatV3 {
+		serializeEpochSummary(hasher, block.Epoch)
+	}
+	if version >= blockFormatV4 {
+		serializeOptionalEpochSummary(hasher, block.Epoch)
+	}
+	
+	// Write data length and data, or the Merkle root standing in for it
+	body := block.Data
+	if block.MerkleRoot != nil {
+		body = block.MerkleRoot
+	}
+	binary.LittleEndian.PutUint32(lenBuf[:], uint32(len(body)))
 	hasher.Write(lenBuf[:])
-	hasher.Write(block.Data)
+	hasher.Write(body)
 	
 	// Write prev hash length and hash
 	binary.LittleEndian.PutUint32(lenBuf[:], uint32(len(block.PrevHash)))
@@ -145,11 +300,13 @@ func calculateHashStreaming(block *Block) []byte {
 	return hasher.Sum(nil)
 }
 
-// calculateHash returns a SHA-256 hash of the serialized block.
-// Uses streaming for large blocks to reduce memory usage.
+// calculateHash returns the hash of the serialized block using the
+// block's hash algorithm. Uses streaming for large blocks to reduce memory
+// usage.
 func calculateHash(block *Block) []byte {
-	// Use streaming hash for large blocks to reduce memory pressure
-	if len(block.Data) > 64*1024 { // 64KB threshold
+	// Use streaming hash for large blocks (over 64KB) to reduce memory
+	// pressure, and for every algorithm other than the SHA-256 fast path
+	if len(block.Data) > 64*1024 || block.HashAlgo != HashSHA256 {
 		return calculateHashStreaming(block)
 	}
 	
@@ -177,15 +334,19 @@ func validateDifficulty(hash []byte, difficulty int) bool {
 }
 
 // proofOfWork finds a valid hash that satisfies the difficulty constraint.
-// It returns the discovered hash and the nonce used to generate it.
+// It returns the discovered hash and the nonce used to generate it. Should
+// the nonce wrap around, the block's extra nonce is rolled.
 // Supports cancellation via context.
-func proofOfWork(ctx context.Context, block *Block, difficulty int) ([]byte, int, error) {
+func proofOfWork(ctx context.Context, block *Block, difficulty int) ([]byte, uint64, error) {
 	if difficulty < 0 || difficulty > 64 {
 		return nil, 0, errors.New("invalid difficulty level")
 	}
 	
-	nonce := 0
-	var hash []byte
+	var nonce uint64
+	tmpl, err := newPoWTemplate(block)
+	if err != nil {
+		return nil, 0, err
+	}
 	
 	// Check for cancellation every 1000 iterations to avoid overhead
 	const checkInterval = 1000
@@ -200,24 +361,260 @@ func proofOfWork(ctx context.Context, block *Block, difficulty int) ([]byte, int
 			}
 		}
 		
-		block.Nonce = nonce
-		hash = calculateHash(block)
-		
-		if validateDifficulty(hash, difficulty) {
-			return hash, nonce, nil
+		if hash := tmpl.hash(nonce); validateDifficulty(hash, difficulty) {
+			block.Nonce = nonce
+			return bytes.Clone(hash), nonce, nil
 		}
 		nonce++
+		if nonce == 0 {
+			if !formatActive(blockFormatV5, block.Index) {
+				return nil, 0, errNonceSpaceExhausted
+			}
+			// The extra nonce changes the layout, so serialize again
+			block.ExtraNonce++
+			if tmpl, err = newPoWTemplate(block); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+}
+
+// powNonceOffset is where the nonce starts in a serialized block, after
+// the version, hash algorithm, index and timestamp. It is the same in
+// every format version.
+const powNonceOffset = 1 + 1 + 8 + 8
+
+// powTemplate is a candidate block serialized once for the nonce search.
+// Each attempt overwrites the nonce in place and hashes the bytes, instead
+// of serializing the whole block, data included for legacy blocks, again.
+// SHA-256 midstate caching would not help: the nonce falls in the first
+// 64-byte block of the message, so no hashing work precedes it.
+type powTemplate struct {
+	buf []byte
+	h   hash.Hash // nil for SHA-256, which uses sha256.Sum256
+	sum [sha256.Size]byte
+}
+
+func newPoWTemplate(block *Block) (*powTemplate, error) {
+	hasher, err := hasherByID(block.HashAlgo)
+	if err != nil {
+		return nil, err
+	}
+	t := &powTemplate{buf: serializeBlock(block)}
+	if hasher.ID() != HashSHA256 {
+		t.h = hasher.New()
 	}
+	return t, nil
+}
+
+// hash returns the hash of the block with nonce. The result is only valid
+// until the next call.
+func (t *powTemplate) hash(nonce uint64) []byte {
+	binary.LittleEndian.PutUint64(t.buf[powNonceOffset:], nonce)
+	if t.h == nil {
+		t.sum = sha256.Sum256(t.buf)
+		return t.sum[:]
+	}
+	t.h.Reset()
+	t.h.Write(t.buf)
+	return t.h.Sum(t.sum[:0])
+}
+
+// errNonceSpaceExhausted reports that no nonce up to the search's limit
+// solves a candidate block.
+var errNonceSpaceExhausted = errors.New("nonce space exhausted")
+
+// proofOfWorkParallel splits the nonce search across the given number of
+// workers. Worker i tries nonces i, i+workers, i+2*workers, ... so the
+// workers hash disjoint parts of the nonce space, and the first solution
+// found stops the others. It also returns the total number of hashes tried.
+func proofOfWorkParallel(ctx context.Context, block *Block, difficulty int, workers int) ([]byte, uint64, uint64, error) {
+	return searchNonces(ctx, block, difficulty, workers, math.MaxUint64, nil)
+}
+
+// searchNonces is proofOfWorkParallel over the nonces up to maxNonce. It
+// returns errNonceSpaceExhausted when none of them solves the block. If
+// counter is not nil the workers add the hashes they try to it as they go,
+// so it can be read while the search runs.
+func searchNonces(ctx context.Context, block *Block, difficulty int, workers int, maxNonce uint64, counter *atomic.Uint64) ([]byte, uint64, uint64, error) {
+	if difficulty < 0 || difficulty > 64 {
+		return nil, 0, 0, errors.New("invalid difficulty level")
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	searchCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	type solution struct {
+		hash  []byte
+		nonce uint64
+	}
+	if _, err := hasherByID(block.HashAlgo); err != nil {
+		return nil, 0, 0, err
+	}
+	found := make(chan solution, workers)
+	var attempts atomic.Uint64
+	var wg sync.WaitGroup
+
+	const checkInterval = 1000
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(start uint64) {
+			defer wg.Done()
+
+			// Each worker hashes its own copy so only the nonce differs.
+			// The hash algorithm was checked above.
+			tmpl, _ := newPoWTemplate(block)
+			var tried, reported uint64
+			report := func() {
+				attempts.Add(tried - reported)
+				if counter != nil {
+					counter.Add(tried - reported)
+				}
+				reported = tried
+			}
+			defer report()
+
+			step := uint64(workers)
+			for nonce := start; nonce <= maxNonce; nonce += step {
+				if tried%checkInterval == 0 {
+					report()
+					select {
+					case <-searchCtx.Done():
+						return
+					default:
+					}
+				}
+
+				hash := tmpl.hash(nonce)
+				tried++
+
+				if validateDifficulty(hash, difficulty) {
+					found <- solution{hash: bytes.Clone(hash), nonce: nonce}
+					stop()
+					return
+				}
+				if maxNonce-nonce < step {
+					// The next nonce would pass the limit or wrap around
+					return
+				}
+			}
+		}(uint64(w))
+	}
+
+	wg.Wait()
+
+	select {
+	case s := <-found:
+		block.Nonce = s.nonce
+		return s.hash, s.nonce, attempts.Load(), nil
+	default:
+		if err := ctx.Err(); err != nil {
+			return nil, 0, attempts.Load(), err
+		}
+		return nil, 0, attempts.Load(), errNonceSpaceExhausted
+	}
+}
+
+// defaultProgressInterval is how often a miner reports its progress when
+// its options do not say.
+const defaultProgressInterval = time.Second
+
+// MinerOptions configure the nonce search of a candidate block.
+type MinerOptions struct {
+	Workers int // goroutines hashing in parallel; fewer than 1 means 1
+
+	// Progress, if set, is called every ProgressInterval while the search
+	// runs, with the hashes tried and the time spent so far. It is called
+	// from one goroutine at a time and never after the search returns.
+	Progress         func(attempts uint64, elapsed time.Duration)
+	ProgressInterval time.Duration
+}
+
+// reportProgress calls opts.Progress with the value of attempts every
+// interval until the returned function is called.
+func reportProgress(attempts *atomic.Uint64, opts MinerOptions) (stop func()) {
+	interval := opts.ProgressInterval
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+	start := time.Now()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				opts.Progress(attempts.Load(), now.Sub(start))
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// expectedTimeToBlock estimates how long a miner hashing at rate hashes
+// per second takes to solve a block of the given difficulty. Every hash is
+// an independent trial, so the estimate does not shrink with the time
+// already spent on a block.
+func expectedTimeToBlock(difficulty int, rate float64) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	seconds := math.Pow(16, float64(difficulty)) / rate
+	if seconds >= math.MaxInt64/float64(time.Second) {
+		return math.MaxInt64
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// newCandidateBlock builds the unsolved successor of prevBlock, stamped
+// with the time of clock and recording the target its proof-of-work has
+// to meet. Since the target of a whole hex-digit difficulty is an exact
+// power of two, the hex-prefix check used while mining is equivalent to
+// comparing against the recorded target.
+func newCandidateBlock(clock Clock, prevBlock *Block, data string, difficulty int) *Block {
+	block := &Block{
+		Index:      prevBlock.Index + 1,
+		Timestamp:  orSystem(clock).Now().Unix(),
+		Data:       []byte(data),
+		PrevHash:   prevBlock.Hash,
+		Bits:       difficultyToCompact(difficulty),
+		HashAlgo:   prevBlock.HashAlgo,
+		MerkleRoot: dataMerkleRoot(prevBlock.HashAlgo, []byte(data)),
+	}
+	// Fields of format versions the chain has not activated yet are left out
+	if !formatActive(blockFormatV2, block.Index) {
+		block.Bits = 0
+	}
+	if !formatActive(blockFormatV4, block.Index) {
+		block.MerkleRoot = nil
+	}
+	return block
 }
 
 // generateBlock creates a new block referencing the previous one
 // and performs proof-of-work to finalize its hash.
 func generateBlock(ctx context.Context, prevBlock *Block, data string, difficulty int) (*Block, error) {
-	newBlock := &Block{
-		Index:     prevBlock.Index + 1,
-		Timestamp: time.Now().Unix(),
-		Data:      []byte(data),
-		PrevHash:  prevBlock.Hash,
+	return generateBlockWith(ctx, SystemClock, prevBlock, data, difficulty)
+}
+
+// generateBlockWith is generateBlock with the timestamp taken from clock.
+func generateBlockWith(ctx context.Context, clock Clock, prevBlock *Block, data string, difficulty int) (*Block, error) {
+	newBlock := newCandidateBlock(clock, prevBlock, data, difficulty)
+	if err := checkBlockSize(newBlock); err != nil {
+		return nil, err
 	}
 	
 	hash, nonce, err := proofOfWork(ctx, newBlock, difficulty)
@@ -230,35 +627,171 @@ func generateBlock(ctx context.Context, prevBlock *Block, data string, difficult
 	return newBlock, nil
 }
 
+// mineBlock is generateBlock with the nonce search spread over workers
+// goroutines. It returns the number of hashes attempted, including on
+// failure, so callers can report the effective hash rate.
+func mineBlock(ctx context.Context, prevBlock *Block, data string, difficulty int, workers int) (*Block, uint64, error) {
+	return mineCandidate(ctx, newCandidateBlock(SystemClock, prevBlock, data, difficulty), difficulty, MinerOptions{Workers: workers})
+}
+
+// mineNext mines the block following chain.
+func mineNext(ctx context.Context, chain []*Block, data string, difficulty int, opts MinerOptions) (*Block, uint64, error) {
+	return mineCandidate(ctx, nextCandidate(SystemClock, chain, data, difficulty), difficulty, opts)
+}
+
+// nextCandidate builds the unsolved block following chain, with the
+// summary of the previous epoch when it starts a new one.
+func nextCandidate(clock Clock, chain []*Block, data string, difficulty int) *Block {
+	newBlock := newCandidateBlock(clock, chain[len(chain)-1], data, difficulty)
+	if formatActive(blockFormatV3, newBlock.Index) {
+		newBlock.Epoch = epochSummaryFor(chain)
+	}
+	return newBlock
+}
+
+// mineCandidate solves the proof-of-work of a candidate block.
+func mineCandidate(ctx context.Context, newBlock *Block, difficulty int, opts MinerOptions) (*Block, uint64, error) {
+	return solveCandidate(ctx, newBlock, difficulty, opts, math.MaxUint64)
+}
+
+// solveCandidate searches the nonces up to maxNonce, rolling the extra
+// nonce and starting over each time they are exhausted. Progress reports
+// count the hashes of every extra nonce.
+func solveCandidate(ctx context.Context, newBlock *Block, difficulty int, opts MinerOptions, maxNonce uint64) (*Block, uint64, error) {
+	if err := checkBlockSize(newBlock); err != nil {
+		return nil, 0, err
+	}
+	var attempts atomic.Uint64
+	if opts.Progress != nil {
+		defer reportProgress(&attempts, opts)()
+	}
+	for {
+		hash, nonce, _, err := searchNonces(ctx, newBlock, difficulty, opts.Workers, maxNonce, &attempts)
+		if errors.Is(err, errNonceSpaceExhausted) && formatActive(blockFormatV5, newBlock.Index) {
+			newBlock.ExtraNonce++
+			continue
+		}
+		if err != nil {
+			return nil, attempts.Load(), fmt.Errorf("proof of work failed: %w", err)
+		}
+
+		newBlock.Hash = hash
+		newBlock.Nonce = nonce
+		return newBlock, attempts.Load(), nil
+	}
+}
+
+// hashRate returns the number of hashes per second for the given work.
+func hashRate(attempts uint64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(attempts) / elapsed.Seconds()
+}
+
 // validateBlockPair validates a single block against its predecessor
 func validateBlockPair(prevBlock, currBlock *Block, difficulty int, hashCache *HashCache) error {
+	return validateBlockPairWith(prevBlock, currBlock, &PoWEngine{Difficulty: difficulty}, hashCache)
+}
+
+// validateHeaderPair validates the header of a block against its
+// predecessor: algorithm, linkage, hash and proof-of-work. It checks the
+// data only as far as a legacy block's hash covers it.
+func validateHeaderPair(prevBlock, currBlock *Block, difficulty int, hashCache *HashCache) error {
+	currHash, err := checkHeaderLink(prevBlock, currBlock, hashCache)
+	if err != nil {
+		return err
+	}
+	return checkProofOfWork(currBlock, currHash, difficulty)
+}
+
+// checkHeaderLink checks the parts of a header every consensus engine
+// shares: the height, the algorithm, the link to the predecessor and the
+// hash, which it returns.
+func checkHeaderLink(prevBlock, currBlock *Block, hashCache *HashCache) ([]byte, error) {
+	// Heights decide which checkpoint applies, so they must be consecutive
+	if currBlock.Index != prevBlock.Index+1 {
+		return nil, &BlockValidationError{
+			Index: currBlock.Index,
+			Err:   fmt.Errorf("%w: block %d follows block %d", ErrBadHeight, currBlock.Index, prevBlock.Index),
+		}
+	}
+
+	// Every block must use the hash algorithm the chain started with
+	if currBlock.HashAlgo != prevBlock.HashAlgo {
+		return nil, &BlockValidationError{
+			Index: currBlock.Index,
+			Err:   fmt.Errorf("%w: block uses algorithm %d, chain uses %d", ErrHashAlgorithm, currBlock.HashAlgo, prevBlock.HashAlgo),
+		}
+	}
+	if _, err := hasherByID(currBlock.HashAlgo); err != nil {
+		return nil, &BlockValidationError{Index: currBlock.Index, Err: fmt.Errorf("%w: %v", ErrHashAlgorithm, err)}
+	}
+
+	if err := checkBlockFormat(currBlock); err != nil {
+		return nil, &BlockValidationError{Index: currBlock.Index, Err: err}
+	}
+
 	// Get or compute previous block hash
-	prevHash, ok := hashCache.Get(prevBlock.Index)
+	prevHash, ok := hashCache.Get(prevBlock)
 	if !ok {
-		prevHash = calculateHash(prevBlock)
-		hashCache.Set(prevBlock.Index, prevHash)
+		prevHash = committedHash(prevBlock)
+		hashCache.Set(prevBlock, prevHash)
 	}
 
 	// Check previous hash link
 	if !bytes.Equal(currBlock.PrevHash, prevHash) {
-		return fmt.Errorf("block %d: invalid previous hash", currBlock.Index)
+		return nil, &BlockValidationError{Index: currBlock.Index, Err: ErrBrokenLink}
 	}
 
 	// Get or compute current block hash
-	currHash, ok := hashCache.Get(currBlock.Index)
+	currHash, ok := hashCache.Get(currBlock)
 	if !ok {
-		currHash = calculateHash(currBlock)
-		hashCache.Set(currBlock.Index, currHash)
+		currHash = committedHash(currBlock)
+		hashCache.Set(currBlock, currHash)
 	}
 
 	// Check current hash
 	if !bytes.Equal(currBlock.Hash, currHash) {
-		return fmt.Errorf("block %d: invalid hash", currBlock.Index)
+		return nil, &BlockValidationError{Index: currBlock.Index, Err: ErrHashMismatch}
 	}
+	if err := checkCheckpoint(currBlock.Index, currHash); err != nil {
+		return nil, err
+	}
+
+	return currHash, nil
+}
 
+// checkProofOfWork checks that a block's hash meets difficulty and the
+// target the block commits to.
+func checkProofOfWork(currBlock *Block, currHash []byte, difficulty int) error {
 	// Check proof-of-work difficulty
 	if !validateDifficulty(currHash, difficulty) {
-		return fmt.Errorf("block %d: hash does not meet difficulty %d", currBlock.Index, difficulty)
+		return &BlockValidationError{
+			Index: currBlock.Index,
+			Err:   fmt.Errorf("%w: hash does not meet difficulty %d", ErrInsufficientWork, difficulty),
+		}
+	}
+
+	// The target the block commits to may be harder than the schedule's,
+	// but not easier, so that it records the difficulty the block had to
+	// meet. The chain does not retarget: the schedule is its difficulty at
+	// every height.
+	if currBlock.Bits != 0 {
+		if target := compactToTarget(currBlock.Bits); target.Sign() <= 0 || target.Cmp(difficultyToTarget(difficulty)) > 0 {
+			return &BlockValidationError{
+				Index: currBlock.Index,
+				Err:   fmt.Errorf("%w: target %08x is easier than difficulty %d", ErrDifficultyBits, currBlock.Bits, difficulty),
+			}
+		}
+	}
+
+	// Check the target the block itself commits to
+	if currBlock.Bits != 0 && !hashMeetsTarget(currHash, compactToTarget(currBlock.Bits)) {
+		return &BlockValidationError{
+			Index: currBlock.Index,
+			Err:   fmt.Errorf("%w: hash does not meet target %08x", ErrInsufficientWork, currBlock.Bits),
+		}
 	}
 
 	return nil
@@ -268,113 +801,157 @@ func validateBlockPair(prevBlock, currBlock *Block, difficulty int, hashCache *H
 // to avoid redundant hash computations.
 // Optimized version with better memory management and early exits.
 func isChainValidCached(chain []*Block, difficulty int) bool {
-	if len(chain) == 0 {
-		return true
-	}
-	
+	return validateChain(chain, difficulty) == nil
+}
+
+// validateChain validates a chain like isChainValidCached but returns the
+// first problem found as a *BlockValidationError.
+func validateChain(chain []*Block, difficulty int) error {
+	return validateChainWith(chain, &PoWEngine{Difficulty: difficulty})
+}
+
+// validateChainReport validates every block of the chain against its
+// predecessor and collects all problems instead of stopping at the first.
+func validateChainReport(chain []*Block, difficulty int) *ValidationReport {
+	return validateChainReportFrom(chain, &PoWEngine{Difficulty: difficulty}, 1)
+}
+
+// validateChainReportFrom is validateChainReport for a chain sealed by
+// engine and the blocks from start on, for when the earlier ones are known
+// to be valid.
+func validateChainReportFrom(chain []*Block, engine Engine, start int) *ValidationReport {
+	start = max(start, 1)
+	report := &ValidationReport{Blocks: len(chain), Skipped: max(min(start, len(chain))-1, 0)}
 	hashCache := NewHashCache(len(chain))
-	
-	for i := 1; i < len(chain); i++ {
-		if err := validateBlockPair(chain[i-1], chain[i], difficulty, hashCache); err != nil {
-			return false
+	for _, block := range chain {
+		if block.Redaction != nil {
+			report.Redacted = append(report.Redacted, block.Index)
 		}
 	}
-	return true
+
+	buried := lastCheckpoint(len(chain) - 1)
+	for i := start; i < len(chain); i++ {
+		err := validateBuriedPair(chain[i-1], chain[i], i, engine, buried, hashCache)
+		if err == nil {
+			err = checkEpochSummary(chain[:i], chain[i])
+		}
+		var blockErr *BlockValidationError
+		if errors.As(err, &blockErr) {
+			report.Problems = append(report.Problems, blockErr)
+		}
+	}
+	return report
 }
 
-// validateChainConcurrent validates blocks concurrently with proper error handling
-func validateChainConcurrent(ctx context.Context, chain []*Block, difficulty int, maxWorkers int) error {
-	if len(chain) == 0 {
-		return nil
+// defaultValidateThreshold is the chain length from which concurrent
+// validation uses workers; shorter chains are cheaper to check in sequence.
+const defaultValidateThreshold = 1000
+
+// ValidateOptions configures validateChainConcurrent. Zero values select
+// the defaults.
+type ValidateOptions struct {
+	// Workers is the number of goroutines checking blocks. It defaults to
+	// runtime.NumCPU().
+	Workers int
+	// Threshold is the shortest chain validated with workers; shorter
+	// chains are validated sequentially. It defaults to
+	// defaultValidateThreshold. Set it to 1 to always use workers.
+	Threshold int
+}
+
+// validateChainConcurrent validates a chain like validateChain, spreading
+// the blocks over a pool of workers. Like validateChain it returns the
+// problem with the lowest block index, whichever worker finds it first.
+func validateChainConcurrent(ctx context.Context, chain []*Block, difficulty int, opts ValidateOptions) error {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
 	}
-	
-	if len(chain) < maxWorkers {
-		maxWorkers = len(chain) - 1
+	threshold := opts.Threshold
+	if threshold <= 0 {
+		threshold = defaultValidateThreshold
 	}
-	
-	if maxWorkers <= 0 {
+	if len(chain) < threshold {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return validateChain(chain, difficulty)
+	}
+	if workers > len(chain)-1 {
+		workers = len(chain) - 1
+	}
+	if workers <= 0 {
 		return nil
 	}
-	
-	// Channel for validation jobs and results
-	jobs := make(chan int, len(chain)-1)
-	results := make(chan ValidationResult, len(chain)-1)
-	
+
+	engine := &PoWEngine{Difficulty: difficulty}
 	hashCache := NewHashCache(len(chain))
-	
-	// Worker function
-	worker := func() {
-		for i := range jobs {
-			select {
-			case <-ctx.Done():
-				results <- ValidationResult{Index: i, Valid: false, Error: ctx.Err()}
-				return
-			default:
-			}
-			
-			err := validateBlockPair(chain[i-1], chain[i], difficulty, hashCache)
-			results <- ValidationResult{
-				Index: i,
-				Valid: err == nil,
-				Error: err,
-			}
-		}
+	buried := lastCheckpoint(len(chain) - 1)
+
+	// Blocks are handed out in order, so once a block fails only the lower
+	// blocks still in flight can report an earlier problem; later blocks
+	// are skipped.
+	var (
+		mu        sync.Mutex
+		failIndex = len(chain)
+		failErr   error
+	)
+	failedBefore := func(i int) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return failIndex < i
 	}
-	
-	// Start workers
+
+	jobs := make(chan int)
 	var wg sync.WaitGroup
-	for i := 0; i < maxWorkers; i++ {
+	for w := 0; w < workers; w++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			worker()
+			for i := range jobs {
+				if failedBefore(i) {
+					continue
+				}
+				err := validateBuriedPair(chain[i-1], chain[i], i, engine, buried, hashCache)
+				if err == nil {
+					err = checkEpochSummary(chain[:i], chain[i])
+				}
+				if err != nil {
+					mu.Lock()
+					if i < failIndex {
+						failIndex, failErr = i, err
+					}
+					mu.Unlock()
+				}
+			}
 		}()
 	}
-	
-	// Send jobs
-	go func() {
-		defer close(jobs)
-		for i := 1; i < len(chain); i++ {
-			select {
-			case jobs <- i:
-			case <-ctx.Done():
-				return
-			}
-		}
-	}()
-	
-	// Wait for workers to finish
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-	
-	// Collect results
+
 	for i := 1; i < len(chain); i++ {
+		if failedBefore(i) {
+			break
+		}
 		select {
-		case result := <-results:
-			if !result.Valid {
-				return result.Error
-			}
+		case jobs <- i:
 		case <-ctx.Done():
-			return ctx.Err()
+		}
+		if ctx.Err() != nil {
+			break
 		}
 	}
-	
-	return nil
+	close(jobs)
+	wg.Wait()
+
+	if failErr != nil {
+		return failErr
+	}
+	return ctx.Err()
 }
 
 // isChainValidConcurrent validates a chain using concurrent processing
 // for better performance on large chains
 func isChainValidConcurrent(ctx context.Context, chain []*Block, difficulty int) bool {
-	// Use concurrent validation for large chains
-	if len(chain) < 1000 {
-		return isChainValidCached(chain, difficulty)
-	}
-	
-	const maxWorkers = 4
-	err := validateChainConcurrent(ctx, chain, difficulty, maxWorkers)
-	return err == nil
+	return validateChainConcurrent(ctx, chain, difficulty, ValidateOptions{}) == nil
 }
 
 // writeChainJSON saves the blockchain to a JSON file.
@@ -384,71 +961,227 @@ func writeChainJSON(chain []*Block, path string) error {
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	enc := json.NewEncoder(f)
-	enc.SetIndent("", "  ")
-	return enc.Encode(chain)
+	if err := encodeChainJSON(f, chain); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
-// newGenesisBlock returns the first block of the chain.
-func newGenesisBlock() *Block {
-	b := &Block{
-		Index:     0,
-		Timestamp: time.Now().Unix(),
-		Data:      []byte("Genesis"),
-		PrevHash:  []byte{},
-	}
-	b.Hash = calculateHash(b)
-	return b
+// newGenesisBlock returns the genesis block of the default chain
+// parameters, hashed with hasher. Later blocks inherit its algorithm.
+func newGenesisBlock(hasher Hasher) *Block {
+	return defaultChainParams.genesisWith(hasher)
 }
 
-// main demonstrates block creation and chain validation.
+// command is a blockchain subcommand. run receives the arguments after the
+// command name and returns the process exit code.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands = []command{
+	{"mine", "mine a new chain (the default when no command is given)", runMine},
+	{"validate", "check a chain file offline", runValidate},
+	{"inspect", "show a chain summary or a single block", runInspect},
+	{"redact", "remove the data of a stored block, keeping its hash", runRedact},
+	{"retention", "apply a retention policy to the stored chain", runRetentionCommand},
+	{"repair", "find corrupted or missing stored blocks and truncate the chain before them", runRepair},
+	{"diff", "report where two chain files diverge and which has the most work", runDiff},
+	{"stats", "show block interval, work, size and difficulty statistics", runStats},
+	{"import", "validate a chain file and store it in a data directory", runImport},
+	{"export", "write the stored chain in another format", runExport},
+	{"snapshot", "write a bootstrap snapshot of the stored chain", runSnapshot},
+	{"restore", "start a data directory from a bootstrap snapshot", runRestore},
+	{"serve", "serve a chain over JSON-RPC and WebSocket", runServe},
+	{"daemon", "mine continuously while serving and saving the chain", runDaemon},
+	{"watch", "print new blocks of a node or data directory as they appear", runWatch},
+	{"mirror", "keep a PostgreSQL table in step with a node or data directory", runMirror},
+	{"verify-against", "compare the state of a chain with a node's at a height", runVerifyAgainst},
+	{"wallet", "manage keys, signatures and payment requests", runWallet},
+}
+
+// main runs the subcommand named by the arguments.
 func main() {
-	blocks := flag.Int("blocks", 2, "number of additional blocks to generate")
-	difficulty := flag.Int("difficulty", 4, "proof-of-work difficulty")
-	output := flag.String("output", "", "optional path to write blockchain as JSON")
-	concurrent := flag.Bool("concurrent", false, "use concurrent validation for large chains")
-	timeout := flag.Duration("timeout", 30*time.Minute, "timeout for long-running operations")
-	flag.Parse()
+	os.Exit(run(os.Args[1:]))
+}
+
+// run dispatches the command line to a command and returns the exit code.
+// A leading -error-json applies to every command.
+func run(args []string) int {
+	if len(args) > 0 && (args[0] == "-error-json" || args[0] == "--error-json") {
+		errorJSON = true
+		args = args[1:]
+	}
+	// Without a command the flat flags of earlier versions still mean "mine"
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runMine(args)
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+	if args[0] != "help" {
+		if errorJSON {
+			return failf(exitConfig, "unknown command %q", args[0])
+		}
+		fmt.Printf("Unknown command %q\n\n", args[0])
+	}
+	printUsage()
+	return exitConfig
+}
+
+func printUsage() {
+	fmt.Println("Usage: blockchain <command> [flags]\n\nCommands:")
+	for _, cmd := range commands {
+		fmt.Printf("  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Println("\nRun 'blockchain <command> -h' for the flags of a command.")
+}
+
+// runMine implements the mine subcommand: it mines a fresh chain, validates
+// it and optionally writes it out.
+func runMine(args []string) int {
+	fs := flag.NewFlagSet("mine", flag.ContinueOnError)
+	blocks := fs.Int("blocks", 2, "number of additional blocks to generate")
+	difficulty := fs.Int("difficulty", 4, "proof-of-work difficulty")
+	output := fs.String("output", "", "optional path to write blockchain to (.json, .jsonl or .pb, optionally .gz)")
+	concurrent := fs.Bool("concurrent", false, "use concurrent validation for large chains")
+	timeout := fs.Duration("timeout", 30*time.Minute, "timeout for long-running operations")
+	workers := fs.Int("workers", runtime.NumCPU(), "number of parallel mining workers")
+	dataDir := fs.String("datadir", "", "optional directory to write the session summary to")
+	progress := fs.Duration("progress", time.Second, "interval between mining progress reports; 0 disables them")
+	audit := fs.Bool("audit", false, "print a nonce distribution audit of the mined blocks")
+	fs.String("hash", "sha256", "block hash algorithm: sha256, sha3-256 or blake3")
+	validatorKey := fs.String("validator-key", "", "keystore of the validator signing the blocks of a proof-of-stake chain")
+	stepClock := fs.Bool("step-clock", false, "stamp blocks one block interval apart from the genesis timestamp instead of with the current time, for reproducible chains")
+	network, paramsPath := addChainFlags(fs)
+	logOpts := addLogFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return flagError(err)
+	}
 
 	// Validate input parameters
 	if *blocks < 0 {
-		fmt.Printf("Error: blocks must be non-negative\n")
-		os.Exit(1)
+		return failf(exitConfig, "blocks must be non-negative")
+	}
+	if *workers < 1 {
+		return failf(exitConfig, "workers must be at least 1")
+	}
+	if *progress < 0 {
+		return failf(exitConfig, "progress must not be negative")
+	}
+	params, err := chainParamsFlags(fs, *network, *paramsPath)
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+	*difficulty = params.Difficulty
+	if *stepClock && params.Consensus == ConsensusPoS {
+		return failf(exitConfig, "-step-clock needs a proof-of-work chain; proof-of-stake blocks are stamped with their slot")
+	}
+	genesis, err := params.genesisBlock()
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+	logger, err := logOpts.newLogger(os.Stderr)
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+	// Blocks are sealed by the chain's consensus engine: mined, or signed
+	// by a validator
+	var key *Wallet
+	if params.Consensus == ConsensusPoS {
+		if *validatorKey == "" {
+			return failf(exitConfig, "a proof-of-stake chain needs -validator-key")
+		}
+		pass, err := readPassphrase(os.Stdin, "Passphrase: ")
+		if err != nil {
+			return fail(err)
+		}
+		if key, err = loadKeystore(*validatorKey, pass); err != nil {
+			return fail(err)
+		}
+	}
+	engine, err := params.engine(genesis, key)
+	if err != nil {
+		return failCode(exitConfig, err)
 	}
-	if *difficulty < 0 || *difficulty > 32 {
-		fmt.Printf("Error: difficulty must be between 0 and 32\n")
-		os.Exit(1)
+	index := 0
+	pow, _ := engine.(*PoWEngine)
+	if *stepClock {
+		interval := time.Duration(params.BlockInterval) * time.Second
+		pow.Clock = NewSteppingClock(time.Unix(genesis.Timestamp, 0).Add(interval), interval)
+	}
+	if pow != nil {
+		pow.Miner = MinerOptions{Workers: *workers, ProgressInterval: *progress}
+		if *progress > 0 {
+			pow.Miner.Progress = func(attempts uint64, elapsed time.Duration) {
+				rate := hashRate(attempts, elapsed)
+				logger.Info("mining_progress", slog.Int("index", index), slog.Uint64("attempts", attempts), slog.Duration("elapsed", elapsed),
+					slog.Float64("hash_rate", rate), slog.Duration("expected_time", expectedTimeToBlock(*difficulty, rate)))
+			}
+		}
 	}
 
-	blockchain := []*Block{newGenesisBlock()}
+	blockchain := []*Block{genesis}
 
-	fmt.Printf("Generating %d blocks with difficulty %d (timeout: %v)...\n", *blocks, *difficulty, *timeout)
+	logger.Info("mining_started", slog.String("chain_id", params.ChainID), slog.Int("blocks", *blocks), slog.Int("difficulty", *difficulty),
+		slog.Int("workers", *workers), slog.Duration("timeout", *timeout), slog.String("hash_algo", params.HashAlgo))
 	start := time.Now()
-	
+
+	// The session is recorded however the run ends; generationTime is the
+	// time spent mining so far
+	session := &SessionSummary{Started: start}
+	stopHeapTracking := trackPeakHeap(100 * time.Millisecond)
+	var generationTime time.Duration
+	defer func() {
+		session.finish(generationTime, stopHeapTracking())
+		session.Print(os.Stdout)
+		session.record(logger, *dataDir)
+	}()
+
+	// Stop mining gracefully on SIGINT/SIGTERM so the session is still recorded
+	sigCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
 	// Create context with timeout for cancellation
-	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	ctx, cancel := context.WithTimeout(sigCtx, *timeout)
 	defer cancel()
 	
 	for i := 1; i <= *blocks; i++ {
-		block, err := generateBlock(ctx, blockchain[len(blockchain)-1], fmt.Sprintf("Block %d", i), *difficulty)
+		index = i
+		blockStart := time.Now()
+		block, err := sealNext(ctx, engine, blockchain, fmt.Sprintf("Block %d", i))
+		var attempts uint64
+		if pow != nil {
+			attempts = pow.Hashes() - session.HashesAttempted
+		}
+		session.HashesAttempted += attempts
 		if err != nil {
-			if errors.Is(err, context.DeadlineExceeded) {
-				fmt.Printf("Timeout exceeded while generating block %d\n", i)
+			generationTime = time.Since(start)
+			session.Error = err.Error()
+			if sigCtx.Err() != nil {
+				logger.Warn("mining_interrupted", slog.Int("index", i))
+				session.Interrupted, session.Error = true, ""
+			} else if errors.Is(err, context.DeadlineExceeded) {
+				logger.Error("mining_timeout", slog.Int("index", i), slog.Duration("timeout", *timeout))
 			} else {
-				fmt.Printf("Error generating block %d: %v\n", i, err)
+				logger.Error("mining_failed", slog.Int("index", i), slog.Any("error", err))
 			}
-			os.Exit(1)
+			return failReported(err)
 		}
 		blockchain = append(blockchain, block)
-		if i%100 == 0 || i == *blocks {
-			fmt.Printf("Generated %d/%d blocks\n", i, *blocks)
-		}
+		session.BlocksMined++
+		logger.Info("block_mined", slog.Int("index", block.Index), slog.String("hash", fmt.Sprintf("%x", block.Hash)),
+			slog.Uint64("attempts", attempts), slog.Duration("duration", time.Since(blockStart)))
 	}
 	
-	generationTime := time.Since(start)
-	fmt.Printf("Generation completed in %v (avg: %v per block)\n", 
-		generationTime, generationTime/time.Duration(*blocks))
+	generationTime = time.Since(start)
+	logger.Info("mining_finished", slog.Int("blocks", *blocks), slog.Duration("duration", generationTime),
+		slog.Float64("hash_rate", hashRate(session.HashesAttempted, generationTime)))
 
 	fmt.Println("\nBlockchain:")
 	displayLimit := 10
@@ -473,30 +1206,46 @@ func main() {
 	// Validate using appropriate method
 	fmt.Print("\nValidating blockchain...")
 	validationStart := time.Now()
-	var isValid bool
 	
 	// Create new context for validation (separate from generation timeout)
 	validationCtx, validationCancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer validationCancel()
 	
-	if *concurrent && len(blockchain) >= 1000 {
-		isValid = isChainValidConcurrent(validationCtx, blockchain, *difficulty)
+	var validErr error
+	if pow == nil {
+		validErr = validateChainWith(blockchain, engine)
+		if validErr != nil {
+			logger.Error("validation_failed", slog.Any("error", validErr))
+		}
+		fmt.Printf(" (using %s validation)", engine.Name())
+	} else if *concurrent && len(blockchain) >= defaultValidateThreshold {
+		validErr = validateChainConcurrent(validationCtx, blockchain, *difficulty, ValidateOptions{})
 		fmt.Printf(" (using concurrent validation)")
 	} else {
-		isValid = isChainValidCached(blockchain, *difficulty)
+		validErr = validateChain(blockchain, *difficulty)
 		fmt.Printf(" (using cached validation)")
 	}
+	isValid := validErr == nil
 	
 	validationTime := time.Since(validationStart)
 	fmt.Printf("\nIs blockchain valid? %t (validated in %v)\n", isValid, validationTime)
+	if !isValid {
+		for _, problem := range validateChainReportFrom(blockchain, engine, 1).Problems {
+			logger.Error("validation_failed", slog.Int("index", problem.Index), slog.Any("error", problem.Err))
+		}
+	}
+
+	if *audit {
+		auditNonces(blockchain, *difficulty).Print(os.Stdout)
+	}
 
 	if *output != "" {
-		if err := writeChainJSON(blockchain, *output); err != nil {
-			fmt.Printf("Error writing JSON: %v\n", err)
-			os.Exit(1)
-		} else {
-			fmt.Printf("Blockchain written to %s\n", *output)
+		if err := writeChainFile(blockchain, *output); err != nil {
+			logger.Error("chain_write_failed", slog.String("path", *output), slog.Any("error", err))
+			session.Error = err.Error()
+			return failReported(err)
 		}
+		logger.Info("chain_written", slog.String("path", *output), slog.Int("blocks", len(blockchain)))
 	}
 
 	// Performance summary
@@ -504,5 +1253,12 @@ func main() {
 	fmt.Printf("- Total blocks: %d\n", len(blockchain))
 	fmt.Printf("- Average generation time: %v/block\n", generationTime/time.Duration(*blocks))
 	fmt.Printf("- Validation time: %v\n", validationTime)
-	fmt.Printf("- Total runtime: %v\n", time.Since(start))
+
+	if validErr != nil {
+		// A freshly mined chain that fails validation is a bug, but the
+		// exit code still has to say so
+		session.Error = validErr.Error()
+		return failReported(validErr)
+	}
+	return 0
 }
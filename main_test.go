@@ -5,8 +5,12 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -256,4 +260,341 @@ func TestValidateChain_InvalidPoW(t *testing.T) {
 	if isChainValidConcurrent(ctx, chain, difficulty) {
 		t.Error("Expected chain to be invalid due to faulty PoW, but isChainValidConcurrent returned true")
 	}
-}
\ No newline at end of file
+}
+
+// TestProofOfWorkParallel checks that the parallel miner finds a valid nonce
+// and that the reported hash matches the block contents.
+func TestProofOfWorkParallel(t *testing.T) {
+	const difficulty = 3
+	block := &Block{Index: 1, Timestamp: 1, Data: []byte("parallel"), PrevHash: []byte("prev")}
+
+	hash, nonce, attempts, err := proofOfWorkParallel(context.Background(), block, difficulty, 4)
+	if err != nil {
+		t.Fatalf("proofOfWorkParallel failed: %v", err)
+	}
+	if attempts == 0 {
+		t.Error("expected at least one hash attempt to be reported")
+	}
+	if block.Nonce != nonce {
+		t.Errorf("block nonce %d does not match returned nonce %d", block.Nonce, nonce)
+	}
+	if !bytes.Equal(hash, calculateHash(block)) {
+		t.Error("returned hash does not match the hash of the solved block")
+	}
+	if !validateDifficulty(hash, difficulty) {
+		t.Error("returned hash does not meet difficulty")
+	}
+}
+
+// TestPoWTemplate checks that patching the nonce of a serialized block
+// gives the hash calculateHash computes, in every format version and with
+// every algorithm.
+func TestPoWTemplate(t *testing.T) {
+	epoch := &EpochSummary{Epoch: 1, Hash: make([]byte, 32)}
+	for _, block := range []*Block{
+		{Index: 1, Timestamp: 2, Data: []byte("legacy"), PrevHash: []byte("prev")},
+		{Index: 1, Timestamp: 2, Data: make([]byte, 100*1024), PrevHash: []byte("prev"), Bits: 0x1f0fffff},
+		{Index: 100, Timestamp: 2, Data: []byte("epoch"), PrevHash: []byte("prev"), Bits: 0x1f0fffff, Epoch: epoch},
+		newCandidateBlock(nil, &Block{Hash: []byte("prev")}, "merkle", 1),
+		{Index: 1, Data: []byte("extra"), MerkleRoot: make([]byte, 32), ExtraNonce: 7},
+	} {
+		for _, h := range hashers {
+			b := *block
+			b.HashAlgo = h.ID()
+			tmpl, err := newPoWTemplate(&b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, nonce := range []uint64{0, 1, 1 << 40, math.MaxUint64} {
+				b.Nonce = nonce
+				if got := tmpl.hash(nonce); !bytes.Equal(got, calculateHash(&b)) {
+					t.Errorf("version %d, %s, nonce %d: template hash differs", blockFormatVersion(&b), h.Name(), nonce)
+				}
+			}
+		}
+	}
+	if _, err := newPoWTemplate(&Block{HashAlgo: 9}); err == nil {
+		t.Error("expected an unknown hash algorithm to be rejected")
+	}
+}
+
+// BenchmarkNonceAttempt compares hashing a candidate per nonce from the
+// block with patching a serialized template.
+func BenchmarkNonceAttempt(b *testing.B) {
+	for _, size := range []int{64, 64 * 1024} {
+		legacy := &Block{Index: 1, Timestamp: 1, Data: make([]byte, size), PrevHash: make([]byte, 32), Bits: 0x1f0fffff}
+		b.Run(fmt.Sprintf("calculateHash/legacy-%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				legacy.Nonce = uint64(i)
+				calculateHash(legacy)
+			}
+		})
+		b.Run(fmt.Sprintf("template/legacy-%d", size), func(b *testing.B) {
+			tmpl, _ := newPoWTemplate(legacy)
+			for i := 0; i < b.N; i++ {
+				tmpl.hash(uint64(i))
+			}
+		})
+	}
+	candidate := newCandidateBlock(nil, &Block{Hash: make([]byte, 32)}, string(make([]byte, 64*1024)), 4)
+	b.Run("calculateHash/merkle", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			candidate.Nonce = uint64(i)
+			calculateHash(candidate)
+		}
+	})
+	b.Run("template/merkle", func(b *testing.B) {
+		tmpl, _ := newPoWTemplate(candidate)
+		for i := 0; i < b.N; i++ {
+			tmpl.hash(uint64(i))
+		}
+	})
+}
+
+// TestSolveCandidate_ExtraNonce checks that the miner rolls the extra nonce
+// when the nonce space runs out, and that the resulting version 5 block
+// validates and survives every encoding.
+func TestSolveCandidate_ExtraNonce(t *testing.T) {
+	chain := makeBlockchain(2, 2)
+	block := newCandidateBlock(nil, chain[1], "extra", 2)
+	// 4 nonces solve difficulty 2 with probability 1/64, so the search
+	// has to roll the extra nonce; it is deterministic apart from Timestamp
+	block.Timestamp = 1
+	if _, _, err := solveCandidate(context.Background(), block, 2, MinerOptions{Workers: 2}, 3); err != nil {
+		t.Fatal(err)
+	}
+	if block.ExtraNonce == 0 || block.Nonce > 3 || blockFormatVersion(block) != 0x05 {
+		t.Fatalf("unexpected solution: nonce %d, extra nonce %d", block.Nonce, block.ExtraNonce)
+	}
+	chain = append(chain, block)
+	if err := validateChain(chain, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	rolled := *block
+	rolled.ExtraNonce++
+	if bytes.Equal(calculateHash(&rolled), block.Hash) || !bytes.Equal(calculateHashStreaming(block), block.Hash) {
+		t.Error("the extra nonce is not hashed consistently")
+	}
+	for _, name := range []string{"chain.json", "chain.pb"} {
+		path := filepath.Join(t.TempDir(), name)
+		if err := writeChainFile(chain, path); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := importChain(path, &PoWEngine{Difficulty: 2}, DecodePolicy{Strict: true})
+		if err != nil || loaded[2].ExtraNonce != block.ExtraNonce {
+			t.Errorf("%s: extra nonce lost: %v", name, err)
+		}
+	}
+
+	// Nonces beyond the int64 range keep their full value
+	big := &Block{Index: 1, Nonce: math.MaxUint64}
+	var decoded Block
+	if err := decoded.UnmarshalProto(big.MarshalProto()); err != nil || decoded.Nonce != math.MaxUint64 {
+		t.Errorf("nonce decoded as %d, %v", decoded.Nonce, err)
+	}
+}
+
+// TestMinerProgress checks that a search reports growing attempt counts
+// while it runs and stops reporting once it returns.
+func TestMinerProgress(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	var reports []uint64
+	var elapsed time.Duration
+	opts := MinerOptions{Workers: 2, ProgressInterval: 10 * time.Millisecond, Progress: func(attempts uint64, d time.Duration) {
+		reports = append(reports, attempts)
+		elapsed = d
+	}}
+	block := &Block{Index: 1, Data: []byte("never")}
+	_, total, err := mineCandidate(ctx, block, 64, opts)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to stop the search, got %v", err)
+	}
+	n := len(reports)
+	if n < 2 || elapsed <= 0 {
+		t.Fatalf("got %d progress reports", n)
+	}
+	for i := 1; i < n; i++ {
+		if reports[i] < reports[i-1] || reports[i] > total {
+			t.Fatalf("attempts went from %d to %d, total %d", reports[i-1], reports[i], total)
+		}
+	}
+	if reports[n-1] == 0 {
+		t.Error("no attempts reported")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if len(reports) != n {
+		t.Error("progress reported after the search returned")
+	}
+
+	if d := expectedTimeToBlock(2, 256); d != time.Second {
+		t.Errorf("expected time %v, want 1s", d)
+	}
+	if d := expectedTimeToBlock(64, 1); d != math.MaxInt64 {
+		t.Errorf("expected time %v does not saturate", d)
+	}
+}
+
+// TestProofOfWorkParallel_Cancelled verifies that all workers stop when the context is cancelled.
+func TestProofOfWorkParallel_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	block := &Block{Index: 1, Data: []byte("never")}
+	if _, _, _, err := proofOfWorkParallel(ctx, block, 64, 4); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+// TestValidateChain_TypedErrors checks that each failure class is reported with the offending index.
+func TestValidateChain_TypedErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		tamper func(chain []*Block)
+		want   error
+	}{
+		{"broken link", func(c []*Block) { c[2].PrevHash = []byte("elsewhere") }, ErrBrokenLink},
+		{"hash mismatch", func(c []*Block) { c[2].Data = []byte("tampered") }, ErrHashMismatch},
+		{"insufficient work", func(c []*Block) {
+			c[2].Data = []byte("tampered")
+			c[2].Nonce = 0
+			for validateDifficulty(calculateHash(c[2]), 2) {
+				c[2].Nonce++
+			}
+			c[2].Hash = calculateHash(c[2])
+		}, ErrInsufficientWork},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			chain := makeBlockchain(4, 2)
+			tc.tamper(chain)
+
+			err := validateChain(chain, 2)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+			var blockErr *BlockValidationError
+			if !errors.As(err, &blockErr) || blockErr.Index != 2 {
+				t.Fatalf("expected error for block 2, got %v", err)
+			}
+		})
+	}
+}
+
+// TestValidateChainReport checks that every problem is collected, not just the first.
+func TestValidateChainReport(t *testing.T) {
+	chain := makeBlockchain(5, 1)
+	if report := validateChainReport(chain, 1); !report.Valid() || report.Err() != nil {
+		t.Fatalf("expected valid chain, got %v", report.Err())
+	}
+
+	// Overwriting stored hashes breaks only the blocks themselves, since links
+	// are checked against recomputed hashes
+	chain[1].Hash = []byte("bad")
+	chain[3].Hash = []byte("bad")
+
+	report := validateChainReport(chain, 1)
+	if len(report.Problems) != 2 {
+		t.Fatalf("expected 2 problems, got %d: %v", len(report.Problems), report.Err())
+	}
+	if report.Problems[0].Index != 1 || report.Problems[1].Index != 3 {
+		t.Errorf("unexpected problem indexes: %v", report.Err())
+	}
+	if !errors.Is(report.Err(), ErrHashMismatch) {
+		t.Errorf("expected joined error to match ErrHashMismatch")
+	}
+}
+
+// TestValidateChainConcurrent_ZeroWorkBlock guards against validators that check only hash
+// links: a block re-hashed without any proof-of-work must be rejected by the worker pool too.
+func TestValidateChainConcurrent_ZeroWorkBlock(t *testing.T) {
+	const difficulty = 2
+	chain := makeBlockchain(6, difficulty)
+
+	// Tamper with the last block and find a consistent hash that does no work
+	last := chain[len(chain)-1]
+	last.Data = []byte("forged")
+	for last.Nonce = 0; validateDifficulty(calculateHash(last), difficulty); last.Nonce++ {
+	}
+	last.Hash = calculateHash(last)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err := validateChainConcurrent(ctx, chain, difficulty, ValidateOptions{Workers: 3, Threshold: 1})
+	if !errors.Is(err, ErrInsufficientWork) {
+		t.Fatalf("expected ErrInsufficientWork from concurrent validation, got %v", err)
+	}
+}
+
+// TestValidateChainConcurrent_LowestIndex checks that the worker pool reports
+// the earliest bad block however the workers are scheduled.
+func TestValidateChainConcurrent_LowestIndex(t *testing.T) {
+	const difficulty = 1
+	chain := makeBlockchain(12, difficulty)
+	chain[3].Data = []byte("tampered")
+	chain[9].Data = []byte("tampered")
+
+	opts := ValidateOptions{Workers: 8, Threshold: 1}
+	for run := 0; run < 50; run++ {
+		err := validateChainConcurrent(context.Background(), chain, difficulty, opts)
+		var blockErr *BlockValidationError
+		if !errors.As(err, &blockErr) || blockErr.Index != 3 {
+			t.Fatalf("run %d: expected the error of block 3, got %v", run, err)
+		}
+	}
+
+	// Below the threshold the chain is validated sequentially, with the same result
+	err := validateChainConcurrent(context.Background(), chain, difficulty, ValidateOptions{})
+	var blockErr *BlockValidationError
+	if !errors.As(err, &blockErr) || blockErr.Index != 3 {
+		t.Fatalf("sequential: expected the error of block 3, got %v", err)
+	}
+}
+
+// TestBlockLimits refuses oversized blocks when they are generated,
+// decoded, validated and submitted.
+func TestBlockLimits(t *testing.T) {
+	chain := makeBlockchain(2, 1)
+	t.Cleanup(func() { blockLimits = defaultBlockLimits })
+	blockLimits = BlockLimits{MaxBlockBytes: 512, MaxDataBytes: 64}
+
+	data := strings.Repeat("x", 65)
+	if _, err := generateBlock(context.Background(), chain[1], data, 1); !errors.Is(err, ErrBlockTooLarge) {
+		t.Errorf("generateBlock: expected ErrBlockTooLarge, got %v", err)
+	}
+	if _, _, err := mineBlock(context.Background(), chain[1], data, 1, 2); !errors.Is(err, ErrBlockTooLarge) {
+		t.Errorf("mineBlock: expected ErrBlockTooLarge, got %v", err)
+	}
+
+	// Mine it under the default limits, then lower them again
+	blockLimits = defaultBlockLimits
+	oversized, err := generateBlock(context.Background(), chain[1], data, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockLimits = BlockLimits{MaxBlockBytes: 512, MaxDataBytes: 64}
+
+	var verr *BlockValidationError
+	if err := validateChain(append(chain, oversized), 1); !errors.As(err, &verr) || verr.Index != 2 || !errors.Is(err, ErrBlockTooLarge) {
+		t.Errorf("validateChain: expected ErrBlockTooLarge at block 2, got %v", err)
+	}
+	raw, _ := json.Marshal(oversized)
+	if _, err := decodeBlockJSON(raw, 2, DecodePolicy{}); !errors.Is(err, ErrBlockTooLarge) {
+		t.Errorf("lenient decode: expected ErrBlockTooLarge, got %v", err)
+	}
+
+	var resp struct{ Result *string }
+	rpcPost(t, newRPCServer(chain, 1), `{"jsonrpc":"2.0","method":"submitblock","params":[`+string(raw)+`],"id":1}`, &resp)
+	if resp.Result == nil || *resp.Result != "bad-blk-length" {
+		t.Errorf("submitblock: expected bad-blk-length, got %v", resp.Result)
+	}
+
+	// The encoded size is limited as well as the data
+	blockLimits = BlockLimits{MaxBlockBytes: oversized.protoSize() - 1, MaxDataBytes: 1000}
+	if err := checkBlockSize(oversized); !errors.Is(err, ErrBlockTooLarge) {
+		t.Errorf("expected the encoded size to be limited, got %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Block data is committed to through a Merkle tree over fixed-size chunks,
+// so a header fixes the data without carrying it. Leaves and inner nodes
+// are hashed with distinct prefixes, so a leaf can never pass for a node,
+// and an odd node is carried up a level unchanged rather than paired with
+// a copy of itself.
+
+// dataChunkSize is the size of the data chunks at the leaves of the tree;
+// the last chunk may be shorter.
+const dataChunkSize = 1024
+
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// dataChunks splits data into the leaves of its Merkle tree. Empty data
+// has a single empty leaf.
+func dataChunks(data []byte) [][]byte {
+	if len(data) == 0 {
+		return [][]byte{{}}
+	}
+	chunks := make([][]byte, 0, (len(data)+dataChunkSize-1)/dataChunkSize)
+	for len(data) > dataChunkSize {
+		chunks = append(chunks, data[:dataChunkSize])
+		data = data[dataChunkSize:]
+	}
+	return append(chunks, data)
+}
+
+// merkleRoot returns the root of the Merkle tree over data.
+func merkleRoot(h Hasher, data []byte) []byte {
+	level := merkleLeaves(h, dataChunks(data))
+	for len(level) > 1 {
+		level = merkleParents(h, level)
+	}
+	return level[0]
+}
+
+func merkleLeaves(h Hasher, chunks [][]byte) [][]byte {
+	leaves := make([][]byte, len(chunks))
+	for i, chunk := range chunks {
+		leaves[i] = merkleLeaf(h, chunk)
+	}
+	return leaves
+}
+
+// merkleParents returns the level of the tree above level.
+func merkleParents(h Hasher, level [][]byte) [][]byte {
+	parents := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			parents = append(parents, level[i])
+			continue
+		}
+		parents = append(parents, merkleNode(h, level[i], level[i+1]))
+	}
+	return parents
+}
+
+func merkleLeaf(h Hasher, chunk []byte) []byte {
+	hasher := h.New()
+	hasher.Write([]byte{merkleLeafPrefix})
+	hasher.Write(chunk)
+	return hasher.Sum(nil)
+}
+
+func merkleNode(h Hasher, left, right []byte) []byte {
+	hasher := h.New()
+	hasher.Write([]byte{merkleNodePrefix})
+	hasher.Write(left)
+	hasher.Write(right)
+	return hasher.Sum(nil)
+}
+
+// dataMerkleRoot returns the Merkle root of data under the hash algorithm
+// with the given ID, or nil if the algorithm is unknown.
+func dataMerkleRoot(algo byte, data []byte) []byte {
+	h, err := hasherByID(algo)
+	if err != nil {
+		return nil
+	}
+	return merkleRoot(h, data)
+}
+
+// MerkleProof proves that a chunk of data is a leaf of a block's Merkle
+// tree, so a client holding only the block's header can check that the
+// block includes it.
+type MerkleProof struct {
+	Index  int      `json:"index"`  // position of the chunk among the leaves
+	Leaves int      `json:"leaves"` // number of leaves in the tree
+	Chunk  []byte   `json:"chunk"`
+	Path   [][]byte `json:"path"` // sibling hashes from the leaf up to the root
+}
+
+// merkleProof builds the proof for chunk index of data.
+func merkleProof(h Hasher, data []byte, index int) (*MerkleProof, error) {
+	chunks := dataChunks(data)
+	if index < 0 || index >= len(chunks) {
+		return nil, fmt.Errorf("chunk %d not found; the data has %d chunks", index, len(chunks))
+	}
+	proof := &MerkleProof{Index: index, Leaves: len(chunks), Chunk: chunks[index]}
+	level := merkleLeaves(h, chunks)
+	for i := index; len(level) > 1; i /= 2 {
+		// The last node of an odd level has no sibling and is carried up
+		if sibling := i ^ 1; sibling < len(level) {
+			proof.Path = append(proof.Path, level[sibling])
+		}
+		level = merkleParents(h, level)
+	}
+	return proof, nil
+}
+
+// root recomputes the Merkle root the proof leads to.
+func (p *MerkleProof) root(h Hasher) ([]byte, error) {
+	if p.Leaves < 1 || p.Index < 0 || p.Index >= p.Leaves {
+		return nil, fmt.Errorf("chunk %d out of range for %d leaves", p.Index, p.Leaves)
+	}
+	node := merkleLeaf(h, p.Chunk)
+	path := p.Path
+	for i, n := p.Index, p.Leaves; n > 1; i, n = i/2, (n+1)/2 {
+		if i^1 >= n {
+			continue
+		}
+		if len(path) == 0 {
+			return nil, errors.New("merkle proof is too short")
+		}
+		if i%2 == 0 {
+			node = merkleNode(h, node, path[0])
+		} else {
+			node = merkleNode(h, path[0], node)
+		}
+		path = path[1:]
+	}
+	if len(path) != 0 {
+		return nil, errors.New("merkle proof is too long")
+	}
+	return node, nil
+}
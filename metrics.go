@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// metricsSample is one reading of a node's chain and mining metrics.
+type metricsSample struct {
+	Time       time.Time
+	Height     int
+	Difficulty float64 // difficulty of the tip's target
+	// HashRate is the local miner's hashes per second since the previous
+	// sample.
+	HashRate    float64
+	BlocksMined uint64
+	Hashes      uint64
+	StaleWork   uint64
+	RateLimited uint64 // requests refused by the rate limiter
+	// NodeID, when set, tags the sample with the node's identity so that
+	// series survive restarts and address changes
+	NodeID string
+}
+
+// sampleMetrics reads the server's metrics. prev is the previous sample,
+// or nil for the first one, which reports no hash rate.
+func (s *rpcServer) sampleMetrics(now time.Time, prev *metricsSample) metricsSample {
+	tip := s.tip()
+	sample := metricsSample{
+		Time:        now,
+		Height:      tip.Index,
+		Difficulty:  targetToDifficulty(blockTarget(tip, s.difficulty)),
+		BlocksMined: s.miner.blocks.Load(),
+		Hashes:      s.miner.hashes.Load(),
+		StaleWork:   s.miner.stale.Load(),
+		RateLimited: s.limiter.Refused(),
+	}
+	if s.identity != nil {
+		sample.NodeID = nodeID(s.identity.PublicKey)
+	}
+	if prev != nil {
+		if elapsed := now.Sub(prev.Time).Seconds(); elapsed > 0 {
+			sample.HashRate = float64(sample.Hashes-prev.Hashes) / elapsed
+		}
+	}
+	return sample
+}
+
+// metricsExporter pushes samples to a time-series backend, for users
+// without a metrics scraper. An http or https URL is an InfluxDB write
+// endpoint and receives line protocol; a graphite://host:port URL is a
+// Graphite plaintext listener.
+type metricsExporter struct {
+	url    *url.URL
+	prefix string // InfluxDB measurement, or Graphite path prefix
+	client *http.Client
+}
+
+// newMetricsExporter returns an exporter pushing to rawURL.
+func newMetricsExporter(rawURL, prefix string) (*metricsExporter, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metrics URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "graphite":
+	default:
+		return nil, fmt.Errorf("unsupported metrics URL %q (want http(s):// for InfluxDB or graphite://host:port)", rawURL)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("metrics URL %q has no host", rawURL)
+	}
+	if prefix == "" {
+		return nil, fmt.Errorf("metrics prefix must not be empty")
+	}
+	return &metricsExporter{url: u, prefix: prefix, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// push sends one sample.
+func (e *metricsExporter) push(ctx context.Context, sample metricsSample) error {
+	var buf bytes.Buffer
+	if e.url.Scheme == "graphite" {
+		writeGraphite(&buf, e.prefix, sample)
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", e.url.Host)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		_, err = conn.Write(buf.Bytes())
+		return err
+	}
+
+	writeInfluxLine(&buf, e.prefix, sample)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url.String(), &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("metrics endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// writeInfluxLine writes the sample as one InfluxDB line protocol point,
+// tagged with the node ID when it has one.
+func writeInfluxLine(w io.Writer, measurement string, s metricsSample) {
+	escape := strings.NewReplacer(",", `\,`, " ", `\ `)
+	series := escape.Replace(measurement)
+	if s.NodeID != "" {
+		series += ",node_id=" + s.NodeID
+	}
+	fmt.Fprintf(w, "%s height=%di,difficulty=%g,hash_rate=%g,blocks_mined=%di,hashes=%di,stale_work=%di,rate_limited=%di %d\n",
+		series, s.Height, s.Difficulty, s.HashRate, s.BlocksMined, s.Hashes, s.StaleWork, s.RateLimited, s.Time.UnixNano())
+}
+
+// writeGraphite writes the sample in Graphite's plaintext protocol, one
+// metric per line, with the node ID as a Graphite tag when it has one.
+func writeGraphite(w io.Writer, prefix string, s metricsSample) {
+	ts := s.Time.Unix()
+	for _, m := range []struct {
+		name  string
+		value any
+	}{
+		{"height", s.Height},
+		{"difficulty", s.Difficulty},
+		{"hash_rate", s.HashRate},
+		{"blocks_mined", s.BlocksMined},
+		{"hashes", s.Hashes},
+		{"stale_work", s.StaleWork},
+		{"rate_limited", s.RateLimited},
+	} {
+		path := prefix + "." + m.name
+		if s.NodeID != "" {
+			path += ";node_id=" + s.NodeID
+		}
+		fmt.Fprintf(w, "%s %v %d\n", path, m.value, ts)
+	}
+}
+
+// exportMetrics pushes a sample of the server's metrics every interval
+// until ctx is cancelled. Failed pushes are logged and not retried; the
+// next sample carries the cumulative counters anyway.
+func exportMetrics(ctx context.Context, server *rpcServer, e *metricsExporter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var prev *metricsSample
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sample := server.sampleMetrics(now, prev)
+			prev = &sample
+			pushCtx, cancel := context.WithTimeout(ctx, interval)
+			err := e.push(pushCtx, sample)
+			cancel()
+			if err != nil && ctx.Err() == nil {
+				server.logger.Warn("metrics_push_failed", slog.String("url", e.url.Redacted()), slog.Any("error", err))
+			}
+		}
+	}
+}
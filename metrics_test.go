@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsFormats(t *testing.T) {
+	sample := metricsSample{
+		Time:        time.Unix(1700000000, 5),
+		Height:      12,
+		Difficulty:  2,
+		HashRate:    1500.5,
+		BlocksMined: 3,
+		Hashes:      4096,
+		StaleWork:   1,
+		RateLimited: 7,
+	}
+
+	var influx strings.Builder
+	writeInfluxLine(&influx, "my chain", sample)
+	want := `my\ chain height=12i,difficulty=2,hash_rate=1500.5,blocks_mined=3i,hashes=4096i,stale_work=1i,rate_limited=7i 1700000000000000005` + "\n"
+	if influx.String() != want {
+		t.Errorf("influx line:\n got %q\nwant %q", influx.String(), want)
+	}
+
+	var graphite strings.Builder
+	writeGraphite(&graphite, "node1", sample)
+	lines := strings.Split(strings.TrimSpace(graphite.String()), "\n")
+	if len(lines) != 7 || lines[0] != "node1.height 12 1700000000" || lines[2] != "node1.hash_rate 1500.5 1700000000" {
+		t.Errorf("unexpected graphite output:\n%s", graphite.String())
+	}
+}
+
+func TestSampleMetrics(t *testing.T) {
+	server := newRPCServer(makeBlockchain(4, 2), 2)
+	first := server.sampleMetrics(time.Unix(100, 0), nil)
+	if first.Height != 3 || first.Difficulty != 2 || first.HashRate != 0 {
+		t.Errorf("first sample: %+v", first)
+	}
+	server.miner.hashes.Add(500)
+	second := server.sampleMetrics(time.Unix(110, 0), &first)
+	if second.Hashes != 500 || second.HashRate != 50 {
+		t.Errorf("second sample: %+v", second)
+	}
+}
+
+func TestMetricsExporterPush(t *testing.T) {
+	sample := metricsSample{Time: time.Unix(1700000000, 0), Height: 7}
+
+	received := make(chan string, 1)
+	influx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r.URL.RawQuery + " " + string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer influx.Close()
+
+	e, err := newMetricsExporter(influx.URL+"/write?db=chain", "blockchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.push(context.Background(), sample); err != nil {
+		t.Fatalf("influx push: %v", err)
+	}
+	if got := <-received; !strings.HasPrefix(got, "db=chain blockchain height=7i,") {
+		t.Errorf("influx received %q", got)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- line
+	}()
+
+	e, err = newMetricsExporter("graphite://"+ln.Addr().String(), "blockchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.push(context.Background(), sample); err != nil {
+		t.Fatalf("graphite push: %v", err)
+	}
+	if got := <-received; got != "blockchain.height 7 1700000000\n" {
+		t.Errorf("graphite received %q", got)
+	}
+
+	for _, bad := range []string{"udp://127.0.0.1:8089", "graphite://", "::"} {
+		if _, err := newMetricsExporter(bad, "blockchain"); err == nil {
+			t.Errorf("newMetricsExporter(%q) accepted an invalid URL", bad)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+// Errors that cancel a nonce search before it finishes.
+var (
+	// errStaleTip cancels a search whose tip has been replaced.
+	errStaleTip = errors.New("chain tip changed")
+	// errTemplateRefresh cancels a search whose template is rebuilt, or
+	// that is paused.
+	errTemplateRefresh = errors.New("template refresh requested")
+)
+
+// Miner mines on the tip of a server in its own goroutine. Each candidate
+// is built from the current tip when its search starts. When the tip
+// changes mid-search, the search is abandoned and a new candidate is built
+// on the new tip, rather than finishing a block that would fork. Refresh
+// rebuilds the candidate on demand, for when its data changes.
+//
+// A Miner is started once; after Stop it cannot be started again.
+type Miner struct {
+	server     *rpcServer
+	difficulty int
+	workers    int
+
+	mu      sync.Mutex
+	paused  bool
+	resumed chan struct{} // closed by Resume while paused
+	refresh chan struct{} // closed and replaced to cancel the search
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func newMiner(server *rpcServer, difficulty, workers int) *Miner {
+	return &Miner{
+		server:     server,
+		difficulty: difficulty,
+		workers:    workers,
+		refresh:    make(chan struct{}),
+	}
+}
+
+// Start runs the miner until ctx is cancelled or Stop is called.
+func (m *Miner) Start(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.done != nil {
+		return errors.New("miner already started")
+	}
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.done = make(chan struct{})
+	go func() {
+		defer close(m.done)
+		m.run(ctx)
+	}()
+	return nil
+}
+
+// Stop ends mining and waits for the current search to return.
+func (m *Miner) Stop() {
+	m.mu.Lock()
+	cancel, done := m.cancel, m.done
+	m.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// Pause abandons the current search and mines nothing until Resume.
+func (m *Miner) Pause() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.paused {
+		return
+	}
+	m.paused = true
+	m.resumed = make(chan struct{})
+	m.interrupt()
+}
+
+// Resume starts mining again after Pause, on a fresh candidate.
+func (m *Miner) Resume() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.paused {
+		return
+	}
+	m.paused = false
+	close(m.resumed)
+}
+
+// Paused reports whether the miner is paused.
+func (m *Miner) Paused() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.paused
+}
+
+// Refresh abandons the current search and builds a new candidate on the
+// tip. The hashes already spent are not counted as stale work.
+func (m *Miner) Refresh() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.interrupt()
+}
+
+// interrupt cancels the current search. The caller must hold m.mu.
+func (m *Miner) interrupt() {
+	close(m.refresh)
+	m.refresh = make(chan struct{})
+}
+
+// next waits while the miner is paused and returns the channel that
+// interrupts the next search, or false once ctx is cancelled.
+func (m *Miner) next(ctx context.Context) (<-chan struct{}, bool) {
+	for {
+		m.mu.Lock()
+		if !m.paused {
+			refresh := m.refresh
+			m.mu.Unlock()
+			return refresh, true
+		}
+		resumed := m.resumed
+		m.mu.Unlock()
+		select {
+		case <-resumed:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// run mines blocks until ctx is cancelled or a mined block is rejected
+// for anything but a changed tip.
+func (m *Miner) run(ctx context.Context) {
+	server, difficulty := m.server, m.difficulty
+	for {
+		refresh, ok := m.next(ctx)
+		if !ok {
+			return
+		}
+		chain, tipChanged := server.work()
+		searchCtx, cancel := context.WithCancelCause(ctx)
+		go func() {
+			select {
+			case <-tipChanged:
+				cancel(errStaleTip)
+			case <-refresh:
+				cancel(errTemplateRefresh)
+			case <-searchCtx.Done():
+			}
+		}()
+
+		start := time.Now()
+		opts := MinerOptions{Workers: m.workers, Progress: func(attempts uint64, elapsed time.Duration) {
+			rate := hashRate(attempts, elapsed)
+			server.miner.hashRate.Store(math.Float64bits(rate))
+			server.logger.Debug("mining_progress", slog.Int("index", len(chain)), slog.Uint64("attempts", attempts),
+				slog.Float64("hash_rate", rate), slog.Duration("expected_time", expectedTimeToBlock(difficulty, rate)))
+		}}
+		engine := &PoWEngine{Difficulty: difficulty, Miner: opts}
+		block, err := sealNext(searchCtx, engine, chain, fmt.Sprintf("Block %d", len(chain)))
+		attempts := engine.Hashes()
+		cause := context.Cause(searchCtx)
+		cancel(nil)
+		server.miner.hashes.Add(attempts)
+
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err == nil:
+			// A block found just as the tip changed loses the race in addBlock
+			reason := server.addBlock(ctx, block)
+			if reason == "" {
+				server.miner.blocks.Add(1)
+				server.logger.Info("block_mined", slog.Int("index", block.Index), slog.String("hash", fmt.Sprintf("%x", block.Hash)),
+					slog.Uint64("attempts", attempts), slog.Duration("duration", time.Since(start)))
+				continue
+			}
+			if reason != "bad-prevblk" && reason != "inconclusive" {
+				server.logger.Error("mined_block_rejected", slog.Int("index", block.Index), slog.String("reason", reason))
+				return
+			}
+		case errors.Is(cause, errTemplateRefresh):
+			continue
+		case !errors.Is(cause, errStaleTip):
+			server.logger.Error("mining_failed", slog.Int("index", len(chain)), slog.Any("error", err))
+			return
+		}
+
+		server.miner.stale.Add(1)
+		server.miner.staleHashes.Add(attempts)
+		server.logger.Info("stale_work_discarded",
+			slog.Int("index", len(chain)), slog.Uint64("hashes", attempts), slog.Duration("duration", time.Since(start)))
+	}
+}
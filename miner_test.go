@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds, failing the test after ten seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestMinerPauseResume pauses and resumes a running miner over RPC and
+// checks that no block is mined while it is paused.
+func TestMinerPauseResume(t *testing.T) {
+	server := newRPCServer([]*Block{newGenesisBlock(sha256Hasher{})}, 1)
+	miner := newMiner(server, 1, 1)
+	server.mining = miner
+	if err := miner.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer miner.Stop()
+	waitFor(t, "a block", func() bool { return server.miner.blocks.Load() > 0 })
+
+	var resp struct{ Error *rpcError }
+	rpcPost(t, server, `{"jsonrpc":"2.0","method":"setgenerate","params":[false],"id":1}`, &resp)
+	if resp.Error != nil || !miner.Paused() {
+		t.Fatalf("setgenerate false: %v, paused %t", resp.Error, miner.Paused())
+	}
+	// A block found just before the pause may still be added
+	time.Sleep(20 * time.Millisecond)
+	height := server.tip().Index
+	time.Sleep(50 * time.Millisecond)
+	if server.tip().Index != height {
+		t.Fatal("mined while paused")
+	}
+	var info struct{ Result map[string]any }
+	rpcPost(t, server, `{"jsonrpc":"2.0","method":"getmininginfo","id":1}`, &info)
+	if info.Result["generate"] != false {
+		t.Errorf("getmininginfo reports generate %v", info.Result["generate"])
+	}
+
+	rpcPost(t, server, `{"jsonrpc":"2.0","method":"setgenerate","params":[true],"id":1}`, &resp)
+	waitFor(t, "mining to resume", func() bool { return server.tip().Index > height })
+
+	miner.Stop()
+	if err := miner.Start(context.Background()); err == nil {
+		t.Error("a stopped miner started again")
+	}
+}
+
+// TestMinerRefresh checks that a refreshed template is not counted as
+// stale work and that mining goes on.
+func TestMinerRefresh(t *testing.T) {
+	server := newRPCServer([]*Block{newGenesisBlock(sha256Hasher{})}, 1)
+	miner := newMiner(server, 8, 1)
+	if err := miner.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer miner.Stop()
+
+	// Hashes are counted when a search ends, here only by a refresh
+	waitFor(t, "a refreshed search", func() bool {
+		miner.Refresh()
+		return server.miner.hashes.Load() > 0
+	})
+	hashes := server.miner.hashes.Load()
+	waitFor(t, "the next search", func() bool {
+		miner.Refresh()
+		return server.miner.hashes.Load() > hashes
+	})
+	if server.miner.stale.Load() != 0 {
+		t.Errorf("refreshes counted as %d stale templates", server.miner.stale.Load())
+	}
+}
+
+// TestSetGenerateWithoutMiner checks that a node that does not mine says so.
+func TestSetGenerateWithoutMiner(t *testing.T) {
+	server := newRPCServer(makeBlockchain(2, 1), 1)
+	var resp struct{ Error *rpcError }
+	rpcPost(t, server, `{"jsonrpc":"2.0","method":"setgenerate","params":[true],"id":1}`, &resp)
+	if resp.Error == nil {
+		t.Error("expected setgenerate to fail without a miner")
+	}
+}
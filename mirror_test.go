@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// memMirrorStore is a mirrorStore holding rows in memory.
+type memMirrorStore struct {
+	rows    []rpcBlock
+	applies int
+}
+
+func (s *memMirrorStore) tip(ctx context.Context) (int, error) {
+	return len(s.rows) - 1, nil
+}
+
+func (s *memMirrorStore) hashAt(ctx context.Context, height int) (string, error) {
+	if height >= len(s.rows) {
+		return "", nil
+	}
+	return s.rows[height].Hash, nil
+}
+
+func (s *memMirrorStore) apply(ctx context.Context, fork int, blocks []rpcBlock) error {
+	s.applies++
+	s.rows = append(s.rows[:fork+1], blocks...)
+	return nil
+}
+
+func TestMirrorReorg(t *testing.T) {
+	ctx := context.Background()
+	chain := makeBlockchain(5, 1)
+	src := &sliceWatchSource{chain: chain}
+	db := &memMirrorStore{}
+	m := &mirror{src: src, db: db, batch: 2}
+
+	check := func(want []*Block) {
+		t.Helper()
+		tip, err := m.poll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tip != len(want)-1 || len(db.rows) != len(want) {
+			t.Fatalf("mirrored %d blocks (tip %d), want %d", len(db.rows), tip, len(want))
+		}
+		for i, block := range want {
+			if db.rows[i].Hash != blockView(want, block).Hash {
+				t.Errorf("row %d holds another block", i)
+			}
+		}
+	}
+
+	check(chain)
+	if db.applies != 3 {
+		t.Errorf("5 blocks in batches of 2 took %d transactions", db.applies)
+	}
+	db.applies = 0
+	check(chain)
+	if db.applies != 0 {
+		t.Error("an unchanged chain was written again")
+	}
+
+	fork := extendChain(t, chain[:3], 3, "fork")
+	src.chain = fork
+	check(fork)
+
+	// A shorter branch deletes the rows above the fork without new blocks
+	src.chain = fork[:3]
+	check(fork[:3])
+}
+
+func TestMirrorSQL(t *testing.T) {
+	chain := makeBlockchain(2, 1)
+	chain[1].Data = []byte("it's \x00 data")
+	redacted := blockView(chain, chain[1])
+	redacted.Redacted, redacted.RedactionReason, redacted.Data = true, "owner's request", ""
+
+	sql := mirrorSQL(0, []rpcBlock{blockView(chain, chain[1])})
+	for _, want := range []string{
+		"DELETE FROM blocks WHERE height > 0;",
+		`'\x` + "6974277320" + "00" + `2064617461'::bytea`,
+		"ON CONFLICT (height) DO UPDATE",
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("SQL lacks %q:\n%s", want, sql)
+		}
+	}
+	if sql := mirrorSQL(0, []rpcBlock{redacted}); !strings.Contains(sql, `'owner''s request'`) || !strings.Contains(sql, "0, true,") {
+		t.Errorf("redacted block SQL:\n%s", sql)
+	}
+	if sql := mirrorSQL(3, nil); sql != "DELETE FROM blocks WHERE height > 3;\n" {
+		t.Errorf("SQL without blocks: %q", sql)
+	}
+}
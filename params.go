@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ChainParams are the parameters that define a chain. Nodes with the same
+// parameters build the same genesis block, so their chains can be compared
+// and exchanged.
+type ChainParams struct {
+	ChainID      string `json:"chain_id"`
+	NetworkMagic uint32 `json:"network_magic"`
+	GenesisData  string `json:"genesis_data"`
+	// GenesisTimestamp is in Unix seconds
+	GenesisTimestamp int64  `json:"genesis_timestamp"`
+	Difficulty       int    `json:"difficulty"`
+	HashAlgo         string `json:"hash_algo"`
+	// BlockInterval is the target time between blocks, in seconds
+	BlockInterval int64 `json:"block_interval"`
+	// MaxBlockBytes and MaxDataBytes override defaultBlockLimits when set
+	MaxBlockBytes int64 `json:"max_block_bytes,omitempty"`
+	MaxDataBytes  int64 `json:"max_data_bytes,omitempty"`
+	// Consensus is "pow", the default, or "pos". A proof-of-stake chain
+	// has slots of BlockInterval seconds and needs Validators, which only
+	// .json params files can list.
+	Consensus  string      `json:"consensus,omitempty"`
+	Validators []Validator `json:"validators,omitempty"`
+	// FormatActivations are the heights from which block format versions
+	// are valid; see FormatActivation. Only .json params files can list
+	// them, and versions without one are valid at every height.
+	FormatActivations []FormatActivation `json:"format_activations,omitempty"`
+	// Checkpoints pin block hashes, in hex, by height; see
+	// chainCheckpoints. Only .json params files can list them.
+	Checkpoints map[int]string `json:"checkpoints,omitempty"`
+	// Features are the experimental features the chain's nodes enable;
+	// see featureRegistry. Only .json params files can list them.
+	Features []string `json:"features,omitempty"`
+}
+
+// defaultChainParams are those of mainnet, used when a command is given
+// neither -network nor -params.
+var defaultChainParams = networks["mainnet"]
+
+// networks are the built-in chain parameters, selected with -network.
+// regtest has difficulty 0, so blocks are mined instantly, for
+// integration tests.
+var networks = map[string]ChainParams{
+	"mainnet": {
+		ChainID:          "main",
+		NetworkMagic:     0x4d46426d,
+		GenesisData:      "Genesis",
+		GenesisTimestamp: 1704067200, // 2024-01-01T00:00:00Z
+		Difficulty:       4,
+		HashAlgo:         "sha256",
+		BlockInterval:    600,
+	},
+	"testnet": {
+		ChainID:          "test",
+		NetworkMagic:     0x4d464274,
+		GenesisData:      "Testnet genesis",
+		GenesisTimestamp: 1704067200,
+		Difficulty:       2,
+		HashAlgo:         "sha256",
+		BlockInterval:    60,
+	},
+	"regtest": {
+		ChainID:          "regtest",
+		NetworkMagic:     0x4d464272,
+		GenesisData:      "Regtest genesis",
+		GenesisTimestamp: 1704067200,
+		Difficulty:       0,
+		HashAlgo:         "sha256",
+		BlockInterval:    1,
+	},
+}
+
+// networkParams returns the parameters of a built-in network.
+func networkParams(name string) (ChainParams, error) {
+	params, ok := networks[strings.ToLower(name)]
+	if !ok {
+		names := make([]string, 0, len(networks))
+		for n := range networks {
+			names = append(names, n)
+		}
+		slices.Sort(names)
+		return ChainParams{}, fmt.Errorf("unknown network %q (want one of %s)", name, strings.Join(names, ", "))
+	}
+	return params, nil
+}
+
+// loadChainParams reads chain parameters from a .json, .yaml or .yml file.
+// Fields the file leaves out keep their values in base.
+func loadChainParams(path string, base ChainParams) (*ChainParams, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+	case ".yaml", ".yml":
+		if raw, err = flatYAMLToJSON(raw); err != nil {
+			return nil, &ChainDecodeError{Path: path, Err: err}
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported chain params format %q (use .json, .yaml or .yml)", path, ext)
+	}
+
+	params := base
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&params); err != nil {
+		return nil, &ChainDecodeError{Path: path, Err: err}
+	}
+	if err := params.check(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &params, nil
+}
+
+// flatYAMLToJSON converts the YAML subset chain params and retention
+// policies need, one "key: value" per line with # comments, to a JSON
+// object. Integers, including 0x hex, become numbers; other values become
+// strings.
+func flatYAMLToJSON(raw []byte) ([]byte, error) {
+	fields := make(map[string]any)
+	sc := bufio.NewScanner(bytes.NewReader(raw))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line == "---" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", n)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if _, dup := fields[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", n, key)
+		}
+		switch {
+		case len(value) >= 2 && (value[0] == '"' || value[0] == '\''):
+			if value[len(value)-1] != value[0] {
+				return nil, fmt.Errorf("line %d: unterminated string", n)
+			}
+			fields[key] = value[1 : len(value)-1]
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+			if v, err := strconv.ParseInt(value, 0, 64); err == nil {
+				fields[key] = v
+			} else {
+				fields[key] = value
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+func (p *ChainParams) check() error {
+	if p.ChainID == "" {
+		return fmt.Errorf("chain_id must be set")
+	}
+	if p.Difficulty < 0 || p.Difficulty > 32 {
+		return fmt.Errorf("difficulty must be between 0 and 32")
+	}
+	if p.BlockInterval <= 0 {
+		return fmt.Errorf("block_interval must be positive")
+	}
+	if _, err := hasherByName(p.HashAlgo); err != nil {
+		return err
+	}
+	if p.MaxBlockBytes < 0 || p.MaxDataBytes < 0 {
+		return fmt.Errorf("max_block_bytes and max_data_bytes must not be negative")
+	}
+	if limits := p.limits(); limits.MaxDataBytes > limits.MaxBlockBytes {
+		return fmt.Errorf("max_data_bytes %d exceeds max_block_bytes %d", limits.MaxDataBytes, limits.MaxBlockBytes)
+	}
+	if err := checkFormatActivations(p.FormatActivations); err != nil {
+		return err
+	}
+	if _, err := parseCheckpoints(p.Checkpoints); err != nil {
+		return err
+	}
+	if err := checkFeatures(p.Features); err != nil {
+		return err
+	}
+	switch p.Consensus {
+	case "", ConsensusPoW:
+		if len(p.Validators) != 0 {
+			return fmt.Errorf("validators are set on a proof-of-work chain")
+		}
+	case ConsensusPoS:
+		if _, _, err := parseValidators(p.Validators); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown consensus %q (want %s or %s)", p.Consensus, ConsensusPoW, ConsensusPoS)
+	}
+	return nil
+}
+
+// limits returns the chain's block limits, the defaults for those unset.
+func (p *ChainParams) limits() BlockLimits {
+	limits := defaultBlockLimits
+	if p.MaxBlockBytes > 0 {
+		limits.MaxBlockBytes = p.MaxBlockBytes
+	}
+	if p.MaxDataBytes > 0 {
+		limits.MaxDataBytes = p.MaxDataBytes
+	}
+	return limits
+}
+
+// genesisBlock builds the chain's first block. It depends only on the
+// parameters, so every node derives the same block.
+func (p *ChainParams) genesisBlock() (*Block, error) {
+	hasher, err := hasherByName(p.HashAlgo)
+	if err != nil {
+		return nil, err
+	}
+	return p.genesisWith(hasher), nil
+}
+
+func (p *ChainParams) genesisWith(hasher Hasher) *Block {
+	b := &Block{
+		Index:     0,
+		Timestamp: p.GenesisTimestamp,
+		Data:      []byte(p.GenesisData),
+		PrevHash:  []byte{},
+		HashAlgo:  hasher.ID(),
+	}
+	b.Hash = calculateHash(b)
+	return b
+}
+
+// addChainFlags registers -network, -params and -features on fs. Commands
+// that take them resolve their parameters with chainParamsFlags.
+func addChainFlags(fs *flag.FlagSet) (network, params *string) {
+	network = fs.String("network", "mainnet", "built-in chain parameters: mainnet, testnet or regtest")
+	params = fs.String("params", "", "chain parameters file (.json or .yaml) overriding those of -network")
+	fs.String("features", "", "comma-separated experimental features to enable, besides those of the params file: pos, grpc or stratum")
+	return network, params
+}
+
+// chainParamsFlags resolves the chain parameters of a command from its
+// -network, its -params file, if any, and its -difficulty and -hash flags.
+// -difficulty and -hash override the network and file when given
+// explicitly, and apply with their defaults when neither is, and
+// -features adds to the file's features. The chain's block limits, format
+// activations and features become blockLimits, formatActivations and
+// enabledFeatures for the rest of the command.
+func chainParamsFlags(fs *flag.FlagSet, network, path string) (*ChainParams, error) {
+	params, err := networkParams(network)
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		p, err := loadChainParams(path, params)
+		if err != nil {
+			return nil, err
+		}
+		params = *p
+	}
+	chosen := flagPassed(fs, "network") || path != ""
+	if f := fs.Lookup("difficulty"); f != nil && (!chosen || flagPassed(fs, "difficulty")) {
+		params.Difficulty = f.Value.(flag.Getter).Get().(int)
+	}
+	if f := fs.Lookup("hash"); f != nil && (!chosen || flagPassed(fs, "hash")) {
+		params.HashAlgo = f.Value.String()
+	}
+	if f := fs.Lookup("features"); f != nil {
+		params.Features = slices.Clone(params.Features)
+		for _, name := range parseFeatures(f.Value.String()) {
+			if !slices.Contains(params.Features, name) {
+				params.Features = append(params.Features, name)
+			}
+		}
+	}
+	if err := params.check(); err != nil {
+		return nil, err
+	}
+	if params.Consensus == ConsensusPoS && !slices.Contains(params.Features, FeaturePoS) {
+		return nil, fmt.Errorf("consensus %s needs the experimental %s feature (enable it with -features %s)", ConsensusPoS, FeaturePoS, FeaturePoS)
+	}
+	blockLimits = params.limits()
+	formatActivations = params.FormatActivations
+	enabledFeatures = params.Features
+	chainCheckpoints, _ = parseCheckpoints(params.Checkpoints)
+	chainValidatorsHash = nil
+	if params.Consensus == ConsensusPoS {
+		chainValidatorsHash = validatorSetHash(params.Validators)
+	}
+	return &params, nil
+}
+
+// chainEngineFlags is chainParamsFlags for commands that validate a chain
+// without sealing blocks. It also returns the chain's consensus engine,
+// which has no validator key.
+func chainEngineFlags(fs *flag.FlagSet, network, path string) (*ChainParams, Engine, error) {
+	params, err := chainParamsFlags(fs, network, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	genesis, err := params.genesisBlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	engine, err := params.engine(genesis, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return params, engine, nil
+}
+
+// flagPassed reports whether the flag name was given on the command line.
+func flagPassed(fs *flag.FlagSet, name string) bool {
+	passed := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+	return passed
+}
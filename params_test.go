@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadChainParams(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "testnet.json")
+	yamlPath := filepath.Join(dir, "testnet.yaml")
+	os.WriteFile(jsonPath, []byte(`{"chain_id": "testnet", "network_magic": 185665799, "genesis_data": "Test genesis: 1", "genesis_timestamp": 1700000000, "difficulty": 2, "hash_algo": "blake3", "block_interval": 60}`), 0o644)
+	os.WriteFile(yamlPath, []byte(`---
+# test network
+chain_id: testnet
+network_magic: 0x0b110907
+genesis_data: "Test genesis: 1"
+genesis_timestamp: 1700000000 # 2023-11-14
+difficulty: 2
+hash_algo: blake3
+block_interval: 60
+`), 0o644)
+
+	fromJSON, err := loadChainParams(jsonPath, defaultChainParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromYAML, err := loadChainParams(yamlPath, defaultChainParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromJSON, fromYAML) {
+		t.Errorf("JSON and YAML params differ:\n%+v\n%+v", fromJSON, fromYAML)
+	}
+
+	a, err := fromJSON.genesisBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := fromYAML.genesisBlock()
+	if !bytes.Equal(a.Hash, b.Hash) || a.Timestamp != 1700000000 || a.HashAlgo != HashBLAKE3 {
+		t.Errorf("genesis blocks differ: %+v %+v", a, b)
+	}
+	if bytes.Equal(a.Hash, newGenesisBlock(blake3Hasher{}).Hash) {
+		t.Error("testnet genesis equals the default genesis")
+	}
+	if !bytes.Equal(newGenesisBlock(sha256Hasher{}).Hash, newGenesisBlock(sha256Hasher{}).Hash) {
+		t.Error("default genesis is not deterministic")
+	}
+
+	for name, content := range map[string]string{
+		"unknown.yaml":  "chain_id: x\nmagic: 1\n",
+		"badtype.json":  `{"difficulty": "two"}`,
+		"range.yaml":    "difficulty: 40\n",
+		"noalgo.yaml":   "hash_algo: md5\n",
+		"interval.json": `{"block_interval": 0}`,
+		"params.toml":   "difficulty = 2\n",
+		"negative.yaml": "max_block_bytes: -1\n",
+		"limits.json":   `{"max_block_bytes": 1000, "max_data_bytes": 2000}`,
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0o644)
+		if _, err := loadChainParams(path, defaultChainParams); err == nil {
+			t.Errorf("%s: loaded invalid params", name)
+		}
+	}
+}
+
+func TestChainParamsFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "params.yaml")
+	os.WriteFile(path, []byte("difficulty: 2\nhash_algo: sha3-256\n"), 0o644)
+
+	resolve := func(args ...string) (*ChainParams, error) {
+		t.Helper()
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Int("difficulty", 4, "")
+		fs.String("hash", "sha256", "")
+		network, paramsPath := addChainFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		return chainParamsFlags(fs, *network, *paramsPath)
+	}
+
+	for _, tc := range []struct {
+		args       []string
+		chainID    string
+		difficulty int
+		hash       string
+	}{
+		{nil, "main", 4, "sha256"},
+		{[]string{"-difficulty", "1"}, "main", 1, "sha256"},
+		{[]string{"-params", path}, "main", 2, "sha3-256"},
+		{[]string{"-params", path, "-difficulty", "3"}, "main", 3, "sha3-256"},
+		{[]string{"-network", "regtest"}, "regtest", 0, "sha256"},
+		{[]string{"-network", "testnet", "-params", path}, "test", 2, "sha3-256"},
+		{[]string{"-network", "testnet", "-hash", "blake3"}, "test", 2, "blake3"},
+	} {
+		p, err := resolve(tc.args...)
+		if err != nil {
+			t.Errorf("%v: %v", tc.args, err)
+			continue
+		}
+		if p.ChainID != tc.chainID || p.Difficulty != tc.difficulty || p.HashAlgo != tc.hash {
+			t.Errorf("%v: got %s, difficulty %d, %s", tc.args, p.ChainID, p.Difficulty, p.HashAlgo)
+		}
+	}
+	if _, err := resolve("-network", "devnet"); err == nil {
+		t.Error("unknown network accepted")
+	}
+
+	t.Cleanup(func() { blockLimits = defaultBlockLimits })
+	os.WriteFile(path, []byte("max_data_bytes: 4096\n"), 0o644)
+	if _, err := resolve("-params", path); err != nil {
+		t.Fatal(err)
+	}
+	if want := (BlockLimits{MaxBlockBytes: defaultBlockLimits.MaxBlockBytes, MaxDataBytes: 4096}); blockLimits != want {
+		t.Errorf("block limits %+v, want %+v", blockLimits, want)
+	}
+}
+
+func TestNetworks(t *testing.T) {
+	// Bitcoin's mainnet, testnet3 and regtest magics, which would let
+	// either network's nodes take the other's messages for their own
+	magics := map[uint32]string{0xd9b4bef9: "bitcoin", 0x0709110b: "bitcoin testnet", 0xdab5bffa: "bitcoin regtest"}
+	geneses := make(map[string]string)
+	for name, params := range networks {
+		if err := params.check(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		genesis, err := params.genesisBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other, dup := magics[params.NetworkMagic]; dup {
+			t.Errorf("%s and %s share a network magic", name, other)
+		}
+		if other, dup := geneses[string(genesis.Hash)]; dup {
+			t.Errorf("%s and %s share a genesis block", name, other)
+		}
+		magics[params.NetworkMagic] = name
+		geneses[string(genesis.Hash)] = name
+	}
+
+	// regtest mines instantly
+	regtest := networks["regtest"]
+	genesis, _ := regtest.genesisBlock()
+	block, hashes, err := mineBlock(context.Background(), genesis, "instant", regtest.Difficulty, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashes != 1 {
+		t.Errorf("regtest block took %d hashes", hashes)
+	}
+	if err := validateChain([]*Block{genesis, block}, regtest.Difficulty); err != nil {
+		t.Errorf("regtest chain: %v", err)
+	}
+}
+
+func TestNetworkMagic(t *testing.T) {
+	chain := makeBlockchain(2, 1)
+	server := newRPCServer(chain, 1)
+	server.magic = networks["testnet"].NetworkMagic
+	node := httptest.NewServer(server)
+	defer node.Close()
+
+	var height int
+	client := newRPCClient(node.URL)
+	if err := client.call(context.Background(), "getblockcount", &height); err != nil {
+		t.Errorf("request without a magic: %v", err)
+	}
+	client.magic = networks["testnet"].NetworkMagic
+	if err := client.call(context.Background(), "getblockcount", &height); err != nil {
+		t.Errorf("request with the node's magic: %v", err)
+	}
+
+	client.magic = networks["mainnet"].NetworkMagic
+	next, _, _ := mineBlock(context.Background(), chain[1], "mainnet block", 1, 1)
+	var reason any
+	err := client.call(context.Background(), "submitblock", &reason, next)
+	var rpcErr *rpcError
+	if !errors.As(err, &rpcErr) || rpcErr.Code != rpcWrongNetwork {
+		t.Errorf("block from another network: got %v, want a wrong network error", err)
+	}
+	if tip := server.tip(); tip.Index != 1 {
+		t.Errorf("block from another network was appended at %d", tip.Index)
+	}
+	if _, err := client.proof(context.Background(), 0, 0); err == nil || !strings.Contains(err.Error(), "wrong network") {
+		t.Errorf("proof request from another network: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Payment requests are URIs of the form
+//
+//	myfbc:<address>?amount=1.5&memo=coffee
+//
+// modelled on Bitcoin's BIP 21. Amounts are in coins with up to eight
+// decimal places. Unknown parameters are ignored unless they start with
+// "req-", which marks them as required and makes the URI invalid.
+const (
+	paymentURIScheme = "myfbc"
+
+	// coinUnits is the number of base units in one coin.
+	coinUnits    = 100_000_000
+	coinDecimals = 8
+)
+
+// PaymentRequest is a parsed payment URI. Amount is in base units and zero
+// when the URI does not specify one.
+type PaymentRequest struct {
+	Address string
+	Amount  uint64
+	Memo    string
+}
+
+// String renders the request as a URI accepted by parsePaymentURI.
+func (r PaymentRequest) String() string {
+	q := url.Values{}
+	if r.Amount > 0 {
+		q.Set("amount", formatAmount(r.Amount))
+	}
+	if r.Memo != "" {
+		q.Set("memo", r.Memo)
+	}
+	s := paymentURIScheme + ":" + r.Address
+	if len(q) > 0 {
+		// Encode spaces as %20, which every URI handler understands
+		s += "?" + strings.ReplaceAll(q.Encode(), "+", "%20")
+	}
+	return s
+}
+
+// parsePaymentURI parses and validates a payment URI. The address must be a
+// valid base58check address, and each parameter may appear at most once.
+func parsePaymentURI(s string) (PaymentRequest, error) {
+	scheme, rest, ok := strings.Cut(s, ":")
+	if !ok || !strings.EqualFold(scheme, paymentURIScheme) {
+		return PaymentRequest{}, fmt.Errorf("payment URI must start with %q", paymentURIScheme+":")
+	}
+	addr, rawQuery, _ := strings.Cut(rest, "?")
+	if strings.HasPrefix(addr, "//") {
+		return PaymentRequest{}, errors.New("payment URI must not contain an authority")
+	}
+	if _, err := decodeAddress(addr); err != nil {
+		return PaymentRequest{}, err
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return PaymentRequest{}, fmt.Errorf("invalid payment URI query: %w", err)
+	}
+	req := PaymentRequest{Address: addr}
+	for key, values := range query {
+		if len(values) > 1 {
+			return PaymentRequest{}, fmt.Errorf("parameter %q appears more than once", key)
+		}
+		switch {
+		case key == "amount":
+			if req.Amount, err = parseAmount(values[0]); err != nil {
+				return PaymentRequest{}, err
+			}
+			if req.Amount == 0 {
+				return PaymentRequest{}, errors.New("amount must be positive")
+			}
+		case key == "memo":
+			req.Memo = values[0]
+		case strings.HasPrefix(key, "req-"):
+			return PaymentRequest{}, fmt.Errorf("unsupported required parameter %q", key)
+		}
+	}
+	return req, nil
+}
+
+// parseAmount converts a decimal coin amount such as "1.25" to base units.
+// Signs, exponents and more than eight decimal places are rejected.
+func parseAmount(s string) (uint64, error) {
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" || len(frac) > coinDecimals || strings.Contains(s, ".") && frac == "" {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	for _, c := range whole + frac {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("invalid amount %q", s)
+		}
+	}
+
+	coins, err := strconv.ParseUint(whole, 10, 64)
+	if err != nil || coins > (1<<64-1)/coinUnits {
+		return 0, fmt.Errorf("amount %q is too large", s)
+	}
+	units, _ := strconv.ParseUint(frac+strings.Repeat("0", coinDecimals-len(frac)), 10, 64)
+	total := coins*coinUnits + units
+	if total < coins*coinUnits {
+		return 0, fmt.Errorf("amount %q is too large", s)
+	}
+	return total, nil
+}
+
+// formatAmount renders base units as a decimal coin amount without
+// trailing zeros.
+func formatAmount(units uint64) string {
+	s := fmt.Sprintf("%d.%08d", units/coinUnits, units%coinUnits)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestPaymentURI_RoundTrip checks that rendered requests parse back unchanged.
+func TestPaymentURI_RoundTrip(t *testing.T) {
+	w, err := NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := PaymentRequest{Address: w.Address(), Amount: 150_000_000, Memo: "coffee & cake"}
+
+	uri := req.String()
+	if !strings.HasPrefix(uri, "myfbc:"+w.Address()+"?amount=1.5&") || strings.Contains(uri, "+") {
+		t.Errorf("unexpected URI %q", uri)
+	}
+	parsed, err := parsePaymentURI(uri)
+	if err != nil {
+		t.Fatalf("parsePaymentURI(%q): %v", uri, err)
+	}
+	if parsed != req {
+		t.Errorf("round trip changed request: %+v != %+v", parsed, req)
+	}
+}
+
+// TestPaymentURI_Rejects covers the inputs the strict parser must refuse.
+func TestPaymentURI_Rejects(t *testing.T) {
+	w, err := NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := w.Address()
+	typo := "x"
+	if strings.HasSuffix(addr, typo) {
+		typo = "y"
+	}
+	bad := map[string]string{
+		"wrong scheme":       "bitcoin:" + addr,
+		"authority":          "myfbc://" + addr,
+		"bad address":        "myfbc:" + addr[:len(addr)-1] + typo,
+		"duplicate amount":   "myfbc:" + addr + "?amount=1&amount=2",
+		"negative amount":    "myfbc:" + addr + "?amount=-1",
+		"zero amount":        "myfbc:" + addr + "?amount=0",
+		"exponent amount":    "myfbc:" + addr + "?amount=1e3",
+		"too many decimals":  "myfbc:" + addr + "?amount=0.000000001",
+		"overflowing amount": "myfbc:" + addr + "?amount=184467440737.09551616",
+		"required parameter": "myfbc:" + addr + "?req-expires=10",
+	}
+	for name, uri := range bad {
+		if _, err := parsePaymentURI(uri); err == nil {
+			t.Errorf("%s: %q should be rejected", name, uri)
+		}
+	}
+
+	if _, err := parsePaymentURI("MYFBC:" + addr + "?label=shop"); err != nil {
+		t.Errorf("uppercase scheme and unknown optional params should be accepted: %v", err)
+	}
+}
+
+// TestParseAmount checks decimal conversion to base units.
+func TestParseAmount(t *testing.T) {
+	cases := map[string]uint64{
+		"1":                    coinUnits,
+		"0.00000001":           1,
+		"12.5":                 1_250_000_000,
+		"184467440737.0955161": 18446744073709551610,
+	}
+	for in, want := range cases {
+		got, err := parseAmount(in)
+		if err != nil || got != want {
+			t.Errorf("parseAmount(%q) = %d, %v; want %d", in, got, err, want)
+		}
+		if in != "184467440737.0955161" && formatAmount(got) != in {
+			t.Errorf("formatAmount(%d) = %q, want %q", got, formatAmount(got), in)
+		}
+	}
+	for _, in := range []string{"", ".5", "1.", "1,5", "+1", " 1"} {
+		if _, err := parseAmount(in); err == nil {
+			t.Errorf("parseAmount(%q) should fail", in)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Peers are the nodes this one trades blocks with: the clients that submit
+// blocks to it over JSON-RPC, known by IP address, and the nodes a light
+// client syncs from, known by URL. A PeerManager scores them as Bitcoin
+// Core does: each misbehavior adds points, and a peer reaching banScore is
+// banned for banDuration. Bans are kept in the data directory, so they
+// survive restarts. Clients on a loopback address are scored but never
+// banned, so a local tool cannot lock its own operator out.
+
+// banScore is the misbehavior score at which a peer is banned.
+const banScore = 100
+
+// banDuration is how long a peer stays banned, as Bitcoin Core's -bantime.
+const banDuration = 24 * time.Hour
+
+// peerBansName is the file in a data directory holding the ban list.
+const peerBansName = "banned.json"
+
+// Misbehavior penalties. An invalid block is proof of a broken or hostile
+// peer and bans it at once; protocol violations may be bugs and take
+// several.
+const (
+	penaltyInvalidBlock = 100
+	penaltyProtocol     = 10
+)
+
+// maxPeers is how many peers a PeerManager scores before it forgets the
+// one seen longest ago, so clients cycling addresses cannot grow it
+// without bound. Bans are kept apart and are not forgotten.
+const maxPeers = 10000
+
+// latencyWeight is the weight of a new sample in a peer's latency average.
+const latencyWeight = 0.2
+
+// Ban is an entry of the ban list.
+type Ban struct {
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason"`
+}
+
+// PeerInfo is what a PeerManager knows of a peer.
+type PeerInfo struct {
+	Addr        string        `json:"addr"`
+	Latency     time.Duration `json:"latency"`
+	Misbehavior int           `json:"misbehavior"`
+	// Score ranks peers, higher first: 100 less misbehavior and up to 50
+	// points for latency, one per 10ms
+	Score    int       `json:"score"`
+	LastSeen time.Time `json:"last_seen"`
+	Banned   bool      `json:"banned"`
+}
+
+type peerState struct {
+	latency     time.Duration
+	misbehavior int
+	lastSeen    time.Time
+}
+
+// PeerManager tracks peers' latency and misbehavior and keeps the ban
+// list. It is safe for concurrent use.
+type PeerManager struct {
+	path string // ban list file; "" keeps bans in memory
+	now  func() time.Time
+
+	mu    sync.Mutex
+	peers map[string]*peerState
+	bans  map[string]Ban
+	known map[string]KnownPeer // by URL
+}
+
+// newPeerManager returns a manager keeping its ban list in dataDir, or in
+// memory when dataDir is "". Expired bans are dropped.
+func newPeerManager(dataDir string) (*PeerManager, error) {
+	m := &PeerManager{now: time.Now, peers: make(map[string]*peerState), bans: make(map[string]Ban), known: make(map[string]KnownPeer)}
+	if dataDir == "" {
+		return m, nil
+	}
+	m.path = filepath.Join(dataDir, peerBansName)
+	raw, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &m.bans); err != nil {
+		return nil, fmt.Errorf("%s: %w", m.path, err)
+	}
+	for addr, ban := range m.bans {
+		if !ban.Until.After(m.now()) {
+			delete(m.bans, addr)
+		}
+	}
+	return m, nil
+}
+
+// peer returns the state of addr, creating it. The caller must hold m.mu.
+func (m *PeerManager) peer(addr string) *peerState {
+	p, ok := m.peers[addr]
+	if !ok {
+		if len(m.peers) >= maxPeers {
+			m.forget()
+		}
+		p = &peerState{}
+		m.peers[addr] = p
+	}
+	p.lastSeen = m.now()
+	return p
+}
+
+// forget drops the peer seen longest ago. The caller must hold m.mu.
+func (m *PeerManager) forget() {
+	var stalest string
+	for addr, p := range m.peers {
+		if stalest == "" || p.lastSeen.Before(m.peers[stalest].lastSeen) {
+			stalest = addr
+		}
+	}
+	delete(m.peers, stalest)
+}
+
+// RecordLatency adds a round-trip time measured to addr to its average.
+func (m *PeerManager) RecordLatency(addr string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.peer(addr)
+	if p.latency == 0 {
+		p.latency = d
+		return
+	}
+	p.latency += time.Duration(latencyWeight * float64(d-p.latency))
+}
+
+// Misbehaving adds points to the misbehavior score of addr and bans it once
+// the score reaches banScore. It reports whether addr is now banned.
+func (m *PeerManager) Misbehaving(addr string, points int, reason string) (bool, error) {
+	m.mu.Lock()
+	p := m.peer(addr)
+	p.misbehavior += points
+	score := p.misbehavior
+	m.mu.Unlock()
+	if score < banScore || isLoopback(addr) {
+		return false, nil
+	}
+	return true, m.Ban(addr, banDuration, fmt.Sprintf("misbehavior score %d: %s", score, reason))
+}
+
+// Ban bans addr for d and saves the ban list.
+func (m *PeerManager) Ban(addr string, d time.Duration, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bans[addr] = Ban{Until: m.now().Add(d), Reason: reason}
+	return m.save()
+}
+
+// Unban lifts the ban of addr and clears its misbehavior score. It
+// reports whether addr was banned.
+func (m *PeerManager) Unban(addr string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p, ok := m.peers[addr]; ok {
+		p.misbehavior = 0
+	}
+	if _, ok := m.bans[addr]; !ok {
+		return false, nil
+	}
+	delete(m.bans, addr)
+	return true, m.save()
+}
+
+// Banned reports whether addr is banned. A loopback address never is,
+// even if a ban list names it.
+func (m *PeerManager) Banned(addr string) bool {
+	if isLoopback(addr) {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ban, ok := m.bans[addr]
+	return ok && ban.Until.After(m.now())
+}
+
+// Bans returns the bans in force.
+func (m *PeerManager) Bans() map[string]Ban {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bans := make(map[string]Ban, len(m.bans))
+	for addr, ban := range m.bans {
+		if ban.Until.After(m.now()) {
+			bans[addr] = ban
+		}
+	}
+	return bans
+}
+
+// Peers returns the peers seen, best score first and banned peers last.
+func (m *PeerManager) Peers() []PeerInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	peers := make([]PeerInfo, 0, len(m.peers))
+	for addr, p := range m.peers {
+		ban, banned := m.bans[addr]
+		peers = append(peers, PeerInfo{
+			Addr:        addr,
+			Latency:     p.latency,
+			Misbehavior: p.misbehavior,
+			Score:       banScore - p.misbehavior - min(int(p.latency/(10*time.Millisecond)), 50),
+			LastSeen:    p.lastSeen,
+			Banned:      banned && ban.Until.After(m.now()),
+		})
+	}
+	slices.SortFunc(peers, func(a, b PeerInfo) int {
+		if a.Banned != b.Banned {
+			if a.Banned {
+				return 1
+			}
+			return -1
+		}
+		return cmp.Or(cmp.Compare(b.Score, a.Score), cmp.Compare(a.Addr, b.Addr))
+	})
+	return peers
+}
+
+// save writes the ban list, replacing the file atomically. The caller
+// must hold m.mu.
+func (m *PeerManager) save() error {
+	if m.path == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(m.bans, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// isLoopback reports whether addr, an IP address or host name, is a
+// loopback address.
+func isLoopback(addr string) bool {
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip.IsLoopback()
+	}
+	return addr == "localhost"
+}
+
+// remoteHost returns the IP address of the client of r, the peer address
+// of JSON-RPC clients. The port is left out, since a client gets a new
+// one with every connection.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// peerKey is the context key of the peer a request came from.
+type peerKey struct{}
+
+func withPeer(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, peerKey{}, addr)
+}
+
+// peerFrom returns the peer stored by withPeer, or "".
+func peerFrom(ctx context.Context) string {
+	addr, _ := ctx.Value(peerKey{}).(string)
+	return addr
+}
+
+// callPeers serves getpeerinfo, listbanned and setban. On a node with
+// tenants only admins may change the ban list; see authorize.
+func (s *rpcServer) callPeers(ctx context.Context, method string, params []json.RawMessage) (any, error) {
+	if s.peers == nil {
+		return nil, &rpcError{Code: rpcInvalidRequest, Message: "this node has no peer manager"}
+	}
+	switch method {
+	case "getpeerinfo":
+		if err := rpcArgs(params); err != nil {
+			return nil, err
+		}
+		return s.peers.Peers(), nil
+	case "listbanned":
+		if err := rpcArgs(params); err != nil {
+			return nil, err
+		}
+		return s.peers.Bans(), nil
+	}
+
+	// setban "addr" "add"|"remove" [seconds], as in Bitcoin Core
+	var addr, command string
+	seconds := int64(banDuration / time.Second)
+	var err error
+	if len(params) == 3 {
+		err = rpcArgs(params, &addr, &command, &seconds)
+	} else {
+		err = rpcArgs(params, &addr, &command)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch command {
+	case "add":
+		if seconds <= 0 {
+			return nil, &rpcError{Code: rpcInvalidParam, Message: "ban time must be positive"}
+		}
+		err = s.peers.Ban(addr, time.Duration(seconds)*time.Second, "manually added")
+	case "remove":
+		var banned bool
+		if banned, err = s.peers.Unban(addr); err == nil && !banned {
+			return nil, &rpcError{Code: rpcMiscError, Message: "Error: Unban failed. Requested address/subnet was not previously manually banned."}
+		}
+	default:
+		return nil, &rpcError{Code: rpcInvalidParam, Message: `command must be "add" or "remove"`}
+	}
+	if err != nil {
+		return nil, &rpcError{Code: rpcMiscError, Message: err.Error()}
+	}
+	return nil, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPeerManagerScores(t *testing.T) {
+	m, err := newPeerManager("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.RecordLatency("10.0.0.1", 100*time.Millisecond)
+	m.RecordLatency("10.0.0.1", 200*time.Millisecond)
+	m.RecordLatency("10.0.0.2", 10*time.Millisecond)
+	if banned, err := m.Misbehaving("10.0.0.2", penaltyProtocol, "test"); err != nil || banned {
+		t.Fatalf("Misbehaving = %v, %v; one protocol violation must not ban", banned, err)
+	}
+
+	peers := m.Peers()
+	if len(peers) != 2 {
+		t.Fatalf("got %d peers, want 2", len(peers))
+	}
+	if peers[0].Addr != "10.0.0.2" || peers[0].Score != banScore-penaltyProtocol-1 {
+		t.Errorf("best peer %+v, want 10.0.0.2 with score %d", peers[0], banScore-penaltyProtocol-1)
+	}
+	if got := peers[1].Latency; got != 120*time.Millisecond {
+		t.Errorf("latency average %v, want 120ms", got)
+	}
+
+	for range banScore/penaltyProtocol - 2 {
+		m.Misbehaving("10.0.0.2", penaltyProtocol, "test")
+	}
+	if m.Banned("10.0.0.2") {
+		t.Fatal("banned below the ban score")
+	}
+	if banned, _ := m.Misbehaving("10.0.0.2", penaltyProtocol, "test"); !banned || !m.Banned("10.0.0.2") {
+		t.Fatal("not banned at the ban score")
+	}
+
+	// Loopback peers are never banned
+	for _, addr := range []string{"127.0.0.1", "::1", "localhost"} {
+		if banned, _ := m.Misbehaving(addr, penaltyInvalidBlock, "test"); banned || m.Banned(addr) {
+			t.Errorf("loopback peer %s banned", addr)
+		}
+	}
+
+	// Not even by hand, or by a ban list that names them
+	if m.Ban("127.0.0.1", time.Hour, "manual"); m.Banned("127.0.0.1") {
+		t.Error("loopback peer banned by hand")
+	}
+
+	if unbanned, err := m.Unban("10.0.0.2"); err != nil || !unbanned {
+		t.Fatalf("Unban = %v, %v", unbanned, err)
+	}
+	if banned, _ := m.Misbehaving("10.0.0.2", penaltyProtocol, "test"); banned {
+		t.Error("score kept after an unban")
+	}
+}
+
+// TestPeerManagerBound checks that the peers scored are capped, the one
+// seen longest ago forgotten first.
+func TestPeerManagerBound(t *testing.T) {
+	m, _ := newPeerManager("")
+	now := time.Unix(0, 0)
+	m.now = func() time.Time { now = now.Add(time.Second); return now }
+	for i := range maxPeers + 1 {
+		m.RecordLatency(fmt.Sprintf("10.%d.%d.%d", i>>16, i>>8&0xff, i&0xff), time.Millisecond)
+	}
+	if n := len(m.Peers()); n != maxPeers {
+		t.Fatalf("%d peers kept, want %d", n, maxPeers)
+	}
+	if _, ok := m.peers["10.0.0.0"]; ok {
+		t.Error("the stalest peer was kept")
+	}
+	if _, ok := m.peers["10.0.0.1"]; !ok {
+		t.Error("a peer other than the stalest was forgotten")
+	}
+}
+
+func TestPeerManagerPersistence(t *testing.T) {
+	dir := t.TempDir()
+	m, err := newPeerManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Misbehaving("10.0.0.1", penaltyInvalidBlock, "bad-hash"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Ban("10.0.0.2", time.Hour, "manual"); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := newPeerManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.Banned("10.0.0.1") || !reloaded.Banned("10.0.0.2") {
+		t.Fatalf("bans lost across a restart: %v", reloaded.Bans())
+	}
+	if reason := reloaded.Bans()["10.0.0.1"].Reason; !strings.Contains(reason, "bad-hash") {
+		t.Errorf("ban reason %q does not name the misbehavior", reason)
+	}
+
+	// Expired bans lapse, and are dropped on the next load
+	reloaded.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if reloaded.Banned("10.0.0.2") || !reloaded.Banned("10.0.0.1") {
+		t.Errorf("after two hours: bans %v", reloaded.Bans())
+	}
+	if err := reloaded.Ban("10.0.0.3", -3*time.Hour, "expired"); err != nil {
+		t.Fatal(err)
+	}
+	again, err := newPeerManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := again.bans["10.0.0.3"]; ok {
+		t.Error("expired ban loaded")
+	}
+}
+
+func TestRPC_PeerBans(t *testing.T) {
+	chain := makeBlockchain(2, 1)
+	s := newRPCServer(chain, 1)
+	peers, err := newPeerManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.peers = peers
+
+	post := func(remote, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	next, err := generateBlock(context.Background(), chain[1], "submitted", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := *next
+	stale.PrevHash = chain[0].Hash
+	raw, _ := json.Marshal(&stale)
+	// A stale block is not misbehavior
+	post("10.0.0.1:5000", `{"jsonrpc":"2.0","method":"submitblock","params":[`+string(raw)+`],"id":1}`)
+	if peers.Banned("10.0.0.1") {
+		t.Fatal("peer banned for a stale block")
+	}
+
+	forged := *next
+	forged.Data = []byte("forged")
+	raw, _ = json.Marshal(&forged)
+	post("10.0.0.1:5000", `{"jsonrpc":"2.0","method":"submitblock","params":[`+string(raw)+`],"id":2}`)
+	if !peers.Banned("10.0.0.1") {
+		t.Fatal("peer not banned for an invalid block")
+	}
+	// The ban covers every port of the address
+	if rec := post("10.0.0.1:5001", `{"jsonrpc":"2.0","method":"getblockcount","id":3}`); rec.Code != http.StatusForbidden {
+		t.Errorf("banned peer got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	post("127.0.0.1:5000", `{"jsonrpc":"2.0","method":"submitblock","params":[`+string(raw)+`],"id":4}`)
+	var resp struct {
+		Result []PeerInfo
+		Error  *rpcError
+	}
+	if err := json.Unmarshal(post("127.0.0.1:5000", `{"jsonrpc":"2.0","method":"getpeerinfo","id":5}`).Body.Bytes(), &resp); err != nil || resp.Error != nil {
+		t.Fatalf("getpeerinfo: %v %v", err, resp.Error)
+	}
+	if len(resp.Result) != 2 || resp.Result[0].Banned || resp.Result[1].Addr != "10.0.0.1" || !resp.Result[1].Banned {
+		t.Errorf("getpeerinfo = %+v", resp.Result)
+	}
+
+	var ban struct {
+		Error *rpcError
+	}
+	json.Unmarshal(post("127.0.0.1:5000", `{"jsonrpc":"2.0","method":"setban","params":["10.0.0.1","remove"],"id":6}`).Body.Bytes(), &ban)
+	if ban.Error != nil || peers.Banned("10.0.0.1") {
+		t.Fatalf("setban remove: %v", ban.Error)
+	}
+	json.Unmarshal(post("127.0.0.1:5000", `{"jsonrpc":"2.0","method":"setban","params":["10.0.0.9","add",60],"id":7}`).Body.Bytes(), &ban)
+	if ban.Error != nil || !peers.Banned("10.0.0.9") {
+		t.Fatalf("setban add: %v", ban.Error)
+	}
+	var listed struct {
+		Result map[string]Ban
+	}
+	json.Unmarshal(post("127.0.0.1:5000", `{"jsonrpc":"2.0","method":"listbanned","id":8}`).Body.Bytes(), &listed)
+	if _, ok := listed.Result["10.0.0.9"]; len(listed.Result) != 1 || !ok {
+		t.Errorf("listbanned = %v", listed.Result)
+	}
+	json.Unmarshal(post("127.0.0.1:5000", `{"jsonrpc":"2.0","method":"setban","params":["10.0.0.9","drop"],"id":9}`).Body.Bytes(), &ban)
+	if ban.Error == nil || ban.Error.Code != rpcInvalidParam {
+		t.Errorf("setban with an unknown command: %v", ban.Error)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// TestSCRAM checks the client against the example exchange of RFC 7677.
+func TestSCRAM(t *testing.T) {
+	s := &scramClient{password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO", clientFirstBare: "n=user,r=rOprNGfwEbeRWgbNEkqO"}
+	final, err := s.clientFinal("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="; final != want {
+		t.Errorf("client final %q, want %q", final, want)
+	}
+	if err := s.verifyServerFinal("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err != nil {
+		t.Error(err)
+	}
+	if err := s.verifyServerFinal("v=AAAA"); err == nil {
+		t.Error("forged server signature accepted")
+	}
+	if _, err := s.clientFinal("r=someoneelse,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"); err == nil {
+		t.Error("server nonce that does not extend the client's accepted")
+	}
+}
+
+// fakePostgres accepts one connection, authenticates it with auth and
+// answers queries with a fixed result, or an error for "FAIL". auth is
+// "scram" for SCRAM-SHA-256 with the password "secret", "scram-no-final"
+// for the same without the SASLFinal message, or "password" or "md5" to
+// ask for a cleartext or MD5 password.
+func fakePostgres(t *testing.T, auth string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		send := func(typ byte, body []byte) {
+			conn.Write(append(binary.BigEndian.AppendUint32([]byte{typ}, uint32(len(body)+4)), body...))
+		}
+		authFailed := func() {
+			send('E', []byte("SFATAL\x00C28P01\x00Mpassword authentication failed\x00\x00"))
+		}
+		var n [4]byte
+		io.ReadFull(r, n[:])
+		io.ReadFull(r, make([]byte, binary.BigEndian.Uint32(n[:])-4))
+		switch auth {
+		case "password":
+			send('R', binary.BigEndian.AppendUint32(nil, 3))
+		case "md5":
+			send('R', append(binary.BigEndian.AppendUint32(nil, 5), "salt"...))
+		default:
+			send('R', append(binary.BigEndian.AppendUint32(nil, 10), "SCRAM-SHA-256\x00\x00"...))
+		}
+
+		salt := []byte("fake salt")
+		salted, _ := pbkdf2.Key(sha256.New, "secret", salt, 4096, sha256.Size)
+		var clientFirstBare, serverFirst string
+		for {
+			typ, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			io.ReadFull(r, n[:])
+			body := make([]byte, binary.BigEndian.Uint32(n[:])-4)
+			io.ReadFull(r, body)
+			switch {
+			case typ == 'p' && serverFirst == "":
+				// SASLInitialResponse: the mechanism, then "n,," and the
+				// client's first message
+				_, first, _ := strings.Cut(string(body), "\x00")
+				clientFirstBare = strings.TrimPrefix(first[4:], "n,,")
+				nonce := strings.TrimPrefix(clientFirstBare, "n=,r=")
+				serverFirst = "r=" + nonce + "server,s=" + base64.StdEncoding.EncodeToString(salt) + ",i=4096"
+				send('R', append(binary.BigEndian.AppendUint32(nil, 11), serverFirst...))
+			case typ == 'p':
+				withoutProof, proof, _ := strings.Cut(string(body), ",p=")
+				authMessage := clientFirstBare + "," + serverFirst + "," + withoutProof
+				clientKey, _ := base64.StdEncoding.DecodeString(proof)
+				storedKey := sha256.Sum256(scramHMAC(salted, "Client Key"))
+				for i, b := range scramHMAC(storedKey[:], authMessage) {
+					if i < len(clientKey) {
+						clientKey[i] ^= b
+					}
+				}
+				if sum := sha256.Sum256(clientKey); sum != storedKey {
+					authFailed()
+					return
+				}
+				if auth != "scram-no-final" {
+					signature := scramHMAC(scramHMAC(salted, "Server Key"), authMessage)
+					send('R', append(binary.BigEndian.AppendUint32(nil, 12), "v="+base64.StdEncoding.EncodeToString(signature)...))
+				}
+				send('R', binary.BigEndian.AppendUint32(nil, 0))
+				send('S', []byte("server_version\x0016\x00"))
+				send('Z', []byte("I"))
+			case typ == 'Q':
+				if string(body) == "FAIL\x00" {
+					send('E', []byte("SERROR\x00C42601\x00Msyntax error\x00\x00"))
+				} else {
+					send('T', []byte{0, 2})
+					row := []byte{0, 2}
+					row = binary.BigEndian.AppendUint32(row, 2)
+					row = append(row, "42"...)
+					row = binary.BigEndian.AppendUint32(row, 0xffffffff)
+					send('D', row)
+					send('C', []byte("SELECT 1\x00"))
+				}
+				send('Z', []byte("I"))
+			case typ == 'X':
+				return
+			}
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestPostgresQuery(t *testing.T) {
+	ctx := context.Background()
+	db, err := dialPostgres(ctx, "postgres://mirror:secret@"+fakePostgres(t, "scram")+"/chain?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.query(ctx, "SELECT 42, NULL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || len(rows[0]) != 2 || rows[0][0] == nil || *rows[0][0] != "42" || rows[0][1] != nil {
+		t.Errorf("unexpected rows %v", rows)
+	}
+	var pgErr *PgError
+	if err := db.exec(ctx, "FAIL"); !errors.As(err, &pgErr) || pgErr.Code != "42601" {
+		t.Errorf("expected a syntax error, got %v", err)
+	}
+	// The connection is still usable after an error
+	if _, err := db.query(ctx, "SELECT 1"); err != nil {
+		t.Errorf("query after error: %v", err)
+	}
+
+	if _, err := dialPostgres(ctx, "postgres://mirror:wrong@"+fakePostgres(t, "scram")+"/chain"); !errors.As(err, &pgErr) || pgErr.Code != "28P01" {
+		t.Errorf("expected an authentication error, got %v", err)
+	}
+	// Without TLS, only SCRAM keeps the password off the wire, and only
+	// its final message proves the server knows it
+	for _, auth := range []string{"password", "md5", "scram-no-final"} {
+		if _, err := dialPostgres(ctx, "postgres://mirror:secret@"+fakePostgres(t, auth)+"/chain"); err == nil || errors.As(err, &pgErr) {
+			t.Errorf("%s: expected the client to refuse, got %v", auth, err)
+		}
+	}
+	if _, err := dialPostgres(ctx, "postgres://localhost/chain?sslmode=require"); err == nil {
+		t.Error("sslmode=require accepted")
+	}
+}
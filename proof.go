@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// verifyChunkProof checks that the proof's chunk is included in the block
+// with the given header. The header itself must have been validated, for
+// example with validateHeaders.
+func verifyChunkProof(header *BlockHeader, proof *MerkleProof) error {
+	if header.MerkleRoot == nil {
+		return fmt.Errorf("block %d has no merkle root", header.Index)
+	}
+	h, err := hasherByID(header.HashAlgo)
+	if err != nil {
+		return err
+	}
+	root, err := proof.root(h)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(root, header.MerkleRoot) {
+		return &BlockValidationError{
+			Index: header.Index,
+			Err:   fmt.Errorf("%w: chunk %d is not in the block", ErrHashMismatch, proof.Index),
+		}
+	}
+	return nil
+}
+
+// proofResponse is the body of GET /proof/{height}/{chunk}.
+type proofResponse struct {
+	Height     int          `json:"height"`
+	BlockHash  string       `json:"blockhash"`
+	MerkleRoot string       `json:"merkleroot"`
+	Proof      *MerkleProof `json:"proof"`
+}
+
+// serveProof answers GET /proof/{height}/{chunk} with the inclusion proof
+// of a data chunk. Blocks carry no transactions, so the chunk index plays
+// the part of a transaction ID.
+func (s *rpcServer) serveProof(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeRESTError(w, http.StatusMethodNotAllowed, "proofs must be requested with GET")
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/proof/"), "/")
+	if len(parts) != 2 {
+		writeRESTError(w, http.StatusNotFound, "expected /proof/{height}/{chunk}")
+		return
+	}
+	height, err1 := strconv.Atoi(parts[0])
+	chunk, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		writeRESTError(w, http.StatusBadRequest, "height and chunk must be integers")
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if height < 0 || height >= len(s.chain) {
+		writeRESTError(w, http.StatusNotFound, "Block height out of range")
+		return
+	}
+	block := s.chain[height]
+	switch {
+	case s.pruned(height):
+		writeRESTError(w, http.StatusGone, "block data was pruned")
+		return
+	case block.MerkleRoot == nil:
+		writeRESTError(w, http.StatusNotFound, "block predates merkle roots")
+		return
+	case block.Redaction != nil:
+		writeRESTError(w, http.StatusNotFound, "block data was redacted")
+		return
+	}
+	h, err := hasherByID(block.HashAlgo)
+	if err != nil {
+		writeRESTError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	proof, err := merkleProof(h, block.Data, chunk)
+	if err != nil {
+		writeRESTError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(proofResponse{
+		Height:     height,
+		BlockHash:  hex.EncodeToString(block.Hash),
+		MerkleRoot: hex.EncodeToString(block.MerkleRoot),
+		Proof:      proof,
+	})
+}
+
+func writeRESTError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMerkleProof(t *testing.T) {
+	h := sha256Hasher{}
+	for _, chunks := range []int{1, 2, 3, 5, 8} {
+		data := bytes.Repeat([]byte("abcdefgh"), chunks*dataChunkSize/8)
+		root := merkleRoot(h, data)
+		for i := 0; i < chunks; i++ {
+			proof, err := merkleProof(h, data, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := proof.root(h); err != nil || !bytes.Equal(got, root) {
+				t.Errorf("%d chunks, chunk %d: proof leads to %x (%v), want %x", chunks, i, got, err, root)
+			}
+		}
+	}
+
+	data := bytes.Repeat([]byte{1}, 3*dataChunkSize)
+	proof, err := merkleProof(h, data, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := &BlockHeader{Index: 7, MerkleRoot: merkleRoot(h, data)}
+	if err := verifyChunkProof(header, proof); err != nil {
+		t.Fatalf("verifyChunkProof: %v", err)
+	}
+	forged := *proof
+	forged.Chunk = []byte("forged")
+	if err := verifyChunkProof(header, &forged); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("forged chunk accepted: %v", err)
+	}
+	forged = *proof
+	forged.Path = proof.Path[:len(proof.Path)-1]
+	if err := verifyChunkProof(header, &forged); err == nil {
+		t.Error("truncated proof accepted")
+	}
+	if _, err := merkleProof(h, data, 3); err == nil {
+		t.Error("proof built for a chunk past the end")
+	}
+}
+
+func TestProofEndpoint(t *testing.T) {
+	chain := makeBlockchain(3, 1)
+	data := strings.Repeat("x", 2*dataChunkSize) + "tail"
+	block, _, err := mineBlock(context.Background(), chain[2], data, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newRPCServer(append(chain, block), 1)
+
+	get := func(path string, v any) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if v != nil {
+			if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+				t.Fatalf("decode %s: %v", path, err)
+			}
+		}
+		return rec.Code
+	}
+
+	var resp proofResponse
+	if code := get("/proof/3/2", &resp); code != http.StatusOK {
+		t.Fatalf("GET /proof/3/2: status %d", code)
+	}
+	if string(resp.Proof.Chunk) != "tail" {
+		t.Errorf("proof carries chunk %q", resp.Proof.Chunk)
+	}
+	if err := verifyChunkProof(block.Header(), resp.Proof); err != nil {
+		t.Errorf("served proof does not verify: %v", err)
+	}
+
+	for path, want := range map[string]int{
+		"/proof/3/3":   http.StatusNotFound,
+		"/proof/9/0":   http.StatusNotFound,
+		"/proof/0/0":   http.StatusNotFound, // the genesis block has no merkle root
+		"/proof/x/0":   http.StatusBadRequest,
+		"/proof/3/0/1": http.StatusNotFound,
+	} {
+		if code := get(path, nil); code != want {
+			t.Errorf("GET %s: status %d, want %d", path, code, want)
+		}
+	}
+}
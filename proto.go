@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Protobuf encoding of blocks and chains as defined in
+// proto/blockchain.proto. The messages are small enough that encoding them
+// by hand avoids depending on the protobuf runtime and code generator,
+// while staying byte-compatible with generated code in other languages.
+
+// Protobuf wire types.
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+var errProtoTruncated = errors.New("proto: truncated message")
+
+// MarshalProto encodes the block as a Block message. As in proto3, fields
+// holding their zero value are omitted.
+func (b *Block) MarshalProto() []byte {
+	var out []byte
+	out = appendProtoVarint(out, 1, uint64(b.Index))
+	out = appendProtoVarint(out, 2, uint64(b.Timestamp))
+	out = appendProtoBytes(out, 3, b.Data)
+	out = appendProtoBytes(out, 4, b.PrevHash)
+	out = appendProtoBytes(out, 5, b.Hash)
+	out = appendProtoVarint(out, 6, b.Nonce)
+	out = appendProtoVarint(out, 7, uint64(b.Bits))
+	out = appendProtoVarint(out, 8, uint64(b.HashAlgo))
+	if b.MerkleRoot != nil {
+		// Written even when empty, since its presence changes the hash
+		out = appendProtoTag(out, 11, protoBytes)
+		out = binary.AppendUvarint(out, uint64(len(b.MerkleRoot)))
+		out = append(out, b.MerkleRoot...)
+	}
+	if b.Epoch != nil {
+		// Written even when empty, since its presence changes the hash
+		msg := b.Epoch.marshalProto()
+		out = appendProtoTag(out, 9, protoBytes)
+		out = binary.AppendUvarint(out, uint64(len(msg)))
+		out = append(out, msg...)
+	}
+	if b.Redaction != nil {
+		msg := b.Redaction.marshalProto()
+		out = appendProtoTag(out, 10, protoBytes)
+		out = binary.AppendUvarint(out, uint64(len(msg)))
+		out = append(out, msg...)
+	}
+	out = appendProtoVarint(out, 12, b.ExtraNonce)
+	out = appendProtoBytes(out, 13, b.Signature)
+	return out
+}
+
+// protoSize returns the length of the block's MarshalProto encoding
+// without copying its data. Every Block field number is below 16, so each
+// tag is one byte.
+func (b *Block) protoSize() int64 {
+	n := protoVarintSize(uint64(b.Index)) + protoVarintSize(uint64(b.Timestamp)) +
+		protoBytesSize(len(b.Data)) + protoBytesSize(len(b.PrevHash)) + protoBytesSize(len(b.Hash)) +
+		protoVarintSize(b.Nonce) + protoVarintSize(uint64(b.Bits)) + protoVarintSize(uint64(b.HashAlgo)) +
+		protoVarintSize(b.ExtraNonce) + protoBytesSize(len(b.Signature))
+	if b.MerkleRoot != nil {
+		n += 1 + uvarintSize(uint64(len(b.MerkleRoot))) + len(b.MerkleRoot)
+	}
+	if b.Epoch != nil {
+		m := len(b.Epoch.marshalProto())
+		n += 1 + uvarintSize(uint64(m)) + m
+	}
+	if b.Redaction != nil {
+		m := len(b.Redaction.marshalProto())
+		n += 1 + uvarintSize(uint64(m)) + m
+	}
+	return int64(n)
+}
+
+func protoVarintSize(v uint64) int {
+	if v == 0 {
+		return 0
+	}
+	return 1 + uvarintSize(v)
+}
+
+func protoBytesSize(n int) int {
+	if n == 0 {
+		return 0
+	}
+	return 1 + uvarintSize(uint64(n)) + n
+}
+
+func uvarintSize(v uint64) int {
+	return (bits.Len64(v|1) + 6) / 7
+}
+
+func (e *EpochSummary) marshalProto() []byte {
+	var out []byte
+	out = appendProtoVarint(out, 1, uint64(e.Epoch))
+	out = appendProtoBytes(out, 2, e.Hash)
+	out = appendProtoVarint(out, 3, uint64(e.StartTime))
+	out = appendProtoVarint(out, 4, uint64(e.EndTime))
+	out = appendProtoVarint(out, 5, uint64(e.DataBytes))
+	out = appendProtoBytes(out, 6, e.ValidatorsHash)
+	return out
+}
+
+func (e *EpochSummary) unmarshalProto(msg []byte) error {
+	*e = EpochSummary{}
+	return walkProto(msg, func(field int, wireType int, v uint64, raw []byte) error {
+		want := protoVarint
+		if field == 2 || field == 6 {
+			want = protoBytes
+		}
+		if field <= 6 && wireType != want {
+			return fmt.Errorf("proto: epoch field %d has wire type %d, want %d", field, wireType, want)
+		}
+
+		switch field {
+		case 1:
+			e.Epoch = int(int64(v))
+		case 2:
+			e.Hash = append([]byte{}, raw...)
+		case 3:
+			e.StartTime = int64(v)
+		case 4:
+			e.EndTime = int64(v)
+		case 5:
+			e.DataBytes = int64(v)
+		case 6:
+			e.ValidatorsHash = append([]byte{}, raw...)
+		}
+		return nil
+	})
+}
+
+func (r *Redaction) marshalProto() []byte {
+	var out []byte
+	out = appendProtoBytes(out, 1, []byte(r.Reason))
+	out = appendProtoVarint(out, 2, uint64(r.RedactedAt))
+	out = appendProtoVarint(out, 3, uint64(r.DataLength))
+	return out
+}
+
+func (r *Redaction) unmarshalProto(msg []byte) error {
+	*r = Redaction{}
+	return walkProto(msg, func(field int, wireType int, v uint64, raw []byte) error {
+		want := protoVarint
+		if field == 1 {
+			want = protoBytes
+		}
+		if field <= 3 && wireType != want {
+			return fmt.Errorf("proto: redaction field %d has wire type %d, want %d", field, wireType, want)
+		}
+
+		switch field {
+		case 1:
+			r.Reason = string(raw)
+		case 2:
+			r.RedactedAt = int64(v)
+		case 3:
+			r.DataLength = int(int64(v))
+		}
+		return nil
+	})
+}
+
+// UnmarshalProto decodes a Block message into b. Unknown fields are
+// skipped so that newer encoders stay readable. Messages beyond
+// blockLimits are refused before their data is copied.
+func (b *Block) UnmarshalProto(msg []byte) error {
+	*b = Block{Data: []byte{}, PrevHash: []byte{}}
+	if n := int64(len(msg)); n > blockLimits.MaxBlockBytes {
+		return fmt.Errorf("proto: %w: block is %d bytes, limit %d", ErrBlockTooLarge, n, blockLimits.MaxBlockBytes)
+	}
+	return walkProto(msg, func(field int, wireType int, v uint64, raw []byte) error {
+		switch field {
+		case 1, 2, 6, 7, 8, 12:
+			if wireType != protoVarint {
+				return fmt.Errorf("proto: field %d has wire type %d, want varint", field, wireType)
+			}
+		case 3, 4, 5, 9, 10, 11, 13:
+			if wireType != protoBytes {
+				return fmt.Errorf("proto: field %d has wire type %d, want bytes", field, wireType)
+			}
+		}
+
+		switch field {
+		case 1:
+			b.Index = int(int64(v))
+		case 2:
+			b.Timestamp = int64(v)
+		case 3:
+			if n := int64(len(raw)); n > blockLimits.MaxDataBytes {
+				return fmt.Errorf("proto: %w: data is %d bytes, limit %d", ErrBlockTooLarge, n, blockLimits.MaxDataBytes)
+			}
+			b.Data = append([]byte{}, raw...)
+		case 4:
+			b.PrevHash = append([]byte{}, raw...)
+		case 5:
+			b.Hash = append([]byte{}, raw...)
+		case 6:
+			b.Nonce = v
+		case 7:
+			// uint32 fields keep the low 32 bits, as generated code does
+			b.Bits = uint32(v)
+		case 8:
+			if uint32(v) > math.MaxUint8 {
+				return fmt.Errorf("proto: hash algorithm %d out of range", uint32(v))
+			}
+			b.HashAlgo = byte(v)
+		case 9:
+			b.Epoch = new(EpochSummary)
+			return b.Epoch.unmarshalProto(raw)
+		case 10:
+			b.Redaction = new(Redaction)
+			return b.Redaction.unmarshalProto(raw)
+		case 11:
+			b.MerkleRoot = append([]byte{}, raw...)
+		case 12:
+			b.ExtraNonce = v
+		case 13:
+			b.Signature = append([]byte{}, raw...)
+		}
+		return nil
+	})
+}
+
+// marshalChainProto encodes a chain as a Chain message.
+func marshalChainProto(chain []*Block) []byte {
+	var out []byte
+	for _, block := range chain {
+		// Empty blocks must still be written to keep their position
+		out = appendProtoTag(out, 1, protoBytes)
+		msg := block.MarshalProto()
+		out = binary.AppendUvarint(out, uint64(len(msg)))
+		out = append(out, msg...)
+	}
+	return out
+}
+
+// unmarshalChainProto decodes a Chain message.
+func unmarshalChainProto(msg []byte) ([]*Block, error) {
+	var chain []*Block
+	err := walkProto(msg, func(field int, wireType int, _ uint64, raw []byte) error {
+		if field != 1 {
+			return nil
+		}
+		if wireType != protoBytes {
+			return fmt.Errorf("proto: field 1 has wire type %d, want bytes", wireType)
+		}
+		block := new(Block)
+		if err := block.UnmarshalProto(raw); err != nil {
+			return fmt.Errorf("block %d: %w", len(chain), err)
+		}
+		chain = append(chain, block)
+		return nil
+	})
+	return chain, err
+}
+
+func appendProtoTag(out []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(out, uint64(field)<<3|uint64(wireType))
+}
+
+func appendProtoVarint(out []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return out
+	}
+	out = appendProtoTag(out, field, protoVarint)
+	return binary.AppendUvarint(out, v)
+}
+
+func appendProtoBytes(out []byte, field int, b []byte) []byte {
+	if len(b) == 0 {
+		return out
+	}
+	out = appendProtoTag(out, field, protoBytes)
+	out = binary.AppendUvarint(out, uint64(len(b)))
+	return append(out, b...)
+}
+
+// walkProto calls fn for every field of a message. Varint fields pass
+// their value in v and length-delimited fields their contents in raw;
+// fixed-size fields are skipped over.
+func walkProto(msg []byte, fn func(field int, wireType int, v uint64, raw []byte) error) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errProtoTruncated
+		}
+		msg = msg[n:]
+		field, wireType := key>>3, int(key&7)
+		if field == 0 || field > 1<<29-1 {
+			return fmt.Errorf("proto: invalid field number %d", field)
+		}
+
+		var v uint64
+		var raw []byte
+		switch wireType {
+		case protoVarint:
+			if v, n = binary.Uvarint(msg); n <= 0 {
+				return errProtoTruncated
+			}
+			msg = msg[n:]
+		case protoFixed64, protoFixed32:
+			size := 8
+			if wireType == protoFixed32 {
+				size = 4
+			}
+			if len(msg) < size {
+				return errProtoTruncated
+			}
+			msg = msg[size:]
+		case protoBytes:
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || size > uint64(len(msg)-n) {
+				return errProtoTruncated
+			}
+			raw = msg[n : n+int(size)]
+			msg = msg[n+int(size):]
+		default:
+			return fmt.Errorf("proto: unsupported wire type %d", wireType)
+		}
+
+		if err := fn(int(field), wireType, v, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestBlockProto_KnownEncoding pins the encoding to what protoc-generated
+// code produces for the same message, with zero fields omitted.
+func TestBlockProto_KnownEncoding(t *testing.T) {
+	block := &Block{Index: 1, Data: []byte("hi"), PrevHash: []byte{}, Nonce: 300, Bits: 0x1d00ffff, HashAlgo: HashBLAKE3}
+	want := "08011a02686930ac0238ffff83e8014002"
+	if got := hex.EncodeToString(block.MarshalProto()); got != want {
+		t.Fatalf("MarshalProto = %s, want %s", got, want)
+	}
+
+	var decoded Block
+	if err := decoded.UnmarshalProto(block.MarshalProto()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, block) {
+		t.Errorf("round trip changed block: %+v != %+v", decoded, *block)
+	}
+}
+
+// TestChainProto_RoundTrip checks that a mined chain survives a protobuf
+// round trip through a file and is still valid.
+func TestChainProto_RoundTrip(t *testing.T) {
+	chain := makeBlockchain(4, 1)
+	chain[2].Timestamp = -5 // negative int64s use ten-byte varints
+	chain[2].Hash = calculateHash(chain[2])
+
+	path := filepath.Join(t.TempDir(), "chain.pb")
+	if err := writeChainFile(chain, path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := unmarshalChainProto(data)
+	if err != nil {
+		t.Fatalf("unmarshalChainProto: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, chain) {
+		t.Fatal("decoded chain differs from the original")
+	}
+}
+
+// TestBlockProto_SkipsUnknownFields verifies forward compatibility with
+// encoders that know about more fields.
+func TestBlockProto_SkipsUnknownFields(t *testing.T) {
+	block := &Block{Index: 3, Data: []byte("x"), PrevHash: []byte{}, Hash: bytes.Repeat([]byte{1}, 32)}
+	msg := block.MarshalProto()
+	msg = append(msg, 0x78, 0x05)                         // field 15, varint
+	msg = append(msg, 0x85, 0x01, 1, 2, 3, 4)             // field 16, fixed32
+	msg = append(msg, 0x89, 0x01, 1, 2, 3, 4, 5, 6, 7, 8) // field 17, fixed64
+	msg = append(msg, 0x92, 0x01, 0x02, 'o', 'k')         // field 18, bytes
+
+	var decoded Block
+	if err := decoded.UnmarshalProto(msg); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, block) {
+		t.Errorf("unknown fields changed block: %+v", decoded)
+	}
+}
+
+// TestBlockProto_Malformed covers inputs the decoder must reject.
+func TestBlockProto_Malformed(t *testing.T) {
+	full := (&Block{Index: 1, Data: []byte("data")}).MarshalProto()
+	cases := map[string][]byte{
+		"truncated bytes":   full[:len(full)-1],
+		"truncated varint":  {0x08, 0x80},
+		"wrong wire type":   {0x0a, 0x00},
+		"field zero":        {0x00, 0x01},
+		"unsupported group": {0x0b},
+		"large hash algo":   {0x40, 0x80, 0x02},
+	}
+	for name, msg := range cases {
+		var b Block
+		if err := b.UnmarshalProto(msg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	var b Block
+	if err := b.UnmarshalProto(full[:len(full)-1]); !errors.Is(err, errProtoTruncated) {
+		t.Errorf("expected errProtoTruncated, got %v", err)
+	}
+}
+
+// TestBlockProto_Size checks protoSize against the encoding and that
+// UnmarshalProto refuses blocks beyond the limits.
+func TestBlockProto_Size(t *testing.T) {
+	chain := makeBlockchain(3, 1)
+	chain[1].Timestamp = -5
+	chain[2].ExtraNonce = 1 << 40
+	chain[2].Epoch = &EpochSummary{Epoch: 1, Hash: make([]byte, 32), DataBytes: 300}
+	redacted := *chain[1]
+	redacted.Data = []byte{}
+	redacted.Redaction = &Redaction{Reason: "gdpr", RedactedAt: 1700000000, DataLength: 7}
+	big := *chain[1]
+	big.Data = make([]byte, 200)
+	for _, block := range append(chain, &redacted, &big, &Block{}) {
+		if got, want := block.protoSize(), int64(len(block.MarshalProto())); got != want {
+			t.Errorf("block %d: protoSize %d, encoding is %d bytes", block.Index, got, want)
+		}
+	}
+
+	t.Cleanup(func() { blockLimits = defaultBlockLimits })
+	msg := big.MarshalProto()
+	var decoded Block
+	blockLimits = BlockLimits{MaxBlockBytes: 1 << 10, MaxDataBytes: 100}
+	if err := decoded.UnmarshalProto(msg); !errors.Is(err, ErrBlockTooLarge) {
+		t.Errorf("expected ErrBlockTooLarge for the data, got %v", err)
+	}
+	blockLimits = BlockLimits{MaxBlockBytes: 100, MaxDataBytes: 100}
+	if err := decoded.UnmarshalProto(msg); !errors.Is(err, ErrBlockTooLarge) {
+		t.Errorf("expected ErrBlockTooLarge for the block, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"time"
+)
+
+// minPruneDepth is the fewest recent blocks a pruning daemon keeps whole,
+// as with Bitcoin Core's 288, so that a short reorg never needs a body it
+// has discarded.
+const minPruneDepth = 288
+
+// pruneReason is the Redaction reason of a block whose body was pruned.
+const pruneReason = "pruned"
+
+// pruneBlock returns a copy of block without its data, redacted as pruned.
+// The header, and the data length that epoch summaries need, are kept, so
+// the chain still validates. A block already without data is returned as
+// it is.
+func pruneBlock(block *Block) *Block {
+	if block.Redaction != nil {
+		return block
+	}
+	pruned := *block
+	pruned.Redaction = &Redaction{Reason: pruneReason, RedactedAt: time.Now().Unix(), DataLength: len(block.Data), trusted: true}
+	pruned.Data = []byte{}
+	return &pruned
+}
+
+// pruneHeightOf returns the height below which chain was pruned: one above
+// the highest pruned block, or 0.
+func pruneHeightOf(chain []*Block) int {
+	for h := len(chain) - 1; h > 0; h-- {
+		if r := chain[h].Redaction; r != nil && r.Reason == pruneReason {
+			return h + 1
+		}
+	}
+	return 0
+}
+
+// prune discards the bodies of the blocks more than pruneDepth below the
+// tip, returning how many it pruned and the new prune height. The genesis
+// block is kept whole. Blocks are replaced by pruned copies, so snapshots
+// taken earlier keep their data.
+func (s *rpcServer) prune() (n, height int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pruneDepth <= 0 {
+		return 0, s.pruneHeight
+	}
+	for h := max(s.pruneHeight, 1); h < len(s.chain)-s.pruneDepth; h++ {
+		if block := pruneBlock(s.chain[h]); block != s.chain[h] {
+			s.chain[h] = block
+			n++
+		}
+		s.pruneHeight = h + 1
+	}
+	return n, s.pruneHeight
+}
+
+// pruned reports whether the body of the block at height was discarded.
+// The caller must hold s.mu.
+func (s *rpcServer) pruned(height int) bool {
+	return height > 0 && height < s.pruneHeight
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestPrune discards old bodies, keeps the chain valid and refuses body
+// requests for the pruned range.
+func TestPrune(t *testing.T) {
+	chain := makeBlockchain(10, 1)
+	s := newRPCServer(chain, 1)
+	s.pruneDepth = 3
+	before := s.snapshot()
+
+	if n, height := s.prune(); n != 6 || height != 7 {
+		t.Fatalf("pruned %d blocks up to height %d, want 6 and 7", n, height)
+	}
+	if n, _ := s.prune(); n != 0 {
+		t.Errorf("pruned %d blocks again", n)
+	}
+	pruned := s.snapshot()
+	if len(before[3].Data) == 0 {
+		t.Error("pruning changed an earlier snapshot")
+	}
+	if len(pruned[0].Data) == 0 || len(pruned[6].Data) != 0 || len(pruned[7].Data) == 0 {
+		t.Error("wrong blocks pruned")
+	}
+	if err := validateChain(pruned, 1); err != nil {
+		t.Fatalf("pruned chain is invalid: %v", err)
+	}
+	if summarizeEpoch(0, pruned).DataBytes != summarizeEpoch(0, before).DataBytes {
+		t.Error("pruning changed the data size in the epoch summary")
+	}
+	if got := pruneHeightOf(pruned); got != 7 {
+		t.Errorf("prune height of the stored chain is %d, want 7", got)
+	}
+
+	var info struct {
+		Result struct {
+			Pruned      bool
+			PruneHeight int
+		}
+	}
+	rpcPost(t, s, `{"jsonrpc":"2.0","method":"getblockchaininfo","id":1}`, &info)
+	if !info.Result.Pruned || info.Result.PruneHeight != 7 {
+		t.Errorf("getblockchaininfo: %+v", info.Result)
+	}
+
+	var resp struct {
+		Result *rpcBlock
+		Error  *rpcError
+	}
+	rpcPost(t, s, `{"jsonrpc":"2.0","method":"getblock","params":["`+hex.EncodeToString(chain[4].Hash)+`"],"id":1}`, &resp)
+	if resp.Error == nil || resp.Error.Code != rpcMiscError {
+		t.Errorf("expected getblock of a pruned block to fail, got %+v", resp)
+	}
+	resp.Error = nil
+	rpcPost(t, s, `{"jsonrpc":"2.0","method":"getblock","params":["`+hex.EncodeToString(chain[8].Hash)+`"],"id":1}`, &resp)
+	if resp.Error != nil || resp.Result == nil || resp.Result.Height != 8 {
+		t.Errorf("getblock of a kept block: %+v", resp)
+	}
+	var headers struct{ Result []*BlockHeader }
+	rpcPost(t, s, `{"jsonrpc":"2.0","method":"getheaders","params":[0,10],"id":1}`, &headers)
+	if err := validateHeaders(headers.Result, 1); err != nil {
+		t.Errorf("headers of the pruned range: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/proof/4/0", nil))
+	if rec.Code != http.StatusGone {
+		t.Errorf("proof of a pruned block: status %d", rec.Code)
+	}
+}
+
+// TestDaemonPruneDepth refuses a prune depth too shallow for reorgs.
+func TestDaemonPruneDepth(t *testing.T) {
+	code := runDaemon([]string{"-datadir", t.TempDir(), "-prune", "10", "-addr", "127.0.0.1:0"})
+	if code != exitConfig {
+		t.Errorf("expected exit code %d, got %d", exitConfig, code)
+	}
+}
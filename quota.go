@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// quotaUsageName is the file in a data directory holding the tenants'
+// usage counters.
+const quotaUsageName = "quota-usage.json"
+
+// JSON-RPC error codes of refused credentials and exhausted quotas.
+// -32005 is the "limit exceeded" code of EIP-1474.
+const (
+	rpcUnauthorized  = -32002
+	rpcQuotaExceeded = -32005
+)
+
+// Quota names, as reported in QuotaError and the tenants file.
+const (
+	quotaRequests      = "requests_per_day"
+	quotaBytes         = "bytes_per_day"
+	quotaSubscriptions = "subscriptions"
+)
+
+// Tenant is a credential of a shared node and its quotas. A zero limit is
+// unlimited. Requests count JSON-RPC calls, so a batch counts once per
+// call; bytes count response bodies and WebSocket events; subscriptions
+// bound the WebSocket connections open at once. The tenant with an empty
+// key serves requests that carry no credential, which are refused when
+// there is none.
+type Tenant struct {
+	Name           string `json:"name"`
+	Key            string `json:"key"`
+	RequestsPerDay int64  `json:"requests_per_day"`
+	BytesPerDay    int64  `json:"bytes_per_day"`
+	Subscriptions  int64  `json:"subscriptions"`
+	// RateLimit caps the tenant's requests per second, allowing bursts of
+	// RateBurst; 0 leaves only the per-client limit
+	RateLimit float64 `json:"rate_limit,omitempty"`
+	RateBurst int     `json:"rate_burst,omitempty"`
+	// Role is read-only, user (the default) or admin; see auth.go
+	Role string `json:"role,omitempty"`
+	// Admin tenants may read the usage of every tenant and control the
+	// node. "admin": true is the older spelling of "role": "admin"
+	Admin bool `json:"admin,omitempty"`
+}
+
+// TenantUsage counts what a tenant used on one UTC day.
+type TenantUsage struct {
+	Day      string `json:"day"` // YYYY-MM-DD
+	Requests int64  `json:"requests"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// QuotaError reports a request refused because its tenant used up one of
+// its quotas. It is returned to JSON-RPC clients as the error's data.
+type QuotaError struct {
+	Tenant string `json:"tenant"`
+	Quota  string `json:"quota"`
+	Limit  int64  `json:"limit"`
+	Used   int64  `json:"used"`
+	// Reset is when a daily quota starts over; subscriptions are freed by
+	// closing one instead
+	Reset time.Time `json:"reset,omitzero"`
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("tenant %q exceeded its %s quota (%d of %d)", e.Tenant, e.Quota, e.Used, e.Limit)
+}
+
+// errUnauthorized refuses a request whose credential no tenant holds.
+var errUnauthorized = errors.New("missing or unknown API key")
+
+// loadTenants reads a tenants file: a JSON array of Tenant.
+func loadTenants(path string) ([]Tenant, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	var tenants []Tenant
+	if err := dec.Decode(&tenants); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(tenants) == 0 {
+		return nil, fmt.Errorf("%s lists no tenants", path)
+	}
+	names, keys := map[string]bool{}, map[string]bool{}
+	for i := range tenants {
+		t := &tenants[i]
+		switch {
+		case t.Name == "":
+			return nil, fmt.Errorf("%s: a tenant has no name", path)
+		case names[t.Name]:
+			return nil, fmt.Errorf("%s: tenant %q is listed twice", path, t.Name)
+		case keys[t.Key]:
+			return nil, fmt.Errorf("%s: tenant %q shares its key with another tenant", path, t.Name)
+		case t.RequestsPerDay < 0 || t.BytesPerDay < 0 || t.Subscriptions < 0 || t.RateLimit < 0 || t.RateBurst < 0:
+			return nil, fmt.Errorf("%s: tenant %q has a negative quota", path, t.Name)
+		case t.RateLimit > 0 && t.RateBurst == 0:
+			return nil, fmt.Errorf("%s: tenant %q has a rate_limit but no rate_burst", path, t.Name)
+		case !validRole(t.Role):
+			return nil, fmt.Errorf("%s: tenant %q has unknown role %q", path, t.Name, t.Role)
+		case t.Admin && t.Role != "" && t.Role != roleAdmin:
+			return nil, fmt.Errorf("%s: tenant %q is admin and %s", path, t.Name, t.Role)
+		}
+		if t.Role == roleAdmin {
+			t.Admin = true
+		}
+		names[t.Name], keys[t.Key] = true, true
+	}
+	return tenants, nil
+}
+
+// quotaTracker authenticates requests and counts each tenant's usage
+// against its quotas. Keys are looked up by their SHA-256, so a lookup
+// takes the same time whichever key prefix matches.
+type quotaTracker struct {
+	mu      sync.Mutex
+	tenants map[[32]byte]*Tenant
+	usage   map[string]*TenantUsage // by tenant name
+	subs    map[string]int64
+	now     func() time.Time
+}
+
+func newQuotaTracker(tenants []Tenant, usage map[string]*TenantUsage) *quotaTracker {
+	q := &quotaTracker{
+		tenants: make(map[[32]byte]*Tenant, len(tenants)),
+		usage:   make(map[string]*TenantUsage, len(tenants)),
+		subs:    make(map[string]int64),
+		now:     time.Now,
+	}
+	for i := range tenants {
+		t := &tenants[i]
+		q.tenants[sha256.Sum256([]byte(t.Key))] = t
+		q.usage[t.Name] = &TenantUsage{}
+		if u, ok := usage[t.Name]; ok {
+			*q.usage[t.Name] = *u
+		}
+	}
+	return q
+}
+
+// authenticate returns the tenant of the request's API key, given as a
+// bearer token, as the password of basic auth with the tenant's name as
+// the user, or, since browsers cannot set headers on a WebSocket, as the
+// apikey query parameter.
+func (q *quotaTracker) authenticate(r *http.Request) (*Tenant, error) {
+	key := r.URL.Query().Get("apikey")
+	user, basic := "", false
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		token, ok := strings.CutPrefix(auth, "Bearer ")
+		if !ok {
+			if user, token, ok = r.BasicAuth(); !ok {
+				return nil, errUnauthorized
+			}
+			basic = true
+		}
+		key = token
+	}
+	t, ok := q.tenants[sha256.Sum256([]byte(key))]
+	if !ok || (basic && user != t.Name) {
+		return nil, errUnauthorized
+	}
+	return t, nil
+}
+
+// today returns the tenant's counters for the current UTC day, starting
+// them over when the day has changed. The caller must hold q.mu.
+func (q *quotaTracker) today(t *Tenant) *TenantUsage {
+	u := q.usage[t.Name]
+	if day := q.now().UTC().Format(time.DateOnly); u.Day != day {
+		*u = TenantUsage{Day: day}
+	}
+	return u
+}
+
+// reset returns the start of the next UTC day.
+func (q *quotaTracker) reset() time.Time {
+	return q.now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+// request counts one request, refusing it when the tenant has used up its
+// requests or bytes for the day.
+func (q *quotaTracker) request(t *Tenant) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u := q.today(t)
+	if err := q.checkBytes(t, u); err != nil {
+		return err
+	}
+	if t.RequestsPerDay > 0 && u.Requests >= t.RequestsPerDay {
+		return &QuotaError{Tenant: t.Name, Quota: quotaRequests, Limit: t.RequestsPerDay, Used: u.Requests, Reset: q.reset()}
+	}
+	u.Requests++
+	return nil
+}
+
+// checkBytes fails when the tenant has used up its bytes for the day. The
+// caller must hold q.mu.
+func (q *quotaTracker) checkBytes(t *Tenant, u *TenantUsage) error {
+	if t.BytesPerDay > 0 && u.Bytes >= t.BytesPerDay {
+		return &QuotaError{Tenant: t.Name, Quota: quotaBytes, Limit: t.BytesPerDay, Used: u.Bytes, Reset: q.reset()}
+	}
+	return nil
+}
+
+// sent counts n bytes sent to the tenant. It fails once the tenant has
+// used up its bytes for the day; the response that crossed the limit has
+// already been sent in full.
+func (q *quotaTracker) sent(t *Tenant, n int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u := q.today(t)
+	u.Bytes += n
+	return q.checkBytes(t, u)
+}
+
+// subscribe holds one of the tenant's subscriptions until release is
+// called.
+func (q *quotaTracker) subscribe(t *Tenant) (release func(), err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if n := q.subs[t.Name]; t.Subscriptions > 0 && n >= t.Subscriptions {
+		return nil, &QuotaError{Tenant: t.Name, Quota: quotaSubscriptions, Limit: t.Subscriptions, Used: n}
+	}
+	q.subs[t.Name]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.mu.Lock()
+			q.subs[t.Name]--
+			q.mu.Unlock()
+		})
+	}, nil
+}
+
+// usageView is the getusage view of a tenant.
+type usageView struct {
+	Tenant        string         `json:"tenant"`
+	Day           string         `json:"day"`
+	Requests      int64          `json:"requests"`
+	Bytes         int64          `json:"bytes"`
+	Subscriptions int64          `json:"subscriptions"`
+	Limits        map[string]any `json:"limits"`
+}
+
+// view returns the usage of the named tenant.
+func (q *quotaTracker) view(name string) (*usageView, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, t := range q.tenants {
+		if t.Name != name {
+			continue
+		}
+		u := q.today(t)
+		return &usageView{
+			Tenant:        t.Name,
+			Day:           u.Day,
+			Requests:      u.Requests,
+			Bytes:         u.Bytes,
+			Subscriptions: q.subs[t.Name],
+			Limits: map[string]any{
+				quotaRequests:      t.RequestsPerDay,
+				quotaBytes:         t.BytesPerDay,
+				quotaSubscriptions: t.Subscriptions,
+			},
+		}, true
+	}
+	return nil, false
+}
+
+// quotaUsagePath returns the path of the usage file in dataDir.
+func quotaUsagePath(dataDir string) string {
+	return filepath.Join(dataDir, quotaUsageName)
+}
+
+// readQuotaUsage reads the usage counters saved in dataDir. A missing file
+// means no usage yet.
+func readQuotaUsage(dataDir string) (map[string]*TenantUsage, error) {
+	raw, err := os.ReadFile(quotaUsagePath(dataDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var usage map[string]*TenantUsage
+	if err := json.Unmarshal(raw, &usage); err != nil {
+		return nil, fmt.Errorf("%s: %w", quotaUsagePath(dataDir), err)
+	}
+	return usage, nil
+}
+
+// save writes the usage counters to dataDir, replacing the file
+// atomically as writeFeedOffset does.
+func (q *quotaTracker) save(dataDir string) error {
+	q.mu.Lock()
+	raw, err := json.MarshalIndent(q.usage, "", "  ")
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	path := quotaUsagePath(dataDir)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// tenantKey is the context key of the tenant a request is served for.
+type tenantKey struct{}
+
+// withTenant returns a context carrying the tenant a request is served for.
+func withTenant(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, tenantKey{}, t)
+}
+
+// tenantFrom returns the tenant stored by withTenant, or nil when the node
+// has no quotas.
+func tenantFrom(ctx context.Context) *Tenant {
+	t, _ := ctx.Value(tenantKey{}).(*Tenant)
+	return t
+}
+
+// quotaRPCError converts errUnauthorized, *QuotaError and *RateLimitError
+// to JSON-RPC errors.
+func quotaRPCError(err error) *rpcError {
+	var qerr *QuotaError
+	if errors.As(err, &qerr) {
+		return &rpcError{Code: rpcQuotaExceeded, Message: qerr.Error(), Data: qerr}
+	}
+	var lerr *RateLimitError
+	if errors.As(err, &lerr) {
+		return &rpcError{Code: rpcQuotaExceeded, Message: lerr.Error(), Data: lerr}
+	}
+	return &rpcError{Code: rpcUnauthorized, Message: err.Error()}
+}
+
+// refuse answers a request that failed authentication, a quota check or
+// the rate limit, with 401 or 429 and, for a daily quota or a rate limit,
+// a Retry-After header.
+func refuse(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusUnauthorized
+	var qerr *QuotaError
+	var lerr *RateLimitError
+	if errors.As(err, &lerr) {
+		status = http.StatusTooManyRequests
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lerr.RetryAfter.Seconds()))))
+	} else if errors.As(err, &qerr) {
+		status = http.StatusTooManyRequests
+		if !qerr.Reset.IsZero() {
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(qerr.Reset).Seconds())+1))
+		}
+	} else {
+		w.Header().Add("WWW-Authenticate", `Bearer realm="blockchain"`)
+		w.Header().Add("WWW-Authenticate", `Basic realm="blockchain"`)
+	}
+	if r.URL.Path != "/" {
+		writeRESTError(w, status, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(rpcResponse{JSONRPC: "2.0", Error: quotaRPCError(err), ID: json.RawMessage("null")})
+}
+
+// countingWriter counts the bytes of a response body. It passes Hijack
+// through for WebSocket upgrades, whose frames are counted separately.
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the writer's Flush.
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *countingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("websocket: response does not support hijacking")
+	}
+	return hj.Hijack()
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLoadTenants reads a tenants file and rejects invalid ones.
+func TestLoadTenants(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tenants.json")
+	os.WriteFile(path, []byte(`[
+		{"name": "public", "key": "", "requests_per_day": 100},
+		{"name": "explorer", "key": "secret", "bytes_per_day": 1000000, "subscriptions": 2, "admin": true}
+	]`), 0o644)
+	tenants, err := loadTenants(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tenants) != 2 || tenants[1].Subscriptions != 2 || !tenants[1].Admin {
+		t.Errorf("unexpected tenants %+v", tenants)
+	}
+
+	for _, bad := range []string{
+		`[]`,
+		`[{"name": "a", "key": "k", "requests": 5}]`,
+		`[{"key": "k"}]`,
+		`[{"name": "a", "key": "k"}, {"name": "a", "key": "l"}]`,
+		`[{"name": "a", "key": "k"}, {"name": "b", "key": "k"}]`,
+		`[{"name": "a", "key": "k", "bytes_per_day": -1}]`,
+	} {
+		os.WriteFile(path, []byte(bad), 0o644)
+		if _, err := loadTenants(path); err == nil {
+			t.Errorf("expected %s to be rejected", bad)
+		}
+	}
+}
+
+// TestQuotaTracker counts requests, bytes and subscriptions against their
+// limits and starts the daily counters over at midnight UTC.
+func TestQuotaTracker(t *testing.T) {
+	now := time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC)
+	q := newQuotaTracker([]Tenant{{Name: "a", Key: "k", RequestsPerDay: 2, BytesPerDay: 100, Subscriptions: 1}},
+		map[string]*TenantUsage{"a": {Day: "2024-03-01", Requests: 1}})
+	q.now = func() time.Time { return now }
+	tenant := q.tenants[sha256.Sum256([]byte("k"))]
+
+	if err := q.request(tenant); err != nil {
+		t.Fatal(err)
+	}
+	var qerr *QuotaError
+	if err := q.request(tenant); !errors.As(err, &qerr) || qerr.Quota != quotaRequests || qerr.Used != 2 ||
+		!qerr.Reset.Equal(time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected the request quota to be exceeded, got %v", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := q.request(tenant); err != nil {
+		t.Fatalf("the request quota did not reset: %v", err)
+	}
+	if err := q.sent(tenant, 150); !errors.As(err, &qerr) || qerr.Quota != quotaBytes {
+		t.Fatalf("expected the byte quota to be exceeded, got %v", err)
+	}
+	if err := q.request(tenant); !errors.As(err, &qerr) || qerr.Quota != quotaBytes {
+		t.Errorf("a tenant out of bytes can still make requests: %v", err)
+	}
+
+	release, err := q.subscribe(tenant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.subscribe(tenant); !errors.As(err, &qerr) || qerr.Quota != quotaSubscriptions || !qerr.Reset.IsZero() {
+		t.Errorf("expected the subscription quota to be exceeded, got %v", err)
+	}
+	release()
+	release()
+	if _, err := q.subscribe(tenant); err != nil {
+		t.Errorf("subscription was not released: %v", err)
+	}
+
+	// Usage survives a restart
+	dir := t.TempDir()
+	if err := q.save(dir); err != nil {
+		t.Fatal(err)
+	}
+	usage, err := readQuotaUsage(dir)
+	if err != nil || *usage["a"] != (TenantUsage{Day: "2024-03-02", Requests: 1, Bytes: 150}) {
+		t.Errorf("read back %+v, %v", usage["a"], err)
+	}
+	if usage, err := readQuotaUsage(t.TempDir()); err != nil || usage != nil {
+		t.Errorf("missing usage file: %v, %v", usage, err)
+	}
+}
+
+// TestRPCQuotas serves requests for two tenants and checks authentication,
+// structured quota errors and getusage.
+func TestRPCQuotas(t *testing.T) {
+	s := newRPCServer(makeBlockchain(3, 1), 1)
+	s.quotas = newQuotaTracker([]Tenant{
+		{Name: "alice", Key: "alice-key", RequestsPerDay: 3, Subscriptions: 1},
+		{Name: "ops", Key: "ops-key", Admin: true},
+	}, nil)
+	post := func(key, body string, v any) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if v != nil {
+			if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+				t.Fatalf("decode response %q: %v", rec.Body.String(), err)
+			}
+		}
+		return rec
+	}
+
+	var resp rpcResponse
+	if rec := post("", `{"jsonrpc":"2.0","method":"getblockcount","id":1}`, &resp); rec.Code != http.StatusUnauthorized ||
+		resp.Error == nil || resp.Error.Code != rpcUnauthorized {
+		t.Fatalf("missing key: status %d, error %+v", rec.Code, resp.Error)
+	}
+	if rec := post("wrong", `{"jsonrpc":"2.0","method":"getblockcount","id":1}`, nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unknown key: status %d", rec.Code)
+	}
+
+	// A batch counts once per call: the fourth call is over the quota
+	var batch []struct {
+		Result json.RawMessage
+		Error  *struct {
+			Code int
+			Data QuotaError
+		}
+	}
+	post("alice-key", `[{"jsonrpc":"2.0","method":"getblockcount","id":1},{"jsonrpc":"2.0","method":"getblockcount","id":2},
+		{"jsonrpc":"2.0","method":"getusage","id":3},{"jsonrpc":"2.0","method":"getblockcount","id":4}]`, &batch)
+	if len(batch) != 4 || batch[2].Error != nil {
+		t.Fatalf("unexpected batch response %+v", batch)
+	}
+	if e := batch[3].Error; e == nil || e.Code != rpcQuotaExceeded || e.Data.Tenant != "alice" || e.Data.Quota != quotaRequests || e.Data.Limit != 3 {
+		t.Errorf("expected a structured quota error, got %+v", e)
+	}
+	var usage usageView
+	json.Unmarshal(batch[2].Result, &usage)
+	if usage.Tenant != "alice" || usage.Requests != 3 || usage.Bytes != 0 {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+
+	// Other endpoints are refused with 429 and told when to retry
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/proof/1/0?apikey=alice-key", nil))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("proof over quota: status %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	var other struct {
+		Result usageView
+		Error  *rpcError
+	}
+	post("ops-key", `{"jsonrpc":"2.0","method":"getusage","params":["alice"],"id":1}`, &other)
+	if other.Error != nil || other.Result.Requests != 3 || other.Result.Bytes == 0 {
+		t.Errorf("admin getusage: %+v", other)
+	}
+	s.quotas.tenants[sha256.Sum256([]byte("alice-key"))].RequestsPerDay = 0
+	post("alice-key", `{"jsonrpc":"2.0","method":"getusage","params":["ops"],"id":1}`, &other)
+	if other.Error == nil || other.Error.Code != rpcUnauthorized {
+		t.Errorf("expected alice to be refused the usage of ops, got %+v", other.Error)
+	}
+
+	// One subscription at a time
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	wsDial(t, srv, "/ws?apikey=alice-key")
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ws?apikey=alice-key", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("second subscription: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A node open to the public can be flooded with cheap requests, such as a
+// batch of getblock calls scanning the whole chain. The rate limiter gives
+// every client IP address, and every API key with a rate_limit, a token
+// bucket: a request takes a token, and tokens come back at the limit's
+// rate up to its burst. Like the daily quotas, it counts JSON-RPC calls
+// one by one, so a batch costs as much as its calls sent apart.
+
+// maxRateBuckets is how many buckets the limiter holds before it drops
+// those that have refilled, whose clients have gone quiet.
+const maxRateBuckets = 10000
+
+// Rate limit kinds, as reported in RateLimitError.
+const (
+	rateLimitClient = "client"
+	rateLimitKey    = "key"
+)
+
+// RateLimitError reports a request refused because its client or API key
+// sends faster than its rate. It is returned to JSON-RPC clients as the
+// error's data.
+type RateLimitError struct {
+	Limit      string        `json:"limit"` // client or key
+	Client     string        `json:"client"`
+	Rate       float64       `json:"rate"`
+	RetryAfter time.Duration `json:"retry_after"`
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s %s exceeded its rate limit of %g requests per second; retry in %v", e.Limit, e.Client, e.Rate, e.RetryAfter.Round(time.Millisecond))
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	rate   float64
+	burst  int
+}
+
+// full reports whether the bucket will have refilled by now.
+func (b *tokenBucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= float64(b.burst)
+}
+
+// rateLimiter holds the token buckets of clients and API keys. A nil
+// limiter allows everything.
+type rateLimiter struct {
+	rate  float64 // per client IP; 0 for no per-client limit
+	burst int
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+
+	refused atomic.Uint64
+}
+
+// newRateLimiter returns a limiter allowing each client IP rate requests
+// per second, in bursts of up to burst.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: burst, now: time.Now, buckets: make(map[string]*tokenBucket)}
+}
+
+// refill returns the bucket under key topped up to now. The caller must
+// hold l.mu.
+func (l *rateLimiter) refill(key string, rate float64, burst int, now time.Time) *tokenBucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		l.buckets[key] = b
+	}
+	// A tenant's limits may have changed since the bucket was made
+	b.rate, b.burst = rate, burst
+	b.tokens = min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	return b
+}
+
+// take spends a token of the client at addr and, when it has a rate
+// limit, of tenant. The request is refused, and no token spent, if either
+// bucket is empty.
+func (l *rateLimiter) take(addr string, tenant *Tenant) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if len(l.buckets) >= maxRateBuckets {
+		l.sweep(now)
+	}
+
+	type limit struct {
+		kind, client string
+		rate         float64
+		burst        int
+		bucket       *tokenBucket
+	}
+	var limits []limit
+	if l.rate > 0 {
+		limits = append(limits, limit{kind: rateLimitClient, client: addr, rate: l.rate, burst: l.burst})
+	}
+	if tenant != nil && tenant.RateLimit > 0 {
+		limits = append(limits, limit{kind: rateLimitKey, client: tenant.Name, rate: tenant.RateLimit, burst: tenant.RateBurst})
+	}
+	for i := range limits {
+		lim := &limits[i]
+		lim.bucket = l.refill(lim.kind+" "+lim.client, lim.rate, lim.burst, now)
+		if lim.bucket.tokens < 1 {
+			l.refused.Add(1)
+			wait := time.Duration((1 - lim.bucket.tokens) / lim.rate * float64(time.Second))
+			return &RateLimitError{Limit: lim.kind, Client: lim.client, Rate: lim.rate, RetryAfter: wait}
+		}
+	}
+	for _, lim := range limits {
+		lim.bucket.tokens--
+	}
+	return nil
+}
+
+// sweep drops the buckets that have refilled, which a new bucket would
+// match. The caller must hold l.mu.
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.full(now) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// tenantRates reports whether any of tenants has a rate limit.
+func tenantRates(tenants []Tenant) bool {
+	return slices.ContainsFunc(tenants, func(t Tenant) bool { return t.RateLimit > 0 })
+}
+
+// Refused returns how many requests the limiter has refused.
+func (l *rateLimiter) Refused() uint64 {
+	if l == nil {
+		return 0
+	}
+	return l.refused.Load()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newRateLimiter(1, 3)
+	l.now = func() time.Time { return now }
+
+	for i := range 3 {
+		if err := l.take("10.0.0.1", nil); err != nil {
+			t.Fatalf("request %d of the burst refused: %v", i, err)
+		}
+	}
+	err := l.take("10.0.0.1", nil)
+	var lerr *RateLimitError
+	if !errors.As(err, &lerr) || lerr.Limit != rateLimitClient || lerr.RetryAfter != time.Second {
+		t.Fatalf("request past the burst: %v", err)
+	}
+	if err := l.take("10.0.0.2", nil); err != nil {
+		t.Errorf("another client refused: %v", err)
+	}
+	now = now.Add(time.Second)
+	if err := l.take("10.0.0.1", nil); err != nil {
+		t.Errorf("refilled token refused: %v", err)
+	}
+
+	// A key's limit holds across addresses, and a refused request spends
+	// no token of the other bucket
+	tenant := &Tenant{Name: "explorer", RateLimit: 0.5, RateBurst: 1}
+	if err := l.take("10.0.0.3", tenant); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.take("10.0.0.4", tenant); !errors.As(err, &lerr) || lerr.Limit != rateLimitKey || lerr.RetryAfter != 2*time.Second {
+		t.Fatalf("second request of a key: %v", err)
+	}
+	if b := l.buckets[rateLimitClient+" 10.0.0.4"]; b.tokens != 3 {
+		t.Errorf("refused request spent a client token: %v left", b.tokens)
+	}
+	if got := l.Refused(); got != 2 {
+		t.Errorf("Refused() = %d, want 2", got)
+	}
+
+	now = now.Add(time.Hour)
+	l.sweep(now)
+	if len(l.buckets) != 0 {
+		t.Errorf("%d refilled buckets kept", len(l.buckets))
+	}
+	var none *rateLimiter
+	if err := none.take("10.0.0.1", tenant); err != nil || none.Refused() != 0 {
+		t.Error("a nil limiter limits")
+	}
+}
+
+func TestRPC_RateLimit(t *testing.T) {
+	s := newRPCServer(makeBlockchain(2, 1), 1)
+	s.limiter = newRateLimiter(0.001, 3)
+
+	// A batch costs a token per call
+	var batch []struct {
+		Result *int
+		Error  *rpcError
+	}
+	call := `{"jsonrpc":"2.0","method":"getblockcount","id":1}`
+	rpcPost(t, s, "["+strings.Repeat(call+",", 4)+call+"]", &batch)
+	if len(batch) != 5 {
+		t.Fatalf("got %d responses", len(batch))
+	}
+	for i, resp := range batch {
+		if limited := resp.Error != nil && resp.Error.Code == rpcQuotaExceeded; limited != (i >= 3) {
+			t.Errorf("call %d: %+v", i, resp.Error)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/proof/1/0", nil))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("REST request past the limit: status %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	var body map[string]string
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if !strings.Contains(body["error"], "rate limit") {
+		t.Errorf("error %q", body["error"])
+	}
+}
+
+func TestTenantRates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	os.WriteFile(path, []byte(`[{"name": "a", "key": "k", "rate_limit": 5}]`), 0o644)
+	if _, err := loadTenants(path); err == nil {
+		t.Error("rate_limit without rate_burst accepted")
+	}
+	os.WriteFile(path, []byte(`[{"name": "a", "key": "k", "rate_limit": 5, "rate_burst": 10}]`), 0o644)
+	tenants, err := loadTenants(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tenantRates(tenants) || tenantRates([]Tenant{{Name: "b"}}) {
+		t.Error("tenantRates")
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Redaction records that a block's data was removed from storage, for
+// example to honour an erasure request. The block keeps its stored hash,
+// so later blocks still link to it. A legacy block's hash can no longer
+// be recomputed from its contents, so it is believed only when this node
+// redacted the block; a block with a Merkle root keeps a fully checkable
+// header.
+type Redaction struct {
+	Reason     string `json:"reason"`
+	RedactedAt int64  `json:"redacted_at"`
+	DataLength int    `json:"data_length"` // length of the removed data
+
+	// trusted is set on redactions made by this node or read back from its
+	// own store. Anyone can attach a marker to a block with a made-up hash,
+	// so markers from elsewhere are not trusted.
+	trusted bool
+}
+
+// redactBlock removes the data of a block and records why. Redacting a
+// block twice is an error, since the original length would be lost.
+func redactBlock(block *Block, reason string) error {
+	if block.Redaction != nil {
+		return fmt.Errorf("block %d is already redacted", block.Index)
+	}
+	block.Redaction = &Redaction{
+		Reason:     reason,
+		RedactedAt: time.Now().Unix(),
+		DataLength: len(block.Data),
+		trusted:    true,
+	}
+	block.Data = []byte{}
+	return nil
+}
+
+// committedHash returns the hash a block commits to: recomputed from its
+// contents, or the stored hash for a legacy block this node redacted,
+// whose data is gone. A block with a Merkle root still hashes without its
+// data, and so does a legacy block redacted elsewhere, which then fails
+// to match its stored hash.
+func committedHash(block *Block) []byte {
+	if unverifiable(block) {
+		return block.Hash
+	}
+	return calculateHash(block)
+}
+
+// unverifiable reports whether block's hash cannot be recomputed and is
+// taken on trust: a legacy block this node redacted.
+func unverifiable(block *Block) bool {
+	return block.Redaction != nil && block.Redaction.trusted && block.MerkleRoot == nil
+}
+
+// dataLength returns the length of the block's data, including data that
+// was redacted.
+func dataLength(block *Block) int {
+	if block.Redaction != nil {
+		return block.Redaction.DataLength
+	}
+	return len(block.Data)
+}
+
+// runRedact implements the redact subcommand, which removes the data of a
+// block in the chain stored in a data directory.
+func runRedact(args []string) int {
+	fs := flag.NewFlagSet("redact", flag.ContinueOnError)
+	dataDir := fs.String("datadir", "", "data directory holding an imported chain (required)")
+	index := fs.Int("index", -1, "index of the block to redact (required)")
+	reason := fs.String("reason", "", "why the data is removed (required)")
+	if err := fs.Parse(args); err != nil {
+		return flagError(err)
+	}
+	if *dataDir == "" || *index < 0 || *reason == "" {
+		return usage("Usage: blockchain redact -datadir dir -index n -reason text")
+	}
+
+	path := chainStorePath(*dataDir)
+	chain, err := readChainFile(path, DecodePolicy{Strict: true, TrustRedactions: true})
+	if errors.Is(err, os.ErrNotExist) {
+		return failf(exitStorage, "no chain in %s; run 'blockchain import' first", *dataDir)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if *index >= len(chain) {
+		return failf(exitConfig, "block %d not found; the chain has %d blocks", *index, len(chain))
+	}
+	if err := redactBlock(chain[*index], *reason); err != nil {
+		return fail(err)
+	}
+	if err := saveStore(*dataDir, chain); err != nil {
+		return fail(fmt.Errorf("writing chain: %w", err))
+	}
+	fmt.Printf("Redacted %d bytes from block %d\n", chain[*index].Redaction.DataLength, *index)
+	return 0
+}
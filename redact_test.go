@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestRedactBlock checks that a redacted block keeps the chain valid and
+// survives every chain file format.
+func TestRedactBlock(t *testing.T) {
+	chain := makeBlockchain(4, 1)
+	if err := redactBlock(chain[1], "erasure request"); err != nil {
+		t.Fatal(err)
+	}
+	if err := redactBlock(chain[1], "again"); err == nil {
+		t.Error("expected a second redaction to fail")
+	}
+	if len(chain[1].Data) != 0 || chain[1].Redaction.DataLength != len("Block 1") {
+		t.Fatalf("unexpected redacted block %+v", chain[1])
+	}
+
+	if err := validateChain(chain, 1); err != nil {
+		t.Fatal(err)
+	}
+	if report := validateChainReport(chain, 1); !report.Valid() || !reflect.DeepEqual(report.Redacted, []int{1}) {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	for _, name := range []string{"chain.json", "chain.jsonl.gz", "chain.pb"} {
+		path := filepath.Join(t.TempDir(), name)
+		if err := writeChainFile(chain, path); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := importChain(path, &PoWEngine{Difficulty: 1}, DecodePolicy{Strict: true}); err == nil {
+			t.Errorf("%s: strict import believed a redaction marker from a file", name)
+		}
+		loaded, err := importChain(path, &PoWEngine{Difficulty: 1}, DecodePolicy{Strict: true, TrustRedactions: true})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(loaded[1].Redaction, chain[1].Redaction) {
+			t.Errorf("%s: redaction record changed to %+v", name, loaded[1].Redaction)
+		}
+	}
+
+	var resp struct{ Result rpcBlock }
+	rpcPost(t, newRPCServer(chain, 1), fmt.Sprintf(`{"jsonrpc":"2.0","method":"getblock","params":[%q],"id":1}`, hex.EncodeToString(chain[1].Hash)), &resp)
+	if !resp.Result.Redacted || resp.Result.RedactionReason != "erasure request" || resp.Result.Data != "" {
+		t.Errorf("getblock did not flag the redaction: %+v", resp.Result)
+	}
+}
+
+// TestRedactBlockLinks checks that redaction does not hide a broken link or
+// data kept alongside a redaction record.
+func TestRedactBlockLinks(t *testing.T) {
+	chain := makeBlockchain(4, 1)
+	redactBlock(chain[2], "erasure request")
+	chain[2].Hash = append([]byte{}, chain[1].Hash...)
+	if err := validateChain(chain, 1); err == nil {
+		t.Error("expected a changed redacted hash to break the next link")
+	}
+
+	block := &Block{Index: 1, Data: []byte("kept"), PrevHash: make([]byte, 32), Hash: make([]byte, 32), Redaction: &Redaction{}}
+	if err := checkBlockFields(block, 1, DecodePolicy{Strict: true}); err == nil {
+		t.Error("expected a redacted block with data to be rejected")
+	}
+}
+
+// TestRunRedact redacts a block of a stored chain.
+func TestRunRedact(t *testing.T) {
+	dataDir := t.TempDir()
+	if err := writeChainFile(makeBlockchain(3, 1), chainStorePath(dataDir)); err != nil {
+		t.Fatal(err)
+	}
+	if code := runRedact([]string{"-datadir", dataDir, "-index", "1", "-reason", "test"}); code != 0 {
+		t.Fatalf("redact: expected exit code 0, got %d", code)
+	}
+	if code := runRedact([]string{"-datadir", dataDir, "-index", "1", "-reason", "test"}); code != 1 {
+		t.Errorf("second redact: expected exit code 1, got %d", code)
+	}
+	chain, err := importChain(chainStorePath(dataDir), &PoWEngine{Difficulty: 1}, DecodePolicy{Strict: true, TrustRedactions: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chain[1].Redaction == nil || chain[1].Redaction.Reason != "test" {
+		t.Error("stored block was not redacted")
+	}
+}
+
+// TestForgedRedactedBlock offers a block claiming to be a redacted legacy
+// block with an all-zero hash, which meets any difficulty. Nothing may
+// believe its stored hash: not submitblock, the decoders, validation or
+// the validate command.
+func TestForgedRedactedBlock(t *testing.T) {
+	chain := makeBlockchain(2, 1)
+	forged := fmt.Sprintf(`{"index": 2, "timestamp": 0, "prev_hash": %q, "hash": %q, "nonce": 0, "data": "",
+		"redaction": {"reason": "erasure request", "redacted_at": 1, "data_length": 5}}`,
+		base64.StdEncoding.EncodeToString(chain[1].Hash), base64.StdEncoding.EncodeToString(make([]byte, 32)))
+
+	s := newRPCServer(chain, 1)
+	if reason := s.submitBlock(context.Background(), json.RawMessage(forged)); reason == nil {
+		t.Fatal("submitblock accepted the forged block")
+	}
+	if s.tip().Index != 1 {
+		t.Fatalf("tip moved to %d", s.tip().Index)
+	}
+
+	if _, err := decodeBlockJSON([]byte(forged), 2, DecodePolicy{Strict: true}); err == nil {
+		t.Error("strict decoding accepted the redaction marker")
+	}
+	block, err := decodeBlockJSON([]byte(forged), 2, DecodePolicy{})
+	if err != nil {
+		t.Fatalf("lenient decoding: %v", err)
+	}
+	if err := validateChain(append(chain, block), 1); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("validateChain: expected ErrHashMismatch, got %v", err)
+	}
+	if work := blockWork(block, 1); work.Sign() != 0 {
+		t.Errorf("the forged block counts %v work", work)
+	}
+
+	// Data kept next to the marker is refused even leniently
+	withData := strings.Replace(forged, `"data": ""`, `"data": "YXJiaXRyYXJ5"`, 1)
+	if _, err := decodeBlockJSON([]byte(withData), 2, DecodePolicy{}); err == nil {
+		t.Error("lenient decoding accepted a redacted block with data")
+	}
+
+	raw, _ := json.Marshal(chain)
+	file := filepath.Join(t.TempDir(), "chain.json")
+	os.WriteFile(file, append(raw[:len(raw)-1], append([]byte(","+forged), ']')...), 0o644)
+	for _, args := range [][]string{{"-file", file, "-difficulty", "1"}, {"-file", file, "-difficulty", "1", "-strict"}} {
+		if code := runValidate(args); code == exitOK {
+			t.Errorf("validate %v passed the forged block", args)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"log/slog"
+	"math/big"
+)
+
+// A block that does not build on the tip but on an earlier block of the
+// chain, or on a block of such a side branch, is kept aside rather than
+// refused. Once a side branch has more work than the chain above the
+// block they share, the node reorganizes onto it, as Bitcoin Core does.
+// Blocks of a side branch that has less work are answered "inconclusive":
+// valid, but not on the best chain.
+
+// maxSideBlocks bounds the side-branch blocks a node keeps. Past it the
+// lowest are dropped, which a branch that far behind can spare.
+const maxSideBlocks = 256
+
+// sideParent returns the block block builds on, on the chain or a side
+// branch, or nil. The caller must hold s.mu.
+func (s *rpcServer) sideParent(block *Block) *Block {
+	if h := block.Index - 1; h >= 0 && h < len(s.chain) && bytes.Equal(s.chain[h].Hash, block.PrevHash) {
+		return s.chain[h]
+	}
+	if parent, ok := s.side[hex.EncodeToString(block.PrevHash)]; ok && parent.Index == block.Index-1 {
+		return parent
+	}
+	return nil
+}
+
+// branchTo returns the side branch ending with block, oldest first,
+// starting just above the chain block it forks from. The caller must hold
+// s.mu, and block's parent must be known to sideParent.
+func (s *rpcServer) branchTo(block *Block) []*Block {
+	branch := []*Block{block}
+	for {
+		parent := s.sideParent(branch[0])
+		if parent.Index < len(s.chain) && s.chain[parent.Index] == parent {
+			break
+		}
+		branch = append([]*Block{parent}, branch...)
+	}
+	return branch
+}
+
+// extendSide validates a block building on a side branch or below the
+// tip and either keeps it aside, returning "inconclusive", or switches
+// the chain to its branch, returning "". The caller must hold s.mu.
+func (s *rpcServer) extendSide(block *Block) string {
+	parent := s.sideParent(block)
+	if parent == nil {
+		return "bad-prevblk"
+	}
+	if _, ok := s.side[hex.EncodeToString(block.Hash)]; ok {
+		return "duplicate"
+	}
+	branch := s.branchTo(block)
+	fork := branch[0].Index - 1
+	if fork+1 < s.pruneHeight {
+		// The blocks a reorg would need have been pruned
+		return "bad-prevblk"
+	}
+	if err := checkCheckpointFork(fork+1, len(s.chain)-1); err != nil {
+		return rejectReason(err)
+	}
+
+	// The chain up to the fork, then the branch, as validators see it
+	prefix := append(s.chain[:fork+1:fork+1], branch[:len(branch)-1]...)
+	if reason := s.validateNext(prefix, block); reason != "" {
+		return reason
+	}
+
+	work := make([]*big.Int, len(branch))
+	total := s.totalWork[fork]
+	for i, b := range branch {
+		total = new(big.Int).Add(total, s.blockWork(b))
+		work[i] = total
+	}
+	if total.Cmp(s.totalWork[len(s.totalWork)-1]) <= 0 {
+		s.keepSide(block)
+		return "inconclusive"
+	}
+	if depth := len(s.chain) - 1 - fork; s.maxReorgDepth > 0 && depth > s.maxReorgDepth {
+		// Kept so that the branch can be weighed again, and alerted on,
+		// as it grows
+		s.keepSide(block)
+		alert := ReorgRefused{Fork: fork, Tip: s.chain[len(s.chain)-1].Hash, BranchTip: block.Hash, Depth: depth, MaxDepth: s.maxReorgDepth}
+		s.logger.Error("reorg_refused", slog.Int("fork", fork), slog.Int("depth", depth), slog.Int("max_depth", s.maxReorgDepth),
+			slog.String("tip", hex.EncodeToString(alert.Tip)), slog.String("branch_tip", hex.EncodeToString(alert.BranchTip)))
+		s.bus.Publish(alert)
+		return "reorg-too-deep"
+	}
+	old := s.chain[fork+1:]
+	s.chain = append(prefix, block)
+	s.totalWork = append(s.totalWork[:fork+1:fork+1], work...)
+	for _, b := range old {
+		s.keepSide(b)
+	}
+	for _, b := range branch {
+		delete(s.side, hex.EncodeToString(b.Hash))
+	}
+	close(s.tipChanged)
+	s.tipChanged = make(chan struct{})
+	s.reorgs.Add(1)
+
+	reorg := ChainReorged{Fork: fork, OldTip: old[len(old)-1].Hash, NewTip: block.Hash, Removed: len(old), Added: len(branch)}
+	s.logger.Warn("chain_reorged", slog.Int("fork", fork), slog.Int("removed", reorg.Removed), slog.Int("added", reorg.Added),
+		slog.String("old_tip", hex.EncodeToString(reorg.OldTip)), slog.String("new_tip", hex.EncodeToString(reorg.NewTip)))
+	s.bus.Publish(reorg)
+	return ""
+}
+
+// keepSide stores a side-branch block, dropping the lowest one when the
+// store is full. The caller must hold s.mu.
+func (s *rpcServer) keepSide(block *Block) {
+	if s.side == nil {
+		s.side = make(map[string]*Block)
+	}
+	if len(s.side) >= maxSideBlocks {
+		var lowest string
+		for key, b := range s.side {
+			if lowest == "" || b.Index < s.side[lowest].Index {
+				lowest = key
+			}
+		}
+		delete(s.side, lowest)
+	}
+	s.side[hex.EncodeToString(block.Hash)] = block
+}
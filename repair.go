@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// chainSumsName is the file in a data directory holding the CRC-32C of
+// every block record of the stored chain, one hex value per line.
+const chainSumsName = "chain.sums"
+
+// ErrChecksumMismatch reports a stored block whose record no longer
+// matches the checksum written with it.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// blockChecksum returns the CRC-32C of a block's record: its JSON
+// encoding, as written on its line of the store.
+func blockChecksum(block *Block) (uint32, error) {
+	record, err := json.Marshal(block)
+	if err != nil {
+		return 0, err
+	}
+	return crc32.Checksum(record, crc32c), nil
+}
+
+func chainSumsPath(dataDir string) string {
+	return filepath.Join(dataDir, chainSumsName)
+}
+
+// writeChainSums stores the checksums of chain in dataDir.
+func writeChainSums(dataDir string, chain []*Block) error {
+	var buf bytes.Buffer
+	for _, block := range chain {
+		sum, err := blockChecksum(block)
+		if err != nil {
+			return fmt.Errorf("block %d: %w", block.Index, err)
+		}
+		fmt.Fprintf(&buf, "%08x\n", sum)
+	}
+	tmp := filepath.Join(dataDir, "tmp-"+chainSumsName)
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, chainSumsPath(dataDir))
+}
+
+// addChainSums writes checksums for the store already in dataDir, which
+// holds chain. They are dated to the store, so that readChainSums does not
+// take them for those of a save that never finished.
+func addChainSums(dataDir string, chain []*Block) error {
+	store, err := os.Stat(chainStorePath(dataDir))
+	if err != nil {
+		return err
+	}
+	if err := writeChainSums(dataDir, chain); err != nil {
+		return err
+	}
+	return os.Chtimes(chainSumsPath(dataDir), time.Time{}, store.ModTime())
+}
+
+// readChainSums loads the checksums in dataDir, or returns nil if the store
+// was written without them or they are not the store's; see saveStore.
+func readChainSums(dataDir string) ([]uint32, error) {
+	info, err := os.Stat(chainSumsPath(dataDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	store, err := os.Stat(chainStorePath(dataDir))
+	if errors.Is(err, os.ErrNotExist) || err == nil && store.ModTime().Before(info.ModTime()) {
+		// The save that wrote the checksums did not get to the store
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(chainSumsPath(dataDir))
+	if err != nil {
+		return nil, err
+	}
+	sums := []uint32{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		sum, err := strconv.ParseUint(sc.Text(), 16, 32)
+		if err != nil {
+			return nil, &ChainDecodeError{Path: chainSumsPath(dataDir), Err: fmt.Errorf("line %d: %w", n, err)}
+		}
+		sums = append(sums, uint32(sum))
+	}
+	return sums, sc.Err()
+}
+
+// saveStore writes chain to the store in dataDir along with its checksums.
+// The two are renamed into place one after the other, checksums first, so
+// a crash between them leaves checksums newer than the store they were not
+// written for. readChainSums ignores checksums newer than the store, which
+// is then checked as one written before checksums existed until the next
+// save.
+func saveStore(dataDir string, chain []*Block) error {
+	if err := writeChainSums(dataDir, chain); err != nil {
+		return err
+	}
+	return saveChain(chain, chainStorePath(dataDir))
+}
+
+// checkChainSums compares the blocks of chain against their stored
+// checksums. Blocks beyond the end of sums are not checked.
+func checkChainSums(chain []*Block, sums []uint32) []*BlockValidationError {
+	var problems []*BlockValidationError
+	for i, block := range chain[:min(len(chain), len(sums))] {
+		if sum, err := blockChecksum(block); err != nil || sum != sums[i] {
+			problems = append(problems, &BlockValidationError{Index: i, Err: ErrChecksumMismatch})
+		}
+	}
+	return problems
+}
+
+// StoreScan is the result of scanning a data directory's chain store
+// record by record.
+type StoreScan struct {
+	Blocks   []*Block // the blocks that could be decoded, up to the first unreadable record
+	Problems []*BlockValidationError
+	// Verified is the number of leading blocks that passed every check,
+	// so Verified-1 is the last verifiable height.
+	Verified int
+	// Checksums reports whether the store had checksums to compare.
+	Checksums bool
+}
+
+// scanStore reads the store in dataDir without giving up at the first
+// problem, as fsck does. Each record is compared with its checksum and each
+// block with its predecessor as engine checks it. A store cut short, or whose
+// checksums list more blocks than it holds, is missing blocks.
+func scanStore(dataDir string, engine Engine) (*StoreScan, error) {
+	sums, err := readChainSums(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(chainStorePath(dataDir))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scan := &StoreScan{Checksums: sums != nil}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		scan.Problems = append(scan.Problems, &BlockValidationError{Index: 0, Err: fmt.Errorf("unreadable: %w", err)})
+		return scan, nil
+	}
+	defer zr.Close()
+
+	cache := NewHashCache(2)
+	dec := json.NewDecoder(zr)
+	complete := false
+	for i := 0; ; i++ {
+		var record json.RawMessage
+		if err := dec.Decode(&record); err == io.EOF {
+			complete = true
+			break
+		} else if err != nil {
+			// Nothing past a damaged stretch of the stream can be trusted
+			scan.Problems = append(scan.Problems, &BlockValidationError{Index: i, Err: fmt.Errorf("unreadable: %w", err)})
+			break
+		}
+
+		// A record is this node's own, redaction marker included, when it
+		// matches its checksum or the store predates checksums
+		own := sums == nil
+		switch {
+		case sums != nil && i >= len(sums):
+			scan.Problems = append(scan.Problems, &BlockValidationError{Index: i, Err: errors.New("no checksum")})
+		case sums != nil && crc32.Checksum(record, crc32c) != sums[i]:
+			scan.Problems = append(scan.Problems, &BlockValidationError{Index: i, Err: ErrChecksumMismatch})
+		default:
+			own = true
+		}
+		block, err := decodeBlockJSON(record, i, DecodePolicy{Strict: true, TrustRedactions: own})
+		if err != nil {
+			scan.Problems = append(scan.Problems, &BlockValidationError{Index: i, Err: err})
+			break
+		}
+		scan.Blocks = append(scan.Blocks, block)
+
+		switch {
+		case block.Index != i:
+			scan.Problems = append(scan.Problems, &BlockValidationError{Index: i, Err: fmt.Errorf("stored at position %d but has index %d", i, block.Index)})
+		case i == 0 && !bytes.Equal(committedHash(block), block.Hash):
+			scan.Problems = append(scan.Problems, &BlockValidationError{Index: 0, Err: ErrHashMismatch})
+		case i > 0:
+			if err := validateBlockPairWith(scan.Blocks[i-1], block, engine, cache); err != nil {
+				var blockErr *BlockValidationError
+				if !errors.As(err, &blockErr) {
+					blockErr = &BlockValidationError{Index: i, Err: err}
+				}
+				scan.Problems = append(scan.Problems, blockErr)
+			}
+		}
+	}
+	if n := len(scan.Blocks); complete && n < len(sums) {
+		scan.Problems = append(scan.Problems, &BlockValidationError{Index: n, Err: fmt.Errorf("missing: the checksums list %d blocks, the store holds %d", len(sums), n)})
+	}
+
+	scan.Verified = len(scan.Blocks)
+	for _, p := range scan.Problems {
+		scan.Verified = min(scan.Verified, p.Index)
+	}
+	return scan, nil
+}
+
+// runRepair implements the repair subcommand: it scans the stored chain
+// for corrupted and missing blocks and truncates it to the last verifiable
+// height. The damaged store is kept next to the repaired one.
+func runRepair(args []string) int {
+	fs := flag.NewFlagSet("repair", flag.ContinueOnError)
+	dataDir := fs.String("datadir", "", "data directory holding an imported chain (required)")
+	fs.Int("difficulty", 4, "proof-of-work difficulty the chain was mined at")
+	network, paramsPath := addChainFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "report the problems without changing the store")
+	if err := fs.Parse(args); err != nil {
+		return flagError(err)
+	}
+	if *dataDir == "" {
+		return usage("Usage: blockchain repair -datadir dir [-difficulty n] [-network name] [-params file] [-dry-run]")
+	}
+	_, engine, err := chainEngineFlags(fs, *network, *paramsPath)
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+
+	scan, err := scanStore(*dataDir, engine)
+	if errors.Is(err, os.ErrNotExist) {
+		return failf(exitStorage, "no chain in %s; run 'blockchain import' first", *dataDir)
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	if len(scan.Problems) == 0 {
+		fmt.Printf("Store is sound (%d blocks)\n", len(scan.Blocks))
+		if scan.Checksums || *dryRun {
+			return exitOK
+		}
+		if err := addChainSums(*dataDir, scan.Blocks); err != nil {
+			return fail(fmt.Errorf("writing checksums: %w", err))
+		}
+		fmt.Printf("Wrote checksums for %d blocks\n", len(scan.Blocks))
+		return exitOK
+	}
+
+	fmt.Printf("Store is damaged (%d problems):\n", len(scan.Problems))
+	for _, p := range scan.Problems {
+		fmt.Printf("- %v\n", p)
+	}
+	if scan.Verified == 0 {
+		return failf(exitStorage, "no block of %s can be verified; restore a known-good export with 'blockchain import'", *dataDir)
+	}
+	kept := scan.Blocks[:scan.Verified]
+	if *dryRun {
+		fmt.Printf("Dry run: would truncate the chain to height %d\n", scan.Verified-1)
+		return failReported(&BlockValidationError{Index: scan.Verified, Err: errors.New("store is damaged")})
+	}
+
+	path := chainStorePath(*dataDir)
+	if err := os.Rename(path, path+".corrupt"); err != nil {
+		return fail(err)
+	}
+	if err := saveStore(*dataDir, kept); err != nil {
+		return fail(fmt.Errorf("writing chain: %w", err))
+	}
+	// Every kept block was just validated
+	if err := writeCheckpoint(*dataDir, newCheckpoint(kept, engine.MinDifficulty(), nil)); err != nil {
+		return fail(fmt.Errorf("writing checkpoint: %w", err))
+	}
+	fmt.Printf("Truncated the chain to height %d; the damaged store is kept as %s\n", scan.Verified-1, path+".corrupt")
+	return exitOK
+}
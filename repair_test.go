@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestStoreChecksums checks that the self-check compares stored blocks
+// against the checksums written with them.
+func TestStoreChecksums(t *testing.T) {
+	dataDir := t.TempDir()
+	chain := makeBlockchain(4, 1)
+	if err := saveStore(dataDir, chain); err != nil {
+		t.Fatal(err)
+	}
+	sums, err := readChainSums(dataDir)
+	if err != nil || len(sums) != 4 {
+		t.Fatalf("read %d checksums: %v", len(sums), err)
+	}
+	if r := selfCheck(chain, &PoWEngine{Difficulty: 1}, nil, sums, 0); !r.OK() {
+		t.Fatalf("valid store: %v", r.Err())
+	}
+
+	// A redaction field is outside the hash; only its checksum catches it
+	chain[2].Redaction = &Redaction{Reason: "flipped", DataLength: 1}
+	r := selfCheck(chain, &PoWEngine{Difficulty: 1}, nil, sums, 0)
+	if r.FirstBad() != 2 || !errors.Is(r.Err(), ErrChecksumMismatch) {
+		t.Errorf("expected a checksum mismatch at block 2, got %v", r.Err())
+	}
+
+	if sums, err := readChainSums(t.TempDir()); err != nil || sums != nil {
+		t.Errorf("store without checksums: %v, %v", sums, err)
+	}
+}
+
+// TestStoreChecksumsInterruptedSave checks that checksums written by a
+// save that crashed before renaming the store are not held against it.
+func TestStoreChecksumsInterruptedSave(t *testing.T) {
+	dataDir := t.TempDir()
+	chain := makeBlockchain(4, 1)
+	if err := saveStore(dataDir, chain); err != nil {
+		t.Fatal(err)
+	}
+	redacted := append([]*Block{}, chain...)
+	redacted[2] = pruneBlock(chain[2])
+	if err := writeChainSums(dataDir, redacted); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := os.Stat(chainStorePath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(chainSumsPath(dataDir), stored.ModTime(), stored.ModTime().Add(time.Second))
+
+	if sums, err := readChainSums(dataDir); err != nil || sums != nil {
+		t.Fatalf("checksums newer than the store: %v, %v", sums, err)
+	}
+	scan, err := scanStore(dataDir, &PoWEngine{Difficulty: 1})
+	if err != nil || len(scan.Problems) != 0 || scan.Checksums {
+		t.Fatalf("scan after an interrupted save: %+v, %v", scan, err)
+	}
+
+	// Once the store catches up, the checksums apply again
+	if err := saveStore(dataDir, redacted); err != nil {
+		t.Fatal(err)
+	}
+	if sums, err := readChainSums(dataDir); err != nil || len(sums) != 4 {
+		t.Errorf("read %d checksums after the next save: %v", len(sums), err)
+	}
+}
+
+// TestRepair scans damaged stores and truncates them to the last
+// verifiable height.
+func TestRepair(t *testing.T) {
+	chain := makeBlockchain(6, 1)
+	store := func(t *testing.T) string {
+		t.Helper()
+		dataDir := t.TempDir()
+		if err := saveStore(dataDir, chain); err != nil {
+			t.Fatal(err)
+		}
+		return dataDir
+	}
+
+	t.Run("sound", func(t *testing.T) {
+		dataDir := store(t)
+		scan, err := scanStore(dataDir, &PoWEngine{Difficulty: 1})
+		if err != nil || len(scan.Problems) != 0 || scan.Verified != 6 {
+			t.Fatalf("scan: %+v, %v", scan, err)
+		}
+		if code := runRepair([]string{"-datadir", dataDir, "-difficulty", "1"}); code != exitOK {
+			t.Errorf("expected exit code %d, got %d", exitOK, code)
+		}
+	})
+
+	t.Run("corrupted block", func(t *testing.T) {
+		dataDir := store(t)
+		tampered := make([]*Block, len(chain))
+		copy(tampered, chain)
+		b := *chain[3]
+		b.Nonce++
+		tampered[3] = &b
+		if err := writeChainFile(tampered, chainStorePath(dataDir)); err != nil {
+			t.Fatal(err)
+		}
+
+		if code := runRepair([]string{"-datadir", dataDir, "-difficulty", "1", "-dry-run"}); code != exitValidation {
+			t.Errorf("dry run: expected exit code %d, got %d", exitValidation, code)
+		}
+		scan, err := scanStore(dataDir, &PoWEngine{Difficulty: 1})
+		if err != nil || scan.Verified != 3 || !errors.Is(scan.Problems[0], ErrChecksumMismatch) {
+			t.Fatalf("expected a checksum mismatch at block 3, got %+v, %v", scan, err)
+		}
+
+		if code := runRepair([]string{"-datadir", dataDir, "-difficulty", "1"}); code != exitOK {
+			t.Fatalf("expected exit code %d, got %d", exitOK, code)
+		}
+		repaired, report, err := loadStoredChain(dataDir, &PoWEngine{Difficulty: 1}, true)
+		if err != nil || !report.Valid() || len(repaired) != 3 {
+			t.Fatalf("repaired store: %d blocks, %v", len(repaired), err)
+		}
+		if cp, _ := readCheckpoint(dataDir); cp == nil || cp.Height != 2 {
+			t.Errorf("checkpoint not moved to the new tip: %+v", cp)
+		}
+		if _, err := os.Stat(chainStorePath(dataDir) + ".corrupt"); err != nil {
+			t.Errorf("damaged store not kept: %v", err)
+		}
+	})
+
+	t.Run("truncated file", func(t *testing.T) {
+		dataDir := store(t)
+		data, _ := os.ReadFile(chainStorePath(dataDir))
+		os.WriteFile(chainStorePath(dataDir), data[:len(data)/2], 0o644)
+		scan, err := scanStore(dataDir, &PoWEngine{Difficulty: 1})
+		if err != nil || len(scan.Problems) == 0 || scan.Verified >= 6 || scan.Verified != len(scan.Blocks) {
+			t.Fatalf("expected an unreadable tail, got %+v, %v", scan, err)
+		}
+	})
+
+	t.Run("missing blocks", func(t *testing.T) {
+		dataDir := store(t)
+		if err := saveChain(chain[:4], chainStorePath(dataDir)); err != nil {
+			t.Fatal(err)
+		}
+		scan, err := scanStore(dataDir, &PoWEngine{Difficulty: 1})
+		if err != nil || len(scan.Problems) != 1 || scan.Problems[0].Index != 4 || scan.Verified != 4 {
+			t.Fatalf("expected blocks 4 and 5 to be missing, got %+v, %v", scan, err)
+		}
+	})
+
+	t.Run("no checksums", func(t *testing.T) {
+		dataDir := store(t)
+		os.Remove(chainSumsPath(dataDir))
+		// The checksums written now are newer than the store, and must
+		// still count as its own
+		past := time.Now().Add(-time.Hour)
+		os.Chtimes(chainStorePath(dataDir), past, past)
+		if code := runRepair([]string{"-datadir", dataDir, "-difficulty", "1"}); code != exitOK {
+			t.Fatalf("expected exit code %d, got %d", exitOK, code)
+		}
+		if sums, err := readChainSums(dataDir); err != nil || len(sums) != 6 {
+			t.Errorf("checksums not written: %d, %v", len(sums), err)
+		}
+	})
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshotDirName is the directory in a data directory holding the chain
+// snapshots the retention policy keeps.
+const snapshotDirName = "snapshots"
+
+// RetentionPolicy declares how long a node keeps what, for example "full
+// blocks for 30 days, headers forever, bodies archived to S3". Block
+// headers are always kept: pruning and redaction remove a block's data and
+// record why, as the redact command does, so the chain stays linked and its
+// proof-of-work checkable.
+type RetentionPolicy struct {
+	// PruneBodiesAfter is the block age, such as "30d" or "12h", after
+	// which its data is removed. Empty keeps data forever.
+	PruneBodiesAfter string `json:"prune_bodies_after"`
+	// KeepRecent blocks below the tip are never pruned or redacted, since
+	// a reorg may still replace them
+	KeepRecent int `json:"keep_recent"`
+
+	// ArchiveURL, when set, is where block data is copied before it is
+	// pruned: s3://bucket/prefix or file:///path
+	ArchiveURL      string `json:"archive_url"`
+	ArchiveEndpoint string `json:"archive_endpoint"` // S3-compatible endpoint; default AWS
+	ArchiveRegion   string `json:"archive_region"`
+
+	// RedactPattern is a regular expression; the data of blocks it matches
+	// is removed whatever their age, and never archived
+	RedactPattern string `json:"redact_pattern"`
+	RedactReason  string `json:"redact_reason"`
+
+	// SnapshotEvery, when set, keeps a copy of the chain at every multiple
+	// of this height, of which the newest SnapshotKeep are retained
+	SnapshotEvery int `json:"snapshot_every"`
+	SnapshotKeep  int `json:"snapshot_keep"`
+}
+
+// defaultRetentionPolicy holds the values a policy file leaves out.
+var defaultRetentionPolicy = RetentionPolicy{
+	KeepRecent:   100,
+	RedactReason: "matched the retention policy's redact_pattern",
+	SnapshotKeep: 3,
+}
+
+// loadRetentionPolicy reads a policy from a .json, .yaml or .yml file.
+func loadRetentionPolicy(path string) (*RetentionPolicy, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+	case ".yaml", ".yml":
+		if raw, err = flatYAMLToJSON(raw); err != nil {
+			return nil, &ChainDecodeError{Path: path, Err: err}
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported retention policy format %q (use .json, .yaml or .yml)", path, ext)
+	}
+
+	policy := defaultRetentionPolicy
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&policy); err != nil {
+		return nil, &ChainDecodeError{Path: path, Err: err}
+	}
+	return &policy, nil
+}
+
+// parseRetentionAge parses a duration that may also be given in days,
+// such as "30d".
+func parseRetentionAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	return d, nil
+}
+
+// archiveStore is where the retention policy archives block data.
+type archiveStore interface {
+	put(ctx context.Context, key string, body []byte) error
+	// location names the object put stores key as
+	location(key string) string
+}
+
+// newArchiveStore returns the store of an s3:// or file:// URL.
+func newArchiveStore(rawURL, endpoint, region string) (archiveStore, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive URL: %w", err)
+	}
+	switch u.Scheme {
+	case "s3":
+		return newS3Archive(u, endpoint, region)
+	case "file":
+		if u.Path == "" {
+			return nil, fmt.Errorf("archive URL %q has no path", rawURL)
+		}
+		return dirArchive(u.Path), nil
+	}
+	return nil, fmt.Errorf("unsupported archive URL %q (want s3://bucket/prefix or file:///path)", rawURL)
+}
+
+// dirArchive archives into a local directory, such as a mounted volume.
+type dirArchive string
+
+func (d dirArchive) location(key string) string {
+	return "file://" + filepath.ToSlash(filepath.Join(string(d), key))
+}
+
+func (d dirArchive) put(ctx context.Context, key string, body []byte) error {
+	if err := os.MkdirAll(string(d), 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(string(d), key)
+	tmp := filepath.Join(string(d), "tmp-"+key)
+	if err := os.WriteFile(tmp, body, 0o644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// archiveKey names the archived copy of a block. The copy is the whole
+// block in its protobuf encoding, so it can be checked against the header
+// the chain keeps.
+func archiveKey(block *Block) string {
+	return fmt.Sprintf("%010d-%x.pb", block.Index, block.Hash)
+}
+
+// Retention actions, in the order they are carried out for a block.
+const (
+	actionRedact         = "redact"
+	actionArchive        = "archive"
+	actionPrune          = "prune"
+	actionSnapshot       = "snapshot"
+	actionDeleteSnapshot = "delete-snapshot"
+)
+
+// retentionAction is one step of a retention run.
+type retentionAction struct {
+	Kind   string
+	Height int
+	// Detail is the redaction reason of redact and prune, and the object
+	// or file written or removed otherwise
+	Detail string
+}
+
+func (a retentionAction) String() string {
+	return fmt.Sprintf("%s %d: %s", a.Kind, a.Height, a.Detail)
+}
+
+// retentionEngine carries out a retention policy on a chain.
+type retentionEngine struct {
+	policy     RetentionPolicy
+	pruneAfter time.Duration
+	redact     *regexp.Regexp
+	archive    archiveStore
+	dataDir    string
+}
+
+// newRetentionEngine checks policy and prepares it to run on the chain
+// stored in dataDir.
+func newRetentionEngine(policy *RetentionPolicy, dataDir string) (*retentionEngine, error) {
+	e := &retentionEngine{policy: *policy, dataDir: dataDir}
+	var err error
+	if policy.PruneBodiesAfter != "" {
+		if e.pruneAfter, err = parseRetentionAge(policy.PruneBodiesAfter); err != nil {
+			return nil, fmt.Errorf("prune_bodies_after: %w", err)
+		}
+	}
+	if policy.RedactPattern != "" {
+		if e.redact, err = regexp.Compile(policy.RedactPattern); err != nil {
+			return nil, fmt.Errorf("redact_pattern: %w", err)
+		}
+	}
+	if policy.ArchiveURL != "" {
+		if e.archive, err = newArchiveStore(policy.ArchiveURL, policy.ArchiveEndpoint, policy.ArchiveRegion); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case policy.KeepRecent < 0:
+		return nil, fmt.Errorf("keep_recent must not be negative")
+	case policy.SnapshotEvery < 0 || policy.SnapshotKeep < 1:
+		return nil, fmt.Errorf("snapshot_every must not be negative and snapshot_keep must be at least 1")
+	}
+	return e, nil
+}
+
+// plan returns the actions a run at now would take on chain. Genesis and
+// the KeepRecent blocks below the tip are left alone, as are blocks whose
+// data is already gone.
+func (e *retentionEngine) plan(chain []*Block, now time.Time) ([]retentionAction, error) {
+	var actions []retentionAction
+	stable := len(chain) - 1 - e.policy.KeepRecent
+	for h := 1; h <= stable; h++ {
+		block := chain[h]
+		if block.Redaction != nil {
+			continue
+		}
+		switch {
+		case e.redact != nil && e.redact.Match(block.Data):
+			actions = append(actions, retentionAction{actionRedact, h, e.policy.RedactReason})
+		case e.pruneAfter > 0 && now.Sub(time.Unix(block.Timestamp, 0)) > e.pruneAfter:
+			reason := "pruned: older than " + e.policy.PruneBodiesAfter
+			if e.archive != nil {
+				loc := e.archive.location(archiveKey(block))
+				actions = append(actions, retentionAction{actionArchive, h, loc})
+				reason += "; archived to " + loc
+			}
+			actions = append(actions, retentionAction{actionPrune, h, reason})
+		}
+	}
+
+	if e.policy.SnapshotEvery == 0 {
+		return actions, nil
+	}
+	heights, err := listSnapshots(e.dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if at := max(stable, 0) / e.policy.SnapshotEvery * e.policy.SnapshotEvery; at > 0 && !slices.Contains(heights, at) {
+		actions = append(actions, retentionAction{actionSnapshot, at, snapshotPath(e.dataDir, at)})
+		heights = append(heights, at)
+		slices.Sort(heights)
+	}
+	for _, h := range heights[:max(len(heights)-e.policy.SnapshotKeep, 0)] {
+		actions = append(actions, retentionAction{actionDeleteSnapshot, h, snapshotPath(e.dataDir, h)})
+	}
+	return actions, nil
+}
+
+// apply carries out actions in order on chain, which it updates. Blocks are
+// never changed in place: each redacted copy is handed to replace, which
+// may refuse it, and only then stored in chain. Data is archived before it
+// is pruned, and a failed upload stops the run, so nothing is pruned that
+// was not archived. Snapshots are written last, so they hold no data the
+// run removed.
+func (e *retentionEngine) apply(ctx context.Context, chain []*Block, actions []retentionAction, replace func(*Block) error) error {
+	for _, a := range actions {
+		var err error
+		switch a.Kind {
+		case actionArchive:
+			err = e.archive.put(ctx, archiveKey(chain[a.Height]), chain[a.Height].MarshalProto())
+		case actionRedact, actionPrune:
+			updated := *chain[a.Height]
+			if err = redactBlock(&updated, a.Detail); err == nil {
+				if err = replace(&updated); err == nil {
+					chain[a.Height] = &updated
+				}
+			}
+		case actionSnapshot:
+			if err = os.MkdirAll(filepath.Dir(a.Detail), 0o755); err == nil {
+				err = saveChain(chain[:a.Height+1], a.Detail)
+			}
+		case actionDeleteSnapshot:
+			err = os.Remove(a.Detail)
+		}
+		if err != nil {
+			return fmt.Errorf("%s %d: %w", a.Kind, a.Height, err)
+		}
+	}
+	return nil
+}
+
+// snapshotPath returns the file of the snapshot at height.
+func snapshotPath(dataDir string, height int) string {
+	return filepath.Join(dataDir, snapshotDirName, fmt.Sprintf("snapshot-%010d.pb.gz", height))
+}
+
+// listSnapshots returns the heights of the snapshots in dataDir, in order.
+func listSnapshots(dataDir string) ([]int, error) {
+	entries, err := os.ReadDir(filepath.Join(dataDir, snapshotDirName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var heights []int
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), "snapshot-")
+		if name, ok2 := strings.CutSuffix(name, ".pb.gz"); ok && ok2 {
+			if h, err := strconv.Atoi(name); err == nil {
+				heights = append(heights, h)
+			}
+		}
+	}
+	slices.Sort(heights)
+	return heights, nil
+}
+
+// runRetention runs the engine on the daemon's chain now and every
+// interval until ctx is cancelled. Redacted blocks replace the originals in
+// the server's chain and reach the store with its next save. With dryRun
+// the planned actions are only logged.
+func runRetention(ctx context.Context, server *rpcServer, e *retentionEngine, interval time.Duration, dryRun bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		chain := server.snapshot()
+		actions, err := e.plan(chain, time.Now())
+		if err == nil && !dryRun {
+			err = e.apply(ctx, chain, actions, server.replaceBlock)
+		}
+		for _, a := range actions {
+			msg := "retention_applied"
+			if dryRun {
+				msg = "retention_planned"
+			}
+			server.logger.Info(msg, slog.String("action", a.Kind), slog.Int("height", a.Height), slog.String("detail", a.Detail))
+		}
+		if err != nil && ctx.Err() == nil {
+			server.logger.Error("retention_failed", slog.Any("error", err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runRetentionCommand implements the retention subcommand, which applies a
+// retention policy once to the chain stored in a data directory. Stop a
+// daemon using the directory first; it would overwrite the result.
+func runRetentionCommand(args []string) int {
+	fs := flag.NewFlagSet("retention", flag.ContinueOnError)
+	dataDir := fs.String("datadir", "", "data directory holding an imported chain (required)")
+	policyPath := fs.String("policy", "", "retention policy: .json, .yaml or .yml (required)")
+	dryRun := fs.Bool("dry-run", false, "print the actions without taking them")
+	if err := fs.Parse(args); err != nil {
+		return flagError(err)
+	}
+	if *dataDir == "" || *policyPath == "" {
+		return usage("Usage: blockchain retention -datadir dir -policy file [-dry-run]")
+	}
+	policy, err := loadRetentionPolicy(*policyPath)
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+	engine, err := newRetentionEngine(policy, *dataDir)
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+
+	path := chainStorePath(*dataDir)
+	chain, err := readChainFile(path, DecodePolicy{Strict: true, TrustRedactions: true})
+	if errors.Is(err, os.ErrNotExist) {
+		return failf(exitStorage, "no chain in %s; run 'blockchain import' first", *dataDir)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	actions, err := engine.plan(chain, time.Now())
+	if err != nil {
+		return fail(err)
+	}
+	for _, a := range actions {
+		fmt.Println(a)
+	}
+	if *dryRun {
+		fmt.Printf("Dry run: %d actions planned\n", len(actions))
+		return exitOK
+	}
+	// Blocks go to the store only after everything else succeeded
+	if err := engine.apply(context.Background(), chain, actions, func(*Block) error { return nil }); err != nil {
+		return fail(err)
+	}
+	if err := saveStore(*dataDir, chain); err != nil {
+		return fail(fmt.Errorf("writing chain: %w", err))
+	}
+	fmt.Printf("Applied %d actions\n", len(actions))
+	return exitOK
+}
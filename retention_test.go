@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRetentionEngine plans and applies a policy that redacts one block,
+// archives and prunes the other old ones and keeps two snapshots.
+func TestRetentionEngine(t *testing.T) {
+	chain := makeBlockchain(10, 1)
+	dataDir, archiveDir := t.TempDir(), t.TempDir()
+	e, err := newRetentionEngine(&RetentionPolicy{
+		PruneBodiesAfter: "30d",
+		KeepRecent:       3,
+		ArchiveURL:       "file://" + archiveDir,
+		RedactPattern:    "^Block 2$",
+		RedactReason:     "erasure request",
+		SnapshotEvery:    2,
+		SnapshotKeep:     2,
+	}, dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []int{2, 4} {
+		if err := saveChainDir(chain[:h+1], snapshotPath(dataDir, h)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Blocks 1 to 6 are old enough; 7 to 9 are within keep_recent
+	actions, err := e.plan(chain, time.Now().Add(31*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	for _, a := range actions {
+		kinds = append(kinds, fmt.Sprintf("%s %d", a.Kind, a.Height))
+	}
+	want := []string{
+		"archive 1", "prune 1", "redact 2", "archive 3", "prune 3", "archive 4", "prune 4",
+		"archive 5", "prune 5", "archive 6", "prune 6", "snapshot 6", "delete-snapshot 2",
+	}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Fatalf("planned %v, want %v", kinds, want)
+	}
+	young, _ := e.plan(chain, time.Now())
+	for _, a := range young {
+		if a.Kind == actionArchive || a.Kind == actionPrune {
+			t.Errorf("planned %v for a young block", a)
+		}
+	}
+
+	original := chain[1]
+	var replaced []int
+	err = e.apply(context.Background(), chain, actions, func(b *Block) error {
+		replaced = append(replaced, b.Index)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replaced, []int{1, 2, 3, 4, 5, 6}) {
+		t.Errorf("replaced %v", replaced)
+	}
+	if original.Redaction != nil {
+		t.Error("apply changed a block in place")
+	}
+	if err := validateChain(chain, 1); err != nil {
+		t.Fatalf("chain invalid after retention: %v", err)
+	}
+	if r := chain[1].Redaction; r == nil || !strings.Contains(r.Reason, "archived to file://") {
+		t.Errorf("unexpected prune record %+v", r)
+	}
+	if r := chain[2].Redaction; r == nil || r.Reason != "erasure request" {
+		t.Errorf("unexpected redaction record %+v", r)
+	}
+
+	// The archive holds the full block, and nothing of the redacted one
+	raw, err := os.ReadFile(filepath.Join(archiveDir, archiveKey(original)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archived Block
+	if err := archived.UnmarshalProto(raw); err != nil || !bytes.Equal(archived.Data, original.Data) {
+		t.Errorf("archived block %+v, %v", archived, err)
+	}
+	if _, err := os.Stat(filepath.Join(archiveDir, archiveKey(chain[2]))); err == nil {
+		t.Error("a redacted block was archived")
+	}
+
+	heights, err := listSnapshots(dataDir)
+	if err != nil || !reflect.DeepEqual(heights, []int{4, 6}) {
+		t.Fatalf("snapshots %v, %v", heights, err)
+	}
+	snapshot, err := readChainFile(snapshotPath(dataDir, 6), DecodePolicy{Strict: true, TrustRedactions: true})
+	if err != nil || len(snapshot) != 7 || snapshot[2].Redaction == nil {
+		t.Errorf("snapshot holds data the run removed: %v", err)
+	}
+	if actions, _ := e.plan(chain, time.Now().Add(31*24*time.Hour)); len(actions) != 0 {
+		t.Errorf("second run planned %v", actions)
+	}
+}
+
+// saveChainDir writes a chain file, creating its directory.
+func saveChainDir(chain []*Block, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return saveChain(chain, path)
+}
+
+// TestReplaceBlock checks that the daemon refuses a redacted copy of a
+// block a reorg removed.
+func TestReplaceBlock(t *testing.T) {
+	chain := makeBlockchain(3, 1)
+	server := newRPCServer(chain, 1)
+	updated := *chain[1]
+	redactBlock(&updated, "test")
+	if err := server.replaceBlock(&updated); err != nil || server.snapshot()[1] != &updated {
+		t.Fatalf("replace failed: %v", err)
+	}
+	other := *makeBlockchain(3, 1)[2]
+	other.Hash = []byte("elsewhere")
+	if err := server.replaceBlock(&other); err == nil {
+		t.Error("expected a block off the chain to be refused")
+	}
+}
+
+// TestLoadRetentionPolicy reads a YAML policy over the defaults and
+// rejects invalid ones.
+func TestLoadRetentionPolicy(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "retention.yaml")
+	os.WriteFile(path, []byte(`# full blocks for 30 days, headers forever
+prune_bodies_after: 30d
+archive_url: s3://archive/blocks
+redact_pattern: '\d{3}-\d{2}-\d{4}'
+snapshot_every: 1000
+`), 0o644)
+	policy, err := loadRetentionPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := defaultRetentionPolicy
+	want.PruneBodiesAfter = "30d"
+	want.ArchiveURL = "s3://archive/blocks"
+	want.RedactPattern = `\d{3}-\d{2}-\d{4}`
+	want.SnapshotEvery = 1000
+	if *policy != want {
+		t.Errorf("got %+v, want %+v", *policy, want)
+	}
+
+	bad := filepath.Join(dir, "bad.json")
+	os.WriteFile(bad, []byte(`{"prune_after": "30d"}`), 0o644)
+	if _, err := loadRetentionPolicy(bad); err == nil {
+		t.Error("expected an unknown field to be rejected")
+	}
+	for _, p := range []RetentionPolicy{
+		{PruneBodiesAfter: "a month", SnapshotKeep: 1},
+		{RedactPattern: "(", SnapshotKeep: 1},
+		{ArchiveURL: "ftp://archive", SnapshotKeep: 1},
+		{KeepRecent: -1, SnapshotKeep: 1},
+		{SnapshotEvery: 10},
+	} {
+		if _, err := newRetentionEngine(&p, dir); err == nil {
+			t.Errorf("expected %+v to be rejected", p)
+		}
+	}
+}
+
+// TestRunRetentionCommand applies a policy to a stored chain, after a dry
+// run that leaves it alone.
+func TestRunRetentionCommand(t *testing.T) {
+	dataDir := t.TempDir()
+	if err := writeChainFile(makeBlockchain(5, 1), chainStorePath(dataDir)); err != nil {
+		t.Fatal(err)
+	}
+	policy := filepath.Join(dataDir, "retention.json")
+	os.WriteFile(policy, []byte(`{"redact_pattern": "Block [12]$", "keep_recent": 1}`), 0o644)
+
+	redacted := func() []int {
+		t.Helper()
+		chain, err := readChainFile(chainStorePath(dataDir), DecodePolicy{Strict: true, TrustRedactions: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return validateChainReport(chain, 1).Redacted
+	}
+	if code := runRetentionCommand([]string{"-datadir", dataDir, "-policy", policy, "-dry-run"}); code != exitOK {
+		t.Fatalf("dry run: exit code %d", code)
+	}
+	if got := redacted(); len(got) != 0 {
+		t.Fatalf("dry run redacted %v", got)
+	}
+	if code := runRetentionCommand([]string{"-datadir", dataDir, "-policy", policy}); code != exitOK {
+		t.Fatalf("exit code %d", code)
+	}
+	if got := redacted(); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("redacted %v, want [1 2]", got)
+	}
+	if code := runRetentionCommand([]string{"-datadir", dataDir}); code != exitConfig {
+		t.Errorf("missing policy: exit code %d", code)
+	}
+}
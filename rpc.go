@@ -0,0 +1,978 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// JSON-RPC 2.0 error codes, plus the Bitcoin Core codes used for lookups so
+// that existing client libraries interpret them correctly.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcWrongNetwork   = -32001
+	rpcInvalidParam   = -8
+	rpcNotFound       = -5
+	rpcMiscError      = -1
+)
+
+// networkMagicHeader carries the network magic of a client, in hex.
+const networkMagicHeader = "X-Network-Magic"
+
+// rpcMaxBodyBytes bounds the size of a single HTTP request body.
+const rpcMaxBodyBytes = 4 << 20
+
+// maxHeadersPerCall bounds the headers one getheaders call returns, as
+// Bitcoin's headers message does.
+const maxHeadersPerCall = 2000
+
+// rpcHashCacheEntries bounds the cache of block hashes that submitblock
+// reuses when checking links to the tip.
+const rpcHashCacheEntries = 1024
+
+type rpcRequest struct {
+	JSONRPC string            `json:"jsonrpc"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+	ID      json.RawMessage   `json:"id"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  any             `json:"result"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// rpcBlock is the getblock view of a block. Field names follow Bitcoin Core
+// so explorers can display it; hashes are hex rather than base64.
+type rpcBlock struct {
+	Hash              string `json:"hash"`
+	Height            int    `json:"height"`
+	Confirmations     int    `json:"confirmations"`
+	Time              int64  `json:"time"`
+	Nonce             uint64 `json:"nonce"`
+	ExtraNonce        uint64 `json:"extranonce,omitempty"`
+	Bits              string `json:"bits,omitempty"`
+	MerkleRoot        string `json:"merkleroot,omitempty"`
+	Data              string `json:"data"`
+	PreviousBlockHash string `json:"previousblockhash,omitempty"`
+	NextBlockHash     string `json:"nextblockhash,omitempty"`
+
+	Epoch *rpcEpochSummary `json:"epoch,omitempty"`
+	// Redacted blocks have no data; Data is empty and the reason is given
+	Redacted        bool   `json:"redacted,omitempty"`
+	RedactionReason string `json:"redactionreason,omitempty"`
+	// ChainWork is the cumulative work up to the block, in hex, for
+	// blocks on the server's chain
+	ChainWork string `json:"chainwork,omitempty"`
+}
+
+// rpcEpochSummary is the getblock view of an epoch summary.
+type rpcEpochSummary struct {
+	Epoch     int    `json:"epoch"`
+	Hash      string `json:"hash"`
+	StartTime int64  `json:"starttime"`
+	EndTime   int64  `json:"endtime"`
+	DataBytes int64  `json:"databytes"`
+	// ValidatorsHash is set on proof-of-stake chains
+	ValidatorsHash string `json:"validatorshash,omitempty"`
+}
+
+// rpcServer serves a chain over JSON-RPC 2.0 (POST /). Params are positional.
+// Event notifications are available as a WebSocket stream on /ws.
+// Every HTTP request gets a request ID, taken from the X-Request-Id header
+// when the caller sets one, which is echoed back and attached to all log
+// lines and events it causes.
+type rpcServer struct {
+	mu         sync.RWMutex
+	chain      []*Block
+	totalWork  []*big.Int // cumulative work of chain[:i+1]
+	difficulty int
+	// engine, when set, validates submitted blocks in place of
+	// proof-of-work at difficulty; see consensus
+	engine Engine
+	bus    *EventBus
+	logger *slog.Logger
+
+	// tipChanged is closed and replaced whenever a block is appended
+	tipChanged chan struct{}
+	miner      minerStats
+	hashes     *HashCache
+	// magic, when set, is the network magic of the node; requests that
+	// name another network are rejected
+	magic uint32
+	// mining is the node's miner, if it runs one
+	mining *Miner
+	// quotas, when set, requires an API key on every request and counts
+	// it against its tenant's quotas
+	quotas *quotaTracker
+	// pruneDepth, when set, is how many recent blocks keep their bodies;
+	// bodies below pruneHeight, except the genesis block's, are discarded
+	pruneDepth  int
+	pruneHeight int
+	// peers, when set, scores clients by their misbehavior and refuses
+	// those it has banned
+	peers *PeerManager
+	// side holds blocks of side branches by hex hash; see reorg.go
+	side   map[string]*Block
+	reorgs atomic.Uint64
+	// maxReorgDepth, when set, is the most blocks a reorg may remove;
+	// deeper ones are refused with a ReorgRefused alert
+	maxReorgDepth int
+	// wsOrigins are the origins, besides the node's own, of the web
+	// pages allowed to open WebSocket subscriptions
+	wsOrigins []string
+	// identity, when set, is the node's identity key, with which it
+	// answers handshakes
+	identity *Wallet
+	// limiter, when set, refuses clients and API keys sending faster than
+	// their rate
+	limiter *rateLimiter
+	// shutdown, when set, stops the node; the stop RPC calls it
+	shutdown func()
+}
+
+func newRPCServer(chain []*Block, difficulty int) *rpcServer {
+	return &rpcServer{
+		chain:      chain,
+		totalWork:  cumulativeWork(chain, difficulty),
+		difficulty: difficulty,
+		bus:        NewEventBus(),
+		logger:     slog.New(slog.DiscardHandler),
+		tipChanged: make(chan struct{}),
+		hashes:     NewHashCache(rpcHashCacheEntries),
+	}
+}
+
+func (s *rpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get(requestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	w.Header().Set(requestIDHeader, id)
+	ctx := withRequestID(r.Context(), id)
+
+	peer := remoteHost(r)
+	var tenant *Tenant
+	if s.quotas != nil {
+		var err error
+		if tenant, err = s.quotas.authenticate(r); err != nil {
+			s.logger.LogAttrs(ctx, slog.LevelWarn, "request_refused", slog.String("request_id", id), slog.Any("error", err))
+			refuse(w, r, err)
+			return
+		}
+	}
+	// An admin is let through a ban of its address, so that it can lift it
+	if s.peers != nil && s.peers.Banned(peer) && (tenant == nil || !tenant.Admin) {
+		s.logger.LogAttrs(ctx, slog.LevelDebug, "banned_peer_refused", slog.String("request_id", id), slog.String("peer", peer))
+		http.Error(w, "banned", http.StatusForbidden)
+		return
+	}
+	ctx = withPeer(ctx, peer)
+
+	if err := s.checkNetwork(r); err != nil {
+		s.logger.LogAttrs(ctx, slog.LevelWarn, "wrong_network", slog.String("request_id", id), slog.Any("error", err))
+		s.misbehaving(ctx, penaltyProtocol, "wrong network")
+		if r.URL.Path == "/" {
+			writeRPC(w, rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: rpcWrongNetwork, Message: err.Error()}, ID: json.RawMessage("null")})
+		} else {
+			writeRESTError(w, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+
+	if s.quotas != nil {
+		var err error
+		if r.URL.Path != "/" {
+			// JSON-RPC calls are counted one by one, so a batch cannot
+			// get around the request quota or rate limit
+			if err = s.limiter.take(peer, tenant); err == nil {
+				err = s.quotas.request(tenant)
+			}
+		}
+		if err != nil {
+			s.logger.LogAttrs(ctx, slog.LevelWarn, "request_refused", slog.String("request_id", id), slog.Any("error", err))
+			refuse(w, r, err)
+			return
+		}
+		ctx = withTenant(ctx, tenant)
+		cw := &countingWriter{ResponseWriter: w}
+		defer func() { s.quotas.sent(tenant, cw.n) }()
+		w = cw
+	} else if r.URL.Path != "/" {
+		if err := s.limiter.take(peer, nil); err != nil {
+			s.logger.LogAttrs(ctx, slog.LevelWarn, "request_refused", slog.String("request_id", id), slog.Any("error", err))
+			refuse(w, r, err)
+			return
+		}
+	}
+
+	if isGRPC(r) {
+		if !featureEnabled(FeatureGRPC) {
+			writeRESTError(w, http.StatusNotFound, "the experimental grpc feature is not enabled")
+			return
+		}
+		s.serveGRPC(w, r.WithContext(ctx))
+		return
+	}
+	if r.URL.Path == "/ws" {
+		s.serveWS(w, r.WithContext(ctx))
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/proof/") {
+		s.serveProof(w, r.WithContext(ctx))
+		return
+	}
+	if r.URL.Path == "/peers" {
+		s.servePeers(w, r.WithContext(ctx))
+		return
+	}
+	if r.URL.Path == "/status" {
+		s.serveStatus(w, r.WithContext(ctx))
+		return
+	}
+	if r.URL.Path == "/explorer" || strings.HasPrefix(r.URL.Path, "/explorer/") {
+		s.serveExplorer(w, r.WithContext(ctx))
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "JSON-RPC requests must use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body json.RawMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, rpcMaxBodyBytes)).Decode(&body); err != nil {
+		s.misbehaving(ctx, penaltyProtocol, "unparsable request")
+		writeRPC(w, rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: rpcParseError, Message: err.Error()}, ID: json.RawMessage("null")})
+		return
+	}
+
+	// A batch is an array of requests answered by an array of responses
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil || len(batch) == 0 {
+			writeRPC(w, rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: rpcInvalidRequest, Message: "invalid batch"}, ID: json.RawMessage("null")})
+			return
+		}
+		responses := make([]rpcResponse, 0, len(batch))
+		for _, msg := range batch {
+			if resp, ok := s.handle(ctx, msg); ok {
+				responses = append(responses, resp)
+			}
+		}
+		if len(responses) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeRPC(w, responses)
+		return
+	}
+
+	resp, ok := s.handle(ctx, body)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeRPC(w, resp)
+}
+
+// checkNetwork rejects requests whose X-Network-Magic header names another
+// network than the node's, so that clients and blocks of one network are
+// never mixed with another. Requests without the header are accepted.
+func (s *rpcServer) checkNetwork(r *http.Request) error {
+	h := r.Header.Get(networkMagicHeader)
+	if s.magic == 0 || h == "" {
+		return nil
+	}
+	magic, err := strconv.ParseUint(h, 16, 32)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q", networkMagicHeader, h)
+	}
+	if uint32(magic) != s.magic {
+		return fmt.Errorf("wrong network: request is for magic %08x, this node runs %08x", magic, s.magic)
+	}
+	return nil
+}
+
+func writeRPC(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// handle runs a single request. It reports false for notifications, which
+// get no response.
+func (s *rpcServer) handle(ctx context.Context, msg json.RawMessage) (rpcResponse, bool) {
+	var req rpcRequest
+	if err := json.Unmarshal(msg, &req); err != nil || req.Method == "" {
+		return rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: rpcInvalidRequest, Message: "invalid request"}, ID: json.RawMessage("null")}, true
+	}
+	// Bitcoin tooling still sends "1.0" requests; answer them the same way
+	if req.JSONRPC != "2.0" && req.JSONRPC != "1.0" && req.JSONRPC != "" {
+		return rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: rpcInvalidRequest, Message: "unsupported jsonrpc version"}, ID: req.ID}, true
+	}
+
+	start := time.Now()
+	var result any
+	var err error
+	t := tenantFrom(ctx)
+	if lerr := s.limiter.take(peerFrom(ctx), t); lerr != nil {
+		err = quotaRPCError(lerr)
+	} else if t != nil {
+		if qerr := s.quotas.request(t); qerr != nil {
+			err = quotaRPCError(qerr)
+		}
+	}
+	if err == nil {
+		result, err = s.call(ctx, req.Method, req.Params)
+	}
+	s.logCall(ctx, req.Method, time.Since(start), err)
+	if req.ID == nil {
+		return rpcResponse{}, false
+	}
+
+	resp := rpcResponse{JSONRPC: "2.0", Result: result, ID: req.ID}
+	if err != nil {
+		resp.Result = nil
+		var rerr *rpcError
+		if !errors.As(err, &rerr) {
+			rerr = &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+		resp.Error = rerr
+	}
+	return resp, true
+}
+
+func (s *rpcServer) call(ctx context.Context, method string, params []json.RawMessage) (any, error) {
+	if err := authorize(ctx, method); err != nil {
+		return nil, err
+	}
+	switch method {
+	case "getblockcount":
+		if err := rpcArgs(params); err != nil {
+			return nil, err
+		}
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return len(s.chain) - 1, nil
+
+	case "getblockhash":
+		var height int
+		if err := rpcArgs(params, &height); err != nil {
+			return nil, err
+		}
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		if height < 0 || height >= len(s.chain) {
+			return nil, &rpcError{Code: rpcInvalidParam, Message: "Block height out of range"}
+		}
+		return hex.EncodeToString(s.chain[height].Hash), nil
+
+	case "getheaders":
+		// Headers are returned in the chain file encoding, ready for
+		// validateHeaders; bodies are fetched afterwards with getblock
+		var height, count int
+		if err := rpcArgs(params, &height, &count); err != nil {
+			return nil, err
+		}
+		if count < 1 || count > maxHeadersPerCall {
+			return nil, &rpcError{Code: rpcInvalidParam, Message: fmt.Sprintf("count must be between 1 and %d", maxHeadersPerCall)}
+		}
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		if height < 0 || height >= len(s.chain) {
+			return nil, &rpcError{Code: rpcInvalidParam, Message: "Block height out of range"}
+		}
+		headers := make([]*BlockHeader, 0, min(count, len(s.chain)-height))
+		for _, block := range s.chain[height:min(height+count, len(s.chain))] {
+			headers = append(headers, block.Header())
+		}
+		return headers, nil
+
+	case "getblock":
+		// getblock "hash" [verbosity], as in Bitcoin Core: verbosity 0
+		// returns the block's protobuf encoding in hex, 1 the view
+		var hash string
+		verbosity := 1
+		var err error
+		if len(params) == 2 {
+			err = rpcArgs(params, &hash, &verbosity)
+		} else {
+			err = rpcArgs(params, &hash)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if verbosity != 0 && verbosity != 1 {
+			return nil, &rpcError{Code: rpcInvalidParam, Message: "verbosity must be 0 or 1"}
+		}
+		want, err := hex.DecodeString(hash)
+		if err != nil {
+			return nil, &rpcError{Code: rpcInvalidParam, Message: "blockhash must be hexadecimal"}
+		}
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		for _, block := range s.chain {
+			if bytes.Equal(block.Hash, want) {
+				if s.pruned(block.Index) {
+					// The header is still available from getheaders
+					return nil, &rpcError{Code: rpcMiscError, Message: "Block not available (pruned data)"}
+				}
+				if verbosity == 0 {
+					return hex.EncodeToString(block.MarshalProto()), nil
+				}
+				return s.blockView(block), nil
+			}
+		}
+		return nil, &rpcError{Code: rpcNotFound, Message: "Block not found"}
+
+	case "getblockchaininfo":
+		if err := rpcArgs(params); err != nil {
+			return nil, err
+		}
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		info := map[string]any{
+			"blocks":        len(s.chain) - 1,
+			"bestblockhash": hex.EncodeToString(s.chain[len(s.chain)-1].Hash),
+			"difficulty":    float64(s.difficulty),
+			"chainwork":     hexWork(s.totalWork[len(s.totalWork)-1]),
+			"pruned":        s.pruneDepth > 0 || s.pruneHeight > 0,
+		}
+		if s.pruneDepth > 0 || s.pruneHeight > 0 {
+			info["pruneheight"] = s.pruneHeight
+		}
+		return info, nil
+
+	case "getsnapshothash":
+		// The canonical digest of the stored state at a height, for
+		// comparing nodes; see snapshotHash
+		var height int
+		if err := rpcArgs(params, &height); err != nil {
+			return nil, err
+		}
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		if height < 0 || height >= len(s.chain) {
+			return nil, &rpcError{Code: rpcInvalidParam, Message: "Block height out of range"}
+		}
+		return newRPCSnapshot(s.chain, height)
+
+	case "getdifficulty":
+		if err := rpcArgs(params); err != nil {
+			return nil, err
+		}
+		return float64(s.difficulty), nil
+
+	case "getmininginfo":
+		if err := rpcArgs(params); err != nil {
+			return nil, err
+		}
+		s.mu.RLock()
+		height := len(s.chain) - 1
+		s.mu.RUnlock()
+		return map[string]any{
+			"blocks":       height,
+			"difficulty":   float64(s.difficulty),
+			"minedblocks":  s.miner.blocks.Load(),
+			"hashes":       s.miner.hashes.Load(),
+			"stalework":    s.miner.stale.Load(),
+			"stalehashes":  s.miner.staleHashes.Load(),
+			"hashespersec": math.Float64frombits(s.miner.hashRate.Load()),
+			"generate":     s.mining != nil && !s.mining.Paused(),
+		}, nil
+
+	case "setgenerate":
+		// Pauses or resumes the node's miner
+		var generate bool
+		if err := rpcArgs(params, &generate); err != nil {
+			return nil, err
+		}
+		if s.mining == nil {
+			return nil, &rpcError{Code: rpcInvalidRequest, Message: "this node does not mine"}
+		}
+		if generate {
+			s.mining.Resume()
+		} else {
+			s.mining.Pause()
+		}
+		return nil, nil
+
+	case "getusage":
+		// Tenants read their own usage; admins may name another tenant
+		tenant := tenantFrom(ctx)
+		if tenant == nil {
+			return nil, &rpcError{Code: rpcInvalidRequest, Message: "this node has no quotas"}
+		}
+		name := tenant.Name
+		if len(params) > 0 {
+			if err := rpcArgs(params, &name); err != nil {
+				return nil, err
+			}
+		}
+		if name != tenant.Name && !tenant.Admin {
+			return nil, &rpcError{Code: rpcUnauthorized, Message: "only admin tenants can read the usage of others"}
+		}
+		view, ok := s.quotas.view(name)
+		if !ok {
+			return nil, &rpcError{Code: rpcNotFound, Message: "Tenant not found"}
+		}
+		return view, nil
+
+	case "parsepaymenturi":
+		var uri string
+		if err := rpcArgs(params, &uri); err != nil {
+			return nil, err
+		}
+		req, err := parsePaymentURI(uri)
+		if err != nil {
+			return nil, &rpcError{Code: rpcInvalidParam, Message: err.Error()}
+		}
+		result := map[string]any{"address": req.Address}
+		if req.Amount > 0 {
+			result["amount"] = formatAmount(req.Amount)
+		}
+		if req.Memo != "" {
+			result["memo"] = req.Memo
+		}
+		return result, nil
+
+	case "handshake":
+		return s.callHandshake(params)
+
+	case "getpeerinfo", "listbanned", "setban":
+		return s.callPeers(ctx, method, params)
+
+	case "stop":
+		return s.callStop(ctx, params)
+
+	case "submitblock":
+		if len(params) != 1 {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: "expected 1 parameter"}
+		}
+		return s.submitBlock(ctx, params[0]), nil
+	}
+	return nil, &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("method %q not found", method)}
+}
+
+func (s *rpcServer) logCall(ctx context.Context, method string, elapsed time.Duration, err error) {
+	attrs := []slog.Attr{
+		slog.String("request_id", requestID(ctx)),
+		slog.String("method", method),
+		slog.Duration("duration", elapsed),
+	}
+	if err != nil {
+		s.logger.LogAttrs(ctx, slog.LevelWarn, "rpc_call_failed", append(attrs, slog.Any("error", err))...)
+		return
+	}
+	s.logger.LogAttrs(ctx, slog.LevelInfo, "rpc_call", attrs...)
+}
+
+// rpcArgs decodes positional params into dst, requiring an exact count.
+func rpcArgs(params []json.RawMessage, dst ...any) error {
+	if len(params) != len(dst) {
+		return &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("expected %d parameters, got %d", len(dst), len(params))}
+	}
+	for i, p := range params {
+		if err := json.Unmarshal(p, dst[i]); err != nil {
+			return &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("parameter %d: %v", i+1, err)}
+		}
+	}
+	return nil
+}
+
+// blockView builds the getblock result; the caller must hold s.mu.
+func (s *rpcServer) blockView(block *Block) rpcBlock {
+	view := blockView(s.chain, block)
+	if h := block.Index; h < len(s.chain) && s.chain[h] == block {
+		view.ChainWork = hexWork(s.totalWork[h])
+	}
+	return view
+}
+
+// blockView builds the getblock view of a block in chain.
+func blockView(chain []*Block, block *Block) rpcBlock {
+	view := rpcBlock{
+		Hash:          hex.EncodeToString(block.Hash),
+		Height:        block.Index,
+		Confirmations: len(chain) - block.Index,
+		Time:          block.Timestamp,
+		Nonce:         block.Nonce,
+		ExtraNonce:    block.ExtraNonce,
+		Data:          string(block.Data),
+	}
+	if block.Bits != 0 {
+		view.Bits = fmt.Sprintf("%08x", block.Bits)
+	}
+	if block.MerkleRoot != nil {
+		view.MerkleRoot = hex.EncodeToString(block.MerkleRoot)
+	}
+	if block.Index > 0 {
+		view.PreviousBlockHash = hex.EncodeToString(block.PrevHash)
+	}
+	if block.Index+1 < len(chain) {
+		view.NextBlockHash = hex.EncodeToString(chain[block.Index+1].Hash)
+	}
+	if block.Redaction != nil {
+		view.Redacted = true
+		view.RedactionReason = block.Redaction.Reason
+	}
+	if e := block.Epoch; e != nil {
+		view.Epoch = &rpcEpochSummary{
+			Epoch:     e.Epoch,
+			Hash:      hex.EncodeToString(e.Hash),
+			StartTime: e.StartTime,
+			EndTime:   e.EndTime,
+			DataBytes: e.DataBytes,
+		}
+		if len(e.ValidatorsHash) != 0 {
+			view.Epoch.ValidatorsHash = hex.EncodeToString(e.ValidatorsHash)
+		}
+	}
+	return view
+}
+
+// submitBlock appends a block, given in the chain export JSON format, to the
+// tip. Like Bitcoin Core it returns nil on success and a BIP 22 reason
+// string on rejection.
+func (s *rpcServer) submitBlock(ctx context.Context, msg json.RawMessage) any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	block, err := decodeBlockJSON(msg, len(s.chain), DecodePolicy{Strict: true})
+	if reason := s.submit(ctx, block, err); reason != "" {
+		return reason
+	}
+	return nil
+}
+
+// submit appends a block a client submitted, or reports why not, given
+// the error of decoding it. It returns "" or the BIP 22 reason and counts
+// invalid blocks against the client. The caller must hold s.mu.
+func (s *rpcServer) submit(ctx context.Context, block *Block, err error) string {
+	height := len(s.chain)
+	var reason string
+	if errors.Is(err, ErrBlockTooLarge) {
+		reason = "bad-blk-length"
+	} else if err != nil {
+		reason = "rejected: " + err.Error()
+	} else {
+		reason = s.extend(block)
+	}
+	s.announce(ctx, height, reason)
+	switch reason {
+	case "", "duplicate", "bad-prevblk", "bad-height", "inconclusive", "reorg-too-deep":
+		// An honest peer can lose a race for the tip
+	default:
+		s.misbehaving(ctx, penaltyInvalidBlock, reason)
+	}
+	return reason
+}
+
+// misbehaving adds points to the score of the peer a request came from.
+func (s *rpcServer) misbehaving(ctx context.Context, points int, reason string) {
+	peer := peerFrom(ctx)
+	if s.peers == nil || peer == "" {
+		return
+	}
+	banned, err := s.peers.Misbehaving(peer, points, reason)
+	switch {
+	case err != nil:
+		s.logger.LogAttrs(ctx, slog.LevelError, "ban_list_save_failed", slog.String("peer", peer), slog.Any("error", err))
+	case banned:
+		s.logger.LogAttrs(ctx, slog.LevelWarn, "peer_banned", slog.String("request_id", requestID(ctx)), slog.String("peer", peer), slog.String("reason", reason))
+	default:
+		s.logger.LogAttrs(ctx, slog.LevelInfo, "peer_misbehaving", slog.String("request_id", requestID(ctx)), slog.String("peer", peer), slog.Int("points", points), slog.String("reason", reason))
+	}
+}
+
+// addBlock appends a block mined by this node, returning the rejection
+// reason or "" on success. A block mined on a tip that has since changed
+// is rejected with "bad-prevblk".
+func (s *rpcServer) addBlock(ctx context.Context, block *Block) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	height := len(s.chain)
+	s.bus.Publish(BlockMined{Block: block})
+	reason := s.extend(block)
+	s.announce(ctx, height, reason)
+	return reason
+}
+
+// announce logs the outcome of adding a block at height and publishes it
+// on the event bus. The caller must hold s.mu.
+func (s *rpcServer) announce(ctx context.Context, height int, reason string) {
+	id := requestID(ctx)
+	switch reason {
+	case "":
+		// After a reorg the block may be below height; it is the tip
+		block := s.chain[len(s.chain)-1]
+		s.logger.LogAttrs(ctx, slog.LevelInfo, "block_accepted",
+			slog.String("request_id", id), slog.Int("height", block.Index), slog.String("hash", hex.EncodeToString(block.Hash)))
+		s.bus.Publish(BlockAccepted{Block: block, RequestID: id})
+	case "duplicate", "inconclusive":
+	default:
+		s.logger.LogAttrs(ctx, slog.LevelWarn, "validation_failed",
+			slog.String("request_id", id), slog.Int("height", height), slog.String("reason", reason))
+		s.bus.Publish(ValidationFailed{Height: height, Reason: reason, RequestID: id})
+	}
+}
+
+// extend validates a block against the tip and appends it, returning the
+// rejection reason or "" on success. A block building below the tip goes
+// to extendSide. The caller must hold s.mu.
+func (s *rpcServer) extend(block *Block) string {
+	tip := s.chain[len(s.chain)-1]
+	if block.Index < len(s.chain) && bytes.Equal(block.Hash, s.chain[block.Index].Hash) {
+		return "duplicate"
+	}
+	if !bytes.Equal(block.PrevHash, tip.Hash) && s.sideParent(block) != nil {
+		return s.extendSide(block)
+	}
+	if reason := s.validateNext(s.chain, block); reason != "" {
+		return reason
+	}
+	s.chain = append(s.chain, block)
+	s.totalWork = append(s.totalWork, new(big.Int).Add(s.totalWork[len(s.totalWork)-1], s.blockWork(block)))
+	close(s.tipChanged)
+	s.tipChanged = make(chan struct{})
+	return ""
+}
+
+// validateNext validates block as the successor of chain, returning the
+// BIP 22 rejection reason or "".
+func (s *rpcServer) validateNext(chain []*Block, block *Block) string {
+	return rejectReason(s.checkNext(chain, block))
+}
+
+// checkNext checks block as the successor of chain, as every block offered
+// to the server is checked whether it comes alone or in a batch: its
+// height, a timestamp no earlier than its parent's, then the engine's
+// validation. The error is a *BlockValidationError.
+func (s *rpcServer) checkNext(chain []*Block, block *Block) error {
+	prev := chain[len(chain)-1]
+	if block.Index != prev.Index+1 {
+		return &BlockValidationError{Index: block.Index, Err: fmt.Errorf("%w: block %d follows block %d", ErrBadHeight, block.Index, prev.Index)}
+	}
+	if block.Timestamp < prev.Timestamp {
+		return &BlockValidationError{Index: block.Index, Err: fmt.Errorf("%w: %d is before %d", ErrTimeTooOld, block.Timestamp, prev.Timestamp)}
+	}
+	err := validateBlockPairWith(prev, block, s.consensus(), s.hashes)
+	if err == nil {
+		err = checkEpochSummary(chain, block)
+	}
+	return err
+}
+
+// rejectReason returns the BIP 22 rejection reason for a validation
+// error, or "" for nil.
+func rejectReason(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrBrokenLink):
+		return "bad-prevblk"
+	case errors.Is(err, ErrBadHeight):
+		return "bad-height"
+	case errors.Is(err, ErrTimeTooOld):
+		return "time-too-old"
+	case errors.Is(err, ErrHashMismatch):
+		return "bad-hash"
+	case errors.Is(err, ErrInsufficientWork):
+		return "high-hash"
+	case errors.Is(err, ErrDifficultyBits):
+		return "bad-diffbits"
+	case errors.Is(err, ErrCheckpointMismatch):
+		return "checkpoint-mismatch"
+	case errors.Is(err, ErrCheckpointFork):
+		return "bad-fork-prior-to-checkpoint"
+	case errors.Is(err, ErrEpochSummary):
+		return "bad-epoch-summary"
+	case errors.Is(err, ErrBlockTooLarge):
+		return "bad-blk-length"
+	}
+	return "rejected: " + err.Error()
+}
+
+// consensus returns the engine validating blocks offered to the server:
+// the chain's, or proof-of-work at the server's difficulty.
+func (s *rpcServer) consensus() Engine {
+	if s.engine != nil {
+		return s.engine
+	}
+	return &PoWEngine{Difficulty: s.difficulty}
+}
+
+// useEngine makes engine validate the blocks offered to the server and
+// recounts the chain's work by its blocks' targets under it.
+func (s *rpcServer) useEngine(engine Engine) {
+	s.engine = engine
+	s.totalWork = cumulativeWork(s.chain, engine.MinDifficulty())
+}
+
+// blockWork returns the work block adds to a chain: that of the target it
+// commits to, or of the engine's least difficulty for blocks without one,
+// as cumulativeWork counts the chain. The caller must hold s.mu.
+func (s *rpcServer) blockWork(block *Block) *big.Int {
+	return blockWork(block, s.consensus().MinDifficulty())
+}
+
+// TotalWork returns the cumulative work of the chain: the expected number
+// of hashes needed to mine it, which decides between competing branches.
+func (s *rpcServer) TotalWork() *big.Int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return new(big.Int).Set(s.totalWork[len(s.totalWork)-1])
+}
+
+// hexWork formats work as Bitcoin Core's chainwork: 64 hex digits.
+func hexWork(work *big.Int) string {
+	return fmt.Sprintf("%064x", work)
+}
+
+// tip returns the last block of the chain.
+func (s *rpcServer) tip() *Block {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.chain[len(s.chain)-1]
+}
+
+// replaceBlock swaps a block of the chain for an updated copy with the
+// same hash, such as one with its data redacted. It fails if the chain no
+// longer holds the block at that height.
+func (s *rpcServer) replaceBlock(block *Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if block.Index >= len(s.chain) || !bytes.Equal(s.chain[block.Index].Hash, block.Hash) {
+		return fmt.Errorf("block %d is no longer on the chain", block.Index)
+	}
+	s.chain[block.Index] = block
+	return nil
+}
+
+// snapshot returns a copy of the chain that stays consistent while new
+// blocks are appended.
+func (s *rpcServer) snapshot() []*Block {
+	chain, _ := s.work()
+	return chain
+}
+
+// work returns a copy of the chain together with a channel that is closed
+// once its tip is replaced, so miners can tell when their work goes stale.
+func (s *rpcServer) work() ([]*Block, <-chan struct{}) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*Block(nil), s.chain...), s.tipChanged
+}
+
+// runServe implements the serve subcommand, which loads a chain exported
+// with -output and serves it over JSON-RPC.
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	file := fs.String("file", "", "chain file to serve: .json, .jsonl or .pb, optionally .gz (required)")
+	addr := fs.String("addr", "127.0.0.1:7544", "address to listen on")
+	difficulty := fs.Int("difficulty", 4, "proof-of-work difficulty of the chain")
+	maxReorgDepth := fs.Int("max-reorg-depth", 0, "refuse reorgs removing more blocks than this, with a critical alert (0 allows any)")
+	wsOrigins := fs.String("ws-origin", "", "comma-separated origins of web pages, besides the node's own, allowed to subscribe over WebSocket")
+	grpcAddr := fs.String("grpc-addr", "", "address to serve the gRPC BlockchainService on, over HTTP/2")
+	network, paramsPath := addChainFlags(fs)
+	logOpts := addLogFlags(fs)
+	policy := addDecodeLimitFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return flagError(err)
+	}
+	if *file == "" {
+		return usage("Usage: blockchain serve -file chain.json [-addr host:port] [-difficulty n] [-max-reorg-depth n] [-ws-origin origins] [-grpc-addr host:port] [-network name] [-params file] [-features names]")
+	}
+	if *maxReorgDepth < 0 {
+		return failf(exitConfig, "max-reorg-depth must not be negative")
+	}
+	origins, err := parseOrigins(*wsOrigins)
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+	if err := policy.checkLimits(); err != nil {
+		return failCode(exitConfig, err)
+	}
+	params, engine, err := chainEngineFlags(fs, *network, *paramsPath)
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+	*difficulty = engine.MinDifficulty()
+	if *grpcAddr != "" {
+		if err := needFeature(FeatureGRPC, "-grpc-addr"); err != nil {
+			return failCode(exitConfig, err)
+		}
+	}
+
+	logger, err := logOpts.newLogger(os.Stderr)
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+
+	policy.Warn = func(w DecodeWarning) {
+		logger.Warn("decode_warning", slog.Int("index", w.Index), slog.String("problem", w.Message))
+	}
+	chain, err := importChain(*file, engine, *policy)
+	if err != nil {
+		logger.Error("chain_load_failed", slog.String("path", *file), slog.Any("error", err))
+		return failReported(err)
+	}
+
+	server := newRPCServer(chain, *difficulty)
+	server.useEngine(engine)
+	server.logger = logger
+	server.magic = params.NetworkMagic
+	server.maxReorgDepth = *maxReorgDepth
+	server.wsOrigins = origins
+
+	if *grpcAddr != "" {
+		ln, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			logger.Error("listen_failed", slog.String("addr", *grpcAddr), slog.Any("error", err))
+			return failReported(err)
+		}
+		logger.Info("grpc_started", slog.String("addr", ln.Addr().String()))
+		go func() {
+			if err := serveGRPCListener(context.Background(), ln, server, nil); err != nil {
+				logger.Error("grpc_failed", slog.Any("error", err))
+			}
+		}()
+	}
+	logger.Info("server_started", slog.String("addr", *addr), slog.Int("blocks", len(chain)))
+	if err := http.ListenAndServe(*addr, server); err != nil {
+		logger.Error("server_failed", slog.Any("error", err))
+		return failReported(err)
+	}
+	return 0
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// rpcPost sends body to the server and decodes the response into v.
+func rpcPost(t *testing.T, s *rpcServer, body string, v any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("decode response %q: %v", rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+// TestRPC_Queries covers the read-only methods.
+func TestRPC_Queries(t *testing.T) {
+	chain := makeBlockchain(3, 1)
+	s := newRPCServer(chain, 1)
+
+	var count struct {
+		Result int
+		Error  *rpcError
+	}
+	rpcPost(t, s, `{"jsonrpc":"2.0","method":"getblockcount","id":1}`, &count)
+	if count.Error != nil || count.Result != 2 {
+		t.Fatalf("getblockcount: got %d, %v", count.Result, count.Error)
+	}
+
+	var hash struct{ Result string }
+	rpcPost(t, s, `{"jsonrpc":"2.0","method":"getblockhash","params":[1],"id":2}`, &hash)
+	if hash.Result != hex.EncodeToString(chain[1].Hash) {
+		t.Fatalf("getblockhash: got %q", hash.Result)
+	}
+
+	var block struct{ Result rpcBlock }
+	rpcPost(t, s, `{"jsonrpc":"2.0","method":"getblock","params":["`+hash.Result+`"],"id":3}`, &block)
+	if block.Result.Height != 1 || block.Result.Data != "Block 1" || block.Result.Confirmations != 2 {
+		t.Errorf("getblock: unexpected result %+v", block.Result)
+	}
+	if block.Result.NextBlockHash != hex.EncodeToString(chain[2].Hash) {
+		t.Errorf("getblock: wrong next block hash %q", block.Result.NextBlockHash)
+	}
+
+	var missing struct{ Error *rpcError }
+	rpcPost(t, s, `{"jsonrpc":"2.0","method":"getblockhash","params":[7],"id":4}`, &missing)
+	if missing.Error == nil || missing.Error.Code != rpcInvalidParam {
+		t.Errorf("expected out of range error, got %v", missing.Error)
+	}
+}
+
+// TestRPC_SubmitBlock checks that valid blocks extend the chain and invalid
+// ones are rejected with a reason.
+func TestRPC_SubmitBlock(t *testing.T) {
+	chain := makeBlockchain(2, 1)
+	s := newRPCServer(chain, 1)
+
+	next, err := generateBlock(context.Background(), chain[1], "submitted", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := json.Marshal(next)
+
+	var resp struct {
+		Result *string
+		Error  *rpcError
+	}
+	rpcPost(t, s, `{"jsonrpc":"2.0","method":"submitblock","params":[`+string(raw)+`],"id":1}`, &resp)
+	if resp.Error != nil || resp.Result != nil {
+		t.Fatalf("valid block rejected: %v %v", resp.Result, resp.Error)
+	}
+	if len(s.chain) != 3 {
+		t.Fatalf("expected chain of 3 blocks, got %d", len(s.chain))
+	}
+
+	rpcPost(t, s, `{"jsonrpc":"2.0","method":"submitblock","params":[`+string(raw)+`],"id":2}`, &resp)
+	if resp.Result == nil || *resp.Result != "duplicate" {
+		t.Errorf("expected duplicate, got %v", resp.Result)
+	}
+
+	forged := *next
+	forged.Index = 3
+	forged.PrevHash = chain[0].Hash
+	forged.Hash = calculateHash(&forged)
+	raw, _ = json.Marshal(&forged)
+	rpcPost(t, s, `{"jsonrpc":"2.0","method":"submitblock","params":[`+string(raw)+`],"id":3}`, &resp)
+	if resp.Result == nil || *resp.Result != "bad-prevblk" {
+		t.Errorf("expected bad-prevblk, got %v", resp.Result)
+	}
+}
+
+// TestRPC_Protocol covers errors, notifications and batches.
+func TestRPC_Protocol(t *testing.T) {
+	s := newRPCServer(makeBlockchain(1, 1), 1)
+
+	var unknown struct{ Error *rpcError }
+	rpcPost(t, s, `{"jsonrpc":"2.0","method":"getpeers","id":1}`, &unknown)
+	if unknown.Error == nil || unknown.Error.Code != rpcMethodNotFound {
+		t.Errorf("expected method not found, got %v", unknown.Error)
+	}
+
+	var parse struct{ Error *rpcError }
+	rpcPost(t, s, `{"jsonrpc":`, &parse)
+	if parse.Error == nil || parse.Error.Code != rpcParseError {
+		t.Errorf("expected parse error, got %v", parse.Error)
+	}
+
+	if code := rpcPost(t, s, `{"jsonrpc":"2.0","method":"getblockcount"}`, nil); code != http.StatusNoContent {
+		t.Errorf("notification should get no content, got status %d", code)
+	}
+
+	var batch []struct {
+		Result any
+		ID     int
+	}
+	rpcPost(t, s, `[{"jsonrpc":"2.0","method":"getblockcount","id":1},
+		{"jsonrpc":"2.0","method":"getdifficulty","id":2},
+		{"jsonrpc":"2.0","method":"getblockcount"}]`, &batch)
+	if len(batch) != 2 || batch[0].ID != 1 || batch[1].Result != 1.0 {
+		t.Errorf("unexpected batch response %+v", batch)
+	}
+}
+
+// TestRPC_RequestIDs checks that request IDs are echoed back and attached
+// to the log lines of the request.
+func TestRPC_RequestIDs(t *testing.T) {
+	chain := makeBlockchain(2, 1)
+	s := newRPCServer(chain, 1)
+	var logs bytes.Buffer
+	s.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	post := func(id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		if id != "" {
+			req.Header.Set(requestIDHeader, id)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post("trace-42", `{"jsonrpc":"2.0","method":"getblockcount","id":1}`)
+	if got := rec.Header().Get(requestIDHeader); got != "trace-42" {
+		t.Errorf("expected caller ID to be echoed, got %q", got)
+	}
+	if !strings.Contains(logs.String(), "request_id=trace-42 method=getblockcount") {
+		t.Errorf("log line missing request ID: %s", logs.String())
+	}
+
+	for _, id := range []string{"", "bad id\nwith newline", strings.Repeat("x", 65)} {
+		rec := post(id, `{"jsonrpc":"2.0","method":"getblockcount","id":1}`)
+		if got := rec.Header().Get(requestIDHeader); len(got) != 16 {
+			t.Errorf("ID %q: expected a generated ID, got %q", id, got)
+		}
+	}
+
+	logs.Reset()
+	bad := *chain[1]
+	bad.Index = 2
+	raw, _ := json.Marshal(&bad)
+	post("submit-7", `{"jsonrpc":"2.0","method":"submitblock","params":[`+string(raw)+`],"id":2}`)
+	if !strings.Contains(logs.String(), `msg=validation_failed request_id=submit-7`) {
+		t.Errorf("rejection not logged with request ID: %s", logs.String())
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// rpcClient calls the JSON-RPC API of a node, as served by serve and
+// daemon.
+type rpcClient struct {
+	url    string
+	client *http.Client
+	nextID atomic.Int64
+	// magic, when set, is sent with every request so that a node of
+	// another network rejects it
+	magic uint32
+	// pin, when set, is the identity key the node must prove it holds
+	pin ed25519.PublicKey
+}
+
+func newRPCClient(url string) *rpcClient {
+	return &rpcClient{url: url, client: &http.Client{Timeout: 30 * time.Second, Transport: injectedFaults.Transport(nil)}}
+}
+
+// call invokes method with positional params and decodes its result into
+// result. Errors returned by the node are *rpcError values.
+func (c *rpcClient) call(ctx context.Context, method string, result any, params ...any) error {
+	if params == nil {
+		params = []any{}
+	}
+	body, err := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+		"id":      c.nextID.Add(1),
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	c.setMagic(req)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return fmt.Errorf("%s: decode %s response: %w", c.url, method, err)
+	}
+	if reply.Error != nil {
+		return reply.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(reply.Result, result)
+}
+
+// proof fetches the inclusion proof of a data chunk from the node's
+// /proof endpoint, which is served next to the JSON-RPC API.
+func (c *rpcClient) proof(ctx context.Context, height, chunk int) (*MerkleProof, error) {
+	url := fmt.Sprintf("%s/proof/%d/%d", strings.TrimSuffix(c.url, "/"), height, chunk)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.setMagic(req)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var reply struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&reply)
+		return nil, fmt.Errorf("%s: %s (%s)", url, reply.Error, resp.Status)
+	}
+	var reply proofResponse
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return nil, fmt.Errorf("%s: decode proof: %w", url, err)
+	}
+	if reply.Proof == nil {
+		return nil, fmt.Errorf("%s: response carries no proof", url)
+	}
+	return reply.Proof, nil
+}
+
+func (c *rpcClient) setMagic(req *http.Request) {
+	if c.magic != 0 {
+		req.Header.Set(networkMagicHeader, fmt.Sprintf("%08x", c.magic))
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Amazon S3 PutObject with Signature Version 4, enough of it for the
+// retention archive. Any S3-compatible store (MinIO, Ceph, R2) works through
+// the policy's archive_endpoint, with path-style URLs.
+
+// s3Timeout bounds each upload.
+const s3Timeout = time.Minute
+
+// s3Archive stores objects under a prefix of an S3 bucket.
+type s3Archive struct {
+	endpoint *url.URL // scheme and host; objects are at /bucket/key
+	bucket   string
+	prefix   string
+	region   string
+	creds    s3Credentials
+	client   *http.Client
+}
+
+// s3Credentials are the keys requests are signed with.
+type s3Credentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// newS3Archive returns the archive of an s3://bucket/prefix URL. The
+// credentials come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and, for
+// temporary credentials, AWS_SESSION_TOKEN. endpoint defaults to the AWS
+// endpoint of region.
+func newS3Archive(u *url.URL, endpoint, region string) (*s3Archive, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("archive URL %q has no bucket", u)
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	ep, err := url.Parse(endpoint)
+	if err != nil || (ep.Scheme != "http" && ep.Scheme != "https") || ep.Host == "" {
+		return nil, fmt.Errorf("invalid archive endpoint %q", endpoint)
+	}
+	creds := s3Credentials{
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKey == "" || creds.SecretKey == "" {
+		return nil, fmt.Errorf("archiving to S3 needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return &s3Archive{
+		endpoint: ep,
+		bucket:   u.Host,
+		prefix:   strings.Trim(u.Path, "/"),
+		region:   region,
+		creds:    creds,
+		client:   &http.Client{Timeout: s3Timeout},
+	}, nil
+}
+
+func (a *s3Archive) objectKey(key string) string {
+	if a.prefix == "" {
+		return key
+	}
+	return a.prefix + "/" + key
+}
+
+func (a *s3Archive) location(key string) string {
+	return "s3://" + a.bucket + "/" + a.objectKey(key)
+}
+
+// put uploads body as key, replacing any object already there.
+func (a *s3Archive) put(ctx context.Context, key string, body []byte) error {
+	u := *a.endpoint
+	u.Path = "/" + a.bucket + "/" + a.objectKey(key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	sum := sha256.Sum256(body)
+	signS3(req, hex.EncodeToString(sum[:]), a.creds, a.region, time.Now())
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("s3: PUT %s: %s: %s", a.location(key), resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// signS3 adds a Signature Version 4 Authorization header to req, signing
+// its host and every header already set on it. payloadHash is the hex
+// SHA-256 of the body.
+func signS3(req *http.Request, payloadHash string, creds s3Credentials, region string, now time.Time) {
+	stamp := now.UTC().Format("20060102T150405Z")
+	day := stamp[:8]
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	request := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		req.URL.RawQuery, // the archive sends no query; a canonical one would need sorting
+		canonical.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(request))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := s3HMAC([]byte("AWS4"+creds.SecretKey), day)
+	for _, part := range []string{region, "s3", "aws4_request"} {
+		key = s3HMAC(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%x",
+		creds.AccessKey, scope, signed, s3HMAC(key, toSign)))
+}
+
+// s3EscapePath percent-encodes every byte of path other than the
+// unreserved characters and "/", as Signature Version 4 requires.
+func s3EscapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3HMAC(key []byte, msg string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A segment store keeps blocks in flat files, as Bitcoin Core's blk*.dat
+// do, without a database. Blocks are appended as protobuf records to the
+// current segment file, blk00000.dat and up, and a new segment is started
+// when the next record would take it past its size limit. Each record is
+//
+//	magic (4 bytes LE)  length (4 bytes LE)  Block message
+//
+// index.dat holds one fixed-size entry per height, so the entry of any
+// height is read at a known offset:
+//
+//	segment (4 bytes LE)  length (4 bytes LE)  offset (8 bytes LE)  hash (32 bytes)
+//
+// Records are written before their index entry, so a crash can only leave
+// an unindexed record behind, which is never read. Old segments can be
+// deleted by Prune; their index entries stay, so heights keep their
+// meaning.
+//
+// The store is an export target that 'export -segments' brings up to date
+// and 'inspect -segments' reads. It is not the node's storage: the daemon
+// and the other commands load and save the chain store of the data
+// directory, which a reorg can rewrite, while segments are append-only.
+
+// defaultSegmentBytes is the size beyond which a segment store starts a
+// new segment file.
+const defaultSegmentBytes = 128 << 20
+
+// segmentMagic starts every record in a segment file.
+const segmentMagic = 0xb10cf11e
+
+const (
+	segmentRecordHeader = 8
+	segmentEntrySize    = 48
+	segmentIndexName    = "index.dat"
+)
+
+// Errors returned when reading a segment store.
+var (
+	ErrBlockPruned   = errors.New("block pruned")
+	ErrBlockNotFound = errors.New("block not found")
+)
+
+// segmentEntry locates one block in a segment store.
+type segmentEntry struct {
+	segment uint32
+	length  uint32
+	offset  int64
+	hash    [32]byte
+}
+
+// SegmentStore is an append-only block store in rotating segment files.
+// It is safe for concurrent use.
+type SegmentStore struct {
+	dir          string
+	segmentBytes int64
+
+	mu       sync.Mutex
+	index    *os.File
+	entries  []segmentEntry
+	byHash   map[[32]byte]int
+	first    uint32 // lowest segment not pruned
+	current  *os.File
+	segment  uint32              // number of current
+	size     int64               // of current
+	segments map[uint32]*os.File // open for reading
+}
+
+// openSegmentStore opens the segment store in dir, creating it if needed.
+// A trailing partial index entry, left by a crash, is discarded.
+func openSegmentStore(dir string, segmentBytes int64) (*SegmentStore, error) {
+	if segmentBytes <= 0 {
+		segmentBytes = defaultSegmentBytes
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &SegmentStore{dir: dir, segmentBytes: segmentBytes, byHash: make(map[[32]byte]int), segments: make(map[uint32]*os.File)}
+
+	index, err := os.OpenFile(filepath.Join(dir, segmentIndexName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s.index = index
+	raw, err := io.ReadAll(index)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	if whole := len(raw) / segmentEntrySize * segmentEntrySize; whole < len(raw) {
+		raw = raw[:whole]
+		if err := index.Truncate(int64(whole)); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	for off := 0; off < len(raw); off += segmentEntrySize {
+		e := raw[off : off+segmentEntrySize]
+		entry := segmentEntry{
+			segment: binary.LittleEndian.Uint32(e[0:]),
+			length:  binary.LittleEndian.Uint32(e[4:]),
+			offset:  int64(binary.LittleEndian.Uint64(e[8:])),
+		}
+		copy(entry.hash[:], e[16:])
+		s.byHash[entry.hash] = len(s.entries)
+		s.entries = append(s.entries, entry)
+	}
+
+	segments, err := s.listSegments()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	if len(segments) > 0 {
+		s.first, s.segment = segments[0], segments[len(segments)-1]
+	}
+	if n := len(s.entries); n > 0 && s.entries[n-1].segment > s.segment {
+		s.Close()
+		return nil, fmt.Errorf("%s: segment %d of block %d is missing", dir, s.entries[n-1].segment, n-1)
+	}
+	if err := s.openCurrent(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SegmentStore) segmentPath(n uint32) string {
+	return filepath.Join(s.dir, fmt.Sprintf("blk%05d.dat", n))
+}
+
+// listSegments returns the numbers of the segment files in the store, in
+// ascending order.
+func (s *SegmentStore) listSegments() ([]uint32, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []uint32
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), "blk")
+		if name, ok2 := strings.CutSuffix(name, ".dat"); ok && ok2 {
+			if n, err := strconv.ParseUint(name, 10, 32); err == nil {
+				segments = append(segments, uint32(n))
+			}
+		}
+	}
+	// ReadDir sorts by name, and the numbers are zero-padded
+	return segments, nil
+}
+
+// openCurrent opens the current segment for appending.
+func (s *SegmentStore) openCurrent() error {
+	f, err := os.OpenFile(s.segmentPath(s.segment), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.current, s.size = f, info.Size()
+	return nil
+}
+
+// Len returns the number of blocks in the store, pruned ones included.
+func (s *SegmentStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// Append stores the block at the next height.
+func (s *SegmentStore) Append(block *Block) error {
+	return s.AppendBatch([]*Block{block})
+}
+
+// AppendBatch stores blocks at the next heights, all of them or none. The
+// records bound for each segment go out in one write, and the index
+// entries of the whole batch in one write after them. If a write fails,
+// what the batch wrote is cut off again. A crash during the index write
+// can leave the entries of part of the batch, which still hold a prefix
+// of the chain.
+func (s *SegmentStore) AppendBatch(blocks []*Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, block := range blocks {
+		if block.Index != len(s.entries)+i {
+			return fmt.Errorf("block %d appended at height %d", block.Index, len(s.entries)+i)
+		}
+		if len(block.Hash) != 32 {
+			return fmt.Errorf("block %d: hash is %d bytes, want 32", block.Index, len(block.Hash))
+		}
+	}
+
+	segment, size := s.segment, s.size
+	entries := make([]segmentEntry, 0, len(blocks))
+	var pending []byte
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		_, err := injectedFaults.Writer(s.current).Write(pending)
+		pending = pending[:0]
+		return err
+	}
+	index := make([]byte, 0, len(blocks)*segmentEntrySize)
+	for _, block := range blocks {
+		msg := block.MarshalProto()
+		record := len(pending)
+		pending = binary.LittleEndian.AppendUint32(pending, segmentMagic)
+		pending = binary.LittleEndian.AppendUint32(pending, uint32(len(msg)))
+		pending = append(pending, msg...)
+
+		// An empty segment takes a record of any size
+		if n := int64(len(pending) - record); s.size > 0 && s.size+n > s.segmentBytes {
+			rest := append([]byte(nil), pending[record:]...)
+			pending = pending[:record]
+			err := flush()
+			if err == nil {
+				err = s.current.Close()
+				s.segment++
+				if err == nil {
+					err = s.openCurrent()
+				}
+			}
+			if err != nil {
+				return errors.Join(err, s.rollback(segment, size))
+			}
+			pending = rest
+		}
+
+		entry := segmentEntry{segment: s.segment, length: uint32(len(msg)), offset: s.size}
+		copy(entry.hash[:], block.Hash)
+		s.size += int64(segmentRecordHeader + len(msg))
+		index = binary.LittleEndian.AppendUint32(index, entry.segment)
+		index = binary.LittleEndian.AppendUint32(index, entry.length)
+		index = binary.LittleEndian.AppendUint64(index, uint64(entry.offset))
+		index = append(index, entry.hash[:]...)
+		entries = append(entries, entry)
+	}
+	if err := flush(); err != nil {
+		// Drop what was written of the records, which would otherwise
+		// shift the offsets of the records after them
+		return errors.Join(err, s.rollback(segment, size))
+	}
+	if _, err := s.index.WriteAt(index, int64(len(s.entries))*segmentEntrySize); err != nil {
+		return errors.Join(err, s.index.Truncate(int64(len(s.entries))*segmentEntrySize), s.rollback(segment, size))
+	}
+	for _, entry := range entries {
+		s.byHash[entry.hash] = len(s.entries)
+		s.entries = append(s.entries, entry)
+	}
+	return nil
+}
+
+// rollback cuts the segments back to size bytes of segment, deleting any
+// started after it. The caller must hold s.mu.
+func (s *SegmentStore) rollback(segment uint32, size int64) error {
+	var errs []error
+	if s.segment != segment {
+		if s.current != nil {
+			errs = append(errs, s.current.Close())
+		}
+		for n := segment + 1; n <= s.segment; n++ {
+			if err := os.Remove(s.segmentPath(n)); !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+		s.segment = segment
+		if err := s.openCurrent(); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+	}
+	errs = append(errs, s.current.Truncate(size))
+	s.size = size
+	return errors.Join(errs...)
+}
+
+// Block reads the block at height.
+func (s *SegmentStore) Block(height int) (*Block, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if height < 0 || height >= len(s.entries) {
+		return nil, fmt.Errorf("%w: height %d, the store has %d blocks", ErrBlockNotFound, height, len(s.entries))
+	}
+	return s.read(height)
+}
+
+// BlockByHash reads the block with the given hash.
+func (s *SegmentStore) BlockByHash(hash []byte) (*Block, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var key [32]byte
+	copy(key[:], hash)
+	height, ok := s.byHash[key]
+	if !ok || len(hash) != len(key) {
+		return nil, fmt.Errorf("%w: hash %x", ErrBlockNotFound, hash)
+	}
+	return s.read(height)
+}
+
+// read decodes the record of height. The caller must hold s.mu.
+func (s *SegmentStore) read(height int) (*Block, error) {
+	entry := s.entries[height]
+	if entry.segment < s.first {
+		return nil, fmt.Errorf("%w: block %d was in segment %d", ErrBlockPruned, height, entry.segment)
+	}
+	f, ok := s.segments[entry.segment]
+	if !ok {
+		var err error
+		if f, err = os.Open(s.segmentPath(entry.segment)); err != nil {
+			return nil, err
+		}
+		s.segments[entry.segment] = f
+	}
+
+	record := make([]byte, segmentRecordHeader+int(entry.length))
+	if _, err := f.ReadAt(record, entry.offset); err != nil {
+		return nil, fmt.Errorf("block %d: %w", height, err)
+	}
+	if binary.LittleEndian.Uint32(record[0:]) != segmentMagic || binary.LittleEndian.Uint32(record[4:]) != entry.length {
+		return nil, fmt.Errorf("block %d: no record at offset %d of segment %d", height, entry.offset, entry.segment)
+	}
+	block := new(Block)
+	if err := block.UnmarshalProto(record[segmentRecordHeader:]); err != nil {
+		return nil, fmt.Errorf("block %d: %w", height, err)
+	}
+	return block, nil
+}
+
+// Prune deletes the segment files that hold only blocks below height,
+// returning how many it deleted. The current segment is always kept.
+func (s *SegmentStore) Prune(height int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	height = min(height, len(s.entries))
+	// Segments fill in order, so the segment of height bounds the rest
+	keep := s.segment
+	if height < len(s.entries) {
+		keep = s.entries[height].segment
+	}
+	removed := 0
+	for ; s.first < keep; s.first++ {
+		if f, ok := s.segments[s.first]; ok {
+			f.Close()
+			delete(s.segments, s.first)
+		}
+		switch err := os.Remove(s.segmentPath(s.first)); {
+		case err == nil:
+			removed++
+		case !errors.Is(err, os.ErrNotExist):
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// Close closes the store's files.
+func (s *SegmentStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for n, f := range s.segments {
+		errs = append(errs, f.Close())
+		delete(s.segments, n)
+	}
+	if s.current != nil {
+		errs = append(errs, s.current.Close())
+		s.current = nil
+	}
+	if s.index != nil {
+		errs = append(errs, s.index.Close())
+		s.index = nil
+	}
+	return errors.Join(errs...)
+}
+
+// appendSegments appends the blocks of chain the segment store does not
+// have yet. The store must hold a prefix of chain.
+func appendSegments(s *SegmentStore, chain []*Block) (int, error) {
+	n := s.Len()
+	if n > len(chain) {
+		return 0, fmt.Errorf("the segment store has %d blocks, the chain %d", n, len(chain))
+	}
+	if n > 0 {
+		s.mu.Lock()
+		last := s.entries[n-1].hash
+		s.mu.Unlock()
+		if !bytes.Equal(last[:], chain[n-1].Hash) {
+			return 0, fmt.Errorf("the segment store diverges from the chain at or before height %d", n-1)
+		}
+	}
+	if err := s.AppendBatch(chain[n:]); err != nil {
+		return 0, err
+	}
+	return len(chain) - n, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestSegmentStore appends blocks across several segments, reads them
+// back by height and hash after reopening, and prunes old segments.
+func TestSegmentStore(t *testing.T) {
+	dir := t.TempDir()
+	chain := makeBlockchain(20, 1)
+	store, err := openSegmentStore(dir, 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := appendSegments(store, chain[:12]); err != nil || n != 12 {
+		t.Fatalf("appended %d blocks: %v", n, err)
+	}
+	if err := store.Append(chain[15]); err == nil {
+		t.Error("appended a block out of order")
+	}
+	store.Close()
+
+	// A crash mid-entry leaves a partial one behind
+	index, _ := os.OpenFile(filepath.Join(dir, segmentIndexName), os.O_WRONLY|os.O_APPEND, 0o644)
+	index.Write([]byte{1, 2, 3})
+	index.Close()
+
+	store, err = openSegmentStore(dir, 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if n, err := appendSegments(store, chain); err != nil || n != 8 || store.Len() != 20 {
+		t.Fatalf("appended %d more blocks: %v", n, err)
+	}
+	segments, _ := store.listSegments()
+	if len(segments) < 3 {
+		t.Fatalf("expected blocks to span several segments, got %v", segments)
+	}
+	for _, want := range chain {
+		got, err := store.Block(want.Index)
+		if err != nil || !bytes.Equal(got.MarshalProto(), want.MarshalProto()) {
+			t.Fatalf("block %d: %v", want.Index, err)
+		}
+	}
+	if got, err := store.BlockByHash(chain[7].Hash); err != nil || got.Index != 7 {
+		t.Errorf("by hash: %v, %v", got, err)
+	}
+	if _, err := store.Block(20); !errors.Is(err, ErrBlockNotFound) {
+		t.Errorf("expected ErrBlockNotFound, got %v", err)
+	}
+	if _, err := appendSegments(store, makeBlockchain(21, 2)); err == nil {
+		t.Error("appended a diverging chain")
+	}
+
+	removed, err := store.Prune(10)
+	if err != nil || removed == 0 {
+		t.Fatalf("pruned %d segments: %v", removed, err)
+	}
+	if _, err := store.Block(0); !errors.Is(err, ErrBlockPruned) {
+		t.Errorf("expected ErrBlockPruned, got %v", err)
+	}
+	for h := 10; h < 20; h++ {
+		if _, err := store.Block(h); err != nil {
+			t.Errorf("block %d lost to pruning: %v", h, err)
+		}
+	}
+	store.Close()
+
+	store, err = openSegmentStore(dir, 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Block(0); !errors.Is(err, ErrBlockPruned) || store.Len() != 20 {
+		t.Errorf("pruning forgotten on reopen: %v", err)
+	}
+}
+
+// TestExportSegments keeps a segment store up to date with a data
+// directory and reads a block back from it.
+func TestExportSegments(t *testing.T) {
+	dataDir := t.TempDir()
+	segments := filepath.Join(t.TempDir(), "blocks")
+	chain := makeBlockchain(5, 1)
+	if err := saveStore(dataDir, chain[:3]); err != nil {
+		t.Fatal(err)
+	}
+	export := func() int {
+		return runExport([]string{"-datadir", dataDir, "-segments", segments, "-segment-size", "512"})
+	}
+	if code := export(); code != exitOK {
+		t.Fatalf("export: exit code %d", code)
+	}
+	saveStore(dataDir, chain)
+	if code := export(); code != exitOK {
+		t.Fatalf("second export: exit code %d", code)
+	}
+
+	if code := runInspect([]string{"-segments", segments, "-index", "4", "-template", "{{.Index}}\n"}); code != exitOK {
+		t.Errorf("inspect: exit code %d", code)
+	}
+	if code := runInspect([]string{"-segments", segments, "-index", "5"}); code != exitConfig {
+		t.Errorf("inspect beyond the tip: expected exit code %d, got %d", exitConfig, code)
+	}
+	if code := runInspect([]string{"-segments", t.TempDir(), "-index", "0"}); code != exitStorage {
+		t.Errorf("inspect without a store: expected exit code %d, got %d", exitStorage, code)
+	}
+}
+
+// TestSegmentStoreAppendBatch checks that a batch spanning segments is
+// stored whole, and that a failed one leaves no records, entries or
+// segments behind.
+func TestSegmentStoreAppendBatch(t *testing.T) {
+	dir := t.TempDir()
+	chain := makeBlockchain(6, 1)
+	// Every record starts a segment of its own
+	store, err := openSegmentStore(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { store.Close() }()
+	if err := store.AppendBatch(chain[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AppendBatch(chain[3:]); err == nil {
+		t.Error("appended a batch out of order")
+	}
+
+	torn := NewFaults(1)
+	torn.TornWrite = 1
+	injectFaults(t, torn)
+	if err := store.AppendBatch(chain[2:]); !errors.Is(err, ErrInjectedFault) {
+		t.Fatalf("torn batch: %v", err)
+	}
+	injectedFaults = nil
+	if segments, _ := store.listSegments(); len(segments) != 2 || store.Len() != 2 {
+		t.Fatalf("failed batch left %d blocks in segments %v", store.Len(), segments)
+	}
+	if err := store.AppendBatch(chain[2:]); err != nil {
+		t.Fatal(err)
+	}
+
+	store.Close()
+	if store, err = openSegmentStore(dir, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range chain {
+		if got, err := store.Block(want.Index); err != nil || !bytes.Equal(got.Hash, want.Hash) {
+			t.Errorf("block %d: %v", want.Index, err)
+		}
+	}
+}
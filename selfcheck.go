@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"path/filepath"
+	"slices"
+)
+
+// selfCheckSamples is how many blocks the daemon's startup self-check
+// re-verifies at random, by default.
+const selfCheckSamples = 32
+
+// SelfCheckReport is the result of a startup self-check of a stored chain.
+type SelfCheckReport struct {
+	Checked  int // blocks whose hash and proof-of-work were recomputed
+	Problems []*BlockValidationError
+}
+
+// OK reports whether the check found no problem.
+func (r *SelfCheckReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// FirstBad returns the lowest height with a problem, or -1.
+func (r *SelfCheckReport) FirstBad() int {
+	first := -1
+	for _, p := range r.Problems {
+		if first < 0 || p.Index < first {
+			first = p.Index
+		}
+	}
+	return first
+}
+
+// Err returns all problems joined into one error, or nil.
+func (r *SelfCheckReport) Err() error {
+	errs := make([]error, len(r.Problems))
+	for i, p := range r.Problems {
+		errs[i] = p
+	}
+	return errors.Join(errs...)
+}
+
+// selfCheck looks for corruption that loading a stored chain does not
+// catch, since blocks covered by the checkpoint are trusted. It confirms
+// that every block sits at its index and links to its predecessor's stored
+// hash, that the checkpoint names a block of the chain, and recomputes the
+// hash and seal of the genesis block, the tip and samples blocks picked at
+// random, as engine checks them. Every block with a stored checksum in
+// sums is compared against it.
+func selfCheck(chain []*Block, engine Engine, cp *Checkpoint, sums []uint32, samples int) *SelfCheckReport {
+	r := new(SelfCheckReport)
+	r.Problems = checkChainSums(chain, sums)
+	for i, block := range chain {
+		switch {
+		case block.Index != i:
+			r.Problems = append(r.Problems, &BlockValidationError{Index: i, Err: fmt.Errorf("stored at position %d but has index %d", i, block.Index)})
+		case i > 0 && !bytes.Equal(block.PrevHash, chain[i-1].Hash):
+			r.Problems = append(r.Problems, &BlockValidationError{Index: i, Err: ErrBrokenLink})
+		}
+	}
+	if cp != nil && (cp.Height >= len(chain) || !bytes.Equal(chain[cp.Height].Hash, cp.Hash)) {
+		r.Problems = append(r.Problems, &BlockValidationError{Index: min(cp.Height, len(chain)-1), Err: errors.New("the checkpoint does not match the stored chain")})
+	}
+
+	heights := []int{len(chain) - 1}
+	if n := len(chain) - 2; n <= samples {
+		// Small chains are checked in full
+		for h := 1; h <= n; h++ {
+			heights = append(heights, h)
+		}
+	} else {
+		for _, i := range rand.Perm(n)[:samples] {
+			heights = append(heights, i+1)
+		}
+	}
+	slices.Sort(heights)
+
+	if !bytes.Equal(committedHash(chain[0]), chain[0].Hash) {
+		r.Problems = append(r.Problems, &BlockValidationError{Index: 0, Err: ErrHashMismatch})
+	}
+	r.Checked = 1
+	cache := NewHashCache(2 * len(heights))
+	for _, h := range slices.Compact(heights) {
+		if h == 0 {
+			continue
+		}
+		r.Checked++
+		if err := validateBlockPairWith(chain[h-1], chain[h], engine, cache); err != nil {
+			var blockErr *BlockValidationError
+			if !errors.As(err, &blockErr) {
+				blockErr = &BlockValidationError{Index: h, Err: err}
+			}
+			r.Problems = append(r.Problems, blockErr)
+		}
+	}
+	return r
+}
+
+// repairCommands suggests how to recover the data directory of a chain
+// whose first broken block is at firstBad: find every broken block, keep
+// a copy, and restore a known-good chain. The newest snapshot the
+// retention policy kept from before firstBad is preferred to a backup.
+func repairCommands(dataDir string, difficulty int, firstBad int) []string {
+	cmds := []string{
+		fmt.Sprintf("blockchain validate -datadir %s -full -difficulty %d   # list every broken block; rebuilds the checkpoint if none is", dataDir, difficulty),
+		fmt.Sprintf("cp -r %s %s.corrupt   # keep a copy before repairing", dataDir, filepath.Clean(dataDir)),
+		fmt.Sprintf("blockchain repair -datadir %s -difficulty %d   # keep only the blocks before the first broken one, or restore:", dataDir, difficulty),
+	}
+	heights, _ := listSnapshots(dataDir)
+	for i := len(heights) - 1; i >= 0; i-- {
+		if heights[i] < firstBad {
+			return append(cmds, fmt.Sprintf("blockchain import -file %s -datadir %s -difficulty %d -trust-redactions   # restore the snapshot at height %d; later blocks are mined again",
+				snapshotPath(dataDir, heights[i]), dataDir, difficulty, heights[i]))
+		}
+	}
+	return append(cmds, fmt.Sprintf("blockchain import -file backup.json -datadir %s -difficulty %d   # restore a known-good export", dataDir, difficulty))
+}
+
+// printRepairCommands tells the operator why the node will not mine and
+// how to repair its store.
+func printRepairCommands(w io.Writer, dataDir string, difficulty int, firstBad int) {
+	fmt.Fprintf(w, "Refusing to mine on a corrupted chain store (first problem at block %d). To repair:\n", firstBad)
+	for _, cmd := range repairCommands(dataDir, difficulty, firstBad) {
+		fmt.Fprintf(w, "  %s\n", cmd)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestSelfCheck checks that the self-check finds tampering behind the
+// checkpoint when it samples the block, and broken indexes and checkpoints
+// always.
+func TestSelfCheck(t *testing.T) {
+	chain := makeBlockchain(6, 1)
+	cp := newCheckpoint(chain, 1, nil)
+	if r := selfCheck(chain, &PoWEngine{Difficulty: 1}, cp, nil, selfCheckSamples); !r.OK() || r.Checked != 6 {
+		t.Fatalf("valid chain: checked %d, problems %v", r.Checked, r.Err())
+	}
+
+	chain[2].Data = []byte("tampered")
+	if r := selfCheck(chain, &PoWEngine{Difficulty: 1}, cp, nil, 0); !r.OK() || r.Checked != 2 {
+		t.Errorf("without samples only genesis and the tip are checked: checked %d, problems %v", r.Checked, r.Err())
+	}
+	r := selfCheck(chain, &PoWEngine{Difficulty: 1}, cp, nil, 10)
+	if r.OK() || r.FirstBad() != 2 {
+		t.Errorf("tampered data not found: %v", r.Err())
+	}
+
+	chain = makeBlockchain(6, 1)
+	chain[4].Index = 7
+	r = selfCheck(chain, &PoWEngine{Difficulty: 1}, &Checkpoint{Height: 9, Hash: chain[5].Hash}, nil, 0)
+	if r.FirstBad() != 4 || len(r.Problems) != 3 {
+		t.Errorf("expected the index, checkpoint and tip link problems, got %v", r.Err())
+	}
+	var blockErr *BlockValidationError
+	if !errors.As(r.Err(), &blockErr) || exitCodeFor(r.Err()) != exitValidation {
+		t.Errorf("self-check errors are not validation failures: %v", r.Err())
+	}
+}
+
+// TestRepairCommands checks that a snapshot from before the first problem
+// is suggested, and a backup when there is none.
+func TestRepairCommands(t *testing.T) {
+	dataDir := t.TempDir()
+	chain := makeBlockchain(5, 1)
+	if err := saveChainDir(chain[:3], snapshotPath(dataDir, 2)); err != nil {
+		t.Fatal(err)
+	}
+	cmds := strings.Join(repairCommands(dataDir, 1, 3), "\n")
+	if !strings.Contains(cmds, "validate -datadir "+dataDir+" -full") || !strings.Contains(cmds, "import -file "+snapshotPath(dataDir, 2)) {
+		t.Errorf("expected the snapshot to be suggested:\n%s", cmds)
+	}
+	cmds = strings.Join(repairCommands(dataDir, 1, 2), "\n")
+	if strings.Contains(cmds, "snapshot") || !strings.Contains(cmds, "backup.json") {
+		t.Errorf("expected a backup to be suggested:\n%s", cmds)
+	}
+}
+
+// TestDaemonSelfCheck checks that the daemon refuses to start on a store
+// corrupted behind its checkpoint.
+func TestDaemonSelfCheck(t *testing.T) {
+	dataDir := t.TempDir()
+	chain := makeBlockchain(5, 1)
+	if err := writeChainFile(chain, chainStorePath(dataDir)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeCheckpoint(dataDir, newCheckpoint(chain, 1, nil)); err != nil {
+		t.Fatal(err)
+	}
+	chain[2].Data = []byte("tampered")
+	if err := writeChainFile(chain, chainStorePath(dataDir)); err != nil {
+		t.Fatal(err)
+	}
+
+	code := runDaemon([]string{"-datadir", dataDir, "-difficulty", "1", "-addr", "127.0.0.1:0", "-log-level", "error"})
+	if code != exitValidation {
+		t.Errorf("expected exit code %d, got %d", exitValidation, code)
+	}
+}
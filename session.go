@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// SessionSummary is the record of a single run of the node. It is printed
+// and written to the data directory however the run ends, including on
+// interrupt, timeout or failure, so long experiments leave a usable record
+// behind.
+type SessionSummary struct {
+	Started         time.Time     `json:"started"`
+	Duration        time.Duration `json:"duration_ns"`
+	BlocksMined     int           `json:"blocks_mined"`
+	HashesAttempted uint64        `json:"hashes_attempted"`
+	AvgBlockTime    time.Duration `json:"avg_block_time_ns"`
+	HashRate        float64       `json:"hash_rate"`
+	PeakHeapBytes   uint64        `json:"peak_heap_bytes"`
+	Reorgs          int           `json:"reorgs"`
+	Interrupted     bool          `json:"interrupted"`
+	// Error is why the run failed, if it did
+	Error string `json:"error,omitempty"`
+}
+
+// finish fills in the derived fields once mining has stopped.
+func (s *SessionSummary) finish(miningTime time.Duration, peakHeap uint64) {
+	s.Duration = time.Since(s.Started)
+	if s.BlocksMined > 0 {
+		s.AvgBlockTime = miningTime / time.Duration(s.BlocksMined)
+	}
+	s.HashRate = hashRate(s.HashesAttempted, miningTime)
+	s.PeakHeapBytes = peakHeap
+}
+
+// Print writes a human-readable version of the summary.
+func (s *SessionSummary) Print(w io.Writer) {
+	fmt.Fprintf(w, "\nSession Summary:\n")
+	fmt.Fprintf(w, "- Blocks mined: %d\n", s.BlocksMined)
+	fmt.Fprintf(w, "- Hashes attempted: %d (%.0f H/s)\n", s.HashesAttempted, s.HashRate)
+	fmt.Fprintf(w, "- Average block time: %v\n", s.AvgBlockTime)
+	fmt.Fprintf(w, "- Peak heap: %.2f MiB\n", float64(s.PeakHeapBytes)/(1<<20))
+	fmt.Fprintf(w, "- Reorgs: %d\n", s.Reorgs)
+	fmt.Fprintf(w, "- Total runtime: %v\n", s.Duration)
+	if s.Interrupted {
+		fmt.Fprintf(w, "- Run was interrupted before completion\n")
+	}
+	if s.Error != "" {
+		fmt.Fprintf(w, "- Run failed: %s\n", s.Error)
+	}
+}
+
+// record writes the summary to dir, when one is given, and logs where.
+func (s *SessionSummary) record(logger *slog.Logger, dir string) {
+	if dir == "" {
+		return
+	}
+	path, err := writeSessionSummary(dir, s)
+	if err != nil {
+		logger.Error("session_write_failed", slog.String("datadir", dir), slog.Any("error", err))
+		return
+	}
+	logger.Info("session_written", slog.String("path", path))
+}
+
+// writeSessionSummary stores the summary as JSON in dir, named after the
+// session start time so successive runs do not overwrite each other.
+func writeSessionSummary(dir string, s *SessionSummary) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("session-%s.json", s.Started.UTC().Format("20060102T150405Z"))
+	path := filepath.Join(dir, name)
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// trackPeakHeap samples heap usage at the given interval until the returned
+// function is called, which stops sampling and reports the peak observed.
+func trackPeakHeap(interval time.Duration) func() uint64 {
+	var peak atomic.Uint64
+	sample := func() {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		for {
+			cur := peak.Load()
+			if m.HeapAlloc <= cur || peak.CompareAndSwap(cur, m.HeapAlloc) {
+				return
+			}
+		}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			sample()
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() uint64 {
+		close(done)
+		<-stopped
+		sample()
+		return peak.Load()
+	}
+}
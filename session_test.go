@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestWriteSessionSummary checks that the summary is written as JSON to the data directory.
+func TestWriteSessionSummary(t *testing.T) {
+	s := &SessionSummary{
+		Started:         time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		BlocksMined:     4,
+		HashesAttempted: 1000,
+	}
+	s.finish(2*time.Second, 1<<20)
+
+	if s.AvgBlockTime != 500*time.Millisecond {
+		t.Errorf("expected average block time 500ms, got %v", s.AvgBlockTime)
+	}
+	if s.HashRate != 500 {
+		t.Errorf("expected hash rate 500, got %v", s.HashRate)
+	}
+
+	path, err := writeSessionSummary(t.TempDir(), s)
+	if err != nil {
+		t.Fatalf("writeSessionSummary failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded SessionSummary
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("invalid JSON output: %v", err)
+	}
+	if decoded.BlocksMined != 4 || decoded.PeakHeapBytes != 1<<20 {
+		t.Errorf("unexpected decoded summary: %+v", decoded)
+	}
+}
+
+// TestDaemonSessionOnFailure checks that a daemon refusing to start still
+// leaves a session summary saying why.
+func TestDaemonSessionOnFailure(t *testing.T) {
+	dataDir := t.TempDir()
+	if err := os.WriteFile(chainStorePath(dataDir), []byte("not a chain"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code := runDaemon([]string{"-datadir", dataDir, "-difficulty", "1", "-addr", "127.0.0.1:0", "-log-level", "error"}); code == exitOK {
+		t.Fatal("daemon started on a corrupt store")
+	}
+	paths, _ := filepath.Glob(filepath.Join(dataDir, "session-*.json"))
+	if len(paths) != 1 {
+		t.Fatalf("%d session summaries written", len(paths))
+	}
+	data, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s SessionSummary
+	if err := json.Unmarshal(data, &s); err != nil || s.Error == "" {
+		t.Errorf("session %+v (%v) does not record the failure", s, err)
+	}
+}
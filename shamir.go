@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// shamirShare is one share of a secret split with Shamir's scheme over
+// GF(2^8): the value at X of a random polynomial of degree Threshold-1
+// whose constant term is the secret, evaluated byte by byte.
+type shamirShare struct {
+	X         byte
+	Threshold byte
+	Y         []byte
+}
+
+// shamirSplit splits secret into n shares, any k of which reconstruct it.
+func shamirSplit(secret []byte, n, k int) ([]shamirShare, error) {
+	if k < 2 || k > n || n > 255 {
+		return nil, fmt.Errorf("invalid threshold %d of %d shares", k, n)
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("secret is empty")
+	}
+
+	shares := make([]shamirShare, n)
+	for i := range shares {
+		shares[i] = shamirShare{X: byte(i + 1), Threshold: byte(k), Y: make([]byte, len(secret))}
+	}
+
+	coeffs := make([]byte, k)
+	for b, s := range secret {
+		coeffs[0] = s
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			shares[i].Y[b] = gfEval(coeffs, shares[i].X)
+		}
+	}
+	return shares, nil
+}
+
+// shamirCombine reconstructs the secret from at least Threshold shares by
+// Lagrange interpolation at x = 0.
+func shamirCombine(shares []shamirShare) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares given")
+	}
+	k := int(shares[0].Threshold)
+	if k < 2 {
+		// No split has a threshold below 2, and with one share the
+		// interpolation would return that share's value as the secret
+		return nil, fmt.Errorf("invalid threshold %d", k)
+	}
+	size := len(shares[0].Y)
+	seen := make(map[byte]bool)
+	for _, s := range shares {
+		if int(s.Threshold) != k || len(s.Y) != size {
+			return nil, errors.New("shares belong to different splits")
+		}
+		if s.X == 0 || seen[s.X] {
+			return nil, fmt.Errorf("duplicate or invalid share number %d", s.X)
+		}
+		seen[s.X] = true
+	}
+	if len(shares) < k {
+		return nil, fmt.Errorf("need %d shares, got %d", k, len(shares))
+	}
+	shares = shares[:k]
+
+	secret := make([]byte, size)
+	for i, si := range shares {
+		// Lagrange basis polynomial for share i evaluated at 0; in GF(2^8)
+		// subtraction is XOR
+		basis := byte(1)
+		for j, sj := range shares {
+			if i != j {
+				basis = gfMul(basis, gfDiv(sj.X, sj.X^si.X))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(si.Y[b], basis)
+		}
+	}
+	return secret, nil
+}
+
+// gfEval evaluates the polynomial with the given coefficients at x using
+// Horner's method.
+func gfEval(coeffs []byte, x byte) byte {
+	var y byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coeffs[i]
+	}
+	return y
+}
+
+// gfMul multiplies in GF(2^8) modulo the AES polynomial x^8+x^4+x^3+x+1.
+func gfMul(a, b byte) byte {
+	var p byte
+	for b > 0 {
+		if b&1 != 0 {
+			p ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return p
+}
+
+// gfDiv divides a by b, using b^254 as the inverse of b.
+func gfDiv(a, b byte) byte {
+	inv := byte(1)
+	for i := 0; i < 254; i++ {
+		inv = gfMul(inv, b)
+	}
+	return gfMul(a, inv)
+}
+
+// Shares are written as proquints: each 16 bits become a pronounceable
+// five-letter word, which is easy to copy by hand or read aloud.
+const (
+	proquintConsonants = "bdfghjklmnprstvz"
+	proquintVowels     = "aiou"
+)
+
+// encodeShare renders a share as words. The encoding covers the share
+// number, threshold, value and a two-byte checksum so that typos are caught.
+func encodeShare(s shamirShare) string {
+	b := append([]byte{s.X, s.Threshold}, s.Y...)
+	sum := sha256.Sum256(b)
+	b = append(b, sum[:2]...)
+	if len(b)%2 != 0 {
+		b = append(b, 0)
+	}
+
+	words := make([]string, 0, len(b)/2)
+	for i := 0; i < len(b); i += 2 {
+		v := uint16(b[i])<<8 | uint16(b[i+1])
+		words = append(words, string([]byte{
+			proquintConsonants[v>>12&0xf],
+			proquintVowels[v>>10&0x3],
+			proquintConsonants[v>>6&0xf],
+			proquintVowels[v>>4&0x3],
+			proquintConsonants[v&0xf],
+		}))
+	}
+	return strings.Join(words, "-")
+}
+
+// decodeShare parses words produced by encodeShare for a secret of the
+// given size. Words may be separated by dashes or whitespace.
+func decodeShare(text string, secretSize int) (shamirShare, error) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return r == '-' || r == ' ' || r == '\t' || r == '\n'
+	})
+
+	var b []byte
+	for _, w := range words {
+		if len(w) != 5 {
+			return shamirShare{}, fmt.Errorf("invalid share word %q", w)
+		}
+		var v uint16
+		for i, shift := range []uint{12, 10, 6, 4, 0} {
+			alphabet := proquintConsonants
+			if i%2 == 1 {
+				alphabet = proquintVowels
+			}
+			d := strings.IndexByte(alphabet, w[i])
+			if d < 0 {
+				return shamirShare{}, fmt.Errorf("invalid share word %q", w)
+			}
+			v |= uint16(d) << shift
+		}
+		b = append(b, byte(v>>8), byte(v))
+	}
+
+	n := 2 + secretSize
+	if len(b) < n+2 {
+		return shamirShare{}, errors.New("share is too short")
+	}
+	sum := sha256.Sum256(b[:n])
+	if !bytes.Equal(b[n:n+2], sum[:2]) {
+		return shamirShare{}, errors.New("share checksum mismatch; check for typos")
+	}
+	if b[0] == 0 || b[1] < 2 {
+		return shamirShare{}, fmt.Errorf("invalid share %d of threshold %d", b[0], b[1])
+	}
+	return shamirShare{X: b[0], Threshold: b[1], Y: b[2:n]}, nil
+}
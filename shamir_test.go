@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestShamirSplitCombine checks that any threshold-sized subset restores the secret.
+func TestShamirSplitCombine(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	shares, err := shamirSplit(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subsets := [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}}
+	for _, subset := range subsets {
+		var picked []shamirShare
+		for _, i := range subset {
+			picked = append(picked, shares[i])
+		}
+		got, err := shamirCombine(picked)
+		if err != nil {
+			t.Fatalf("combine %v failed: %v", subset, err)
+		}
+		if !bytes.Equal(got, secret) {
+			t.Errorf("combine %v restored %x", subset, got)
+		}
+	}
+
+	if _, err := shamirCombine(shares[:2]); err == nil {
+		t.Error("expected fewer than threshold shares to be rejected")
+	}
+	if _, err := shamirCombine(shares[:1]); err == nil {
+		t.Error("expected a single share to be rejected")
+	}
+}
+
+// TestShamirBadThreshold checks that shares claiming a threshold below 2
+// are refused, when decoded and when combined, instead of yielding a
+// secret.
+func TestShamirBadThreshold(t *testing.T) {
+	shares, err := shamirSplit(bytes.Repeat([]byte{0xcd}, 32), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []byte{0, 1} {
+		forged := shares[0]
+		forged.Threshold = k
+		if _, err := decodeShare(encodeShare(forged), 32); err == nil {
+			t.Errorf("threshold %d: decodeShare accepted the share", k)
+		}
+		if _, err := shamirCombine([]shamirShare{forged}); err == nil {
+			t.Errorf("threshold %d: shamirCombine returned a secret", k)
+		}
+	}
+}
+
+// TestShareWords checks the word encoding round trip and typo detection.
+func TestShareWords(t *testing.T) {
+	shares, err := shamirSplit(bytes.Repeat([]byte{0xab}, 32), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	words := encodeShare(shares[1])
+
+	decoded, err := decodeShare(strings.ReplaceAll(words, "-", " "), 32)
+	if err != nil {
+		t.Fatalf("decodeShare failed: %v", err)
+	}
+	if decoded.X != shares[1].X || decoded.Threshold != 2 || !bytes.Equal(decoded.Y, shares[1].Y) {
+		t.Errorf("decoded share differs: %+v", decoded)
+	}
+
+	// Swap a consonant in the first word
+	typo := []byte(words)
+	if typo[0] == 'b' {
+		typo[0] = 'd'
+	} else {
+		typo[0] = 'b'
+	}
+	if _, err := decodeShare(string(typo), 32); err == nil {
+		t.Error("expected a typo to fail the checksum")
+	}
+}
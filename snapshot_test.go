@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// copyChain deep-copies a chain through its protobuf encoding.
+func copyChain(t *testing.T, chain []*Block) []*Block {
+	t.Helper()
+	out, err := unmarshalChainProto(marshalChainProto(chain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestSnapshotHash(t *testing.T) {
+	chain := makeBlockchain(5, 1)
+	digest := func(chain []*Block, height int) []byte {
+		t.Helper()
+		d, err := snapshotHash(chain, height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	base := digest(chain, 3)
+	if !bytes.Equal(base, digest(copyChain(t, chain), 3)) {
+		t.Error("equal chains have different snapshots")
+	}
+	if bytes.Equal(base, digest(chain, 4)) {
+		t.Error("snapshots at different heights are equal")
+	}
+	if !bytes.Equal(base, digest(append(copyChain(t, chain[:4]), extendChain(t, chain[:4], 1, "other")[4]), 3)) {
+		t.Error("a block above the height changed the snapshot")
+	}
+
+	// Redacting the same block on two nodes at different times gives the
+	// same state; a redaction on one node only does not
+	a, b := copyChain(t, chain), copyChain(t, chain)
+	redactBlock(a[2], "erasure request")
+	redactBlock(b[2], "erasure request")
+	b[2].Redaction.RedactedAt++
+	if !bytes.Equal(digest(a, 3), digest(b, 3)) {
+		t.Error("the redaction time changed the snapshot")
+	}
+	if bytes.Equal(digest(a, 3), base) {
+		t.Error("a redaction did not change the snapshot")
+	}
+
+	if _, err := snapshotHash(chain, 5); err == nil {
+		t.Error("snapshot past the tip succeeded")
+	}
+}
+
+func TestVerifyAgainst(t *testing.T) {
+	chain := makeBlockchain(4, 1)
+	node := httptest.NewServer(newRPCServer(chain, 1))
+	defer node.Close()
+
+	dir := t.TempDir()
+	same := filepath.Join(dir, "same.json")
+	if err := writeChainFile(copyChain(t, chain), same); err != nil {
+		t.Fatal(err)
+	}
+	redacted := copyChain(t, chain)
+	redactBlock(redacted[1], "erasure request")
+	differs := filepath.Join(dir, "differs.json")
+	if err := writeChainFile(redacted, differs); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want int
+	}{
+		{[]string{"-rpc", node.URL, "-file", same}, exitOK},
+		{[]string{"-rpc", node.URL, "-file", differs}, exitValidation},
+		{[]string{"-rpc", node.URL, "-file", differs, "-height", "0"}, exitOK},
+		{[]string{"-rpc", node.URL, "-file", same, "-height", "9"}, exitConfig},
+		{[]string{"-file", same}, exitConfig},
+	} {
+		if code := runVerifyAgainst(tc.args); code != tc.want {
+			t.Errorf("verify-against %v: exit code %d, want %d", tc.args, code, tc.want)
+		}
+	}
+}
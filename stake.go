@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// On a proof-of-stake chain, time is divided into slots of the chain's
+// block interval, counted from the genesis timestamp, and each slot has
+// one proposer. The proposer of a slot is drawn from the validators with a
+// probability proportional to their stake, seeded by the genesis hash and
+// the slot number, so every node derives the same schedule and no block
+// can steer it. A block carries the timestamp of its slot's start and its
+// proposer's signature of its hash in place of a proof-of-work; a slot
+// whose proposer is offline stays empty.
+
+// maxSlotSearch bounds how far ahead Prepare looks for a slot of its own.
+const maxSlotSearch = 1 << 20
+
+// Validator is a proof-of-stake validator as given in chain parameters.
+type Validator struct {
+	PublicKey string `json:"public_key"` // hex ed25519 public key
+	Stake     uint64 `json:"stake"`
+}
+
+type stakeValidator struct {
+	key   ed25519.PublicKey
+	stake uint64
+}
+
+// parseValidators checks a validator set and returns it with its total
+// stake.
+func parseValidators(validators []Validator) ([]stakeValidator, uint64, error) {
+	if len(validators) == 0 {
+		return nil, 0, errors.New("a proof-of-stake chain needs at least one validator")
+	}
+	set := make([]stakeValidator, 0, len(validators))
+	seen := make(map[string]bool)
+	var total uint64
+	for i, v := range validators {
+		key, err := hex.DecodeString(v.PublicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, 0, fmt.Errorf("validator %d: public_key must be a hex ed25519 public key", i)
+		}
+		if v.Stake == 0 {
+			return nil, 0, fmt.Errorf("validator %d: stake must be positive", i)
+		}
+		if seen[string(key)] {
+			return nil, 0, fmt.Errorf("validator %d: duplicate public key", i)
+		}
+		if total+v.Stake < total {
+			return nil, 0, errors.New("total stake overflows")
+		}
+		seen[string(key)] = true
+		total += v.Stake
+		set = append(set, stakeValidator{key: key, stake: v.Stake})
+	}
+	return set, total, nil
+}
+
+// validatorSetHash returns the SHA-256 of the validators' public keys
+// and stakes, in order, to which epoch summaries commit. It assumes the
+// set passed parseValidators.
+func validatorSetHash(validators []Validator) []byte {
+	h := sha256.New()
+	for _, v := range validators {
+		key, _ := hex.DecodeString(v.PublicKey)
+		h.Write(key)
+		h.Write(binary.LittleEndian.AppendUint64(nil, v.Stake))
+	}
+	return h.Sum(nil)
+}
+
+// StakeEngine seals blocks by having the validator scheduled for each slot
+// sign them.
+type StakeEngine struct {
+	validators  []stakeValidator
+	total       uint64
+	seed        []byte
+	genesisTime int64
+	slotSeconds int64
+	key         *Wallet
+
+	clock Clock // tells the slot in progress
+}
+
+// newStakeEngine returns the proof-of-stake engine of the chain starting
+// at genesis, with slots of slotSeconds. key signs the blocks this node
+// proposes and may be nil.
+func newStakeEngine(validators []Validator, genesis *Block, genesisTime, slotSeconds int64, key *Wallet) (*StakeEngine, error) {
+	set, total, err := parseValidators(validators)
+	if err != nil {
+		return nil, err
+	}
+	if slotSeconds <= 0 {
+		return nil, errors.New("slot duration must be positive")
+	}
+	return &StakeEngine{validators: set, total: total, seed: genesis.Hash, genesisTime: genesisTime, slotSeconds: slotSeconds, key: key, clock: SystemClock}, nil
+}
+
+func (e *StakeEngine) Name() string { return ConsensusPoS }
+
+func (e *StakeEngine) MinDifficulty() int { return 0 }
+
+// slotOf returns the slot starting at timestamp, or false if none does.
+func (e *StakeEngine) slotOf(timestamp int64) (uint64, bool) {
+	offset := timestamp - e.genesisTime
+	if offset < 0 || offset%e.slotSeconds != 0 {
+		return 0, false
+	}
+	return uint64(offset / e.slotSeconds), true
+}
+
+// currentSlot returns the slot in progress.
+func (e *StakeEngine) currentSlot() uint64 {
+	offset := e.clock.Now().Unix() - e.genesisTime
+	if offset < 0 {
+		return 0
+	}
+	return uint64(offset / e.slotSeconds)
+}
+
+// proposer returns the validator scheduled to propose in slot.
+func (e *StakeEngine) proposer(slot uint64) ed25519.PublicKey {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], slot)
+	h := sha256.New()
+	h.Write(e.seed)
+	h.Write(buf[:])
+	// The modulo bias is below total/2^64, negligible for any real stake
+	r := binary.BigEndian.Uint64(h.Sum(nil)) % e.total
+	for _, v := range e.validators {
+		if r < v.stake {
+			return v.key
+		}
+		r -= v.stake
+	}
+	panic("unreachable: the stakes add up to the total")
+}
+
+// Prepare stamps block with the start of the next slot after the tip that
+// this node's key is scheduled for.
+func (e *StakeEngine) Prepare(chain []*Block, block *Block) error {
+	if e.key == nil {
+		return errors.New("proof-of-stake blocks need a validator key")
+	}
+	prevSlot, ok := e.slotOf(chain[len(chain)-1].Timestamp)
+	if !ok {
+		return fmt.Errorf("%w: the tip is not at the start of a slot", ErrSlot)
+	}
+	slot := max(prevSlot+1, e.currentSlot())
+	for n := 0; !e.key.PublicKey.Equal(e.proposer(slot)); n++ {
+		if n == maxSlotSearch {
+			return fmt.Errorf("key %x is not scheduled in the next %d slots; is it a validator?", e.key.PublicKey, maxSlotSearch)
+		}
+		slot++
+	}
+	e.stamp(block, slot)
+	return nil
+}
+
+// stamp gives block the timestamp of slot and clears the proof-of-work
+// fields.
+func (e *StakeEngine) stamp(block *Block, slot uint64) {
+	block.Timestamp = e.genesisTime + int64(slot)*e.slotSeconds
+	block.Bits, block.Nonce, block.ExtraNonce = 0, 0, 0
+}
+
+// Seal waits for the slot block was prepared for to begin, then signs it.
+func (e *StakeEngine) Seal(ctx context.Context, block *Block) (*Block, error) {
+	if e.key == nil {
+		return nil, errors.New("proof-of-stake blocks need a validator key")
+	}
+	if _, ok := e.slotOf(block.Timestamp); !ok {
+		return nil, fmt.Errorf("%w: block was not prepared for a slot", ErrSlot)
+	}
+	if wait := time.Unix(block.Timestamp, 0).Sub(e.clock.Now()); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	block.Hash = calculateHash(block)
+	block.Signature = e.key.Sign(block.Hash)
+	return block, nil
+}
+
+// VerifySeal checks that block has no target, starts a slot after prev's
+// that has begun and is signed by that slot's proposer.
+func (e *StakeEngine) VerifySeal(prev, block *Block) error {
+	if block.Timestamp <= prev.Timestamp {
+		return &BlockValidationError{Index: block.Index, Err: fmt.Errorf("%w: block is not later than its parent", ErrSlot)}
+	}
+	if block.Bits != 0 {
+		return &BlockValidationError{Index: block.Index, Err: fmt.Errorf("%w: proof-of-stake blocks have no target", ErrDifficultyBits)}
+	}
+	slot, ok := e.slotOf(block.Timestamp)
+	if !ok {
+		return &BlockValidationError{Index: block.Index, Err: fmt.Errorf("%w: timestamp %d does not start a slot", ErrSlot, block.Timestamp)}
+	}
+	if current := e.currentSlot(); slot > current {
+		return &BlockValidationError{Index: block.Index, Err: fmt.Errorf("%w: slot %d has not begun (now %d)", ErrSlot, slot, current)}
+	}
+
+	proposer := e.proposer(slot)
+	if Verify(proposer, block.Hash, block.Signature) {
+		return nil
+	}
+	for _, v := range e.validators {
+		if Verify(v.key, block.Hash, block.Signature) {
+			return &BlockValidationError{Index: block.Index, Err: fmt.Errorf("%w: signed by %x, slot %d belongs to %x", ErrWrongProposer, v.key, slot, proposer)}
+		}
+	}
+	return &BlockValidationError{Index: block.Index, Err: ErrBadSignature}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestStake returns a proof-of-stake chain's params, genesis block and
+// validator wallets, with stakes 1, 2 and 3 and two-second slots.
+func newTestStake(t *testing.T) (*ChainParams, *Block, []*Wallet) {
+	t.Helper()
+	params, _ := networkParams("regtest")
+	params.Consensus = ConsensusPoS
+	params.Features = []string{FeaturePoS}
+	params.BlockInterval = 2
+	var wallets []*Wallet
+	for i := range 3 {
+		seed := make([]byte, 32)
+		seed[0] = byte(i + 1)
+		w, err := walletFromSeed(seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wallets = append(wallets, w)
+		params.Validators = append(params.Validators, Validator{PublicKey: hex.EncodeToString(w.PublicKey), Stake: uint64(i + 1)})
+	}
+	if err := params.check(); err != nil {
+		t.Fatal(err)
+	}
+	genesis, err := params.genesisBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &params, genesis, wallets
+}
+
+// stakeChain seals n blocks after genesis, each in the first free slot
+// of whichever validator is scheduled.
+func stakeChain(t *testing.T, params *ChainParams, genesis *Block, wallets []*Wallet, n int) []*Block {
+	t.Helper()
+	engines := make(map[string]*StakeEngine)
+	for _, w := range wallets {
+		e, err := params.engine(genesis, w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		engines[string(w.PublicKey)] = e.(*StakeEngine)
+	}
+	chain := []*Block{genesis}
+	for slot := uint64(1); len(chain) <= n; slot++ {
+		e := engines[string(engines[string(wallets[0].PublicKey)].proposer(slot))]
+		block := nextCandidate(nil, chain, fmt.Sprintf("Block %d", len(chain)), 0)
+		e.stamp(block, slot)
+		if _, err := e.Seal(context.Background(), block); err != nil {
+			t.Fatal(err)
+		}
+		chain = append(chain, block)
+	}
+	return chain
+}
+
+func TestStakeEngine(t *testing.T) {
+	params, genesis, wallets := newTestStake(t)
+	chain := stakeChain(t, params, genesis, wallets, 20)
+	verifier, err := params.engine(genesis, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateChainWith(chain, verifier); err != nil {
+		t.Fatalf("valid chain: %v", err)
+	}
+	decoded, err := unmarshalChainProto(marshalChainProto(chain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateChainWith(decoded, verifier); err != nil {
+		t.Fatalf("signatures lost in protobuf: %v", err)
+	}
+	if err := validateChain(chain, 1); err == nil {
+		t.Error("a proof-of-stake chain passed proof-of-work validation")
+	}
+
+	check := func(name string, want error, tamper func(b *Block)) {
+		t.Helper()
+		bad := make([]*Block, len(chain))
+		copy(bad, chain)
+		b := *chain[5]
+		tamper(&b)
+		bad[5] = &b
+		if err := validateChainWith(bad, verifier); !errors.Is(err, want) {
+			t.Errorf("%s: expected %v, got %v", name, want, err)
+		}
+	}
+	check("changed data", ErrBadSignature, func(b *Block) {
+		b.Data = []byte("forged")
+		b.MerkleRoot = dataMerkleRoot(b.HashAlgo, b.Data)
+		b.Hash = calculateHash(b)
+	})
+	check("missing signature", ErrBadSignature, func(b *Block) { b.Signature = nil })
+	check("other validator", ErrWrongProposer, func(b *Block) {
+		e := verifier.(*StakeEngine)
+		slot, _ := e.slotOf(b.Timestamp)
+		for _, w := range wallets {
+			if !w.PublicKey.Equal(e.proposer(slot)) {
+				b.Signature = w.Sign(b.Hash)
+				return
+			}
+		}
+	})
+	check("target", ErrDifficultyBits, func(b *Block) {
+		b.Bits = difficultyToCompact(1)
+		b.Hash = calculateHash(b)
+	})
+	check("timestamp inside a slot", ErrSlot, func(b *Block) {
+		b.Timestamp++
+		b.Hash = calculateHash(b)
+	})
+	check("slot not after its parent", ErrSlot, func(b *Block) {
+		b.Timestamp = chain[4].Timestamp
+		b.Hash = calculateHash(b)
+	})
+
+	// Blocks from slots that have not begun are refused
+	late := verifier.(*StakeEngine)
+	late.clock = NewSteppingClock(time.Unix(chain[10].Timestamp, 0), 0)
+	if err := validateChainWith(chain, late); !errors.Is(err, ErrSlot) {
+		t.Errorf("expected a future slot to be refused, got %v", err)
+	}
+}
+
+// TestStakeCheckpoints checks that a checkpoint buries the seals of
+// proof-of-stake blocks under it as it does proof-of-work ones.
+func TestStakeCheckpoints(t *testing.T) {
+	t.Cleanup(func() { chainCheckpoints = nil })
+	params, genesis, wallets := newTestStake(t)
+	chain := stakeChain(t, params, genesis, wallets, 4)
+	verifier, err := params.engine(genesis, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The signature is not hashed, so the block still links
+	unsigned := *chain[1]
+	unsigned.Signature = nil
+	bad := append([]*Block{chain[0], &unsigned}, chain[2:]...)
+	if err := validateChainWith(bad, verifier); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("unsigned block: expected %v, got %v", ErrBadSignature, err)
+	}
+
+	chainCheckpoints = map[int][]byte{2: chain[2].Hash}
+	if err := validateChainWith(bad, verifier); err != nil {
+		t.Errorf("unsigned block under a checkpoint: %v", err)
+	}
+	if report := validateChainReportFrom(bad, verifier, 1); len(report.Problems) != 0 {
+		t.Errorf("report of an unsigned block under a checkpoint: %v", report.Problems)
+	}
+	if chosen, err := ResolveConflict(chain, bad, verifier); err != nil || len(chosen) != len(chain) {
+		t.Errorf("resolving with the stake engine: %d blocks, %v", len(chosen), err)
+	}
+}
+
+// TestStakeServer checks that a node validates submitted blocks and its
+// stored chain with the chain's engine rather than proof-of-work.
+func TestStakeServer(t *testing.T) {
+	params, genesis, wallets := newTestStake(t)
+	chain := stakeChain(t, params, genesis, wallets, 6)
+	verifier, err := params.engine(genesis, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newRPCServer(append([]*Block{}, chain[:5]...), 0)
+	s.useEngine(verifier)
+	submit := func(b *Block) any {
+		raw, _ := json.Marshal(b)
+		return s.submitBlock(context.Background(), raw)
+	}
+	unsigned := *chain[5]
+	unsigned.Signature = nil
+	if reason := submit(&unsigned); reason == nil {
+		t.Error("unsigned block accepted")
+	}
+	if reason := submit(chain[5]); reason != nil {
+		t.Fatalf("signed block refused: %v", reason)
+	}
+
+	dataDir := t.TempDir()
+	if err := saveStore(dataDir, s.snapshot()); err != nil {
+		t.Fatal(err)
+	}
+	loaded, report, err := loadStoredChain(dataDir, verifier, true)
+	if err != nil || !report.Valid() || len(loaded) != 6 {
+		t.Fatalf("load: %d blocks, %v, %v", len(loaded), report.Err(), err)
+	}
+	if r := selfCheck(loaded, verifier, nil, nil, selfCheckSamples); !r.OK() {
+		t.Errorf("self-check: %v", r.Err())
+	}
+	if _, report, _ := loadStoredChain(dataDir, &PoWEngine{Difficulty: 1}, true); report.Valid() {
+		t.Error("the stored proof-of-stake chain passed proof-of-work validation")
+	}
+}
+
+// TestStakeRoundTrip mines a proof-of-stake chain with `mine`, then
+// validates, imports and loads it with the same params.
+func TestStakeRoundTrip(t *testing.T) {
+	params, genesis, wallets := newTestStake(t)
+	params.Validators = params.Validators[:1]
+	params.BlockInterval = 1
+	paramsPath := writeStakeParams(t, params)
+	dir := t.TempDir()
+	keystore := filepath.Join(dir, "validator.json")
+	if err := saveKeystore(wallets[0], keystore, "pass"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("WALLET_PASSPHRASE", "pass")
+
+	file := filepath.Join(dir, "chain.json")
+	if code := runMine([]string{"-params", paramsPath, "-validator-key", keystore, "-blocks", "2", "-output", file}); code != exitOK {
+		t.Fatalf("mine: exit code %d", code)
+	}
+	if code := runValidate([]string{"-file", file, "-params", paramsPath}); code != exitOK {
+		t.Errorf("validate -params: exit code %d", code)
+	}
+	if code := runValidate([]string{"-file", file, "-difficulty", "1"}); code != exitValidation {
+		t.Errorf("validate as proof-of-work: expected exit code %d, got %d", exitValidation, code)
+	}
+	dataDir := filepath.Join(dir, "data")
+	if code := runImport([]string{"-file", file, "-datadir", dataDir, "-params", paramsPath}); code != exitOK {
+		t.Fatalf("import -params: exit code %d", code)
+	}
+	verifier, err := params.engine(genesis, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, report, err := loadStoredChain(dataDir, verifier, true)
+	if err != nil || !report.Valid() || len(chain) != 3 {
+		t.Fatalf("imported chain: %d blocks, %v, %v", len(chain), report.Err(), err)
+	}
+}
+
+// TestStakeProposers checks that proposers are drawn in proportion to
+// their stake.
+func TestStakeProposers(t *testing.T) {
+	params, genesis, wallets := newTestStake(t)
+	engine, err := params.engine(genesis, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	const slots = 60000
+	for slot := range uint64(slots) {
+		counts[string(engine.(*StakeEngine).proposer(slot))]++
+	}
+	for i, w := range wallets {
+		want := slots * (i + 1) / 6
+		if got := counts[string(w.PublicKey)]; got < want*9/10 || got > want*11/10 {
+			t.Errorf("validator %d with stake %d proposed %d slots, want about %d", i, i+1, got, want)
+		}
+	}
+}
+
+func TestStakeSeal(t *testing.T) {
+	params, genesis, wallets := newTestStake(t)
+	params.Validators = params.Validators[:1]
+	e, err := params.engine(genesis, wallets[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := []*Block{genesis}
+	// The clock stands at the start of the slot after the tip
+	e.(*StakeEngine).clock = ClockFunc(func() time.Time { return time.Unix(chain[len(chain)-1].Timestamp+params.BlockInterval, 0) })
+	for i := 1; i <= 2; i++ {
+		block, err := sealNext(context.Background(), e, chain, "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		chain = append(chain, block)
+	}
+	if err := validateChainWith(chain, e); err != nil {
+		t.Fatalf("sealed chain: %v", err)
+	}
+
+	if chain[2].Timestamp-chain[1].Timestamp != params.BlockInterval {
+		t.Errorf("blocks sealed %d seconds apart, want one slot", chain[2].Timestamp-chain[1].Timestamp)
+	}
+
+	outsider, _ := params.engine(genesis, wallets[1])
+	if _, err := sealNext(context.Background(), outsider, chain, "x"); err == nil {
+		t.Error("a key outside the validator set sealed a block")
+	}
+	none, _ := params.engine(genesis, nil)
+	if _, err := sealNext(context.Background(), none, chain, "x"); err == nil {
+		t.Error("sealed a block without a key")
+	}
+
+	// A slot in the future is waited for until the context ends
+	e.(*StakeEngine).clock = NewSteppingClock(time.Unix(chain[2].Timestamp, 0).Add(-time.Hour), 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := sealNext(ctx, e, chain, "x"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the seal to wait for its slot, got %v", err)
+	}
+}
+
+func TestStakeParams(t *testing.T) {
+	params, _, _ := newTestStake(t)
+	for name, mutate := range map[string]func(p *ChainParams){
+		"no validators":   func(p *ChainParams) { p.Validators = nil },
+		"zero stake":      func(p *ChainParams) { p.Validators[0].Stake = 0 },
+		"bad key":         func(p *ChainParams) { p.Validators[1].PublicKey = "abcd" },
+		"duplicate key":   func(p *ChainParams) { p.Validators[2].PublicKey = p.Validators[0].PublicKey },
+		"unknown engine":  func(p *ChainParams) { p.Consensus = "poa" },
+		"pow with stakes": func(p *ChainParams) { p.Consensus = ConsensusPoW },
+	} {
+		p := *params
+		p.Validators = append([]Validator{}, params.Validators...)
+		mutate(&p)
+		if err := p.check(); err == nil {
+			t.Errorf("%s: params accepted", name)
+		}
+	}
+
+	if code := runDaemon([]string{"-datadir", t.TempDir(), "-params", writeStakeParams(t, params), "-stratum-addr", "127.0.0.1:0"}); code != exitConfig {
+		t.Errorf("stratum on a proof-of-stake chain: expected exit code %d, got %d", exitConfig, code)
+	}
+}
+
+func writeStakeParams(t *testing.T, params *ChainParams) string {
+	t.Helper()
+	raw, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "pos.json")
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
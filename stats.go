@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// ChainStats describes the blocks From through To of a chain.
+type ChainStats struct {
+	From        int           `json:"from"`
+	To          int           `json:"to"`
+	Blocks      int           `json:"blocks"`
+	AvgInterval time.Duration `json:"avg_interval_ns"`
+	// TotalWork is the expected number of hashes needed to mine the range.
+	TotalWork *big.Int `json:"total_work"`
+	// HashRate estimates the hashes per second spent on the range from the
+	// work of its blocks after the first and the time they took.
+	HashRate   float64           `json:"hash_rate"`
+	Sizes      SizeDistribution  `json:"block_sizes"`
+	Difficulty []DifficultyPoint `json:"difficulty_history"`
+}
+
+// SizeDistribution summarizes serialized block sizes in bytes.
+type SizeDistribution struct {
+	Min    int     `json:"min"`
+	Median int     `json:"median"`
+	P90    int     `json:"p90"`
+	Max    int     `json:"max"`
+	Mean   float64 `json:"mean"`
+}
+
+// DifficultyPoint is a block whose target differs from its predecessor's.
+type DifficultyPoint struct {
+	Index      int     `json:"index"`
+	Bits       uint32  `json:"bits"`
+	Difficulty float64 `json:"difficulty"`
+}
+
+// computeChainStats computes statistics over chain[from:to+1]. Blocks
+// without a recorded target are taken to be mined at legacyDifficulty.
+func computeChainStats(chain []*Block, from, to int, legacyDifficulty int) (*ChainStats, error) {
+	if from < 0 || to >= len(chain) || from > to {
+		return nil, fmt.Errorf("invalid block range %d-%d for a chain of %d blocks", from, to, len(chain))
+	}
+	blocks := chain[from : to+1]
+	stats := &ChainStats{From: from, To: to, Blocks: len(blocks), TotalWork: new(big.Int)}
+
+	sizes := make([]int, len(blocks))
+	total := 0
+	laterWork := new(big.Int)
+	lastBits := ^uint32(0)
+	for i, block := range blocks {
+		sizes[i] = blockSize(block)
+		total += sizes[i]
+
+		// The genesis block is not mined, so it has no work or difficulty
+		if block.Index == 0 {
+			continue
+		}
+		work := blockWork(block, legacyDifficulty)
+		stats.TotalWork.Add(stats.TotalWork, work)
+		if i > 0 {
+			laterWork.Add(laterWork, work)
+		}
+
+		if block.Bits != lastBits {
+			stats.Difficulty = append(stats.Difficulty, DifficultyPoint{
+				Index:      block.Index,
+				Bits:       block.Bits,
+				Difficulty: targetToDifficulty(blockTarget(block, legacyDifficulty)),
+			})
+			lastBits = block.Bits
+		}
+	}
+
+	if span := blocks[len(blocks)-1].Timestamp - blocks[0].Timestamp; span > 0 {
+		stats.AvgInterval = time.Duration(span) * time.Second / time.Duration(len(blocks)-1)
+		work, _ := new(big.Float).SetInt(laterWork).Float64()
+		stats.HashRate = work / float64(span)
+	}
+
+	slices.Sort(sizes)
+	stats.Sizes = SizeDistribution{
+		Min:    sizes[0],
+		Median: sizes[len(sizes)/2],
+		P90:    sizes[len(sizes)*9/10],
+		Max:    sizes[len(sizes)-1],
+		Mean:   float64(total) / float64(len(sizes)),
+	}
+	return stats, nil
+}
+
+// blockSize returns the serialized size of a block. A block with a Merkle
+// root serializes its header only, so its data is added.
+func blockSize(block *Block) int {
+	size := len(serializeBlock(block))
+	if block.MerkleRoot != nil {
+		size += len(block.Data)
+	}
+	return size
+}
+
+// blockTarget returns the target the block was mined against. Blocks
+// without a recorded target were mined at legacyDifficulty.
+func blockTarget(block *Block, legacyDifficulty int) *big.Int {
+	if block.Bits == 0 {
+		return difficultyToTarget(legacyDifficulty)
+	}
+	return compactToTarget(block.Bits)
+}
+
+// blockWork returns the expected number of hashes needed to meet the
+// block's target, 2^256 / target. A legacy block redacted elsewhere counts
+// no work, since neither its hash nor its proof-of-work can be checked.
+func blockWork(block *Block, legacyDifficulty int) *big.Int {
+	if block.Redaction != nil && !block.Redaction.trusted && block.MerkleRoot == nil {
+		return new(big.Int)
+	}
+	target := blockTarget(block, legacyDifficulty)
+	if target.Sign() <= 0 {
+		return new(big.Int)
+	}
+	work := new(big.Int).Lsh(big.NewInt(1), 256)
+	return work.Div(work, target)
+}
+
+// Print writes the statistics as a table.
+func (s *ChainStats) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Blocks:\t%d-%d (%d blocks)\n", s.From, s.To, s.Blocks)
+	fmt.Fprintf(tw, "Average interval:\t%v\n", s.AvgInterval)
+	fmt.Fprintf(tw, "Total work:\t%s hashes\n", s.TotalWork)
+	fmt.Fprintf(tw, "Estimated hash rate:\t%.0f H/s\n", s.HashRate)
+	fmt.Fprintf(tw, "Block size:\tmin %d, median %d, p90 %d, max %d, mean %.1f bytes\n",
+		s.Sizes.Min, s.Sizes.Median, s.Sizes.P90, s.Sizes.Max, s.Sizes.Mean)
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nDifficulty history:\n")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "INDEX\tBITS\tDIFFICULTY\n")
+	for _, p := range s.Difficulty {
+		fmt.Fprintf(tw, "%d\t%08x\t%.2f\n", p.Index, p.Bits, p.Difficulty)
+	}
+	tw.Flush()
+}
+
+// runStats implements the stats subcommand.
+func runStats(args []string) int {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	file := fs.String("file", "", "chain file to analyse")
+	dataDir := fs.String("datadir", "", "data directory holding an imported chain (instead of -file)")
+	from := fs.Int("from", 0, "first block of the range")
+	to := fs.Int("to", -1, "last block of the range (default the tip)")
+	difficulty := fs.Int("difficulty", 4, "difficulty of blocks without a recorded target")
+	format := fs.String("format", "table", "output format: table or json")
+	if err := fs.Parse(args); err != nil {
+		return flagError(err)
+	}
+	path := *file
+	if path == "" && *dataDir != "" {
+		path = chainStorePath(*dataDir)
+	}
+	if path == "" || (*format != "table" && *format != "json") {
+		return usage("Usage: blockchain stats (-file chain.json | -datadir dir) [-from n] [-to n] [-format table|json]")
+	}
+
+	chain, err := readChainFile(path, DecodePolicy{TrustRedactions: *file == ""})
+	if errors.Is(err, os.ErrNotExist) && *file == "" {
+		return failf(exitStorage, "no chain in %s; run 'blockchain import' first", *dataDir)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if *to < 0 {
+		*to = len(chain) - 1
+	}
+	stats, err := computeChainStats(chain, *from, *to, *difficulty)
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+
+	if *format == "json" {
+		data, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return fail(err)
+		}
+		fmt.Println(string(data))
+		return 0
+	}
+	stats.Print(os.Stdout)
+	return 0
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestChainStats checks the statistics of a chain with known timestamps
+// and targets.
+func TestChainStats(t *testing.T) {
+	chain := makeBlockchain(5, 1)
+	for i, block := range chain {
+		block.Timestamp = int64(100 + 10*i)
+	}
+	// Raise the target of the last two blocks; stats do not validate
+	for _, block := range chain[3:] {
+		block.Bits = difficultyToCompact(2)
+	}
+
+	stats, err := computeChainStats(chain, 0, 4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Blocks != 5 || stats.AvgInterval != 10*time.Second {
+		t.Errorf("got %d blocks, interval %v", stats.Blocks, stats.AvgInterval)
+	}
+	// Two blocks at 16 expected hashes and two at 256
+	if want := big.NewInt(2*16 + 2*256); stats.TotalWork.Cmp(want) != 0 {
+		t.Errorf("total work %s, want %s", stats.TotalWork, want)
+	}
+	if want := float64(2*16+2*256) / 40; stats.HashRate != want {
+		t.Errorf("hash rate %f, want %f", stats.HashRate, want)
+	}
+	if len(stats.Difficulty) != 2 || stats.Difficulty[1].Index != 3 || stats.Difficulty[1].Difficulty != 2 {
+		t.Errorf("unexpected difficulty history %+v", stats.Difficulty)
+	}
+	if s := stats.Sizes; s.Min > s.Median || s.Median > s.Max || s.Min != len(serializeBlock(chain[0])) {
+		t.Errorf("unexpected size distribution %+v", s)
+	}
+
+	sub, err := computeChainStats(chain, 3, 4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.TotalWork.Int64() != 512 || sub.HashRate != 25.6 {
+		t.Errorf("range 3-4: work %s, rate %f", sub.TotalWork, sub.HashRate)
+	}
+	if _, err := computeChainStats(chain, 2, 5, 1); err == nil {
+		t.Error("expected an out of range block to be rejected")
+	}
+}
+
+// TestRunStats checks the exit codes of the stats subcommand.
+func TestRunStats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.jsonl")
+	if err := writeChainFile(makeBlockchain(3, 1), path); err != nil {
+		t.Fatal(err)
+	}
+	if code := runStats([]string{"-file", path, "-format", "json"}); code != 0 {
+		t.Errorf("stats: expected exit code 0, got %d", code)
+	}
+	if code := runStats([]string{"-file", path, "-from", "1", "-to", "7"}); code != exitConfig {
+		t.Errorf("stats of missing blocks: expected exit code %d, got %d", exitConfig, code)
+	}
+	if code := runStats([]string{"-file", path, "-format", "xml"}); code != 2 {
+		t.Errorf("stats with unknown format: expected exit code 2, got %d", code)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// stratumMaxLine bounds one message from a stratum client.
+const stratumMaxLine = 16 << 10
+
+// stratumJobsPerConn is how many of its most recent jobs a stratum client
+// can still submit solutions for.
+const stratumJobsPerConn = 16
+
+// Stratum error codes, as used by Stratum v1 pools.
+const (
+	stratumOther         = 20
+	stratumJobNotFound   = 21
+	stratumLowDifficulty = 23
+)
+
+// stratumRequest is one line from a stratum client.
+type stratumRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// stratumResponse answers a stratum request.
+type stratumResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result any             `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// stratumNotification pushes a job to a subscribed client.
+type stratumNotification struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params []any           `json:"params"`
+}
+
+// stratumJob is the work an external miner hashes: the serialized
+// candidate block. Its hash, read as a big-endian integer, must be below
+// target once the nonce is written at nonce_offset as 8 little-endian
+// bytes. Clean is set on jobs pushed for a new tip, whose earlier jobs can
+// no longer produce a block.
+type stratumJob struct {
+	JobID       string `json:"job_id"`
+	Height      int    `json:"height"`
+	Header      string `json:"header"`
+	NonceOffset int    `json:"nonce_offset"`
+	Target      string `json:"target"`
+	Algo        string `json:"algo"`
+	Clean       bool   `json:"clean_jobs"`
+}
+
+// stratumServer hands out block templates to external miners over
+// line-delimited JSON on TCP and appends the blocks they solve:
+//
+//	mining.subscribe  []                 the current job; new tips are pushed as mining.notify
+//	mining.getwork    []                 a fresh job
+//	mining.submit     [job_id, nonce]    true, or an error saying why the block was refused
+//
+// Each job carries its own extra nonce, so no two miners, or jobs, hash
+// the same header.
+type stratumServer struct {
+	server     *rpcServer
+	difficulty int
+	conns      atomic.Uint64
+}
+
+func newStratumServer(server *rpcServer, difficulty int) *stratumServer {
+	return &stratumServer{server: server, difficulty: difficulty}
+}
+
+// serve accepts stratum clients on ln until ctx is cancelled, then closes
+// their connections and waits for them.
+func (st *stratumServer) serve(ctx context.Context, ln net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		c := &stratumConn{
+			st:         st,
+			conn:       conn,
+			enc:        json.NewEncoder(conn),
+			jobs:       make(map[string]*Block),
+			extraNonce: st.conns.Add(1)<<32 | 1,
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.serve(ctx)
+		}()
+	}
+}
+
+// stratumConn is the state of one stratum client.
+type stratumConn struct {
+	st      *stratumServer
+	conn    net.Conn
+	writeMu sync.Mutex
+	enc     *json.Encoder
+
+	mu         sync.Mutex
+	jobs       map[string]*Block
+	order      []string // job IDs, oldest first
+	extraNonce uint64   // of the next job; the high 32 bits identify the connection
+	subscribed bool
+}
+
+func (c *stratumConn) serve(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { c.conn.Close() })
+	defer stop()
+	defer c.conn.Close()
+
+	logger := c.st.server.logger
+	remote := slog.String("remote_addr", c.conn.RemoteAddr().String())
+	logger.Info("stratum_connected", remote)
+	defer logger.Info("stratum_disconnected", remote)
+
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 0, 4096), stratumMaxLine)
+	for scanner.Scan() {
+		var req stratumRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil || req.Method == "" {
+			c.send(stratumResponse{ID: json.RawMessage("null"), Error: &rpcError{Code: stratumOther, Message: "invalid request"}})
+			continue
+		}
+		result, err := c.call(ctx, req)
+		resp := stratumResponse{ID: req.ID, Result: result}
+		if err != nil {
+			var rerr *rpcError
+			if !errors.As(err, &rerr) {
+				rerr = &rpcError{Code: stratumOther, Message: err.Error()}
+			}
+			resp.Result, resp.Error = nil, rerr
+		}
+		if c.send(resp) != nil {
+			return
+		}
+	}
+}
+
+// send writes one message; notifications and responses may race.
+func (c *stratumConn) send(v any) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.enc.Encode(v)
+}
+
+func (c *stratumConn) call(ctx context.Context, req stratumRequest) (any, error) {
+	switch req.Method {
+	case "mining.subscribe":
+		c.mu.Lock()
+		already := c.subscribed
+		c.subscribed = true
+		c.mu.Unlock()
+		if !already {
+			go c.notify(ctx)
+		}
+		return c.newJob(false), nil
+
+	case "mining.getwork":
+		return c.newJob(false), nil
+
+	case "mining.submit":
+		var jobID string
+		var nonce uint64
+		if err := rpcArgs(req.Params, &jobID, &nonce); err != nil {
+			return nil, err
+		}
+		return c.submit(ctx, jobID, nonce)
+	}
+	return nil, &rpcError{Code: stratumOther, Message: fmt.Sprintf("method %q not found", req.Method)}
+}
+
+// notify pushes a clean job each time the tip changes.
+func (c *stratumConn) notify(ctx context.Context) {
+	for {
+		_, tipChanged := c.st.server.work()
+		select {
+		case <-tipChanged:
+		case <-ctx.Done():
+			return
+		}
+		if c.send(stratumNotification{ID: json.RawMessage("null"), Method: "mining.notify", Params: []any{c.newJob(true)}}) != nil {
+			return
+		}
+	}
+}
+
+// newJob builds a candidate on the current tip and remembers it for
+// submit, forgetting the oldest job beyond stratumJobsPerConn.
+func (c *stratumConn) newJob(clean bool) *stratumJob {
+	chain := c.st.server.snapshot()
+	candidate := nextCandidate(SystemClock, chain, fmt.Sprintf("Block %d", len(chain)), c.st.difficulty)
+
+	c.mu.Lock()
+	// Before version 5 is active there is no extra nonce, and every job
+	// of the height has the same search space
+	id := fmt.Sprintf("%016x", c.extraNonce)
+	if formatActive(blockFormatV5, candidate.Index) {
+		candidate.ExtraNonce = c.extraNonce
+	}
+	c.extraNonce++
+	c.jobs[id] = candidate
+	c.order = append(c.order, id)
+	if len(c.order) > stratumJobsPerConn {
+		delete(c.jobs, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.mu.Unlock()
+
+	h, _ := hasherByID(candidate.HashAlgo)
+	target := blockTarget(candidate, c.st.difficulty).FillBytes(make([]byte, 32))
+	return &stratumJob{
+		JobID:       id,
+		Height:      candidate.Index,
+		Header:      hex.EncodeToString(serializeBlock(candidate)),
+		NonceOffset: powNonceOffset,
+		Target:      hex.EncodeToString(target),
+		Algo:        h.Name(),
+		Clean:       clean,
+	}
+}
+
+// submit appends the block a job's candidate becomes with nonce.
+func (c *stratumConn) submit(ctx context.Context, jobID string, nonce uint64) (bool, error) {
+	c.mu.Lock()
+	candidate, ok := c.jobs[jobID]
+	c.mu.Unlock()
+	if !ok {
+		return false, &rpcError{Code: stratumJobNotFound, Message: "job not found"}
+	}
+
+	block := *candidate
+	block.Nonce = nonce
+	block.Hash = calculateHash(&block)
+	if !hashMeetsTarget(block.Hash, blockTarget(&block, c.st.difficulty)) {
+		return false, &rpcError{Code: stratumLowDifficulty, Message: "high-hash"}
+	}
+	switch reason := c.st.server.addBlock(ctx, &block); reason {
+	case "":
+		return true, nil
+	case "bad-prevblk", "bad-height", "duplicate", "inconclusive":
+		// Another miner extended the tip first
+		return false, &rpcError{Code: stratumJobNotFound, Message: "stale job: " + reason}
+	default:
+		return false, &rpcError{Code: stratumOther, Message: reason}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// stratumClient is the client side of a stratum connection in tests.
+type stratumClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	id   int
+}
+
+// call sends a request and returns its response, setting aside any
+// notifications that arrive first.
+func (c *stratumClient) call(method string, params ...any) (json.RawMessage, *rpcError, []stratumJob) {
+	c.t.Helper()
+	c.id++
+	if params == nil {
+		params = []any{}
+	}
+	msg, _ := json.Marshal(map[string]any{"id": c.id, "method": method, "params": params})
+	c.conn.Write(append(msg, '\n'))
+
+	var notified []stratumJob
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, err := c.r.ReadBytes('\n')
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		var resp struct {
+			ID     json.RawMessage
+			Method string
+			Params []stratumJob
+			Result json.RawMessage
+			Error  *rpcError
+		}
+		if err := json.Unmarshal(line, &resp); err != nil {
+			c.t.Fatalf("decode %q: %v", line, err)
+		}
+		if resp.Method == "mining.notify" {
+			notified = append(notified, resp.Params...)
+			continue
+		}
+		if string(resp.ID) != fmt.Sprint(c.id) {
+			c.t.Fatalf("response %s to request %d", resp.ID, c.id)
+		}
+		return resp.Result, resp.Error, notified
+	}
+}
+
+// solveJob searches the job's nonces the way an external miner would,
+// returning one that meets its target, or misses it when want is false.
+func solveJob(t *testing.T, job stratumJob, want bool) uint64 {
+	t.Helper()
+	header, _ := hex.DecodeString(job.Header)
+	target, _ := hex.DecodeString(job.Target)
+	for nonce := uint64(0); nonce < 1<<20; nonce++ {
+		binary.LittleEndian.PutUint64(header[job.NonceOffset:], nonce)
+		hash := sha256.Sum256(header)
+		if (bytes.Compare(hash[:], target) < 0) == want {
+			return nonce
+		}
+	}
+	t.Fatal("no nonce found")
+	return 0
+}
+
+// TestStratum serves jobs to an external miner and accepts its solution.
+func TestStratum(t *testing.T) {
+	server := newRPCServer([]*Block{newGenesisBlock(sha256Hasher{})}, 1)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- newStratumServer(server, 1).serve(ctx, ln) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := &stratumClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+
+	raw, rerr, _ := c.call("mining.subscribe")
+	var job stratumJob
+	if rerr != nil || json.Unmarshal(raw, &job) != nil || job.Height != 1 || job.Algo != "sha256" || job.NonceOffset != powNonceOffset {
+		t.Fatalf("subscribe: %s, %v", raw, rerr)
+	}
+	raw, _, _ = c.call("mining.getwork")
+	var other stratumJob
+	json.Unmarshal(raw, &other)
+	if other.JobID == job.JobID || other.Header == job.Header {
+		t.Error("two jobs share a header")
+	}
+
+	if _, rerr, _ := c.call("mining.submit", job.JobID, solveJob(t, job, false)); rerr == nil || rerr.Code != stratumLowDifficulty {
+		t.Errorf("expected a high hash to be refused, got %v", rerr)
+	}
+	if _, rerr, _ := c.call("mining.submit", "nope", 1); rerr == nil || rerr.Code != stratumJobNotFound {
+		t.Errorf("expected an unknown job to be refused, got %v", rerr)
+	}
+
+	nonce := solveJob(t, job, true)
+	raw, rerr, notified := c.call("mining.submit", job.JobID, nonce)
+	if rerr != nil || string(raw) != "true" {
+		t.Fatalf("submit: %s, %v", raw, rerr)
+	}
+	tip := server.tip()
+	if tip.Index != 1 || tip.Nonce != nonce || validateChain(server.snapshot(), 1) != nil {
+		t.Fatalf("solved block not appended: %+v", tip)
+	}
+
+	// The new tip is pushed, and the older job is now stale
+	if _, rerr, more := c.call("mining.submit", other.JobID, solveJob(t, other, true)); rerr == nil || rerr.Code != stratumJobNotFound {
+		t.Errorf("expected a stale job to be refused, got %v", rerr)
+	} else {
+		notified = append(notified, more...)
+	}
+	for i := 0; len(notified) == 0 && i < 100; i++ {
+		// The notification races the responses
+		time.Sleep(5 * time.Millisecond)
+		_, _, more := c.call("mining.getwork")
+		notified = append(notified, more...)
+	}
+	if len(notified) == 0 || notified[0].Height != 2 || !notified[0].Clean {
+		t.Errorf("expected a clean job for height 2, got %+v", notified)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"math/big"
+)
+
+// Targets express proof-of-work requirements as a 256-bit number: a block
+// hash, read as a big-endian integer, must be strictly below its target.
+// Blocks carry the target in Bitcoin's compact "bits" form, which allows far
+// finer steps than whole hex-digit difficulty.
+
+// difficultyToTarget returns the target equivalent to requiring difficulty
+// leading zero hex digits, i.e. 2^(256-4*difficulty). Out of range
+// difficulties yield a zero target that no hash can meet.
+func difficultyToTarget(difficulty int) *big.Int {
+	if difficulty < 0 || difficulty > 64 {
+		return new(big.Int)
+	}
+	return new(big.Int).Lsh(big.NewInt(1), uint(256-4*difficulty))
+}
+
+// targetToDifficulty returns the (fractional) number of leading zero hex
+// digits a target corresponds to, the inverse of difficultyToTarget.
+func targetToDifficulty(target *big.Int) float64 {
+	if target.Sign() <= 0 {
+		return math.Inf(1)
+	}
+	f, _ := new(big.Float).SetInt(target).Float64()
+	return (256 - math.Log2(f)) / 4
+}
+
+// difficultyToCompact returns the compact bits for a hex-digit difficulty.
+func difficultyToCompact(difficulty int) uint32 {
+	return targetToCompact(difficultyToTarget(difficulty))
+}
+
+// compactToTarget expands compact bits into a full target. The top byte is
+// the size of the target in bytes and the low 23 bits are its most
+// significant digits; bit 23 is a sign flag.
+func compactToTarget(bits uint32) *big.Int {
+	mantissa := bits & 0x007fffff
+	negative := bits&0x00800000 != 0
+	size := uint(bits >> 24)
+
+	var target *big.Int
+	if size <= 3 {
+		target = big.NewInt(int64(mantissa >> (8 * (3 - size))))
+	} else {
+		target = new(big.Int).Lsh(big.NewInt(int64(mantissa)), 8*(size-3))
+	}
+	if negative {
+		target.Neg(target)
+	}
+	return target
+}
+
+// targetToCompact encodes a target in compact form, keeping its three most
+// significant bytes. Precision below those bytes is truncated.
+func targetToCompact(target *big.Int) uint32 {
+	if target.Sign() == 0 {
+		return 0
+	}
+
+	abs := new(big.Int).Abs(target)
+	size := uint((abs.BitLen() + 7) / 8)
+
+	var mantissa uint32
+	if size <= 3 {
+		mantissa = uint32(abs.Uint64() << (8 * (3 - size)))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(abs, 8*(size-3)).Uint64())
+	}
+
+	// The sign bit is part of the mantissa, so shift it out of the way
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		size++
+	}
+
+	bits := uint32(size)<<24 | mantissa
+	if target.Sign() < 0 {
+		bits |= 0x00800000
+	}
+	return bits
+}
+
+// hashMeetsTarget reports whether the hash, read as a big-endian integer,
+// is strictly below the target.
+func hashMeetsTarget(hash []byte, target *big.Int) bool {
+	if target.Sign() <= 0 {
+		return false
+	}
+	return new(big.Int).SetBytes(hash).Cmp(target) < 0
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// TestCompactRoundTrip checks that compact encoding is the inverse of expansion.
+func TestCompactRoundTrip(t *testing.T) {
+	cases := []uint32{0x1d00ffff, 0x1b0404cb, 0x207fffff, 0x03123456, 0x02008000, 0x21010000}
+	for _, bits := range cases {
+		target := compactToTarget(bits)
+		if got := targetToCompact(target); got != bits {
+			t.Errorf("compact round trip of %08x produced %08x (target %x)", bits, got, target)
+		}
+	}
+
+	// The well-known Bitcoin genesis target
+	want, _ := new(big.Int).SetString("00000000ffff0000000000000000000000000000000000000000000000000000", 16)
+	if compactToTarget(0x1d00ffff).Cmp(want) != 0 {
+		t.Errorf("unexpected expansion of 0x1d00ffff: %x", compactToTarget(0x1d00ffff))
+	}
+}
+
+// TestDifficultyTargetEquivalence verifies that the target of a hex-digit
+// difficulty accepts exactly the hashes validateDifficulty accepts.
+func TestDifficultyTargetEquivalence(t *testing.T) {
+	for difficulty := 0; difficulty <= 8; difficulty++ {
+		target := compactToTarget(difficultyToCompact(difficulty))
+		if target.Cmp(difficultyToTarget(difficulty)) != 0 {
+			t.Fatalf("difficulty %d: compact encoding lost precision", difficulty)
+		}
+		if got := targetToDifficulty(target); got != float64(difficulty) {
+			t.Errorf("difficulty %d: targetToDifficulty returned %v", difficulty, got)
+		}
+
+		for i := 0; i < 200; i++ {
+			hash := calculateHash(&Block{Index: i, Data: []byte("equivalence"), Nonce: uint64(difficulty)})
+			// Force some leading zeros so the interesting boundary is exercised
+			for j := 0; j < difficulty/2 && j < len(hash); j++ {
+				if i%2 == 0 {
+					hash[j] = 0
+				}
+			}
+			if validateDifficulty(hash, difficulty) != hashMeetsTarget(hash, target) {
+				t.Fatalf("difficulty %d: hex and target checks disagree for %x", difficulty, hash)
+			}
+		}
+	}
+}
+
+// TestBitsCommittedInHash ensures the recorded target is part of the block hash
+// and that legacy blocks without bits keep their original hash.
+func TestBitsCommittedInHash(t *testing.T) {
+	legacy := &Block{Index: 1, Timestamp: 2, Data: []byte("bits"), PrevHash: []byte("prev"), Nonce: 3}
+	withBits := cloneBlock(*legacy)
+	withBits.Bits = difficultyToCompact(2)
+
+	if bytes.Equal(calculateHash(legacy), calculateHash(&withBits)) {
+		t.Error("changing bits did not change the block hash")
+	}
+	if serializeBlock(legacy)[0] != 0x01 {
+		t.Error("legacy blocks must keep the version 1 layout")
+	}
+
+	large := &Block{Data: bytes.Repeat([]byte("a"), 128*1024), Bits: 0x1f00ffff}
+	serialized := sha256.Sum256(serializeBlock(large))
+	if !bytes.Equal(calculateHashStreaming(large), serialized[:]) {
+		t.Error("streaming hash disagrees with serialized hash for version 2 blocks")
+	}
+}
+
+// TestValidateChain_BitsTooHard checks that a block whose hash misses its own recorded target is rejected.
+func TestValidateChain_BitsTooHard(t *testing.T) {
+	chain := makeBlockchain(3, 1)
+	if !isChainValidCached(chain, 1) {
+		t.Fatal("freshly mined chain should be valid")
+	}
+
+	// Claim a much harder target but only redo the work for the base difficulty
+	chain[2].Bits = difficultyToCompact(16)
+	hash, _, err := proofOfWork(context.Background(), chain[2], 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain[2].Hash = hash
+	if isChainValidCached(chain, 1) {
+		t.Error("expected block missing its recorded target to be rejected")
+	}
+}
+
+// TestValidateChain_BitsEasierThanSchedule checks that a block may record a
+// harder target than the chain's difficulty but not an easier one, even if
+// its hash meets both.
+func TestValidateChain_BitsEasierThanSchedule(t *testing.T) {
+	chain := makeBlockchain(3, 2)
+	harder := cloneBlock(*chain[2])
+	if err := validateChain(chain, 1); err != nil {
+		t.Fatalf("blocks mined harder than the schedule: %v", err)
+	}
+
+	for _, bits := range []uint32{difficultyToCompact(0), 0x20800000} {
+		block := cloneBlock(harder)
+		block.Bits = bits
+		hash, nonce, err := proofOfWork(context.Background(), &block, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.Hash, block.Nonce = hash, nonce
+		var verr *BlockValidationError
+		if err := validateChain(append(chain[:2:2], &block), 1); !errors.As(err, &verr) || verr.Index != 2 || !errors.Is(err, ErrDifficultyBits) {
+			t.Errorf("bits %08x: got %v", bits, err)
+		}
+	}
+
+	s := newRPCServer(chain[:2], 1)
+	easy := cloneBlock(harder)
+	easy.Bits = difficultyToCompact(0)
+	easy.Hash, easy.Nonce, _ = proofOfWork(context.Background(), &easy, 2)
+	raw, _ := json.Marshal(&easy)
+	if reason := s.submitBlock(context.Background(), raw); reason != "bad-diffbits" {
+		t.Errorf("submitblock: %v", reason)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"text/template"
+	"time"
+)
+
+// shortHashLen is the number of hex digits the short template helper
+// keeps.
+const shortHashLen = 8
+
+// templateFuncs are the helpers available to -template output. Hashes may
+// be given as bytes, as in a *Block, or as hex strings, as in a getblock
+// view.
+var templateFuncs = template.FuncMap{
+	// hex formats bytes as hex; strings are assumed to be hex already
+	"hex": templateHex,
+	// short is hex cut to its first shortHashLen digits
+	"short": func(v any) (string, error) {
+		s, err := templateHex(v)
+		if len(s) > shortHashLen {
+			s = s[:shortHashLen]
+		}
+		return s, err
+	},
+	// time formats Unix seconds in UTC, as RFC 3339 or with a Go layout:
+	// {{time .Timestamp}} or {{time .Timestamp "2006-01-02"}}
+	"time": func(v any, layout ...string) (string, error) {
+		var sec int64
+		switch v := v.(type) {
+		case int64:
+			sec = v
+		case int:
+			sec = int64(v)
+		default:
+			return "", fmt.Errorf("time: expected Unix seconds, got %T", v)
+		}
+		f := time.RFC3339
+		if len(layout) > 0 {
+			f = layout[0]
+		}
+		return time.Unix(sec, 0).UTC().Format(f), nil
+	},
+}
+
+func templateHex(v any) (string, error) {
+	switch v := v.(type) {
+	case []byte:
+		return hex.EncodeToString(v), nil
+	case string:
+		return v, nil
+	default:
+		return "", fmt.Errorf("hex: expected bytes or a hex string, got %T", v)
+	}
+}
+
+// parseOutputTemplate parses a -template flag with the template helpers.
+func parseOutputTemplate(text string) (*template.Template, error) {
+	return template.New("template").Funcs(templateFuncs).Parse(text)
+}
+
+// renderTemplate executes tmpl for v and ends the output with a newline.
+func renderTemplate(w io.Writer, tmpl *template.Template, v any) error {
+	if err := tmpl.Execute(w, v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
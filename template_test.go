@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputTemplate(t *testing.T) {
+	block := makeBlockchain(2, 1)[1]
+	render := func(text string, v any) string {
+		t.Helper()
+		tmpl, err := parseOutputTemplate(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out strings.Builder
+		if err := renderTemplate(&out, tmpl, v); err != nil {
+			t.Fatalf("%s: %v", text, err)
+		}
+		return out.String()
+	}
+
+	hash := hex.EncodeToString(block.Hash)
+	if got, want := render(`{{.Index}} {{hex .Hash}}`, block), "1 "+hash+"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := render(`{{short .Hash}}`, block), hash[:shortHashLen]+"\n"; got != want {
+		t.Errorf("short: got %q, want %q", got, want)
+	}
+	// getblock views carry hashes as hex strings already
+	view := watchEvent{rpcBlock: rpcBlock{Hash: hash, Time: block.Timestamp}}
+	if got, want := render(`{{short .Hash}} {{time .Time}}`, view), hash[:shortHashLen]+" "+time.Unix(block.Timestamp, 0).UTC().Format(time.RFC3339)+"\n"; got != want {
+		t.Errorf("view: got %q, want %q", got, want)
+	}
+	if got := render(`{{time 86400 "2006-01-02"}}`, nil); got != "1970-01-02\n" {
+		t.Errorf("time with layout: got %q", got)
+	}
+
+	tmpl, _ := parseOutputTemplate(`{{hex .Index}}`)
+	if err := renderTemplate(&strings.Builder{}, tmpl, block); err == nil {
+		t.Error("hex of an integer succeeded")
+	}
+}
+
+func TestInspectTemplate(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "chain.json")
+	if err := writeChainFile(makeBlockchain(3, 1), file); err != nil {
+		t.Fatal(err)
+	}
+	if code := runInspect([]string{"-file", file, "-template", "{{.Index}} {{short .Hash}}"}); code != 0 {
+		t.Errorf("inspect -template: expected exit code 0, got %d", code)
+	}
+	if code := runInspect([]string{"-file", file, "-index", "1", "-template", "{{time .Timestamp}}"}); code != 0 {
+		t.Errorf("inspect -index -template: expected exit code 0, got %d", code)
+	}
+	if code := runInspect([]string{"-file", file, "-template", "{{.Index"}); code != exitConfig {
+		t.Errorf("invalid template: expected exit code %d, got %d", exitConfig, code)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Nodes encrypt their traffic with TLS 1.3. Each node has an ed25519
+// identity key, made on its first run and kept in its data directory, and
+// serves a self-signed certificate for it. There is no certificate
+// authority: a peer is named by its key, which the URL of a peer carries
+// as its user name, as in https://<hex key>@10.0.0.5:7544/. A client of
+// such a URL accepts only a certificate for that key, and TLS makes the
+// node prove it holds the key's private half, so a man in the middle
+// cannot stand in for it.
+
+// nodeKeyName is the file in a data directory holding the node's identity
+// key, a PKCS #8 PEM block.
+const nodeKeyName = "node.key"
+
+// nodeCertValidity is how long a node's self-signed certificate is valid.
+// Peers check the key, not the dates, so this only has to outlast a run.
+const nodeCertValidity = 10 * 365 * 24 * time.Hour
+
+// ErrPeerIdentity reports a TLS peer whose key is not the pinned one.
+var ErrPeerIdentity = errors.New("peer identity mismatch")
+
+// loadNodeKey returns the identity key kept in dataDir, generating and
+// saving one on first use.
+func loadNodeKey(dataDir string) (*Wallet, error) {
+	path := filepath.Join(dataDir, nodeKeyName)
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		w, err := NewWallet()
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(w.privateKey)
+		if err != nil {
+			return nil, err
+		}
+		// The key must not be readable by other users
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+			return nil, err
+		}
+		return w, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s: not a PEM private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 key", path)
+	}
+	return walletFromSeed(priv.Seed())
+}
+
+// nodeCertificate returns a self-signed TLS certificate for key.
+func nodeCertificate(key *Wallet) (tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "blockchain node " + hex.EncodeToString(key.PublicKey[:8])},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(nodeCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.PublicKey, key.privateKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key.privateKey}, nil
+}
+
+// nodeTLSConfig returns the server TLS configuration of a node with key.
+func nodeTLSConfig(key *Wallet) (*tls.Config, error) {
+	cert, err := nodeCertificate(key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS13}, nil
+}
+
+// peerPin returns the identity key pinned in u, or nil if u pins none.
+func peerPin(u *url.URL) (ed25519.PublicKey, error) {
+	if u.User == nil {
+		return nil, nil
+	}
+	if _, hasPassword := u.User.Password(); hasPassword || u.Scheme != "https" {
+		return nil, fmt.Errorf("peer URL %s: only https URLs can pin a key, as https://<hex key>@host:port/", u.Redacted())
+	}
+	key, err := hex.DecodeString(u.User.Username())
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("peer URL %s: the user name must be a hex ed25519 public key", u.Redacted())
+	}
+	return key, nil
+}
+
+// pinnedTLSConfig returns a client TLS configuration accepting only a
+// server holding key. The certificate's chain and names are not checked:
+// the handshake proves the server holds the key, which is all a pin asks.
+func pinnedTLSConfig(key ed25519.PublicKey) *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS13,
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("%w: no certificate", ErrPeerIdentity)
+			}
+			got, ok := cs.PeerCertificates[0].PublicKey.(ed25519.PublicKey)
+			if !ok || !got.Equal(key) {
+				return fmt.Errorf("%w: want key %x", ErrPeerIdentity, key)
+			}
+			return nil
+		},
+	}
+}
+
+// newPeerClient returns a client of the node at rawURL. When the URL pins
+// an identity key, the client talks only to the node holding it; the key
+// is taken out of the URL requests go to.
+func newPeerClient(rawURL string) (*rpcClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	key, err := peerPin(u)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return newRPCClient(rawURL), nil
+	}
+	u.User = nil
+	c := newRPCClient(u.String())
+	c.pin = key
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = pinnedTLSConfig(key)
+	c.client.Transport = transport
+	return c, nil
+}
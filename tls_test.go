@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNodeKey(t *testing.T) {
+	dir := t.TempDir()
+	key, err := loadNodeKey(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dir, nodeKeyName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("node key mode %v, want 0600", info.Mode().Perm())
+	}
+	again, err := loadNodeKey(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.PublicKey.Equal(key.PublicKey) {
+		t.Error("node key changed across loads")
+	}
+
+	os.WriteFile(filepath.Join(dir, nodeKeyName), []byte("not a key"), 0o600)
+	if _, err := loadNodeKey(dir); err == nil {
+		t.Error("loaded a corrupt node key")
+	}
+}
+
+// TestPinnedTLS checks that a client pinning a node's key talks to it,
+// and that a node with another key, or a client pinning none, fails the
+// handshake.
+func TestPinnedTLS(t *testing.T) {
+	key, err := loadNodeKey(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := nodeTLSConfig(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := httptest.NewUnstartedServer(newRPCServer(makeBlockchain(3, 1), 1))
+	node.TLS = config
+	node.StartTLS()
+	defer node.Close()
+	host := strings.TrimPrefix(node.URL, "https://")
+	ctx := context.Background()
+
+	client, err := newPeerClient("https://" + hex.EncodeToString(key.PublicKey) + "@" + host + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var height int
+	if err := client.call(ctx, "getblockcount", &height); err != nil || height != 2 {
+		t.Fatalf("getblockcount over pinned TLS = %d, %v", height, err)
+	}
+	if strings.Contains(client.url, "@") {
+		t.Errorf("key left in the request URL %s", client.url)
+	}
+
+	other, _ := NewWallet()
+	impostor, err := newPeerClient("https://" + hex.EncodeToString(other.PublicKey) + "@" + host + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := impostor.call(ctx, "getblockcount", &height); !errors.Is(err, ErrPeerIdentity) {
+		t.Errorf("node with another key: expected %v, got %v", ErrPeerIdentity, err)
+	}
+	unpinned, err := newPeerClient(node.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unpinned.call(ctx, "getblockcount", &height); err == nil {
+		t.Error("self-signed certificate accepted without a pin")
+	}
+
+	for _, bad := range []string{
+		"http://" + hex.EncodeToString(key.PublicKey) + "@" + host + "/",
+		"https://" + hex.EncodeToString(key.PublicKey) + ":secret@" + host + "/",
+		"https://abcd@" + host + "/",
+	} {
+		if _, err := newPeerClient(bad); err == nil {
+			t.Errorf("client for %s created", bad)
+		}
+		if _, err := parsePeerURL(bad); err == nil {
+			t.Errorf("peer URL %s accepted", bad)
+		}
+	}
+}
+
+func TestMDNSPinsKey(t *testing.T) {
+	peers, _ := newPeerManager("")
+	d := &mdnsDiscovery{advert: &mdnsAdvert{Instance: "node-self", Magic: 1}, peers: peers, logger: slog.New(slog.DiscardHandler)}
+	key, _ := NewWallet()
+	peer := &mdnsAdvert{Instance: "node-tls", Port: 7544, Magic: 1, Key: key.PublicKey}
+	d.handle(context.Background(), peer.response(), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 7)})
+
+	want := "https://" + hex.EncodeToString(key.PublicKey) + "@10.0.0.7:7544/"
+	if known := peers.Known(); len(known) != 1 || known[0].URL != want {
+		t.Errorf("known peers %+v, want %s", known, want)
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// addressVersion is the base58check version byte of wallet addresses.
+const addressVersion = 0x00
+
+// addressPayloadSize is the number of public key hash bytes in an address.
+const addressPayloadSize = 20
+
+// Wallet holds an ed25519 key pair used to sign data on behalf of an address.
+type Wallet struct {
+	PublicKey  ed25519.PublicKey
+	privateKey ed25519.PrivateKey
+}
+
+// NewWallet generates a wallet with a fresh random key pair.
+func NewWallet() (*Wallet, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Wallet{PublicKey: pub, privateKey: priv}, nil
+}
+
+// walletFromSeed rebuilds a wallet from its 32-byte private key seed.
+func walletFromSeed(seed []byte) (*Wallet, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("wallet seed is %d bytes, want %d", len(seed), ed25519.SeedSize)
+	}
+	priv := ed25519.NewKeyFromSeed(seed)
+	return &Wallet{PublicKey: priv.Public().(ed25519.PublicKey), privateKey: priv}, nil
+}
+
+// Address returns the base58check address of the wallet.
+func (w *Wallet) Address() string {
+	return addressFromPublicKey(w.PublicKey)
+}
+
+// Sign signs msg with the wallet's private key.
+func (w *Wallet) Sign(msg []byte) []byte {
+	return ed25519.Sign(w.privateKey, msg)
+}
+
+// Verify reports whether sig is a valid signature of msg by pub.
+func Verify(pub ed25519.PublicKey, msg, sig []byte) bool {
+	return len(pub) == ed25519.PublicKeySize && ed25519.Verify(pub, msg, sig)
+}
+
+// addressFromPublicKey derives an address from the first bytes of the
+// SHA-256 of the public key, protected by a base58check checksum.
+func addressFromPublicKey(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return base58CheckEncode(addressVersion, sum[:addressPayloadSize])
+}
+
+// decodeAddress validates an address and returns its public key hash.
+func decodeAddress(addr string) ([]byte, error) {
+	version, payload, err := base58CheckDecode(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	if version != addressVersion {
+		return nil, fmt.Errorf("invalid address %q: unknown version %#02x", addr, version)
+	}
+	if len(payload) != addressPayloadSize {
+		return nil, fmt.Errorf("invalid address %q: payload is %d bytes", addr, len(payload))
+	}
+	return payload, nil
+}
+
+// scryptParams are the key derivation settings stored in a keystore.
+type scryptParams struct {
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt string `json:"salt"`
+}
+
+// defaultScryptParams trade roughly 32 MiB and a fraction of a second per
+// unlock for resistance to passphrase guessing.
+var defaultScryptParams = scryptParams{N: 1 << 15, R: 8, P: 1}
+
+// Keystores are not trusted to choose their own cost: parameters past
+// these limits, far above the defaults, are refused rather than left to
+// exhaust memory or CPU on unlock.
+const (
+	maxScryptMemory = 1 << 30 // bytes, 128*N*r
+	maxScryptP      = 16
+)
+
+// check reports whether the parameters are within the limits above.
+func (p scryptParams) check() error {
+	if p.N <= 1 || p.R <= 0 || p.P <= 0 || p.P > maxScryptP || p.N > maxScryptMemory/128/p.R {
+		return fmt.Errorf("keystore: scrypt parameters n=%d r=%d p=%d are out of range", p.N, p.R, p.P)
+	}
+	return nil
+}
+
+// keystoreFile is the on-disk form of an encrypted wallet. The private key
+// seed is sealed with AES-256-GCM under a key derived from the passphrase,
+// with the address as additional data.
+type keystoreFile struct {
+	Version    int          `json:"version"`
+	Address    string       `json:"address"`
+	PublicKey  string       `json:"public_key"`
+	KDF        string       `json:"kdf"`
+	KDFParams  scryptParams `json:"kdf_params"`
+	Cipher     string       `json:"cipher"`
+	Nonce      string       `json:"nonce"`
+	Ciphertext string       `json:"ciphertext"`
+}
+
+// saveKeystore encrypts the wallet with passphrase and writes it to path,
+// which must not exist yet: overwriting a keystore would lose its key.
+func saveKeystore(w *Wallet, path, passphrase string) error {
+	params := defaultScryptParams
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	params.Salt = hex.EncodeToString(salt)
+
+	aead, err := keystoreCipher(passphrase, params)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	addr := w.Address()
+	ks := keystoreFile{
+		Version:    1,
+		Address:    addr,
+		PublicKey:  hex.EncodeToString(w.PublicKey),
+		KDF:        "scrypt",
+		KDFParams:  params,
+		Cipher:     "aes-256-gcm",
+		Nonce:      hex.EncodeToString(nonce),
+		Ciphertext: hex.EncodeToString(aead.Seal(nil, nonce, w.privateKey.Seed(), []byte(addr))),
+	}
+
+	data, err := json.MarshalIndent(ks, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Keystores must not be readable by other users
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// loadKeystore reads and decrypts a wallet written by saveKeystore.
+func loadKeystore(path, passphrase string) (*Wallet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ks keystoreFile
+	if err := json.Unmarshal(data, &ks); err != nil {
+		return nil, fmt.Errorf("decode keystore %s: %w", path, err)
+	}
+	if ks.Version != 1 || ks.KDF != "scrypt" || ks.Cipher != "aes-256-gcm" {
+		return nil, fmt.Errorf("unsupported keystore format in %s", path)
+	}
+	if err := ks.KDFParams.check(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	aead, err := keystoreCipher(passphrase, ks.KDFParams)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(ks.Nonce)
+	if err != nil || len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("keystore %s: invalid nonce", path)
+	}
+	ciphertext, err := hex.DecodeString(ks.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("keystore %s: invalid ciphertext", path)
+	}
+
+	seed, err := aead.Open(nil, nonce, ciphertext, []byte(ks.Address))
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupted keystore")
+	}
+	w, err := walletFromSeed(seed)
+	if err != nil {
+		return nil, err
+	}
+	if w.Address() != ks.Address {
+		return nil, fmt.Errorf("keystore %s: key does not match address %s", path, ks.Address)
+	}
+	return w, nil
+}
+
+// keystoreCipher derives the AES-GCM cipher protecting a keystore.
+func keystoreCipher(passphrase string, params scryptParams) (cipher.AEAD, error) {
+	salt, err := hex.DecodeString(params.Salt)
+	if err != nil || len(salt) == 0 {
+		return nil, errors.New("keystore: invalid salt")
+	}
+	key, err := scryptKey([]byte(passphrase), salt, params.N, params.R, params.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// readPassphrase returns $WALLET_PASSPHRASE if set, otherwise reads a line
+// from in after printing a prompt.
+func readPassphrase(in io.Reader, prompt string) (string, error) {
+	if p, ok := os.LookupEnv("WALLET_PASSPHRASE"); ok {
+		return p, nil
+	}
+	fmt.Print(prompt)
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("read passphrase: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// runWallet implements the wallet subcommands and returns the exit code.
+func runWallet(args []string) int {
+	walletUsage := func() int {
+		return usage("Usage: blockchain wallet <new|address|sign|verify|split|restore|request|parse-uri> [flags]")
+	}
+	if len(args) == 0 {
+		return walletUsage()
+	}
+
+	fs := flag.NewFlagSet("wallet "+args[0], flag.ContinueOnError)
+	keystore := fs.String("keystore", "wallet.json", "path to the encrypted keystore")
+	message := fs.String("message", "", "message to sign or verify")
+	pubKey := fs.String("pubkey", "", "hex public key of the signer (verify)")
+	signature := fs.String("signature", "", "hex signature to check (verify)")
+	shares := fs.Int("shares", 5, "number of key shares to create (split)")
+	threshold := fs.Int("threshold", 3, "number of shares needed to restore (split)")
+	amount := fs.String("amount", "", "amount in coins to request, e.g. 1.5 (request)")
+	memo := fs.String("memo", "", "memo to attach to the payment request (request)")
+	uri := fs.String("uri", "", "payment URI to check (parse-uri)")
+	var shareWords stringList
+	fs.Var(&shareWords, "share", "share words to restore from; repeat once per share (restore)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return flagError(err)
+	}
+
+	switch args[0] {
+	case "new":
+		w, err := NewWallet()
+		if err != nil {
+			return fail(err)
+		}
+		pass, err := readPassphrase(os.Stdin, "New passphrase: ")
+		if err != nil {
+			return fail(err)
+		}
+		if err := saveKeystore(w, *keystore, pass); err != nil {
+			return fail(fmt.Errorf("writing keystore: %w", err))
+		}
+		fmt.Printf("Address: %s\nPublic key: %x\nKeystore written to %s\n", w.Address(), w.PublicKey, *keystore)
+
+	case "address", "sign":
+		pass, err := readPassphrase(os.Stdin, "Passphrase: ")
+		if err != nil {
+			return fail(err)
+		}
+		w, err := loadKeystore(*keystore, pass)
+		if err != nil {
+			return fail(err)
+		}
+		fmt.Printf("Address: %s\nPublic key: %x\n", w.Address(), w.PublicKey)
+		if args[0] == "sign" {
+			fmt.Printf("Signature: %x\n", w.Sign([]byte(*message)))
+		}
+
+	case "verify":
+		pub, err := hex.DecodeString(*pubKey)
+		if err != nil {
+			return failf(exitConfig, "invalid public key: %w", err)
+		}
+		sig, err := hex.DecodeString(*signature)
+		if err != nil {
+			return failf(exitConfig, "invalid signature: %w", err)
+		}
+		valid := Verify(pub, []byte(*message), sig)
+		fmt.Printf("Signature valid? %t\n", valid)
+		if !valid {
+			return 1
+		}
+		fmt.Printf("Signer address: %s\n", addressFromPublicKey(pub))
+
+	case "split":
+		pass, err := readPassphrase(os.Stdin, "Passphrase: ")
+		if err != nil {
+			return fail(err)
+		}
+		w, err := loadKeystore(*keystore, pass)
+		if err != nil {
+			return fail(err)
+		}
+		split, err := shamirSplit(w.privateKey.Seed(), *shares, *threshold)
+		if err != nil {
+			return fail(err)
+		}
+		fmt.Printf("Key for %s split into %d shares; any %d restore it.\n", w.Address(), *shares, *threshold)
+		fmt.Println("Store each share separately:")
+		for _, share := range split {
+			fmt.Printf("\nShare %d/%d:\n%s\n", share.X, *shares, encodeShare(share))
+		}
+
+	case "restore":
+		var parsed []shamirShare
+		for _, words := range shareWords {
+			share, err := decodeShare(words, ed25519.SeedSize)
+			if err != nil {
+				return fail(err)
+			}
+			parsed = append(parsed, share)
+		}
+		seed, err := shamirCombine(parsed)
+		if err != nil {
+			return fail(err)
+		}
+		w, err := walletFromSeed(seed)
+		if err != nil {
+			return fail(err)
+		}
+		pass, err := readPassphrase(os.Stdin, "New passphrase: ")
+		if err != nil {
+			return fail(err)
+		}
+		if err := saveKeystore(w, *keystore, pass); err != nil {
+			return fail(fmt.Errorf("writing keystore: %w", err))
+		}
+		fmt.Printf("Restored %s into %s\n", w.Address(), *keystore)
+
+	case "request":
+		req := PaymentRequest{Memo: *memo}
+		if *amount != "" {
+			units, err := parseAmount(*amount)
+			if err != nil {
+				return fail(err)
+			}
+			req.Amount = units
+		}
+		pass, err := readPassphrase(os.Stdin, "Passphrase: ")
+		if err != nil {
+			return fail(err)
+		}
+		w, err := loadKeystore(*keystore, pass)
+		if err != nil {
+			return fail(err)
+		}
+		req.Address = w.Address()
+		fmt.Println(req)
+
+	case "parse-uri":
+		req, err := parsePaymentURI(*uri)
+		if err != nil {
+			return fail(err)
+		}
+		fmt.Printf("Address: %s\n", req.Address)
+		if req.Amount > 0 {
+			fmt.Printf("Amount: %s\n", formatAmount(req.Amount))
+		}
+		if req.Memo != "" {
+			fmt.Printf("Memo: %s\n", req.Memo)
+		}
+
+	default:
+		return walletUsage()
+	}
+	return 0
+}
+
+// stringList is a flag.Value collecting every occurrence of a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
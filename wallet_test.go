@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestScryptVectors checks the scrypt implementation against RFC 7914 section 12.
+func TestScryptVectors(t *testing.T) {
+	cases := []struct {
+		password, salt string
+		N, r, p        int
+		want           string
+	}{
+		{"", "", 16, 1, 1, "77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906"},
+		{"password", "NaCl", 1024, 8, 16, "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b3731622eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640"},
+	}
+	for _, tc := range cases {
+		key, err := scryptKey([]byte(tc.password), []byte(tc.salt), tc.N, tc.r, tc.p, 64)
+		if err != nil {
+			t.Fatalf("scryptKey(%q, %q) failed: %v", tc.password, tc.salt, err)
+		}
+		if got := hex.EncodeToString(key); got != tc.want {
+			t.Errorf("scryptKey(%q, %q) = %s, want %s", tc.password, tc.salt, got, tc.want)
+		}
+	}
+}
+
+// TestBase58 checks encoding against known values and leading-zero handling.
+func TestBase58(t *testing.T) {
+	if got := base58Encode([]byte("hello world")); got != "StV1DL6CwTryKyV" {
+		t.Errorf("base58Encode(hello world) = %s", got)
+	}
+	for _, in := range [][]byte{{}, {0}, {0, 0, 1}, {0xff, 0x00, 0x10}} {
+		out, err := base58Decode(base58Encode(in))
+		if err != nil || !bytes.Equal(out, in) {
+			t.Errorf("round trip of %x produced %x (%v)", in, out, err)
+		}
+	}
+	if _, err := base58Decode("0OIl"); err == nil {
+		t.Error("expected characters outside the alphabet to be rejected")
+	}
+}
+
+// TestWalletAddressAndSignature checks address validation and sign/verify.
+func TestWalletAddressAndSignature(t *testing.T) {
+	w, err := NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := w.Address()
+	if _, err := decodeAddress(addr); err != nil {
+		t.Fatalf("generated address is invalid: %v", err)
+	}
+
+	// Flip one character to break the checksum
+	tampered := []byte(addr)
+	if tampered[5] == 'a' {
+		tampered[5] = 'b'
+	} else {
+		tampered[5] = 'a'
+	}
+	if _, err := decodeAddress(string(tampered)); err == nil {
+		t.Error("expected a tampered address to be rejected")
+	}
+
+	msg := []byte("pay 5 to bob")
+	sig := w.Sign(msg)
+	if !Verify(w.PublicKey, msg, sig) {
+		t.Error("valid signature rejected")
+	}
+	if Verify(w.PublicKey, []byte("pay 500 to bob"), sig) {
+		t.Error("signature accepted for a different message")
+	}
+}
+
+// TestKeystoreRoundTrip checks that a wallet survives encryption and that a wrong passphrase fails.
+func TestKeystoreRoundTrip(t *testing.T) {
+	w, err := NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "wallet.json")
+	if err := saveKeystore(w, path, "correct horse"); err != nil {
+		t.Fatalf("saveKeystore failed: %v", err)
+	}
+
+	loaded, err := loadKeystore(path, "correct horse")
+	if err != nil {
+		t.Fatalf("loadKeystore failed: %v", err)
+	}
+	if loaded.Address() != w.Address() || !bytes.Equal(loaded.Sign([]byte("x")), w.Sign([]byte("x"))) {
+		t.Error("loaded wallet differs from the saved one")
+	}
+
+	if _, err := loadKeystore(path, "battery staple"); err == nil {
+		t.Error("expected wrong passphrase to fail")
+	}
+
+	other, err := NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveKeystore(other, path, "correct horse"); !errors.Is(err, os.ErrExist) {
+		t.Errorf("overwriting a keystore: got %v, want os.ErrExist", err)
+	}
+	if loaded, err := loadKeystore(path, "correct horse"); err != nil || loaded.Address() != w.Address() {
+		t.Error("the existing keystore was replaced")
+	}
+}
+
+// TestKeystoreScryptLimits checks that a keystore asking for more memory
+// or parallelism than the limits is refused before deriving its key.
+func TestKeystoreScryptLimits(t *testing.T) {
+	w, err := NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "wallet.json")
+	if err := saveKeystore(w, path, "pass"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, params := range map[string]scryptParams{
+		"memory": {N: 1 << 30, R: 8, P: 1},
+		"p":      {N: 1 << 15, R: 8, P: 1 << 20},
+		"zero r": {N: 1 << 15, R: 0, P: 1},
+	} {
+		var ks keystoreFile
+		if err := json.Unmarshal(data, &ks); err != nil {
+			t.Fatal(err)
+		}
+		params.Salt = ks.KDFParams.Salt
+		ks.KDFParams = params
+		crafted, _ := json.Marshal(ks)
+		craftedPath := filepath.Join(t.TempDir(), "crafted.json")
+		if err := os.WriteFile(craftedPath, crafted, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadKeystore(craftedPath, "pass"); err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Errorf("%s: got %v, want out of range", name, err)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/template"
+	"time"
+)
+
+// watchReorgDepth is the number of printed blocks watch remembers. A reorg
+// deeper than this is reported from the oldest remembered block on.
+const watchReorgDepth = 1000
+
+const defaultWatchFormat = `{{if .Removed}}removed {{end}}{{.Height}} {{.Hash}} {{.Data}}`
+
+// watchSource is a chain that watch polls for new blocks.
+type watchSource interface {
+	// tip returns the height of the chain's tip.
+	tip(ctx context.Context) (int, error)
+	// hashAt returns the hex hash of the block at height.
+	hashAt(ctx context.Context, height int) (string, error)
+	// block returns the getblock view of the block at height.
+	block(ctx context.Context, height int) (rpcBlock, error)
+}
+
+// rpcWatchSource polls a node over JSON-RPC.
+type rpcWatchSource struct {
+	client *rpcClient
+}
+
+func (s *rpcWatchSource) tip(ctx context.Context) (int, error) {
+	var height int
+	err := s.client.call(ctx, "getblockcount", &height)
+	return height, err
+}
+
+func (s *rpcWatchSource) hashAt(ctx context.Context, height int) (string, error) {
+	var hash string
+	err := s.client.call(ctx, "getblockhash", &hash, height)
+	return hash, err
+}
+
+func (s *rpcWatchSource) block(ctx context.Context, height int) (rpcBlock, error) {
+	var view rpcBlock
+	hash, err := s.hashAt(ctx, height)
+	if err != nil {
+		return view, err
+	}
+	err = s.client.call(ctx, "getblock", &view, hash)
+	return view, err
+}
+
+// newWatchSource returns the source for a node's JSON-RPC URL, or else for
+// a data directory. network, if set, is the network the node must run.
+func newWatchSource(rpcURL, dataDir, network string) (watchSource, error) {
+	if rpcURL == "" {
+		return &storeWatchSource{path: chainStorePath(dataDir)}, nil
+	}
+	client, err := newPeerClient(rpcURL)
+	if err != nil {
+		return nil, err
+	}
+	if network != "" {
+		params, err := networkParams(network)
+		if err != nil {
+			return nil, err
+		}
+		client.magic = params.NetworkMagic
+	}
+	return &rpcWatchSource{client: client}, nil
+}
+
+// storeWatchSource polls the chain stored in a data directory. The store
+// is replaced atomically when it is saved, so it is reloaded whenever its
+// size or modification time changes, and each poll sees one version.
+type storeWatchSource struct {
+	path    string
+	modTime time.Time
+	size    int64
+	chain   []*Block
+}
+
+func (s *storeWatchSource) tip(ctx context.Context) (int, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return 0, err
+	}
+	if s.chain == nil || !info.ModTime().Equal(s.modTime) || info.Size() != s.size {
+		chain, err := readChainFile(s.path, DecodePolicy{TrustRedactions: true})
+		if err != nil {
+			return 0, err
+		}
+		if len(chain) == 0 {
+			return 0, fmt.Errorf("%s contains no blocks", s.path)
+		}
+		s.chain, s.modTime, s.size = chain, info.ModTime(), info.Size()
+	}
+	return len(s.chain) - 1, nil
+}
+
+func (s *storeWatchSource) hashAt(ctx context.Context, height int) (string, error) {
+	if height < 0 || height >= len(s.chain) {
+		return "", fmt.Errorf("block %d not found", height)
+	}
+	return hex.EncodeToString(s.chain[height].Hash), nil
+}
+
+func (s *storeWatchSource) block(ctx context.Context, height int) (rpcBlock, error) {
+	if height < 0 || height >= len(s.chain) {
+		return rpcBlock{}, fmt.Errorf("block %d not found", height)
+	}
+	return blockView(s.chain, s.chain[height]), nil
+}
+
+// watchEvent is the data of a watch format template: the getblock view of
+// a block, and whether a reorg removed it from the chain.
+type watchEvent struct {
+	rpcBlock
+	Removed bool
+}
+
+// watcher prints the blocks of a source as they appear.
+type watcher struct {
+	src    watchSource
+	tmpl   *template.Template
+	out    io.Writer
+	reorgs bool // print the blocks a reorg removes
+	next   int  // height of the next block to print
+	// recent holds the printed blocks still on the chain, oldest first
+	recent []rpcBlock
+}
+
+// poll prints the blocks added since the last poll. Printed blocks that
+// the chain no longer contains are unwound first, newest first, so after
+// a reorg the new branch is printed from the fork point.
+func (w *watcher) poll(ctx context.Context) error {
+	tip, err := w.src.tip(ctx)
+	if err != nil {
+		return err
+	}
+	for len(w.recent) > 0 {
+		last := w.recent[len(w.recent)-1]
+		if last.Height <= tip {
+			hash, err := w.src.hashAt(ctx, last.Height)
+			if err != nil {
+				return err
+			}
+			if hash == last.Hash {
+				break
+			}
+		}
+		w.recent = w.recent[:len(w.recent)-1]
+		w.next = last.Height
+		if w.reorgs {
+			if err := w.print(last, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	for ; w.next <= tip; w.next++ {
+		view, err := w.src.block(ctx, w.next)
+		if err != nil {
+			return err
+		}
+		if n := len(w.recent); n > 0 && view.PreviousBlockHash != w.recent[n-1].Hash {
+			// The chain changed since the unwinding above; the next poll
+			// unwinds again
+			return nil
+		}
+		if err := w.print(view, false); err != nil {
+			return err
+		}
+		w.recent = append(w.recent, view)
+		if len(w.recent) > watchReorgDepth {
+			w.recent = append(w.recent[:0], w.recent[1:]...)
+		}
+	}
+	return nil
+}
+
+func (w *watcher) print(view rpcBlock, removed bool) error {
+	return renderTemplate(w.out, w.tmpl, watchEvent{rpcBlock: view, Removed: removed})
+}
+
+// runWatch implements the watch subcommand, which prints each new block of
+// a node or data directory as it appears, like tail -f.
+func runWatch(args []string) int {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	rpcURL := fs.String("rpc", "", "JSON-RPC URL of a node to watch, such as http://127.0.0.1:7544/")
+	dataDir := fs.String("datadir", "", "data directory to watch (instead of -rpc)")
+	format := fs.String("template", defaultWatchFormat, "Go template for each block; fields are those of getblock plus .Removed")
+	fs.StringVar(format, "format", defaultWatchFormat, "alias of -template")
+	followReorgs := fs.Bool("follow-reorgs", false, "also print the blocks a reorg removes, with .Removed set")
+	from := fs.Int("from", -1, "height of the first block to print (default the current tip)")
+	interval := fs.Duration("interval", time.Second, "how often to poll for new blocks")
+	network := fs.String("network", "", "with -rpc, the network the node must run: mainnet, testnet or regtest")
+	logOpts := addLogFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return flagError(err)
+	}
+	if (*rpcURL == "") == (*dataDir == "") {
+		return usage("Usage: blockchain watch (-rpc url | -datadir dir) [-template text] [-follow-reorgs] [-from n] [-interval d]")
+	}
+	if *interval <= 0 {
+		return failf(exitConfig, "interval must be positive")
+	}
+	tmpl, err := parseOutputTemplate(*format)
+	if err != nil {
+		return failf(exitConfig, "invalid template: %w", err)
+	}
+	logger, err := logOpts.newLogger(os.Stderr)
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+
+	src, err := newWatchSource(*rpcURL, *dataDir, *network)
+	if err != nil {
+		return failCode(exitConfig, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	w := &watcher{src: src, tmpl: tmpl, out: os.Stdout, reorgs: *followReorgs, next: *from}
+	if w.next < 0 {
+		tip, err := src.tip(ctx)
+		if err != nil {
+			return fail(err)
+		}
+		w.next = tip
+	}
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		if err := w.poll(ctx); err != nil && ctx.Err() == nil {
+			logger.Warn("watch_poll_failed", slog.Any("error", err))
+		}
+		select {
+		case <-ctx.Done():
+			return exitOK
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+// sliceWatchSource serves a chain the test replaces between polls.
+type sliceWatchSource struct {
+	chain []*Block
+}
+
+func (s *sliceWatchSource) tip(ctx context.Context) (int, error) {
+	return len(s.chain) - 1, nil
+}
+
+func (s *sliceWatchSource) hashAt(ctx context.Context, height int) (string, error) {
+	return fmt.Sprintf("%x", s.chain[height].Hash), nil
+}
+
+func (s *sliceWatchSource) block(ctx context.Context, height int) (rpcBlock, error) {
+	return blockView(s.chain, s.chain[height]), nil
+}
+
+func TestWatcherReorg(t *testing.T) {
+	ctx := context.Background()
+	chain := makeBlockchain(4, 1)
+	src := &sliceWatchSource{chain: chain}
+	var out strings.Builder
+	w := &watcher{
+		src:    src,
+		tmpl:   template.Must(template.New("format").Parse(`{{if .Removed}}-{{else}}+{{end}}{{.Height}} {{.Data}}`)),
+		out:    &out,
+		reorgs: true,
+		next:   1,
+	}
+	poll := func(want string) {
+		t.Helper()
+		out.Reset()
+		if err := w.poll(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != want {
+			t.Errorf("poll printed %q, want %q", out.String(), want)
+		}
+	}
+
+	poll("+1 Block 1\n+2 Block 2\n+3 Block 3\n")
+	poll("")
+
+	// Replace the last block with a longer fork from block 2
+	fork3, _, err := mineBlock(ctx, chain[2], "Fork 3", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fork4, _, err := mineBlock(ctx, fork3, "Fork 4", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.chain = []*Block{chain[0], chain[1], chain[2], fork3, fork4}
+	poll("-3 Block 3\n+3 Fork 3\n+4 Fork 4\n")
+
+	// Without -follow-reorgs only the new branch is printed
+	w.reorgs = false
+	src.chain = chain
+	poll("+3 Block 3\n")
+}
+
+func TestWatchRPC(t *testing.T) {
+	chain := makeBlockchain(3, 1)
+	node := httptest.NewServer(newRPCServer(chain, 1))
+	defer node.Close()
+
+	src := &rpcWatchSource{client: newRPCClient(node.URL)}
+	var out strings.Builder
+	w := &watcher{src: src, tmpl: template.Must(template.New("format").Parse(defaultWatchFormat)), out: &out}
+	if err := w.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || lines[2] != fmt.Sprintf("2 %x Block 2", chain[2].Hash) {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+
+	if code := runWatch([]string{"-rpc", node.URL, "-format", "{{.Height"}); code != exitConfig {
+		t.Errorf("invalid format: expected exit code %d, got %d", exitConfig, code)
+	}
+}
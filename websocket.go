@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event topics published to WebSocket subscribers.
+const (
+	topicBlocks     = "blocks"
+	topicValidation = "validation"
+	topicAlerts     = "alerts"
+)
+
+// event is a notification pushed to subscribers as a JSON text message.
+type event struct {
+	Topic  string    `json:"topic"`
+	Block  *rpcBlock `json:"block,omitempty"`
+	Height int       `json:"height,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	// Fork and Depth describe a refused reorg
+	Fork  int `json:"fork,omitempty"`
+	Depth int `json:"depth,omitempty"`
+
+	// RequestID identifies the API request that caused the event.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// topicKinds maps WebSocket topics to the bus events they carry.
+var topicKinds = map[string]EventKind{
+	topicBlocks:     KindBlockAccepted,
+	topicValidation: KindValidationFailed,
+	topicAlerts:     KindReorgRefused,
+}
+
+// wsEvent converts a bus event to its WebSocket form, reporting false for
+// kinds no topic carries.
+func (s *rpcServer) wsEvent(ev Event) (event, bool) {
+	switch ev := ev.(type) {
+	case BlockAccepted:
+		s.mu.RLock()
+		view := s.blockView(ev.Block)
+		s.mu.RUnlock()
+		return event{Topic: topicBlocks, Block: &view, RequestID: ev.RequestID}, true
+	case ValidationFailed:
+		return event{Topic: topicValidation, Height: ev.Height, Reason: ev.Reason, RequestID: ev.RequestID}, true
+	case ReorgRefused:
+		return event{Topic: topicAlerts, Reason: "reorg-too-deep", Fork: ev.Fork, Depth: ev.Depth}, true
+	}
+	return event{}, false
+}
+
+// wsWriteTimeout bounds each write to a subscriber, so that one not
+// reading its connection is dropped instead of holding its writer.
+const wsWriteTimeout = 10 * time.Second
+
+// originAllowed reports whether a browser page at the request's Origin may
+// subscribe: pages served by the node itself, such as the explorer, and
+// those at the origins of s.wsOrigins. Browsers send cookies and other
+// credentials with WebSocket handshakes whatever the page, so without
+// this check any site a user visits could read the node's events through
+// them. Requests without an Origin do not come from a page and are let
+// through.
+func (s *rpcServer) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range s.wsOrigins {
+		if strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseOrigins parses a comma-separated list of origins for -ws-origin,
+// such as https://dash.example.com.
+func parseOrigins(list string) ([]string, error) {
+	var origins []string
+	for _, o := range strings.Split(list, ",") {
+		if o = strings.TrimSuffix(strings.TrimSpace(o), "/"); o == "" {
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+			return nil, fmt.Errorf("ws-origin: %q is not an origin such as https://example.com", o)
+		}
+		origins = append(origins, o)
+	}
+	return origins, nil
+}
+
+// serveWS upgrades the request to a WebSocket and streams events for the
+// topics listed in the "topics" query parameter (default: blocks).
+func (s *rpcServer) serveWS(w http.ResponseWriter, r *http.Request) {
+	if !s.originAllowed(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	topics := []string{topicBlocks}
+	if q := r.URL.Query().Get("topics"); q != "" {
+		topics = strings.Split(q, ",")
+	}
+	var kinds []EventKind
+	for _, t := range topics {
+		kind, ok := topicKinds[t]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown topic %q", t), http.StatusBadRequest)
+			return
+		}
+		kinds = append(kinds, kind)
+	}
+
+	tenant := tenantFrom(r.Context())
+	if tenant != nil {
+		release, err := s.quotas.subscribe(tenant)
+		if err != nil {
+			refuse(w, r, err)
+			return
+		}
+		defer release()
+	}
+
+	conn, rw, err := wsAccept(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	sub := s.bus.Subscribe(kinds...)
+	defer sub.Close()
+
+	ctx := r.Context()
+	logAttrs := []slog.Attr{
+		slog.String("request_id", requestID(ctx)),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.String("topics", strings.Join(topics, ",")),
+	}
+	s.logger.LogAttrs(ctx, slog.LevelInfo, "peer_connected", logAttrs...)
+	s.bus.Publish(PeerConnected{Addr: r.RemoteAddr, RequestID: requestID(ctx)})
+	defer s.logger.LogAttrs(ctx, slog.LevelInfo, "peer_disconnected", logAttrs...)
+
+	var writeMu sync.Mutex
+	write := func(opcode byte, payload []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return wsWriteFrame(rw.Writer, opcode, payload)
+	}
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		wsReadLoop(rw.Reader, write)
+	}()
+
+	for {
+		select {
+		case ev, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind; 1008 is "policy violation"
+				write(wsOpClose, []byte{0x03, 0xf0})
+				return
+			}
+			wev, ok := s.wsEvent(ev)
+			if !ok {
+				continue
+			}
+			msg, err := json.Marshal(wev)
+			if err != nil {
+				return
+			}
+			err = write(wsOpText, msg)
+			exhausted := err == nil && tenant != nil && s.quotas.sent(tenant, int64(len(msg))) != nil
+			if exhausted {
+				// Out of bytes for the day
+				write(wsOpClose, []byte{0x03, 0xf0})
+			}
+			if err != nil || exhausted {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// WebSocket opcodes (RFC 6455, section 5.2).
+const (
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xa
+)
+
+// wsMaxClientFrame bounds client frames; clients only send control frames.
+const wsMaxClientFrame = 1 << 16
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsAccept performs the server side of the opening handshake and hijacks
+// the connection.
+func wsAccept(w http.ResponseWriter, r *http.Request) (net.Conn, *bufio.ReadWriter, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+		return nil, nil, errors.New("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, nil, errors.New("unsupported websocket version")
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection cannot be upgraded", http.StatusInternalServerError)
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, rw, nil
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsWriteFrame writes a single unmasked, unfragmented frame.
+func wsWriteFrame(w *bufio.Writer, opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xffff:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	w.Write(header)
+	w.Write(payload)
+	return w.Flush()
+}
+
+// wsReadLoop reads client frames until the connection closes, answering
+// pings and close frames through reply. Data frames are ignored.
+func wsReadLoop(r *bufio.Reader, reply func(opcode byte, payload []byte) error) {
+	for {
+		var head [2]byte
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			return
+		}
+		opcode := head[0] & 0x0f
+		masked := head[1]&0x80 != 0
+		n := uint64(head[1] & 0x7f)
+		switch n {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return
+			}
+			n = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return
+			}
+			n = binary.BigEndian.Uint64(ext[:])
+		}
+		// Clients must mask their frames; 1002 is "protocol error"
+		if !masked || n > wsMaxClientFrame {
+			reply(wsOpClose, []byte{0x03, 0xea})
+			return
+		}
+
+		var mask [4]byte
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+
+		switch opcode {
+		case wsOpClose:
+			reply(wsOpClose, payload)
+			return
+		case wsOpPing:
+			if reply(wsOpPong, payload) != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsDial opens a WebSocket to the test server and returns the reader for
+// server frames.
+func wsDial(t *testing.T, srv *httptest.Server, path string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %s", resp.Status)
+	}
+	// Sample key and accept value from RFC 6455, section 1.3
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("wrong Sec-WebSocket-Accept %q", got)
+	}
+	return conn, r
+}
+
+// wsReadEvent reads one text frame and decodes it as an event.
+func wsReadEvent(t *testing.T, conn net.Conn, r *bufio.Reader) event {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	if head[0] != 0x80|wsOpText {
+		t.Fatalf("expected a final text frame, got %#x", head[0])
+	}
+	n := int(head[1])
+	if n == 126 {
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	var ev event
+	if err := json.Unmarshal(payload, &ev); err != nil {
+		t.Fatalf("decode event %q: %v", payload, err)
+	}
+	return ev
+}
+
+// TestWebSocket_Events checks that accepted and rejected blocks are pushed
+// to subscribers of the matching topics.
+func TestWebSocket_Events(t *testing.T) {
+	chain := makeBlockchain(2, 1)
+	s := newRPCServer(chain, 1)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	conn, r := wsDial(t, srv, "/ws?topics=blocks,validation")
+
+	// Wait for the subscription to be registered before publishing
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if s.bus.Subscribers() == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("subscription was not registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	next, err := generateBlock(context.Background(), chain[1], "pushed", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := json.Marshal(next)
+	if reason := s.submitBlock(context.Background(), raw); reason != nil {
+		t.Fatalf("block rejected: %v", reason)
+	}
+	ev := wsReadEvent(t, conn, r)
+	if ev.Topic != topicBlocks || ev.Block == nil || ev.Block.Height != 2 || ev.Block.Data != "pushed" {
+		t.Errorf("unexpected block event %+v", ev)
+	}
+
+	bad := *next
+	bad.Index = 3
+	bad.PrevHash = chain[0].Hash
+	raw, _ = json.Marshal(&bad)
+	s.submitBlock(context.Background(), raw)
+	ev = wsReadEvent(t, conn, r)
+	if ev.Topic != topicValidation || ev.Height != 3 || ev.Reason != "bad-prevblk" {
+		t.Errorf("unexpected validation event %+v", ev)
+	}
+}
+
+// TestWebSocket_RejectsBadRequests covers unknown topics and plain GETs.
+func TestWebSocket_RejectsBadRequests(t *testing.T) {
+	srv := httptest.NewServer(newRPCServer(makeBlockchain(1, 1), 1))
+	defer srv.Close()
+
+	for _, path := range []string{"/ws?topics=peers", "/ws"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("GET %s: expected 400, got %s", path, resp.Status)
+		}
+	}
+
+	if resp, err := http.Post(srv.URL+"/ws", "application/json", strings.NewReader("{}")); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("POST /ws: expected 400, got %s", resp.Status)
+		}
+	}
+}
+
+// TestWebSocket_Origin checks that browser pages may only subscribe from
+// the node's own origin or an allowed one.
+func TestWebSocket_Origin(t *testing.T) {
+	s := newRPCServer(makeBlockchain(1, 1), 1)
+	s.wsOrigins = []string{"https://dash.example.com"}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	// Allowed origins get past the check to the handshake, which a plain
+	// GET fails
+	for origin, want := range map[string]int{
+		"":                         http.StatusBadRequest,
+		srv.URL:                    http.StatusBadRequest,
+		"https://DASH.example.com": http.StatusBadRequest,
+		"https://evil.example.com": http.StatusForbidden,
+		"http://dash.example.com":  http.StatusForbidden,
+	} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/ws", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("Origin %q: got %s, want %d", origin, resp.Status, want)
+		}
+	}
+
+	if _, err := parseOrigins("https://a.example, dash.example.com"); err == nil {
+		t.Error("expected an origin without a scheme to be refused")
+	}
+	if got, err := parseOrigins("https://a.example/, http://b.example:8080"); err != nil || len(got) != 2 || got[0] != "https://a.example" {
+		t.Errorf("parseOrigins = %q, %v", got, err)
+	}
+}